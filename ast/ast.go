@@ -16,6 +16,12 @@ type Expr = ast.Expr
 // Stmt - All statement nodes implement the Stmt interface.
 type Stmt = ast.Stmt
 
+// Node - All node types implement the Node interface.
+type Node = ast.Node
+
+// Decl - All declaration nodes implement the Decl interface.
+type Decl = ast.Decl
+
 // ----------------------------------------------------------------------------
 // Declarations
 
@@ -243,3 +249,22 @@ type (
 )
 
 // -----------------------------------------------------------------------------
+
+// Inspect traverses an AST in depth-first order: It starts by calling f(node);
+// node must not be nil. If f returns true, Inspect invokes f recursively for each
+// of the non-nil children of node, followed by a call of f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	ast.Inspect(node, f)
+}
+
+// IsExported reports whether name starts with an upper-case letter.
+func IsExported(name string) bool {
+	return ast.IsExported(name)
+}
+
+// NewIdent creates a new Ident without position.
+func NewIdent(name string) *Ident {
+	return ast.NewIdent(name)
+}
+
+// -----------------------------------------------------------------------------
@@ -3,6 +3,7 @@ package astutil
 import (
 	"go/ast"
 	"go/token"
+	"math/big"
 	"reflect"
 	"strconv"
 
@@ -46,7 +47,8 @@ func IsConstBound(kind ConstKind) bool {
 	return kind <= reflect.UnsafePointer
 }
 
-// ToConst converts a ast.BasicLit to constant value.
+// ToConst converts a ast.BasicLit to constant value. An integer is an int64, or an
+// uint64 or a *big.Int if it overflows int64.
 func ToConst(v *ast.BasicLit) (ConstKind, interface{}) {
 	switch v.Kind {
 	case token.INT:
@@ -54,7 +56,11 @@ func ToConst(v *ast.BasicLit) (ConstKind, interface{}) {
 		if err != nil {
 			n2, err2 := strconv.ParseUint(v.Value, 0, 0)
 			if err2 != nil {
-				log.Fatalln("ToConst: strconv.ParseInt failed:", err2)
+				n3, ok := new(big.Int).SetString(v.Value, 0)
+				if !ok {
+					log.Fatalln("ToConst: strconv.ParseInt failed:", err2)
+				}
+				return ConstUnboundInt, n3
 			}
 			return ConstUnboundInt, n2
 		}
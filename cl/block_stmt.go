@@ -3,13 +3,11 @@ package cl
 import (
 	"reflect"
 	"strings"
-	"syscall"
 
 	"github.com/qiniu/qlang/ast"
 	"github.com/qiniu/qlang/ast/astutil"
 	"github.com/qiniu/qlang/exec"
 	"github.com/qiniu/qlang/token"
-	"github.com/qiniu/x/log"
 )
 
 // -----------------------------------------------------------------------------
@@ -27,41 +25,84 @@ const (
 
 func compileBlockStmt(ctx *blockCtx, body *ast.BlockStmt) {
 	for _, stmt := range body.List {
-		switch v := stmt.(type) {
-		case *ast.ExprStmt:
-			compileExprStmt(ctx, v)
-		case *ast.AssignStmt:
-			compileAssignStmt(ctx, v)
-		case *ast.ReturnStmt:
-			compileReturnStmt(ctx, v)
-		default:
-			log.Panicln("compileBlockStmt failed: unknown -", reflect.TypeOf(v))
-		}
+		compileStmt(ctx, stmt)
+	}
+}
+
+func compileNewBlock(ctx *blockCtx, block *ast.BlockStmt) {
+	ctx = newNormBlockCtx(ctx)
+	compileBlockStmt(ctx, block)
+}
+
+func compileStmt(ctx *blockCtx, stmt ast.Stmt) {
+	defer ctx.recoverErr(stmt, ctx.infer.Len())
+	ctx.setPos(stmt)
+	switch v := stmt.(type) {
+	case *ast.ExprStmt:
+		compileExprStmt(ctx, v)
+	case *ast.AssignStmt:
+		compileAssignStmt(ctx, v)
+	case *ast.IncDecStmt:
+		compileIncDecStmt(ctx, v)
+	case *ast.IfStmt:
+		compileIfStmt(ctx, v)
+	case *ast.ForStmt:
+		compileForStmt(ctx, v, "")
+	case *ast.RangeStmt:
+		compileRangeStmt(ctx, v, "")
+	case *ast.SwitchStmt:
+		compileSwitchStmt(ctx, v, "")
+	case *ast.TypeSwitchStmt:
+		compileTypeSwitchStmt(ctx, v, "")
+	case *ast.BranchStmt:
+		compileBranchStmt(ctx, v)
+	case *ast.LabeledStmt:
+		compileLabeledStmt(ctx, v)
+	case *ast.BlockStmt:
+		compileNewBlock(ctx, v)
+	case *ast.ReturnStmt:
+		compileReturnStmt(ctx, v)
+	case *ast.DeclStmt:
+		compileDeclStmt(ctx, v)
+	case *ast.DeferStmt:
+		compileDeferStmt(ctx, v)
+	case *ast.GoStmt:
+		compileGoStmt(ctx, v)
+	case *ast.SendStmt:
+		compileSendStmt(ctx, v)
+	case *ast.SelectStmt:
+		compileSelectStmt(ctx, v, "")
+	case *ast.EmptyStmt:
+	default:
+		logPanic("compileStmt failed: unknown -", reflect.TypeOf(v))
 	}
 }
 
 func compileReturnStmt(ctx *blockCtx, expr *ast.ReturnStmt) {
 	fun := ctx.fun
 	if fun == nil {
-		log.Panicln("compileReturnStmt failed: return statement not in a function.")
+		logPanic("compileReturnStmt failed: return statement not in a function.")
 	}
 	rets := expr.Results
 	if rets == nil {
 		if fun.IsUnnamedOut() {
-			log.Panicln("compileReturnStmt failed: return without values -", fun.Name)
+			logPanic("compileReturnStmt failed: return without values -", fun.Name)
 		}
 		ctx.out.Return(-1)
 		return
 	}
-	for _, ret := range rets {
+	for i, ret := range rets {
 		compileExpr(ctx, ret, 0)
+		if i < fun.NumOut() {
+			convIface(ctx, fun.Out(i).Type)
+		}
 	}
 	n := len(rets)
 	if fun.NumOut() != n {
-		log.Panicln("compileReturnStmt failed: mismatched count of return values -", fun.Name)
+		logPanic("compileReturnStmt failed: mismatched count of return values -", fun.Name)
 	}
 	if ctx.infer.Len() != n {
-		log.Panicln("compileReturnStmt failed: can't use multi values funcation result as return values -", fun.Name)
+		logPanic("compileReturnStmt failed: can't use multi values funcation result as return values -", fun.Name)
 	}
 	results := ctx.infer.GetArgs(uint32(n))
 	for i, result := range results {
@@ -72,39 +113,118 @@ func compileReturnStmt(ctx *blockCtx, expr *ast.ReturnStmt) {
 	ctx.out.Return(int32(n))
 }
 
+func compileDeclStmt(ctx *blockCtx, v *ast.DeclStmt) {
+	d := v.Decl.(*ast.GenDecl)
+	switch d.Tok {
+	case token.CONST:
+		loadConsts(ctx, d)
+		for _, item := range d.Specs {
+			for _, name := range item.(*ast.ValueSpec).Names {
+				if c, ok := ctx.syms[name.Name].(*constDecl); ok {
+					c.eval()
+				}
+			}
+		}
+	case token.VAR:
+		for _, item := range d.Specs {
+			compileVarSpec(ctx, item.(*ast.ValueSpec))
+		}
+	case token.TYPE:
+		loadTypes(ctx, d)
+		for _, item := range d.Specs {
+			if t, ok := ctx.syms[item.(*ast.TypeSpec).Name.Name].(*typeDecl); ok {
+				t.getType()
+			}
+		}
+	default:
+		logPanic("compileDeclStmt failed: unsupported -", d.Tok)
+	}
+}
+
 func compileExprStmt(ctx *blockCtx, expr *ast.ExprStmt) {
 	compileExpr(ctx, expr.X, 0)
-	ctx.infer.PopN(1)
+	v := ctx.infer.Pop().(iValue)
+	if ctx.parent != nil { // results of global statements are left on the stack for the caller.
+		if n := v.NumValues(); n > 0 {
+			ctx.out.Pop(n)
+		}
+	} else if c, ok := v.(*constVal); ok && !astutil.IsConstBound(c.kind) {
+		c.bound(c.boundType(), ctx.out)
+	}
+}
+
+func compileIncDecStmt(ctx *blockCtx, expr *ast.IncDecStmt) {
+	op := token.ADD
+	if expr.Tok == token.DEC {
+		op = token.SUB
+	}
+	compileOpAssign(ctx, expr.X, op, &ast.BasicLit{ValuePos: expr.TokPos, Kind: token.INT, Value: "1"})
+}
+
+// compileOpAssign compiles `x op= y` as `x = x op y`.
+func compileOpAssign(ctx *blockCtx, x ast.Expr, op token.Token, y ast.Expr) {
+	compileBinaryExpr(ctx, &ast.BinaryExpr{X: x, OpPos: x.End(), Op: op, Y: y}, 0)
+	compileExpr(ctx, x, lhsAssign)
+}
+
+var opAssigns = map[token.Token]token.Token{
+	token.ADD_ASSIGN:     token.ADD,
+	token.SUB_ASSIGN:     token.SUB,
+	token.MUL_ASSIGN:     token.MUL,
+	token.QUO_ASSIGN:     token.QUO,
+	token.REM_ASSIGN:     token.REM,
+	token.AND_ASSIGN:     token.AND,
+	token.OR_ASSIGN:      token.OR,
+	token.XOR_ASSIGN:     token.XOR,
+	token.SHL_ASSIGN:     token.SHL,
+	token.SHR_ASSIGN:     token.SHR,
+	token.AND_NOT_ASSIGN: token.AND_NOT,
 }
 
 func compileAssignStmt(ctx *blockCtx, expr *ast.AssignStmt) {
 	if ctx.infer.Len() != 0 {
-		log.Panicln("compileAssignStmt internal error: infer stack is not empty.")
+		logPanic("compileAssignStmt internal error: infer stack is not empty.")
+	}
+	if op, ok := opAssigns[expr.Tok]; ok {
+		if len(expr.Lhs) != 1 || len(expr.Rhs) != 1 {
+			logPanic("compileAssignStmt failed: assignment operation", expr.Tok, "requires single-valued expressions.")
+		}
+		compileOpAssign(ctx, expr.Lhs[0], op, expr.Rhs[0])
+		return
 	}
 	if len(expr.Rhs) == 1 {
-		compileExpr(ctx, expr.Rhs[0], 0)
+		if recv, ok := toRecvExpr(expr.Rhs[0]); ok && len(expr.Lhs) == 2 { // v, ok = <-ch
+			compileRecvExpr(ctx, recv, true, 0)
+		} else {
+			compileExpr(ctx, expr.Rhs[0], 0)
+		}
 		v := ctx.infer.Get(-1).(iValue)
 		n := v.NumValues()
 		if n != 1 {
 			if n == 0 {
-				log.Panicln("compileAssignStmt failed: expr has no return value.")
+				logPanic("compileAssignStmt failed: expr has no return value.")
 			}
 			rhs := make([]interface{}, n)
 			for i := 0; i < n; i++ {
 				rhs[i] = v.Value(i)
 			}
 			ctx.infer.Ret(1, rhs...)
+		} else if len(expr.Lhs) == 1 {
+			convAssign(ctx, expr.Lhs[0], expr.Tok)
 		}
 	} else {
-		for _, item := range expr.Rhs {
+		for i, item := range expr.Rhs {
 			compileExpr(ctx, item, 0)
 			if ctx.infer.Get(-1).(iValue).NumValues() != 1 {
-				log.Panicln("compileAssignStmt failed: expr has multiple values.")
+				logPanic("compileAssignStmt failed: expr has multiple values.")
+			}
+			if i < len(expr.Lhs) {
+				convAssign(ctx, expr.Lhs[i], expr.Tok)
 			}
 		}
 	}
 	if ctx.infer.Len() != len(expr.Lhs) {
-		log.Panicln("compileAssignStmt failed: assign statment has mismatched variables count.")
+		logPanic("compileAssignStmt failed: assign statment has mismatched variables count.")
 	}
 	for i := len(expr.Lhs) - 1; i >= 0; i-- {
 		compileExpr(ctx, expr.Lhs[i], expr.Tok)
@@ -112,6 +232,7 @@ func compileAssignStmt(ctx *blockCtx, expr *ast.AssignStmt) {
 }
 
 func compileExpr(ctx *blockCtx, expr ast.Expr, mode compleMode) {
+	defer locateErr(expr)
 	switch v := expr.(type) {
 	case *ast.Ident:
 		compileIdent(ctx, v.Name, mode)
@@ -121,97 +242,225 @@ func compileExpr(ctx *blockCtx, expr ast.Expr, mode compleMode) {
 		compileCallExpr(ctx, v, mode)
 	case *ast.BinaryExpr:
 		compileBinaryExpr(ctx, v, mode)
+	case *ast.UnaryExpr:
+		compileUnaryExpr(ctx, v, mode)
+	case *ast.ParenExpr:
+		compileExpr(ctx, v.X, mode)
 	case *ast.SelectorExpr:
 		compileSelectorExpr(ctx, v, mode)
 	case *ast.FuncLit:
 		compileFuncLit(ctx, v, mode)
+	case *ast.CompositeLit:
+		compileCompositeLit(ctx, v, nil, mode)
+	case *ast.StarExpr:
+		compileStarExpr(ctx, v, mode)
 	default:
-		log.Panicln("compileExpr failed: unknown -", reflect.TypeOf(v))
+		logPanic("compileExpr failed: unknown -", reflect.TypeOf(v))
 	}
 }
 
 func compileIdent(ctx *blockCtx, name string, mode compleMode) {
 	if mode > lhsBase {
 		in := ctx.infer.Get(-1)
-		addr, err := ctx.findVar(name)
-		if err == nil {
-			if mode == lhsDefine && !addr.inCurrentCtx(ctx) {
-				log.Warn("requireVar: variable is shadowed -", name)
+		if name == "_" { // discard the value
+			if cons, ok := in.(*constVal); ok {
+				cons.bound(cons.boundType(), ctx.out)
 			}
-		} else if mode == lhsAssign || err != syscall.ENOENT {
-			log.Panicln("compileIdent failed:", err, "-", name)
-		} else {
+			ctx.infer.PopN(1)
+			ctx.out.Pop(1)
+			return
+		}
+		var addr iVar
+		var err error
+		var define bool
+		if _, ok := ctx.syms[name]; ok || mode == lhsAssign {
+			addr, err = ctx.findVar(name)
+			if err != nil {
+				logPanic("compileIdent failed:", err, "-", name)
+			}
+		} else { // new variable in current block (it may shadow a variable in outer blocks)
 			typ := boundType(in.(iValue))
-			addr = ctx.insertVar(name, typ)
+			v := ctx.insertVar(name, typ)
+			v.decl = declOf(in)
+			addr, define = v, true
 		}
 		checkType(addr.getType(), in, ctx.out)
+		checkDeclAssign(varDeclOf(addr), addr.getType(), in)
 		ctx.infer.PopN(1)
 		if v, ok := addr.(*execVar); ok {
-			ctx.out.StoreVar((*exec.Var)(v))
+			if !v.cell {
+				ctx.out.StoreVar(v.Var)
+			} else if define {
+				ctx.storeCell(v)
+			} else {
+				ctx.out.LoadVar(v.Var).AddrOp(v.getType().Kind(), exec.OpAssign)
+			}
 		} else {
 			ctx.out.Store(addr.(*stackVar).index)
 		}
 	} else if sym, ok := ctx.find(name); ok {
 		switch v := sym.(type) {
 		case *execVar:
-			ctx.infer.Push(&goValue{t: v.Type})
+			ctx.infer.Push(&goValue{t: v.getType(), decl: v.decl})
 			if mode == inferOnly {
 				return
 			}
-			ctx.out.LoadVar((*exec.Var)(v))
+			ctx.out.LoadVar(v.Var)
+			if v.cell {
+				ctx.out.AddrOp(v.getType().Kind(), exec.OpAddrVal)
+			}
 		case *stackVar:
-			ctx.infer.Push(&goValue{t: v.typ})
+			ctx.infer.Push(&goValue{t: v.typ, decl: v.decl})
 			if mode == inferOnly {
 				return
 			}
 			ctx.out.Load(v.index)
 		case string: // pkgPath
-			pkg := exec.FindGoPackage(v)
-			if pkg == nil {
-				log.Panicln("compileIdent failed: package not found -", v)
-			}
-			ctx.infer.Push(&nonValue{pkg})
+			ctx.infer.Push(&nonValue{ctx.findGoPackage(v)})
+		case *constDecl:
+			compileConst(ctx, v.eval(), mode)
+		case *varDecl:
+			logPanic("compileIdent failed: variable is used before initialization -", name)
 		case *funcDecl:
 			ctx.use(v)
 			ctx.infer.Push(newQlFunc(v))
 			if mode == inferOnly {
 				return
 			}
-			log.Panicln("compileIdent failed: todo - funcDecl")
+			logPanic("compileIdent failed: todo - funcDecl")
 		default:
-			log.Panicln("compileIdent failed: unknown -", reflect.TypeOf(sym))
+			logPanic("compileIdent failed: unknown -", reflect.TypeOf(sym))
+		}
+	} else if name == "iota" && ctx.iota >= 0 {
+		compileConst(ctx, &constVal{v: int64(ctx.iota), kind: astutil.ConstUnboundInt}, mode)
+	} else if v, ok := builtinConsts[name]; ok {
+		ctx.infer.Push(&constVal{v: v, kind: reflect.TypeOf(v).Kind(), reserve: -1})
+		if mode == inferOnly {
+			return
 		}
+		ctx.out.Push(v)
 	} else {
 		addr, kind, ok := ctx.builtin.Find(name)
 		if !ok {
-			log.Panicln("compileIdent failed: unknown -", name)
+			logPanic("compileIdent failed: unknown -", name)
 		}
 		switch kind {
 		case exec.SymbolVar:
 		case exec.SymbolFunc, exec.SymbolFuncv:
-			ctx.infer.Push(newGoFunc(addr, kind, 0))
+			ctx.infer.Push(newGoFunc(ctx.reg, addr, kind, 0))
 			if mode == inferOnly {
 				return
 			}
 		}
-		log.Panicln("compileIdent failed: unknown -", kind, addr)
+		logPanic("compileIdent failed: unknown -", kind, addr)
 	}
 }
 
+var builtinConsts = map[string]interface{}{
+	"true":  true,
+	"false": false,
+}
+
+// compileFuncLit compiles a function literal. If it refers to variables of a loop
+// body that are cells (see execVar.cell), the cells are captured when the closure
+// is created, as variables of its environment (see exec.FuncInfo.Env).
 func compileFuncLit(ctx *blockCtx, v *ast.FuncLit, mode compleMode) {
 	funCtx := newBlockCtx(ctx)
-	decl := newFuncDecl("", v.Type, v.Body, funCtx)
+	cells := ctx.loopCells(refNames(v.Body))
+	env := make([]*exec.Var, len(cells))
+	if len(cells) > 0 {
+		envCtx := newBlockCtx(ctx)
+		for i, c := range cells {
+			ev := &execVar{Var: exec.NewVar(c.Type, c.Name()), decl: c.decl, cell: true}
+			envCtx.syms[c.Name()] = ev
+			env[i] = ev.Var
+		}
+		funCtx = newBlockCtx(envCtx)
+	}
+	decl := newFuncDecl(ctx.funcLitName(), v.Type, v.Body, funCtx)
+	if len(cells) > 0 {
+		decl.fi.Env(env...)
+		decl.cells = cells
+	}
 	ctx.use(decl)
 	ctx.infer.Push(newQlFunc(decl))
 	if mode == inferOnly {
 		return
 	}
+	compileClosure(ctx, decl)
+}
+
+// compileClosure pushes a closure of a function literal.
+func compileClosure(ctx *blockCtx, decl *funcDecl) {
+	for _, c := range decl.cells {
+		ctx.out.LoadVar(c.Var)
+	}
 	ctx.out.GoClosure(decl.fi)
 }
 
+// refNames returns names of the identifiers in node.
+func refNames(node ast.Node) map[string]bool {
+	names := make(map[string]bool)
+	ast.Inspect(node, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Ident); ok {
+			names[ident.Name] = true
+		}
+		return true
+	})
+	return names
+}
+
+// addrNames returns names of the variables whose addresses may be taken in node:
+// operands of &, and variables whose fields or elements are assigned, or whose
+// methods are called (a method may have a pointer receiver).
+func addrNames(node ast.Node) map[string]bool {
+	names := make(map[string]bool)
+	add := func(x ast.Expr) {
+		for {
+			switch v := x.(type) {
+			case *ast.ParenExpr:
+				x = v.X
+			case *ast.SelectorExpr:
+				x = v.X
+			case *ast.IndexExpr:
+				x = v.X
+			case *ast.Ident:
+				names[v.Name] = true
+				return
+			default:
+				return
+			}
+		}
+	}
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch v := n.(type) {
+		case *ast.UnaryExpr:
+			if v.Op == token.AND {
+				add(v.X)
+			}
+		case *ast.AssignStmt:
+			for _, x := range v.Lhs {
+				if _, ok := x.(*ast.Ident); !ok {
+					add(x)
+				}
+			}
+		case *ast.IncDecStmt:
+			if _, ok := v.X.(*ast.Ident); !ok {
+				add(v.X)
+			}
+		case *ast.CallExpr:
+			if sel, ok := v.Fun.(*ast.SelectorExpr); ok {
+				add(sel.X)
+			}
+		}
+		return true
+	})
+	return names
+}
+
 func compileBasicLit(ctx *blockCtx, v *ast.BasicLit, mode compleMode) {
 	if mode > lhsBase {
-		log.Panicln("compileBasicLit: can't be lhs (left hand side) expr.")
+		logPanic("compileBasicLit: can't be lhs (left hand side) expr.")
 	}
 	kind, n := astutil.ToConst(v)
 	ret := &constVal{v: n, kind: kind, reserve: -1}
@@ -231,7 +480,13 @@ func compileBasicLit(ctx *blockCtx, v *ast.BasicLit, mode compleMode) {
 
 func compileBinaryExpr(ctx *blockCtx, v *ast.BinaryExpr, mode compleMode) {
 	if mode > lhsBase {
-		log.Panicln("compileBinaryExpr: can't be lhs (left hand side) expr.")
+		logPanic("compileBinaryExpr: can't be lhs (left hand side) expr.")
+	}
+	if v.Op == token.EQL || v.Op == token.NEQ {
+		if x, ok := nilCompared(ctx, v); ok {
+			compileNilCompare(ctx, v, x, mode)
+			return
+		}
 	}
 	compileExpr(ctx, v.X, inferOnly)
 	compileExpr(ctx, v.Y, inferOnly)
@@ -244,11 +499,14 @@ func compileBinaryExpr(ctx *blockCtx, v *ast.BinaryExpr, mode compleMode) {
 		ret := binaryOp(op, xcons, ycons)
 		ctx.infer.Ret(2, ret)
 		if mode != inferOnly {
-			ret.reserve = ctx.out.Reserve()
+			ret.push(ctx.out)
 		}
 		return
 	}
 	kind, ret := binaryOpResult(op, x, y)
+	if (op == exec.OpEQ || op == exec.OpNE) && !xok && !yok {
+		checkComparable(op, x.(iValue), y.(iValue))
+	}
 	if mode == inferOnly {
 		ctx.infer.Ret(2, ret)
 		return
@@ -262,24 +520,135 @@ func compileBinaryExpr(ctx *blockCtx, v *ast.BinaryExpr, mode compleMode) {
 	ctx.infer.Ret(4, ret)
 }
 
+// nilCompared returns the operand that is compared to the predeclared identifier nil,
+// if v is in the form of x == nil, x != nil, nil == x or nil != x.
+func nilCompared(ctx *blockCtx, v *ast.BinaryExpr) (ast.Expr, bool) {
+	if isNilIdent(ctx, v.Y) {
+		return v.X, true
+	}
+	if isNilIdent(ctx, v.X) {
+		return v.Y, true
+	}
+	return nil, false
+}
+
+func isNilIdent(ctx *blockCtx, e ast.Expr) bool {
+	if ident, ok := e.(*ast.Ident); ok && ident.Name == "nil" {
+		_, ok = ctx.find("nil") // nil isn't redeclared
+		return !ok
+	}
+	return false
+}
+
+// compileNilCompare compiles x == nil or x != nil, where x is a pointer, channel, func,
+// interface, map, slice or unsafe.Pointer.
+func compileNilCompare(ctx *blockCtx, v *ast.BinaryExpr, x ast.Expr, mode compleMode) {
+	if isNilIdent(ctx, x) {
+		logPanic("compileBinaryExpr failed: invalid operation", v.Op, "- operator not defined on nil")
+	}
+	compileExpr(ctx, x, inferOnly)
+	vx, ok := ctx.infer.Get(-1).(iValue)
+	if !ok || vx.NumValues() != 1 {
+		logPanic("compileBinaryExpr failed: operand of", v.Op, "nil isn't a single value")
+	}
+	var kind reflect.Kind
+	if _, ok = vx.(*constVal); !ok {
+		kind = vx.Kind()
+	}
+	switch kind {
+	case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice, reflect.UnsafePointer:
+	default:
+		logPanic("compileBinaryExpr failed: mismatched types", vx.Type(), "and nil")
+	}
+	ctx.infer.Ret(1, &goValue{t: exec.TyBool})
+	if mode == inferOnly {
+		return
+	}
+	compileExpr(ctx, x, 0)
+	ctx.infer.PopN(1)
+	op := exec.OpEQNil
+	if v.Op == token.NEQ {
+		op = exec.OpNENil
+	}
+	ctx.out.BuiltinOp(kind, op)
+}
+
+func compileUnaryExpr(ctx *blockCtx, v *ast.UnaryExpr, mode compleMode) {
+	if mode > lhsBase {
+		logPanic("compileUnaryExpr: can't be lhs (left hand side) expr.")
+	}
+	switch v.Op {
+	case token.ADD: // +x
+		compileExpr(ctx, v.X, mode)
+		return
+	case token.AND: // &x
+		compileAddrExpr(ctx, v, mode)
+		return
+	case token.ARROW: // <-ch
+		compileRecvExpr(ctx, v, false, mode)
+		return
+	}
+	op, ok := unaryOps[v.Op]
+	if !ok {
+		logPanic("compileUnaryExpr failed: unknown operator -", v.Op)
+	}
+	compileExpr(ctx, v.X, inferOnly)
+	x := ctx.infer.Get(-1)
+	if xcons, xok := x.(*constVal); xok { // op <const>
+		ret := unaryOp(op, xcons)
+		ctx.infer.Ret(1, ret)
+		if mode != inferOnly {
+			ret.push(ctx.out)
+		}
+		return
+	}
+	vx := x.(iValue)
+	if vx.NumValues() != 1 {
+		logPanic("unaryOp: argument isn't an expr.")
+	}
+	kind := vx.Kind()
+	if (op.GetInfo().InFirst & (1 << kind)) == 0 {
+		logPanic("compileUnaryExpr failed: invalid operator", op, "argument type -", vx.Type())
+	}
+	if mode == inferOnly {
+		return
+	}
+	ctx.infer.PopN(1)
+	compileExpr(ctx, v.X, 0)
+	ctx.out.BuiltinOp(kind, op)
+}
+
+var unaryOps = map[token.Token]exec.Operator{
+	token.SUB: exec.OpNeg,
+	token.NOT: exec.OpNot,
+	token.XOR: exec.OpBitNot,
+}
+
 func binaryOpResult(op exec.Operator, x, y interface{}) (exec.Kind, iValue) {
 	vx := x.(iValue)
 	vy := y.(iValue)
 	if vx.NumValues() != 1 || vy.NumValues() != 1 {
-		log.Panicln("binaryOp: argument isn't an expr.")
+		logPanic("binaryOp: argument isn't an expr.")
 	}
 	kind := vx.Kind()
 	if !astutil.IsConstBound(kind) {
 		kind = vy.Kind()
 		if !astutil.IsConstBound(kind) {
-			log.Panicln("binaryOp: expect x, y aren't const values either.")
+			logPanic("binaryOp: expect x, y aren't const values either.")
 		}
 	}
-	i := op.GetInfo()
-	if i.Out != exec.SameAsFirst {
-		kind = i.Out
+	kindRet := kind
+	if i := op.GetInfo(); i.Out != exec.SameAsFirst {
+		kindRet = i.Out
+	}
+	if kindRet != kind {
+		return kind, &goValue{t: exec.TypeFromKind(kindRet)}
+	}
+	decl := declOf(vx)
+	if decl == nil {
+		decl = declOf(vy)
 	}
-	return kind, &goValue{t: exec.TypeFromKind(kind)}
+	return kind, &goValue{t: exec.TypeFromKind(kindRet), decl: decl}
 }
 
 var binaryOps = [...]exec.Operator{
@@ -306,7 +675,14 @@ var binaryOps = [...]exec.Operator{
 
 func compileCallExpr(ctx *blockCtx, v *ast.CallExpr, mode compleMode) {
 	if mode > lhsBase {
-		log.Panicln("compileCallExpr: can't be lhs (left hand side) expr.")
+		logPanic("compileCallExpr: can't be lhs (left hand side) expr.")
+	}
+	if compileBuiltinCall(ctx, v, mode) {
+		return
+	}
+	if decl := toNamedDecl(ctx, v.Fun); decl != nil {
+		compileTypeConv(ctx, decl, v, mode)
+		return
 	}
 	compileExpr(ctx, v.Fun, inferOnly)
 	fn := ctx.infer.Get(-1)
@@ -317,20 +693,49 @@ func compileCallExpr(ctx *blockCtx, v *ast.CallExpr, mode compleMode) {
 			ctx.infer.Ret(1, ret)
 			return
 		}
-		for _, arg := range v.Args {
-			compileExpr(ctx, arg, 0)
-		}
+		compileArgs(ctx, v.Args, vfn.Proto(), 0)
 		out := ctx.out
 		nargs := uint32(len(v.Args))
 		args := ctx.infer.GetArgs(nargs)
 		arity := checkFuncCall(vfn.Proto(), 0, args, out)
 		fun := vfn.FuncInfo()
+		if vfn.cells != nil { // it requires a closure to capture the cells
+			compileClosure(ctx, (*funcDecl)(vfn))
+			ctx.beforeCall(v)
+			out.CallGoClosure(arity)
+		} else {
+			ctx.beforeCall(v)
+			if fun.IsVariadic() {
+				out.CallFuncv(fun, arity)
+			} else {
+				out.CallFunc(fun)
+			}
+		}
+		ctx.infer.Ret(uint32(len(v.Args)+1), ret)
+		return
+	case *qlMethod:
+		ret := vfn.Results()
+		if mode == inferOnly {
+			ctx.infer.Ret(1, ret)
+			return
+		}
+		recv := v.Fun.(*ast.SelectorExpr).X
+		compileExpr(ctx, recv, inferOnly)
+		in := ctx.infer.Get(-1).(iValue)
+		compileRecv(ctx, recv, in.Type(), vfn)
+		compileArgs(ctx, v.Args, vfn.Proto(), 1)
+		out := ctx.out
+		nargs := uint32(len(v.Args))
+		args := ctx.infer.GetArgs(nargs)
+		arity := checkFuncCall(vfn.Proto(), 1, args, out)
+		fun := vfn.FuncInfo()
+		ctx.beforeCall(v)
 		if fun.IsVariadic() {
 			out.CallFuncv(fun, arity)
 		} else {
 			out.CallFunc(fun)
 		}
-		ctx.infer.Ret(uint32(len(v.Args)+1), ret)
+		ctx.infer.Ret(uint32(len(v.Args)+2), ret)
 		return
 	case *goFunc:
 		ret := vfn.Results()
@@ -341,13 +746,12 @@ func compileCallExpr(ctx *blockCtx, v *ast.CallExpr, mode compleMode) {
 		if vfn.isMethod != 0 {
 			compileExpr(ctx, v.Fun.(*ast.SelectorExpr).X, 0)
 		}
-		for _, arg := range v.Args {
-			compileExpr(ctx, arg, 0)
-		}
+		compileArgs(ctx, v.Args, vfn.Proto(), vfn.isMethod)
 		nargs := uint32(len(v.Args))
 		args := ctx.infer.GetArgs(nargs)
 		out := ctx.out
 		arity := checkFuncCall(vfn.Proto(), vfn.isMethod, args, out)
+		ctx.beforeCall(v)
 		switch vfn.kind {
 		case exec.SymbolFunc:
 			out.CallGoFunc(exec.GoFuncAddr(vfn.addr))
@@ -358,28 +762,50 @@ func compileCallExpr(ctx *blockCtx, v *ast.CallExpr, mode compleMode) {
 		return
 	case *goValue:
 		if vfn.t.Kind() != reflect.Func {
-			log.Panicln("compileCallExpr failed: call a non function.")
+			logPanic("compileCallExpr failed: call a non function.")
 		}
 		ret := newFuncResults(vfn.t)
 		if mode == inferOnly {
 			ctx.infer.Ret(1, ret)
 			return
 		}
-		for _, arg := range v.Args {
-			compileExpr(ctx, arg, 0)
-		}
-		compileExpr(ctx, v.Fun, 0)
+		compileArgs(ctx, v.Args, vfn.t, 0)
 		nargs := uint32(len(v.Args))
 		args := ctx.infer.GetArgs(nargs)
 		arity := checkFuncCall(vfn.t, 0, args, ctx.out)
+		compileExpr(ctx, v.Fun, 0)
+		ctx.beforeCall(v)
 		ctx.out.CallGoClosure(arity)
 		ctx.infer.Ret(uint32(len(v.Args)+2), ret)
 		return
 	}
-	log.Panicln("compileCallExpr failed: unknown -", reflect.TypeOf(fn))
+	logPanic("compileCallExpr failed: unknown -", reflect.TypeOf(fn))
+}
+
+// compileTypeConv compiles a conversion T(x), where T is a declared non-struct type.
+// T has the same type as its underlying type (see checkDeclAssign), so x must be of
+// that type, and only the declared type of the value is changed.
+func compileTypeConv(ctx *blockCtx, decl *typeDecl, v *ast.CallExpr, mode compleMode) {
+	if len(v.Args) != 1 {
+		logPanic("compileTypeConv failed: conversion to", decl.spec.Name.Name, "requires exactly 1 argument.")
+	}
+	t := toType(ctx, v.Fun)
+	compileExpr(ctx, v.Args[0], mode)
+	x := ctx.infer.Get(-1)
+	if xv, ok := x.(iValue); !ok || xv.NumValues() != 1 {
+		logPanic("compileTypeConv failed: argument isn't an expr.")
+	}
+	if _, ok := x.(*constVal); !ok || mode != inferOnly {
+		checkType(t, x, ctx.out)
+	}
+	ctx.infer.Ret(1, &goValue{t: t, decl: decl})
 }
 
 func compileSelectorExpr(ctx *blockCtx, v *ast.SelectorExpr, mode compleMode) {
+	if decl, pointer := toMethodRecv(ctx, v.X); decl != nil { // T.M or (*T).M
+		compileMethodExpr(ctx, decl, pointer, v.Sel.Name, mode)
+		return
+	}
 	compileExpr(ctx, v.X, inferOnly)
 	x := ctx.infer.Get(-1)
 	switch vx := x.(type) {
@@ -388,44 +814,328 @@ func compileSelectorExpr(ctx *blockCtx, v *ast.SelectorExpr, mode compleMode) {
 		case *exec.GoPackage:
 			addr, kind, ok := nv.Find(v.Sel.Name)
 			if !ok {
-				log.Panicln("compileSelectorExpr: not found -", nv.PkgPath, v.Sel.Name)
+				logPanic("compileSelectorExpr: not found -", nv.PkgPath, v.Sel.Name)
 			}
 			switch kind {
 			case exec.SymbolFunc, exec.SymbolFuncv:
-				ctx.infer.Ret(1, newGoFunc(addr, kind, 0))
+				ctx.infer.Ret(1, newGoFunc(ctx.reg, addr, kind, 0))
 				if mode == inferOnly {
 					return
 				}
-				log.Panicln("compileSelectorExpr: todo")
+				logPanic("compileSelectorExpr: todo")
+			case exec.SymbolVar:
+				compileGoVar(ctx, exec.GoVarAddr(addr), mode)
 			default:
-				log.Panicln("compileSelectorExpr: unknown GoPackage symbol kind -", kind)
+				logPanic("compileSelectorExpr: unknown GoPackage symbol kind -", kind)
 			}
 		default:
-			log.Panicln("compileSelectorExpr: unknown nonValue -", reflect.TypeOf(nv))
+			logPanic("compileSelectorExpr: unknown nonValue -", reflect.TypeOf(nv))
 		}
 	case *goValue:
 		n, t := countPtr(vx.t)
 		name := v.Sel.Name
-		if sf, ok := t.FieldByName(name); ok {
-			log.Panicln("compileSelectorExpr todo: structField -", t, sf)
+		if sf, ok := findField(n, t, name); ok {
+			compileField(ctx, v, t, sf, mode)
+			return
+		}
+		if m := ctx.findMethod(vx.t, vx.decl, name); m != nil {
+			compileMethod(ctx, v, vx.t, m, mode)
+			return
 		}
 		pkgPath, method := normalizeMethod(n, t, name)
-		pkg := exec.FindGoPackage(pkgPath)
-		if pkg == nil {
-			log.Panicln("compileSelectorExpr failed: package not found -", pkgPath)
+		if pkgPath == "" { // not a type of a Go package, eg. a declared type or int
+			logPanic("compileSelectorExpr failed: type", declString(vx.decl, vx.t), "has no field or method", name)
 		}
+		pkg := ctx.findGoPackage(pkgPath)
 		addr, kind, ok := pkg.Find(method)
 		if !ok {
-			log.Panicln("compileSelectorExpr: method not found -", method)
+			logPanic("compileSelectorExpr: method not found -", method)
 		}
-		ctx.infer.Ret(1, newGoFunc(addr, kind, 1))
+		ctx.infer.Ret(1, newGoFunc(ctx.reg, addr, kind, 1))
 		if mode == inferOnly {
 			return
 		}
-		log.Panicln("compileSelectorExpr: todo")
+		logPanic("compileSelectorExpr: todo")
+	case *constVal: // a method of a typed constant of a declared type
+		if vx.decl != nil {
+			if m := ctx.findMethod(vx.Type(), vx.decl, v.Sel.Name); m != nil {
+				compileMethod(ctx, v, vx.Type(), m, mode)
+				return
+			}
+		}
+		logPanic("compileSelectorExpr failed: method not found -", v.Sel.Name)
 	default:
-		log.Panicln("compileSelectorExpr failed: unknown -", reflect.TypeOf(vx))
+		logPanic("compileSelectorExpr failed: unknown -", reflect.TypeOf(vx))
+	}
+}
+
+// compileGoVar compiles a variable of a Go package, whose package is on the top of
+// the infer stack.
+func compileGoVar(ctx *blockCtx, addr exec.GoVarAddr, mode compleMode) {
+	t := reflect.TypeOf(ctx.reg.GoVarInfo(addr).Addr).Elem()
+	if mode > lhsBase {
+		if mode == lhsDefine {
+			logPanic("compileGoVar failed: non-name on left side of :=")
+		}
+		ctx.infer.PopN(1)
+		checkType(t, ctx.infer.Get(-1), ctx.out)
+		ctx.infer.PopN(1)
+		ctx.out.StoreGoVar(addr)
+		return
+	}
+	ctx.infer.Ret(1, &goValue{t: t})
+	if mode == inferOnly {
+		return
+	}
+	ctx.out.LoadGoVar(addr)
+}
+
+// findField finds a field (or a promoted field of an embedded struct) of a struct,
+// or a pointer to a struct, which is t with n levels of pointer.
+func findField(n int, t reflect.Type, name string) (sf reflect.StructField, ok bool) {
+	if n > 1 || t.Kind() != reflect.Struct {
+		return
+	}
+	return t.FieldByName(name)
+}
+
+// compileField compiles a selector expression `x.f` that denotes a field f of x,
+// where x is a struct of type t or a pointer to it.
+func compileField(ctx *blockCtx, v *ast.SelectorExpr, t reflect.Type, sf reflect.StructField, mode compleMode) {
+	if sf.PkgPath != "" && sf.PkgPath != pkgPathScript {
+		logPanic("compileField failed: cannot refer to unexported field -", v.Sel.Name)
+	}
+	if mode > lhsBase {
+		if mode == lhsDefine {
+			logPanic("compileField failed: non-name on left side of :=")
+		}
+		ctx.infer.PopN(1)
+		compileFieldAddr(ctx, v.X, sf.Index)
+		checkType(sf.Type, ctx.infer.Get(-1), ctx.out)
+		ctx.infer.PopN(1)
+		ctx.out.AddrOp(sf.Type.Kind(), exec.OpAssign)
+		return
+	}
+	ctx.infer.Ret(1, &goValue{t: sf.Type, decl: ctx.fieldDecl(t, sf.Index)})
+	if mode == inferOnly {
+		return
+	}
+	compileExpr(ctx, v.X, 0)
+	ctx.infer.PopN(1)
+	for _, idx := range sf.Index { // fields of embedded structs are dereferenced automatically
+		ctx.out.LoadField(idx)
+	}
+}
+
+// compileMethod compiles a selector expression `x.M` that denotes a method M of x.
+func compileMethod(ctx *blockCtx, v *ast.SelectorExpr, xt reflect.Type, m *qlMethod, mode compleMode) {
+	if mode > lhsBase {
+		logPanic("compileMethod: can't be lhs (left hand side) expr.")
+	}
+	ctx.use(m.fn)
+	if mode == inferOnly {
+		ctx.infer.Ret(1, m)
+		return
+	}
+	compileRecv(ctx, v.X, xt, m) // a method value: x.M
+	ctx.out.GoMethodClosure(m.FuncInfo())
+	ctx.infer.Ret(1, &goValue{t: m.Type()})
+}
+
+// compileRecv pushes the receiver of method m, where x is an expression of type xt.
+// It takes address of x (or dereferences x) automatically if needed.
+func compileRecv(ctx *blockCtx, x ast.Expr, xt reflect.Type, m *qlMethod) {
+	t := xt // type of the embedded field (or x itself) that m belongs to
+	_, st := countPtr(t)
+	for _, idx := range m.index {
+		t = st.Field(idx).Type
+		_, st = countPtr(t)
+	}
+	if m.pointer && t.Kind() != reflect.Ptr {
+		if len(m.index) == 0 {
+			compileAddrOf(ctx, x)
+		} else {
+			compileFieldAddr(ctx, x, m.index)
+		}
+		return
+	}
+	compileExpr(ctx, x, 0)
+	ctx.infer.PopN(1)
+	for _, idx := range m.index {
+		ctx.out.LoadField(idx)
+	}
+	if !m.pointer && t.Kind() == reflect.Ptr {
+		ctx.out.AddrOp(st.Kind(), exec.OpAddrVal)
+	}
+}
+
+// toMethodRecv checks if x is T or (*T) where T is a declared type, that is, the
+// receiver of a method expression.
+func toMethodRecv(ctx *blockCtx, x ast.Expr) (decl *typeDecl, pointer bool) {
+	if paren, ok := x.(*ast.ParenExpr); ok {
+		x = paren.X
+		if star, ok := x.(*ast.StarExpr); ok {
+			x, pointer = star.X, true
+		}
+	}
+	if ident, ok := x.(*ast.Ident); ok {
+		if sym, ok := ctx.find(ident.Name); ok {
+			decl, _ = sym.(*typeDecl)
+		}
+	}
+	return
+}
+
+// compileMethodExpr compiles a method expression `T.M` or `(*T).M`, which is a
+// function whose first argument is the receiver.
+func compileMethodExpr(ctx *blockCtx, decl *typeDecl, pointer bool, name string, mode compleMode) {
+	if mode > lhsBase {
+		logPanic("compileMethodExpr: can't be lhs (left hand side) expr.")
+	}
+	decl.getType()
+	m, ok := decl.Methods[name]
+	if !ok {
+		logPanic("compileMethodExpr failed: method not found -", decl.spec.Name.Name, name)
+	}
+	if m.pointer > 0 && !pointer {
+		logPanic("compileMethodExpr failed: method", name, "has pointer receiver, use (*T)."+name, "instead.")
+	}
+	if m.pointer == 0 && pointer {
+		logPanic("compileMethodExpr: todo - (*T).M of value receiver method", name)
+	}
+	fn := m.getFunc(decl, name)
+	ctx.use(fn)
+	ctx.infer.Push(&goValue{t: fn.typeOf()})
+	if mode == inferOnly {
+		return
+	}
+	ctx.out.GoClosure(fn.getFuncInfo())
+}
+
+// compileFieldAddr pushes address of a field of x by its index sequence, where x is
+// an addressable struct or a pointer to a struct.
+func compileFieldAddr(ctx *blockCtx, x ast.Expr, index []int) {
+	compileExpr(ctx, x, inferOnly)
+	t := ctx.infer.Pop().(iValue).Type()
+	if t.Kind() == reflect.Ptr {
+		compileExpr(ctx, x, 0)
+		ctx.infer.PopN(1)
+		t = t.Elem()
+	} else {
+		compileAddrOf(ctx, x)
+	}
+	for i, idx := range index {
+		if i > 0 && t.Kind() == reflect.Ptr { // an embedded *T: address of the field is **T
+			ctx.out.AddrOp(reflect.Ptr, exec.OpAddrVal)
+			t = t.Elem()
+		}
+		ctx.out.AddrField(idx)
+		t = t.Field(idx).Type
+	}
+}
+
+// compileAddrOf pushes address of an addressable expression x, and returns type of x.
+func compileAddrOf(ctx *blockCtx, x ast.Expr) reflect.Type {
+	switch v := x.(type) {
+	case *ast.Ident:
+		sym, _ := ctx.find(v.Name)
+		switch addr := sym.(type) {
+		case *execVar:
+			if addr.cell {
+				ctx.out.LoadVar(addr.Var)
+			} else {
+				ctx.out.AddrVar(addr.Var)
+			}
+			return addr.getType()
+		case *stackVar:
+			logPanic("compileAddrOf: todo - address of argument", v.Name)
+		}
+	case *ast.ParenExpr:
+		return compileAddrOf(ctx, v.X)
+	case *ast.SelectorExpr:
+		compileExpr(ctx, v.X, inferOnly)
+		if in := ctx.infer.Pop().(iValue); in.NumValues() == 1 && in.Type() != nil {
+			n, t := countPtr(in.Type())
+			if sf, ok := findField(n, t, v.Sel.Name); ok {
+				compileFieldAddr(ctx, v.X, sf.Index)
+				return sf.Type
+			}
+		}
+	case *ast.StarExpr:
+		compileExpr(ctx, v.X, 0)
+		return checkPtr(ctx.infer.Pop().(iValue)).Elem()
+	}
+	logPanic("compileAddrOf failed: cannot take address of -", reflect.TypeOf(x))
+	return nil
+}
+
+// checkPtr checks if v is a pointer, and returns its type.
+func checkPtr(v iValue) reflect.Type {
+	if v.NumValues() == 1 {
+		if t := v.Type(); t != nil && t.Kind() == reflect.Ptr {
+			return t
+		}
+	}
+	logPanic("checkPtr failed: invalid indirect of a non-pointer value.")
+	return nil
+}
+
+// compileStarExpr compiles `*p`.
+func compileStarExpr(ctx *blockCtx, v *ast.StarExpr, mode compleMode) {
+	compileExpr(ctx, v.X, inferOnly)
+	x := ctx.infer.Get(-1).(iValue)
+	t := checkPtr(x).Elem()
+	if mode > lhsBase {
+		if mode == lhsDefine {
+			logPanic("compileStarExpr failed: non-name on left side of :=")
+		}
+		ctx.infer.PopN(1)
+		compileExpr(ctx, v.X, 0)
+		ctx.infer.PopN(1)
+		checkType(t, ctx.infer.Get(-1), ctx.out)
+		ctx.infer.PopN(1)
+		ctx.out.AddrOp(t.Kind(), exec.OpAssign)
+		return
+	}
+	ctx.infer.Ret(1, &goValue{t: t, decl: declOf(x)})
+	if mode == inferOnly {
+		return
+	}
+	compileExpr(ctx, v.X, 0)
+	ctx.infer.PopN(1)
+	ctx.out.AddrOp(t.Kind(), exec.OpAddrVal)
+}
+
+// compileAddrExpr compiles `&x`, where x is an addressable expression or a composite
+// literal.
+func compileAddrExpr(ctx *blockCtx, v *ast.UnaryExpr, mode compleMode) {
+	if mode > lhsBase {
+		logPanic("compileAddrExpr: can't be lhs (left hand side) expr.")
+	}
+	x := v.X
+	for {
+		paren, ok := x.(*ast.ParenExpr)
+		if !ok {
+			break
+		}
+		x = paren.X
+	}
+	if lit, ok := x.(*ast.CompositeLit); ok { // &T{...}
+		compileCompositeLit(ctx, lit, nil, mode)
+		t := ctx.infer.Get(-1).(iValue).Type()
+		if mode != inferOnly {
+			ctx.out.MakePtr(t)
+		}
+		ctx.infer.Ret(1, &goValue{t: reflect.PtrTo(t)})
+		return
+	}
+	compileExpr(ctx, x, inferOnly)
+	in := ctx.infer.Pop().(iValue)
+	t := in.Type()
+	if mode != inferOnly {
+		t = compileAddrOf(ctx, x)
 	}
+	ctx.infer.Push(&goValue{t: reflect.PtrTo(t), decl: declOf(in)})
 }
 
 func countPtr(t reflect.Type) (int, reflect.Type) {
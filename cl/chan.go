@@ -0,0 +1,157 @@
+package cl
+
+import (
+	"reflect"
+
+	"github.com/qiniu/qlang/ast"
+	"github.com/qiniu/qlang/exec"
+	"github.com/qiniu/qlang/token"
+)
+
+// -----------------------------------------------------------------------------
+
+var tyVoidFunc = reflect.TypeOf(func() {})
+
+// compileBuiltinCall compiles a call of the builtin function make or close, if
+// v is. It returns false if v isn't, or the name is shadowed by another symbol.
+func compileBuiltinCall(ctx *blockCtx, v *ast.CallExpr, mode compleMode) bool {
+	ident, ok := v.Fun.(*ast.Ident)
+	if !ok {
+		return false
+	}
+	if _, ok := ctx.find(ident.Name); ok {
+		return false
+	}
+	switch ident.Name {
+	case "make":
+		compileMake(ctx, v, mode)
+	case "close":
+		compileClose(ctx, v, mode)
+	default:
+		return false
+	}
+	return true
+}
+
+// compileMake compiles `make(chan T)` or `make(chan T, n)`.
+func compileMake(ctx *blockCtx, v *ast.CallExpr, mode compleMode) {
+	if mode > lhsBase {
+		logPanic("compileMake: can't be lhs (left hand side) expr.")
+	}
+	if n := len(v.Args); n < 1 || n > 2 {
+		logPanic("compileMake failed: invalid count of arguments -", n)
+	}
+	typ := toType(ctx, v.Args[0])
+	if typ == nil {
+		logPanic("compileMake failed: first argument isn't a type -", reflect.TypeOf(v.Args[0]))
+	}
+	if typ.Kind() != reflect.Chan {
+		logPanic("compileMake: todo - make", typ)
+	}
+	if mode == inferOnly {
+		ctx.infer.Push(&goValue{t: typ})
+		return
+	}
+	out := ctx.out
+	if len(v.Args) == 2 {
+		compileExpr(ctx, v.Args[1], 0)
+		checkType(exec.TyInt, ctx.infer.Pop(), out)
+	} else {
+		out.Push(0)
+	}
+	out.MakeChan(typ)
+	ctx.infer.Push(&goValue{t: typ})
+}
+
+// compileClose compiles `close(ch)`.
+func compileClose(ctx *blockCtx, v *ast.CallExpr, mode compleMode) {
+	if mode > lhsBase {
+		logPanic("compileClose: can't be lhs (left hand side) expr.")
+	}
+	if len(v.Args) != 1 {
+		logPanic("compileClose failed: invalid count of arguments -", len(v.Args))
+	}
+	ret := newFuncResults(tyVoidFunc)
+	if mode == inferOnly {
+		ctx.infer.Push(ret)
+		return
+	}
+	compileExpr(ctx, v.Args[0], 0)
+	checkChan(ctx.infer.Pop().(iValue), reflect.SendDir)
+	ctx.beforeCall(v)
+	ctx.out.ChanOp(exec.OpChanClose)
+	ctx.infer.Push(ret)
+}
+
+// checkChan checks if v is a channel that supports dir, and returns its type.
+func checkChan(v iValue, dir reflect.ChanDir) reflect.Type {
+	if v.NumValues() == 1 {
+		if t := v.Type(); t != nil && t.Kind() == reflect.Chan {
+			if t.ChanDir()&dir == 0 {
+				logPanic("checkChan failed: invalid operation on", t)
+			}
+			return t
+		}
+	}
+	logPanic("checkChan failed: channel operation on a non-channel value.")
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+
+// compileSendStmt compiles `ch <- v`.
+func compileSendStmt(ctx *blockCtx, v *ast.SendStmt) {
+	compileSendValues(ctx, v)
+	ctx.out.ChanOp(exec.OpChanSend)
+}
+
+// compileSendValues pushes the channel and the value to send of a send statement.
+func compileSendValues(ctx *blockCtx, v *ast.SendStmt) {
+	compileExpr(ctx, v.Chan, 0)
+	t := checkChan(ctx.infer.Pop().(iValue), reflect.SendDir)
+	compileExpr(ctx, v.Value, 0)
+	convIface(ctx, t.Elem())
+	checkType(t.Elem(), ctx.infer.Pop(), ctx.out)
+}
+
+// toRecvExpr checks if expr is a receive operation `<-ch`.
+func toRecvExpr(expr ast.Expr) (*ast.UnaryExpr, bool) {
+	for {
+		paren, ok := expr.(*ast.ParenExpr)
+		if !ok {
+			break
+		}
+		expr = paren.X
+	}
+	v, ok := expr.(*ast.UnaryExpr)
+	return v, ok && v.Op == token.ARROW
+}
+
+// compileRecvExpr compiles `<-ch`. If commaOk is true, it results two values like
+// `v, ok := <-ch`, where ok reports whether the value is sent before the channel
+// is closed.
+func compileRecvExpr(ctx *blockCtx, v *ast.UnaryExpr, commaOk bool, mode compleMode) {
+	if mode > lhsBase {
+		logPanic("compileRecvExpr: can't be lhs (left hand side) expr.")
+	}
+	compileExpr(ctx, v.X, inferOnly)
+	t := checkChan(ctx.infer.Pop().(iValue), reflect.RecvDir)
+	var ret iValue = &goValue{t: t.Elem()}
+	if commaOk { // like results of a function returning (T, bool)
+		ret = newFuncResults(reflect.FuncOf(nil, []reflect.Type{t.Elem(), exec.TyBool}, false))
+	}
+	if mode == inferOnly {
+		ctx.infer.Push(ret)
+		return
+	}
+	compileExpr(ctx, v.X, 0)
+	ctx.infer.PopN(1)
+	if commaOk {
+		ctx.out.ChanOp(exec.OpChanRecvOk)
+	} else {
+		ctx.out.ChanOp(exec.OpChanRecv)
+	}
+	ctx.infer.Push(ret)
+}
+
+// -----------------------------------------------------------------------------
@@ -0,0 +1,139 @@
+package cl
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/qiniu/qlang/ast/asttest"
+	"github.com/qiniu/qlang/exec"
+	"github.com/qiniu/qlang/parser"
+	"github.com/qiniu/qlang/token"
+)
+
+// -----------------------------------------------------------------------------
+
+var fsTestGoChan = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	func worker(jobs <-chan int, results chan<- int) {
+		for {
+			j, ok := <-jobs
+			if !ok {
+				break
+			}
+			results <- j * 2
+		}
+	}
+
+	jobs := make(chan int, 10)
+	results := make(chan int, 10)
+	for w := 0; w < 3; w++ {
+		go worker(jobs, results)
+	}
+	for i := 1; i <= 5; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	sum := 0
+	for i := 0; i < 5; i++ {
+		sum += <-results
+	}
+	println("sum:", sum)
+`)
+
+func TestGoChan(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestGoChan, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(8) { // sum: 30
+		t.Fatal("n:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestSelect = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	c := make(chan int)
+	quit := make(chan bool)
+	go func(c <-chan int, quit chan<- bool) {
+		sum := 0
+		for i := 0; i < 10; i++ {
+			sum += <-c
+		}
+		quit <- sum == 88
+	}(c, quit)
+
+	x, y := 0, 1
+	ret := false
+loop:
+	for {
+		select {
+		case c <- x:
+			x, y = y, x+y
+		case ok := <-quit:
+			ret = ok
+			break loop
+		}
+	}
+
+	e := make(chan string, 1)
+	s := ""
+	select {
+	case v := <-e:
+		s = v
+	default:
+		s = "default"
+	}
+	e <- "hi"
+	select {
+	case v, ok := <-e:
+		if ok {
+			s += v
+		}
+	default:
+	}
+	println(ret, x, s)
+`)
+
+func TestSelect(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestSelect, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(18) { // true 55 defaulthi
+		t.Fatal("n:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
@@ -4,8 +4,9 @@ import (
 	"errors"
 	"reflect"
 
+	"github.com/qiniu/qlang/ast"
 	"github.com/qiniu/qlang/exec"
-	"github.com/qiniu/x/log"
+	"github.com/qiniu/qlang/token"
 )
 
 type iFuncType interface {
@@ -48,9 +49,9 @@ func checkFuncCall(tfn iFuncType, isMethod int, args []interface{}, b *exec.Buil
 		n := arg.(iValue).NumValues()
 		if n != 1 {
 			if n == 0 {
-				log.Panicln("checkFuncCall:", ErrFuncArgNoReturnValue)
+				logPanic("checkFuncCall:", ErrFuncArgNoReturnValue)
 			} else {
-				log.Panicln("checkFuncCall:", ErrFuncArgCantBeMultiValue)
+				logPanic("checkFuncCall:", ErrFuncArgCantBeMultiValue)
 			}
 		}
 	}
@@ -60,9 +61,9 @@ func checkFuncCall(tfn iFuncType, isMethod int, args []interface{}, b *exec.Buil
 func checkBinaryOp(kind exec.Kind, op exec.Operator, x, y interface{}, b *exec.Builder) {
 	if xcons, xok := x.(*constVal); xok {
 		if xcons.reserve != -1 {
-			xv, ok := boundConst(xcons.v, exec.TypeFromKind(kind))
+			xv, ok := boundConst(xcons.v, constOperandType(kind))
 			if !ok {
-				log.Panicln("checkBinaryOp: invalid operator", kind, "argument type.")
+				logPanic("checkBinaryOp: invalid operator", kind, "argument type.")
 			}
 			xcons.reserve.Push(b, xv)
 		}
@@ -71,26 +72,192 @@ func checkBinaryOp(kind exec.Kind, op exec.Operator, x, y interface{}, b *exec.B
 		i := op.GetInfo()
 		if i.InSecond != (1 << exec.SameAsFirst) {
 			if (uint64(ycons.kind) & i.InSecond) == 0 {
-				log.Panicln("checkBinaryOp: invalid operator", kind, "argument type.")
+				logPanic("checkBinaryOp: invalid operator", kind, "argument type.")
 			}
 			kind = ycons.boundKind()
 		}
 		if ycons.reserve != -1 {
-			yv, ok := boundConst(ycons.v, exec.TypeFromKind(kind))
+			yv, ok := boundConst(ycons.v, constOperandType(kind))
 			if !ok {
-				log.Panicln("checkBinaryOp: invalid operator", kind, "argument type.")
+				logPanic("checkBinaryOp: invalid operator", kind, "argument type.")
 			}
 			ycons.reserve.Push(b, yv)
 		}
 	}
 }
 
+// constOperandType returns the type that a constant operand of a binary operator
+// is converted to, if the other operand is of kind.
+func constOperandType(kind exec.Kind) reflect.Type {
+	if kind == reflect.Interface { // the constant is compared with its default type
+		return exec.TyEmptyInterface
+	}
+	t := exec.TypeFromKind(kind)
+	if t == nil {
+		logPanic("checkBinaryOp: invalid operator", kind, "argument type.")
+	}
+	return t
+}
+
+// checkComparable checks operands x and y of == or != that aren't constants. They
+// must be comparable, and have the same type, unless one of them is an interface
+// that the other implements.
+func checkComparable(op exec.Operator, x, y iValue) {
+	tx, ty := x.Type(), y.Type()
+	if tx == ty || tx.Kind() <= reflect.Complex128 || tx.Kind() == reflect.String {
+		if !tx.Comparable() {
+			logPanic("invalid operation: operator", op, "not defined on", typeString(tx))
+		}
+		return
+	}
+	if !(tx.Kind() == reflect.Interface && ty.Implements(tx)) && !(ty.Kind() == reflect.Interface && tx.Implements(ty)) {
+		logPanic("invalid operation: mismatched types", typeString(tx), "and", typeString(ty))
+	}
+	if !tx.Comparable() || !ty.Comparable() {
+		logPanic("invalid operation: operator", op, "not defined on", typeString(tx))
+	}
+}
+
+// checkDeclAssign checks if v can be assigned to a variable of type t, whose declared
+// type is decl (see toNamedDecl). A declared non-struct type has the same type as
+// its underlying type (eg. Weekday and int in `type Weekday int`), so they are told
+// apart by their declarations: like Go, a value of another named type can't be
+// assigned without a conversion.
+func checkDeclAssign(decl *typeDecl, t reflect.Type, v interface{}) {
+	vdecl := declOf(v)
+	if vdecl == decl {
+		return
+	}
+	if _, ok := v.(*constVal); ok && vdecl == nil { // an untyped constant
+		return
+	}
+	if vt := v.(iValue).Type(); vt != t {
+		return
+	}
+	if decl == nil || vdecl == nil { // a named type of Go (eg. int), or an unnamed type (eg. []int)
+		if _, et := countPtr(t); et.Name() == "" {
+			return
+		}
+	}
+	logPanic("cannot use value of type", declString(vdecl, t), "as type", declString(decl, t), "in assignment")
+}
+
+// declString returns name of the declared type decl of a value of type t, or the
+// string of t if decl is nil.
+func declString(decl *typeDecl, t reflect.Type) string {
+	if decl == nil {
+		return typeString(t)
+	}
+	if t.Kind() == reflect.Ptr {
+		return "*" + decl.spec.Name.Name
+	}
+	return decl.spec.Name.Name
+}
+
 func checkType(t reflect.Type, v interface{}, b *exec.Builder) {
 	if cons, ok := v.(*constVal); ok {
 		cons.bound(t, b)
-	} else if t != v.(iValue).Type() {
-		log.Panicln("checkType failed: return mismatched value type.")
+	} else if !v.(iValue).Type().AssignableTo(t) {
+		logPanic("checkType failed: mismatched value type.")
+	}
+}
+
+// compileArgs compiles arguments of a function call. If the function requires a Go
+// interface, an argument is converted into a value of the interface automatically.
+func compileArgs(ctx *blockCtx, args []ast.Expr, tfn iFuncType, isMethod int) {
+	narg := tfn.NumIn() - isMethod
+	variadic := tfn.IsVariadic()
+	if variadic {
+		narg--
+	}
+	for idx, arg := range args {
+		compileExpr(ctx, arg, 0)
+		if variadic && idx >= narg {
+			convIface(ctx, tfn.In(narg+isMethod).Elem())
+		} else if idx < narg {
+			convIface(ctx, tfn.In(idx+isMethod))
+		}
+	}
+}
+
+// convIface converts the value on the top of the stack into a value of the Go
+// interface typ, if the value isn't one but it has methods of typ. It requires a
+// proxy of typ (see exec.RegisterInterfaces).
+func convIface(ctx *blockCtx, typ reflect.Type) {
+	if typ.Kind() != reflect.Interface || typ.NumMethod() == 0 {
+		return
+	}
+	in, ok := ctx.infer.Get(-1).(*goValue)
+	if !ok || in.t.AssignableTo(typ) {
+		return
+	}
+	if _, ok := exec.FindInterfaceProxy(typ); !ok {
+		logPanic("convIface failed: cannot use", in.t, "as", typ, "value (interface proxy not found).")
+	}
+	methods := make([]*exec.FuncInfo, typ.NumMethod())
+	var index [][]int // index sequences of embedded fields of promoted methods
+	for i := range methods {
+		im := typ.Method(i)
+		m := ctx.findMethod(in.t, in.decl, im.Name)
+		if m == nil {
+			logPanic("convIface failed:", in.t, "doesn't implement", typ, "- missing method", im.Name)
+		}
+		if m.pointer && !addressableRecv(in.t, m.index) {
+			logPanic("convIface failed:", in.t, "doesn't implement", typ, "- method", im.Name, "has pointer receiver")
+		}
+		if m.Type() != im.Type {
+			logPanic("convIface failed:", in.t, "doesn't implement", typ, "- wrong type for method", im.Name)
+		}
+		if len(m.index) != 0 {
+			if index == nil {
+				index = make([][]int, len(methods))
+			}
+			index[i] = m.index
+		}
+		ctx.use(m.fn)
+		methods[i] = m.FuncInfo()
+	}
+	ctx.out.MakeIface(typ, methods, index...)
+	ctx.infer.Ret(1, &goValue{t: typ})
+}
+
+// addressableRecv checks if the receiver of a method, that is promoted from an embedded
+// field of a value of type t by the index sequence, is addressable. So a method with
+// a pointer receiver is in the method set of t.
+func addressableRecv(t reflect.Type, index []int) bool {
+	if t.Kind() == reflect.Ptr {
+		return true
+	}
+	for _, idx := range index {
+		if t = t.Field(idx).Type; t.Kind() == reflect.Ptr {
+			return true
+		}
+	}
+	return false
+}
+
+// convAssign converts the value on the top of the stack into the type of lhs by
+// convIface. tok is ASSIGN or DEFINE; with DEFINE, only variables already declared
+// in the current block (eg. by `var w io.Writer = v`) have a type.
+func convAssign(ctx *blockCtx, lhs ast.Expr, tok token.Token) {
+	var typ reflect.Type
+	if ident, ok := lhs.(*ast.Ident); ok {
+		sym, ok := ctx.syms[ident.Name]
+		if !ok && tok == token.ASSIGN {
+			sym, _ = ctx.find(ident.Name)
+		}
+		v, ok := sym.(interface{ getType() reflect.Type })
+		if !ok {
+			return
+		}
+		typ = v.getType()
+	} else if tok == token.ASSIGN {
+		compileExpr(ctx, lhs, inferOnly)
+		typ = ctx.infer.Pop().(iValue).Type()
+	} else {
+		return
 	}
+	convIface(ctx, typ)
 }
 
 // -----------------------------------------------------------------------------
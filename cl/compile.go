@@ -4,13 +4,14 @@ import (
 	"errors"
 	"path"
 	"reflect"
+	"sort"
+	"strconv"
 	"syscall"
 
 	"github.com/qiniu/qlang/ast"
 	"github.com/qiniu/qlang/ast/astutil"
 	"github.com/qiniu/qlang/exec"
 	"github.com/qiniu/qlang/token"
-	"github.com/qiniu/x/log"
 )
 
 var (
@@ -33,14 +34,49 @@ var (
 // -----------------------------------------------------------------------------
 
 type pkgCtx struct {
-	infer   exec.Stack
-	builtin *exec.GoPackage
-	out     *exec.Builder
-	usedfns []*funcDecl
-}
-
-func newPkgCtx(out *exec.Builder) *pkgCtx {
-	p := &pkgCtx{builtin: exec.FindGoPackage(""), out: out}
+	infer     exec.Stack
+	builtin   *exec.GoPackage
+	importer  exec.Importer
+	reg       *exec.Registry
+	out       *exec.Builder
+	fset      *token.FileSet
+	errs      ErrorList
+	usedfns   []*funcDecl
+	inits     []*funcDecl                  // init functions
+	vars      []*varDecl                   // package-level variables
+	types     map[reflect.Type]*typeDecl   // declared struct types that have methods
+	fields    map[reflect.Type][]*typeDecl // declared types of fields of declared struct types, see toNamedDecl
+	nlits     map[*exec.FuncInfo]int       // count of function literals in a function
+	deferCall *ast.CallExpr                // the call of the defer (or go) statement being compiled
+	deferEnd  *exec.Label                  // the label after the call instruction of the defer (or go) statement
+	deferTok  token.Token                  // token.DEFER or token.GO
+	iota      int                          // value of iota in a const declaration, or -1 if it isn't in a const declaration
+	nstructs  int                          // count of declared struct types, see newNamedStruct
+}
+
+func newPkgCtx(out *exec.Builder, fset *token.FileSet, conf *Config) *pkgCtx {
+	var reg = exec.DefaultRegistry
+	var importer exec.Importer
+	if conf != nil {
+		if conf.Registry != nil {
+			reg = conf.Registry
+		}
+		importer = conf.Importer
+	}
+	if importer == nil {
+		importer = reg
+	}
+	builtin, err := importer.Import("")
+	if err != nil {
+		logPanic("newPkgCtx failed: builtin package -", err)
+	}
+	if out != nil { // a Session sets it later
+		out.SetRegistry(reg)
+	}
+	p := &pkgCtx{builtin: builtin, importer: importer, reg: reg, out: out, fset: fset, iota: -1}
+	p.types = make(map[reflect.Type]*typeDecl)
+	p.fields = make(map[reflect.Type][]*typeDecl)
+	p.nlits = make(map[*exec.FuncInfo]int)
 	p.infer.Init()
 	return p
 }
@@ -53,7 +89,88 @@ func (p *pkgCtx) use(f *funcDecl) {
 	f.used = true
 }
 
-func (p *pkgCtx) resolveFuncs() {
+// registerMethods checks methods of a declared type t. Methods of a struct type are
+// found by its type, and methods of other types are found by declared types of the
+// values (see toNamedDecl).
+func (p *pkgCtx) registerMethods(t reflect.Type, decl *typeDecl) {
+	name := decl.spec.Name.Name
+	if t.Kind() == reflect.Ptr || t.Kind() == reflect.Interface {
+		logPanic("registerMethods failed: invalid receiver type -", name)
+	}
+	if t.Kind() != reflect.Struct {
+		return
+	}
+	for method := range decl.Methods {
+		if _, ok := t.FieldByName(method); ok {
+			logPanic("registerMethods failed: type", name, "has both field and method named", method)
+		}
+	}
+	p.types[t] = decl
+}
+
+// fieldDecl returns the declared type (see toNamedDecl) of a field of struct t by
+// its index sequence.
+func (p *pkgCtx) fieldDecl(t reflect.Type, index []int) *typeDecl {
+	last := len(index) - 1
+	for _, idx := range index[:last] {
+		_, t = countPtr(t.Field(idx).Type)
+	}
+	if decls := p.fields[t]; decls != nil {
+		return decls[index[last]]
+	}
+	return nil
+}
+
+// findMethod finds a method of t (T or *T, where T is a declared type), including
+// a method promoted from an embedded field. decl is the declared type of the value
+// (see toNamedDecl). It returns nil if not found.
+func (p *pkgCtx) findMethod(t reflect.Type, decl *typeDecl, name string) *qlMethod {
+	type embedded struct {
+		t     reflect.Type
+		decl  *typeDecl
+		index []int
+	}
+	level := []embedded{{t: t, decl: decl}}
+	for len(level) > 0 { // breadth first: a shallower method hides the deeper ones
+		var found *qlMethod
+		var next []embedded
+		for _, e := range level {
+			n, et := countPtr(e.t)
+			if n > 1 {
+				continue
+			}
+			decl := e.decl
+			if decl == nil {
+				decl = p.types[et]
+			}
+			if decl != nil {
+				if m, ok := decl.Methods[name]; ok {
+					if found != nil {
+						logPanic("findMethod failed: ambiguous selector -", name)
+					}
+					found = &qlMethod{fn: m.getFunc(decl, name), pointer: m.pointer > 0, index: e.index}
+					continue
+				}
+			}
+			if et.Kind() == reflect.Struct {
+				for i, nf := 0, et.NumField(); i < nf; i++ {
+					if f := et.Field(i); f.Anonymous {
+						index := append(e.index[:len(e.index):len(e.index)], i)
+						next = append(next, embedded{t: f.Type, decl: p.fieldDecl(et, []int{i}), index: index})
+					}
+				}
+			}
+		}
+		if found != nil {
+			return found
+		}
+		level = next
+	}
+	return nil
+}
+
+// resolveFuncs compiles the used functions, and returns them.
+func (p *pkgCtx) resolveFuncs() (compiled []*funcDecl) {
 	for {
 		n := len(p.usedfns)
 		if n == 0 {
@@ -61,10 +178,54 @@ func (p *pkgCtx) resolveFuncs() {
 		}
 		f := p.usedfns[n-1]
 		p.usedfns = p.usedfns[:n-1]
-		f.compile()
+		p.compileFunc(f)
+		compiled = append(compiled, f)
+	}
+	return
+}
+
+// findGoPackage finds a Go package by the importer of this compilation.
+func (p *pkgCtx) findGoPackage(pkgPath string) *exec.GoPackage {
+	pkg, err := p.importer.Import(pkgPath)
+	if err != nil {
+		logPanic(err.Error()+":", strconv.Quote(pkgPath))
+	}
+	return pkg
+}
+
+func (p *pkgCtx) position(pos token.Pos) token.Position {
+	if p.fset == nil {
+		return token.Position{}
+	}
+	return p.fset.Position(pos)
+}
+
+// setPos sets the source position of instructions generated after it, so runtime
+// errors can be located.
+func (p *pkgCtx) setPos(node ast.Node) {
+	if p.fset != nil {
+		p.out.SetPos(p.fset.Position(node.Pos()))
 	}
 }
 
+// beforeCall is called before the call instruction of v is generated.
+func (p *pkgCtx) beforeCall(v *ast.CallExpr) {
+	p.setPos(v)
+	if p.deferCall == v { // it is the call of a defer (or go) statement
+		if p.deferTok == token.GO {
+			p.out.Go(p.deferEnd)
+		} else {
+			p.out.Defer(p.deferEnd)
+		}
+		p.deferCall = nil
+	}
+}
+
+func (p *pkgCtx) compileFunc(f *funcDecl) {
+	defer p.recoverErr(f.typ, p.infer.Len())
+	f.compile()
+}
+
 type fileCtx struct {
 	*blockCtx // it's global blockCtx
 	imports   map[string]string
@@ -81,45 +242,64 @@ func newFileCtx(block *blockCtx) *fileCtx {
 // - pkgName => pkgPath
 // - funcName => *funcDecl
 // - typeName => *typeDecl
+// - constName => *constDecl
 //
 type iSymbol = interface{}
 
 type iVar interface {
-	inCurrentCtx(ctx *blockCtx) bool
 	getType() reflect.Type
 }
 
-type execVar exec.Var
+type execVar struct {
+	*exec.Var
+	decl *typeDecl // see toNamedDecl
 
-func (p *execVar) inCurrentCtx(ctx *blockCtx) bool {
-	return ctx.out.InCurrentCtx((*exec.Var)(p))
+	// cell means the variable holds a pointer to its value, which is allocated
+	// when the variable is declared. It is a variable of a loop (its body or init
+	// statement) that may be captured by closures, so each iteration has its own
+	// one (see FuncInfo.Env).
+	cell bool
 }
 
 func (p *execVar) getType() reflect.Type {
+	if p.cell {
+		return p.Type.Elem()
+	}
 	return p.Type
 }
 
 type stackVar struct {
 	typ   reflect.Type
+	decl  *typeDecl // see toNamedDecl
 	index int32
 }
 
-func (p *stackVar) inCurrentCtx(ctx *blockCtx) bool {
-	return true
-}
-
 func (p *stackVar) getType() reflect.Type {
 	return p.typ
 }
 
+// varDeclOf returns the declared type of variable v, see toNamedDecl.
+func varDeclOf(v iVar) *typeDecl {
+	switch nv := v.(type) {
+	case *execVar:
+		return nv.decl
+	case *stackVar:
+		return nv.decl
+	}
+	return nil
+}
+
 // -----------------------------------------------------------------------------
 
 type blockCtx struct {
 	*pkgCtx
-	file   *fileCtx
-	parent *blockCtx
-	fun    *exec.FuncInfo
-	syms   map[string]iSymbol
+	file      *fileCtx
+	parent    *blockCtx
+	fun       *exec.FuncInfo
+	syms      map[string]iSymbol
+	flow      *flowCtx        // innermost for/switch statement, the target of break/continue
+	captured  map[string]bool // names that closures in a loop body refer to, see compileLoopBody
+	noExecCtx bool            // a normal block (if/for/switch, etc.) doesn't have its own exec.Context
 }
 
 func newBlockCtx(parent *blockCtx) *blockCtx {
@@ -131,6 +311,18 @@ func newBlockCtx(parent *blockCtx) *blockCtx {
 	}
 }
 
+func newNormBlockCtx(parent *blockCtx) *blockCtx {
+	return &blockCtx{
+		pkgCtx:    parent.pkgCtx,
+		file:      parent.file,
+		parent:    parent,
+		fun:       parent.fun,
+		syms:      make(map[string]iSymbol),
+		flow:      parent.flow,
+		noExecCtx: true,
+	}
+}
+
 func newGblBlockCtx(pkg *pkgCtx, parent *blockCtx) *blockCtx {
 	return &blockCtx{
 		pkgCtx: pkg,
@@ -140,12 +332,12 @@ func newGblBlockCtx(pkg *pkgCtx, parent *blockCtx) *blockCtx {
 }
 
 func (p *blockCtx) getNestDepth() (nestDepth uint32) {
-	for {
-		if p = p.parent; p == nil {
-			return
+	for ; p.parent != nil; p = p.parent {
+		if !p.noExecCtx {
+			nestDepth++
 		}
-		nestDepth++
 	}
+	return
 }
 
 func (p *blockCtx) exists(name string) (ok bool) {
@@ -202,7 +394,20 @@ func (p *blockCtx) findVar(name string) (addr iVar, err error) {
 	return nil, ErrSymbolNotVariable
 }
 
-func (p *blockCtx) insertFuncVars(in []reflect.Type, args []string, rets []*exec.Var) {
+// funcLitName returns a name of a function literal in the current function, like
+// `main.func1` in Go.
+func (p *blockCtx) funcLitName() string {
+	name := "main"
+	if p.fun != nil {
+		name = p.fun.Name
+	}
+	p.nlits[p.fun]++
+	return name + ".func" + strconv.Itoa(p.nlits[p.fun])
+}
+
+// insertFuncVars inserts arguments and results of a function. decls and retDecls
+// are their declared types (see toNamedDecl), or nil if none of them has one.
+func (p *blockCtx) insertFuncVars(in []reflect.Type, args []string, decls []*typeDecl, rets []*exec.Var, retDecls []*typeDecl) {
 	n := len(args)
 	if n > 0 {
 		for i := n - 1; i >= 0; i-- {
@@ -211,54 +416,132 @@ func (p *blockCtx) insertFuncVars(in []reflect.Type, args []string, rets []*exec
 				continue
 			}
 			if p.exists(name) {
-				log.Panicln("insertStkVars failed: symbol exists -", name)
+				logPanic("insertStkVars failed: symbol exists -", name)
+			}
+			v := &stackVar{index: int32(i - n), typ: in[i]}
+			if decls != nil {
+				v.decl = decls[i]
 			}
-			p.syms[name] = &stackVar{index: int32(i - n), typ: in[i]}
+			p.syms[name] = v
 		}
 	}
-	for _, ret := range rets {
+	for i, ret := range rets {
 		if ret.IsUnnamedOut() {
 			continue
 		}
-		p.syms[ret.Name()] = (*execVar)(ret)
+		v := &execVar{Var: ret}
+		if retDecls != nil {
+			v.decl = retDecls[i]
+		}
+		p.syms[ret.Name()] = v
+	}
+}
+
+// addressArgs copies the arguments whose addresses are taken in body (see addrNames)
+// into local variables, since arguments on the stack aren't addressable.
+func (p *blockCtx) addressArgs(body *ast.BlockStmt) {
+	names := addrNames(body)
+	args := make([]string, 0, len(names))
+	for name := range names {
+		if _, ok := p.syms[name].(*stackVar); ok {
+			args = append(args, name)
+		}
+	}
+	sort.Strings(args)
+	for _, name := range args {
+		arg := p.syms[name].(*stackVar)
+		delete(p.syms, name)
+		v := p.insertVar(name, arg.typ)
+		v.decl = arg.decl
+		p.out.Load(arg.index).StoreVar(v.Var)
 	}
 }
 
+// insertVar defines a variable. If it is a cell (see execVar.cell), the caller
+// allocates the cell by storeCell.
 func (p *blockCtx) insertVar(name string, typ reflect.Type) *execVar {
 	if p.exists(name) {
-		log.Panicln("insertVar failed: symbol exists -", name)
+		logPanic("insertVar failed: symbol exists -", name)
+	}
+	v := &execVar{Var: exec.NewVar(typ, name)}
+	if p.inLoopCaptured(name) {
+		v.Var, v.cell = exec.NewVar(reflect.PtrTo(typ), name), true
+	}
+	p.out.DefineVar(v.Var)
+	p.syms[name] = v
+	return v
+}
+
+// inLoopCaptured checks if a variable named name declared in this block is in a
+// loop body, and closures in the loop body may refer to it.
+func (p *blockCtx) inLoopCaptured(name string) bool {
+	for ; p.noExecCtx; p = p.parent {
+		if p.captured[name] {
+			return true
+		}
 	}
-	v := exec.NewVar(typ, name)
-	p.out.DefineVar(v)
-	p.syms[name] = (*execVar)(v)
-	return (*execVar)(v)
+	return false
+}
+
+// loopCells returns the cells (see execVar.cell) that a function literal which
+// refers to names may capture.
+func (p *blockCtx) loopCells(names map[string]bool) (cells []*execVar) {
+	seen := make(map[string]bool)
+	for ; p.noExecCtx; p = p.parent {
+		for name, sym := range p.syms {
+			if !names[name] || seen[name] {
+				continue
+			}
+			seen[name] = true
+			if v, ok := sym.(*execVar); ok && v.cell {
+				cells = append(cells, v)
+			}
+		}
+	}
+	sort.Slice(cells, func(i, j int) bool {
+		return cells[i].Name() < cells[j].Name()
+	})
+	return
+}
+
+// storeCell allocates a cell for variable v, and stores the value on the top of the
+// stack (nil means zero value) to it.
+func (p *blockCtx) storeCell(v *execVar) {
+	p.out.MakePtr(v.getType()).StoreVar(v.Var)
+}
+
+func (p *blockCtx) insertConst(name string, c *constDecl) {
+	if p.exists(name) {
+		logPanic("insertConst failed: symbol exists -", name)
+	}
+	p.syms[name] = c
 }
 
 func (p *blockCtx) insertFunc(name string, fun *funcDecl) {
 	if p.exists(name) {
-		log.Panicln("insertFunc failed: symbol exists -", name)
+		logPanic("insertFunc failed: symbol exists -", name)
 	}
 	p.syms[name] = fun
 }
 
 func (p *blockCtx) insertMethod(typeName, methodName string, method *methodDecl) {
 	if p.parent != nil {
-		log.Panicln("insertMethod failed: unexpected - non global method declaration?")
+		logPanic("insertMethod failed: unexpected - non global method declaration?")
 	}
 	typ, err := p.findType(typeName)
 	if err == ErrNotFound {
 		typ = new(typeDecl)
 		p.syms[typeName] = typ
 	} else if err != nil {
-		log.Panicln("insertMethod failed:", err)
+		logPanic("insertMethod failed:", err)
 	} else if typ.Alias {
-		log.Panicln("insertMethod failed: alias?")
+		logPanic("insertMethod failed: alias?")
 	}
 	if typ.Methods == nil {
 		typ.Methods = map[string]*methodDecl{methodName: method}
 	} else {
 		if _, ok := typ.Methods[methodName]; ok {
-			log.Panicln("insertMethod failed: method exists -", typeName, methodName)
+			logPanic("insertMethod failed: method exists -", typeName, methodName)
 		}
 		typ.Methods[methodName] = method
 	}
@@ -271,16 +554,49 @@ type Package struct {
 	syms map[string]iSymbol
 }
 
-// NewPackage creates a qlang package instance.
-func NewPackage(out *exec.Builder, pkg *ast.Package) (p *Package, err error) {
+// Config represents the configuration of compiling a qlang package.
+type Config struct {
+	// Registry is the registry of Go packages that the package is compiled with.
+	// It's recorded in the code, which is executed with it (see exec.NewContext).
+	// If it is nil, exec.DefaultRegistry is used.
+	Registry *exec.Registry
+
+	// Importer finds Go packages in Registry that the package imports. If it is
+	// nil, all Go packages of Registry can be imported.
+	Importer exec.Importer
+}
+
+// NewPackage creates a qlang package instance. fset is the file set that pkg is
+// parsed with, to locate compile errors. If there are compile errors, NewPackage
+// returns them as an ErrorList.
+func NewPackage(out *exec.Builder, pkg *ast.Package, fset *token.FileSet) (p *Package, err error) {
+	return NewPackageEx(out, pkg, fset, nil)
+}
+
+// NewPackageEx creates a qlang package instance, like NewPackage, with the
+// configuration conf (nil means the default configuration).
+func NewPackageEx(out *exec.Builder, pkg *ast.Package, fset *token.FileSet, conf *Config) (p *Package, err error) {
 	if pkg == nil {
-		log.Panicln("NewPackage failed: nil ast.Package")
+		logPanic("NewPackage failed: nil ast.Package")
 	}
 	p = &Package{}
-	ctxPkg := newPkgCtx(out)
+	ctxPkg := newPkgCtx(out, fset, conf)
 	ctx := newGblBlockCtx(ctxPkg, nil)
-	for _, f := range pkg.Files {
-		loadFile(ctx, f)
+	names := make([]string, 0, len(pkg.Files))
+	for name := range pkg.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		loadFile(ctx, pkg.Files[name])
+	}
+	for _, sym := range ctx.syms {
+		switch v := sym.(type) {
+		case *constDecl:
+			ctxPkg.evalConst(v)
+		case *typeDecl:
+			ctxPkg.resolveType(v)
+		}
 	}
 	if pkg.Name == "main" {
 		entry, err := ctx.findFunc("main")
@@ -290,38 +606,72 @@ func NewPackage(out *exec.Builder, pkg *ast.Package) (p *Package, err error) {
 			}
 			return p, err
 		}
+		initVars(ctx, ctxPkg.vars)
+		for _, f := range ctxPkg.inits {
+			ctx.use(f)
+			out.CallFunc(f.getFuncInfo())
+		}
 		ctx.file = entry.ctx.file
 		compileBlockStmt(ctx, entry.body)
 		out.Return(-1)
 		ctxPkg.resolveFuncs()
 	}
 	p.syms = ctx.syms
+	if len(ctxPkg.errs) > 0 {
+		ctxPkg.errs.RemoveMultiples()
+		return p, ctxPkg.errs
+	}
 	return
 }
 
+func (p *pkgCtx) evalConst(decl *constDecl) {
+	defer p.recoverErr(decl.expr, p.infer.Len())
+	decl.eval()
+}
+
+func (p *pkgCtx) resolveType(decl *typeDecl) {
+	var node ast.Node
+	if decl.spec != nil {
+		node = decl.spec
+	} else { // locate the error at the first method of the type
+		for _, m := range decl.Methods {
+			if node == nil || m.typ.Pos() < node.Pos() {
+				node = m.typ
+			}
+		}
+	}
+	defer p.recoverErr(node, p.infer.Len())
+	decl.getType()
+}
+
 func loadFile(ctx *blockCtx, f *ast.File) {
 	file := newFileCtx(ctx)
 	ctx.file = file
 	for _, decl := range f.Decls {
-		switch d := decl.(type) {
-		case *ast.FuncDecl:
-			loadFunc(ctx, d)
-		case *ast.GenDecl:
-			switch d.Tok {
-			case token.IMPORT:
-				loadImports(file, d)
-			case token.TYPE:
-				loadTypes(ctx, d)
-			case token.CONST:
-				loadConsts(ctx, d)
-			case token.VAR:
-				loadVars(ctx, d)
-			default:
-				log.Panicln("tok:", d.Tok, "spec:", reflect.TypeOf(d.Specs).Elem())
-			}
+		loadDecl(ctx, decl)
+	}
+}
+
+func loadDecl(ctx *blockCtx, decl ast.Decl) {
+	defer ctx.recoverErr(decl, ctx.infer.Len())
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		loadFunc(ctx, d)
+	case *ast.GenDecl:
+		switch d.Tok {
+		case token.IMPORT:
+			loadImports(ctx.file, d)
+		case token.TYPE:
+			loadTypes(ctx, d)
+		case token.CONST:
+			loadConsts(ctx, d)
+		case token.VAR:
+			loadVars(ctx, d)
 		default:
-			log.Panicln("gopkg.Package.load: unknown decl -", reflect.TypeOf(decl))
+			logPanic("tok:", d.Tok, "spec:", reflect.TypeOf(d.Specs).Elem())
 		}
+	default:
+		logPanic("gopkg.Package.load: unknown decl -", reflect.TypeOf(decl))
 	}
 }
 
@@ -332,7 +682,9 @@ func loadImports(ctx *fileCtx, d *ast.GenDecl) {
 }
 
 func loadImport(ctx *fileCtx, spec *ast.ImportSpec) {
+	defer locateErr(spec.Path)
 	var pkgPath = astutil.ToString(spec.Path)
+	ctx.findGoPackage(pkgPath)
 	var name string
 	if spec.Name != nil {
 		name = spec.Name.Name
@@ -353,9 +705,45 @@ func loadTypes(ctx *blockCtx, d *ast.GenDecl) {
 }
 
 func loadType(ctx *blockCtx, spec *ast.TypeSpec) {
+	defer locateErr(spec)
+	name := spec.Name.Name
+	if name == "_" {
+		return
+	}
+	decl, ok := ctx.syms[name].(*typeDecl)
+	if ok && decl.spec == nil { // methods of the type are declared before it
+		if spec.Assign.IsValid() {
+			logPanic("loadType failed: methods on alias type -", name)
+		}
+	} else if ctx.exists(name) {
+		logPanic("loadType failed: symbol exists -", name)
+	} else {
+		decl = new(typeDecl)
+		ctx.syms[name] = decl
+	}
+	decl.spec, decl.ctx = spec, newNormBlockCtx(ctx)
+	decl.Alias = spec.Assign.IsValid()
 }
 
 func loadConsts(ctx *blockCtx, d *ast.GenDecl) {
+	var last *ast.ValueSpec // the last spec with init expressions
+	for iota, item := range d.Specs {
+		spec := item.(*ast.ValueSpec)
+		if spec.Values != nil {
+			last = spec
+		} else if spec.Type != nil || last == nil {
+			logPanic("loadConsts failed: missing init expr for const declaration.")
+		}
+		if len(spec.Names) != len(last.Values) {
+			logPanic("loadConsts failed: missing or extra init expr for const declaration.")
+		}
+		for i, name := range spec.Names {
+			if name.Name == "_" {
+				continue
+			}
+			ctx.insertConst(name.Name, newConstDecl(ctx, last.Type, last.Values[i], iota))
+		}
+	}
 }
 
 func loadVars(ctx *blockCtx, d *ast.GenDecl) {
@@ -364,9 +752,6 @@ func loadVars(ctx *blockCtx, d *ast.GenDecl) {
 	}
 }
 
-func loadVar(ctx *blockCtx, spec *ast.ValueSpec) {
-}
-
 func loadFunc(ctx *blockCtx, d *ast.FuncDecl) {
 	var name = d.Name.Name
 	if d.Recv != nil {
@@ -379,7 +764,11 @@ func loadFunc(ctx *blockCtx, d *ast.FuncDecl) {
 			file:    ctx.file,
 		})
 	} else if name == "init" {
-		log.Panicln("loadFunc TODO: init")
+		if d.Type.Params.NumFields() != 0 || d.Type.Results != nil {
+			logPanic("loadFunc failed: func init must have no arguments and no return values.")
+		}
+		funCtx := newBlockCtx(ctx)
+		ctx.inits = append(ctx.inits, newFuncDecl(name, d.Type, d.Body, funCtx))
 	} else {
 		funCtx := newBlockCtx(ctx)
 		ctx.insertFunc(name, newFuncDecl(name, d.Type, d.Body, funCtx))
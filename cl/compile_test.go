@@ -2,6 +2,7 @@ package cl
 
 import (
 	"fmt"
+	"os"
 	"testing"
 
 	"github.com/qiniu/qlang/ast/asttest"
@@ -12,6 +13,9 @@ import (
 
 	_ "github.com/qiniu/qlang/lib/builtin"
 	_ "github.com/qiniu/qlang/lib/fmt"
+	_ "github.com/qiniu/qlang/lib/io"
+	_ "github.com/qiniu/qlang/lib/os"
+	_ "github.com/qiniu/qlang/lib/sort"
 	_ "github.com/qiniu/qlang/lib/strings"
 )
 
@@ -36,7 +40,7 @@ func TestBasic(t *testing.T) {
 
 	bar := pkgs["main"]
 	b := exec.NewBuilder(nil)
-	_, err = NewPackage(b, bar)
+	_, err = NewPackage(b, bar, fset)
 	if err != nil {
 		t.Fatal("Compile failed:", err)
 	}
@@ -68,7 +72,7 @@ func TestUnbound(t *testing.T) {
 
 	bar := pkgs["main"]
 	b := exec.NewBuilder(nil)
-	_, err = NewPackage(b, bar)
+	_, err = NewPackage(b, bar, fset)
 	if err != nil {
 		t.Fatal("Compile failed:", err)
 	}
@@ -100,7 +104,7 @@ func TestOperator(t *testing.T) {
 
 	bar := pkgs["main"]
 	b := exec.NewBuilder(nil)
-	_, err = NewPackage(b, bar)
+	_, err = NewPackage(b, bar, fset)
 	if err != nil {
 		t.Fatal("Compile failed:", err)
 	}
@@ -133,7 +137,7 @@ func TestVar(t *testing.T) {
 
 	bar := pkgs["main"]
 	b := exec.NewBuilder(nil)
-	_, err = NewPackage(b, bar)
+	_, err = NewPackage(b, bar, fset)
 	if err != nil {
 		t.Fatal("Compile failed:", err)
 	}
@@ -169,7 +173,7 @@ func TestVarOp(t *testing.T) {
 
 	bar := pkgs["main"]
 	b := exec.NewBuilder(nil)
-	_, err = NewPackage(b, bar)
+	_, err = NewPackage(b, bar, fset)
 	if err != nil {
 		t.Fatal("Compile failed:", err)
 	}
@@ -205,7 +209,7 @@ func TestGoPackage(t *testing.T) {
 
 	bar := pkgs["main"]
 	b := exec.NewBuilder(nil)
-	_, err = NewPackage(b, bar)
+	_, err = NewPackage(b, bar, fset)
 	if err != nil {
 		t.Fatal("Compile failed:", err)
 	}
@@ -246,7 +250,7 @@ func TestReturn(t *testing.T) {
 
 	bar := pkgs["main"]
 	b := exec.NewBuilder(nil)
-	_, err = NewPackage(b, bar)
+	_, err = NewPackage(b, bar, fset)
 	if err != nil {
 		t.Fatal("Compile failed:", err)
 	}
@@ -285,7 +289,7 @@ func TestFunc(t *testing.T) {
 
 	bar := pkgs["main"]
 	b := exec.NewBuilder(nil)
-	_, err = NewPackage(b, bar)
+	_, err = NewPackage(b, bar, fset)
 	if err != nil {
 		t.Fatal("Compile failed:", err)
 	}
@@ -325,7 +329,7 @@ func TestClosure(t *testing.T) {
 
 	bar := pkgs["main"]
 	b := exec.NewBuilder(nil)
-	_, err = NewPackage(b, bar)
+	_, err = NewPackage(b, bar, fset)
 	if err != nil {
 		t.Fatal("Compile failed:", err)
 	}
@@ -343,3 +347,206 @@ func TestClosure(t *testing.T) {
 }
 
 // -----------------------------------------------------------------------------
+
+var fsTestRegistry = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	import "host"
+
+	println(host.Greet("qlang"))
+`)
+
+func execHostGreet(arity uint32, p *exec.Context) {
+	args := p.GetArgs(1)
+	p.Ret(1, "Hello, "+args[0].(string))
+}
+
+func TestRegistry(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestRegistry, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	_, err = NewPackage(exec.NewBuilder(nil), bar, fset)
+	if err == nil {
+		t.Fatal("NewPackage: host is found in DefaultRegistry")
+	}
+
+	reg := exec.DefaultRegistry.Clone()
+	host := reg.NewGoPackage("host")
+	host.RegisterFuncs(
+		host.Func("Greet", func(name string) string { return "Hello, " + name }, execHostGreet),
+	)
+	b := exec.NewBuilder(nil)
+	_, err = NewPackageEx(b, bar, fset, &Config{Registry: reg})
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := reg.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-2); v != int(13) { // Hello, qlang
+		t.Fatal("n:", v)
+	}
+
+	ctx = exec.NewContext(code) // the code records the registry
+	ctx.Exec(0, code.Len())
+	if v := ctx.Get(-2); v != int(13) {
+		t.Fatal("n:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestMarshalCode = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	import "strings"
+
+	type Point struct {
+		X, Y float64
+	}
+
+	func (p *Point) Scale(k float64) {
+		p.X, p.Y = p.X*k, p.Y*k
+	}
+
+	p := &Point{X: 1.5, Y: 2}
+	p.Scale(2)
+	add := func(a, b float64) float64 {
+		return a + b
+	}
+	r := strings.NewReplacer("a", "x")
+	println(r.Replace("abc"), add(p.X, p.Y))
+`)
+
+func TestMarshalCode(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestMarshalCode, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	data, err := b.Resolve().MarshalBinary()
+	if err != nil {
+		t.Fatal("MarshalBinary failed:", err)
+	}
+	code := new(exec.Code)
+	if err = code.UnmarshalBinary(data); err != nil {
+		t.Fatal("UnmarshalBinary failed:", err)
+	}
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-2); v != int(6) { // xbc 7
+		t.Fatal("n:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestGoVar = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	import "os"
+
+	os.Args = []string{"bar.ql", "x"}
+	println(os.Args)
+`)
+
+func TestGoVar(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestGoVar, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	args := os.Args
+	defer func() {
+		os.Args = args
+	}()
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-2); v != int(11) { // [bar.ql x]
+		t.Fatal("n:", v)
+	}
+	if len(os.Args) != 2 || os.Args[1] != "x" {
+		t.Fatal("os.Args:", os.Args)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestDebug = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	func add(a, b int) int {
+		s := a + b
+		return s
+	}
+
+	x := add(1, 2)
+	f := func(n int) int {
+		return n * x
+	}
+	println(f(add(x, 1)))
+`)
+
+func TestDebug(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestDebug, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	var stops []string
+	d := exec.NewDebugger(code, func(ctx *exec.Context, reason exec.StopReason) exec.StepMode {
+		stop := fmt.Sprint(ctx.Frame().Pos.Line, ":")
+		for _, v := range ctx.Locals() {
+			stop += fmt.Sprintf(" %s=%v", v.Name, v.Value)
+		}
+		for c := ctx.Caller(); c != nil; c = c.Caller() {
+			stop += " <- " + c.Frame().Func
+		}
+		stops = append(stops, stop)
+		return exec.StepContinue
+	})
+	for _, line := range []int{4, 9} {
+		if _, ok := d.SetBreakpoint("bar.ql", line); !ok {
+			t.Fatal("SetBreakpoint failed:", line)
+		}
+	}
+	ctx := exec.NewContext(code)
+	ctx.SetDebugger(d)
+	ctx.Exec(0, code.Len())
+	fmt.Println("stops:", stops)
+	if v := fmt.Sprint(stops); v != "[4: a=1 b=2 s=3 <- main 4: a=3 b=1 s=4 <- main 9: n=4 <- main]" {
+		t.Fatal("stops:", v)
+	}
+	if v := ctx.Get(-2); v != int(3) { // 12
+		t.Fatal("n:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
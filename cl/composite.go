@@ -0,0 +1,323 @@
+package cl
+
+import (
+	"reflect"
+
+	"github.com/qiniu/qlang/ast"
+	"github.com/qiniu/qlang/ast/astutil"
+	"github.com/qiniu/qlang/exec"
+)
+
+// -----------------------------------------------------------------------------
+
+// compileCompositeLit compiles a composite literal. typ is the type of the literal
+// if its type is elided (eg. the elements of []T{{...}, {...}}), or nil otherwise.
+func compileCompositeLit(ctx *blockCtx, v *ast.CompositeLit, typ reflect.Type, mode compleMode) {
+	if mode > lhsBase {
+		logPanic("compileCompositeLit: can't be lhs (left hand side) expr.")
+	}
+	switch t := v.Type.(type) {
+	case nil:
+		if typ == nil {
+			logPanic("compileCompositeLit failed: missing type in composite literal.")
+		}
+	case *ast.ArrayType:
+		if t.Len == nil && isAutoType(t.Elt) { // [a, b, c]
+			compileSliceLitAuto(ctx, v, mode)
+			return
+		}
+		if _, ok := t.Len.(*ast.Ellipsis); ok { // [...]T{a, b, c}
+			_, n := toArrayElts(ctx, v.Elts)
+			typ = reflect.ArrayOf(n, toType(ctx, t.Elt))
+		} else {
+			typ = toType(ctx, t)
+		}
+	case *ast.MapType:
+		if isAutoType(t.Key) && isAutoType(t.Value) { // {k1: v1, k2: v2}
+			compileMapLitAuto(ctx, v, mode)
+			return
+		}
+		typ = toType(ctx, t)
+	default:
+		if typ = toType(ctx, t); typ == nil {
+			logPanic("compileCompositeLit failed: unknown type -", reflect.TypeOf(t))
+		}
+	}
+	if mode == inferOnly {
+		ctx.infer.Push(&goValue{t: typ})
+		return
+	}
+	switch typ.Kind() {
+	case reflect.Slice, reflect.Array:
+		compileArrayLit(ctx, v, typ)
+	case reflect.Map:
+		compileMapLit(ctx, v, typ)
+	case reflect.Struct:
+		compileStructLit(ctx, v, typ)
+	default:
+		logPanic("compileCompositeLit failed: invalid composite literal type -", typ)
+	}
+	ctx.infer.Push(&goValue{t: typ})
+}
+
+func compileArrayLit(ctx *blockCtx, v *ast.CompositeLit, typ reflect.Type) {
+	elts, n := toArrayElts(ctx, v.Elts)
+	if typ.Kind() == reflect.Array && n > typ.Len() {
+		logPanic("compileArrayLit failed: array index", n-1, "out of bounds [0:", typ.Len(), "]")
+	}
+	elem := typ.Elem()
+	for _, elt := range elts {
+		if elt == nil {
+			ctx.out.Push(nil)
+		} else {
+			compileElem(ctx, elt, elem)
+		}
+	}
+	ctx.out.MakeArray(typ, n)
+}
+
+// toArrayElts returns elements of a slice/array literal by their indexes. Elements
+// that are not specified are nil.
+func toArrayElts(ctx *blockCtx, elts []ast.Expr) ([]ast.Expr, int) {
+	var ret []ast.Expr
+	var idx int
+	for _, elt := range elts {
+		if kv, ok := elt.(*ast.KeyValueExpr); ok {
+			idx = evalConstInt(ctx, kv.Key)
+			elt = kv.Value
+		}
+		if idx < 0 {
+			logPanic("toArrayElts failed: index must be non-negative integer constant.")
+		}
+		for len(ret) <= idx {
+			ret = append(ret, nil)
+		}
+		if ret[idx] != nil {
+			logPanic("toArrayElts failed: duplicate index", idx, "in array or slice literal.")
+		}
+		ret[idx] = elt
+		idx++
+	}
+	return ret, len(ret)
+}
+
+func compileMapLit(ctx *blockCtx, v *ast.CompositeLit, typ reflect.Type) {
+	for _, elt := range v.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			logPanic("compileMapLit failed: missing key in map literal.")
+		}
+		compileElem(ctx, kv.Key, typ.Key())
+		compileElem(ctx, kv.Value, typ.Elem())
+	}
+	ctx.out.MakeMap(typ, len(v.Elts))
+}
+
+func compileStructLit(ctx *blockCtx, v *ast.CompositeLit, typ reflect.Type) {
+	n := typ.NumField()
+	vals := make([]ast.Expr, n)
+	if len(v.Elts) > 0 {
+		if _, ok := v.Elts[0].(*ast.KeyValueExpr); ok { // T{name1: v1, name2: v2}
+			for _, elt := range v.Elts {
+				kv, ok := elt.(*ast.KeyValueExpr)
+				if !ok {
+					logPanic("compileStructLit failed: mixture of field:value and value elements in struct literal.")
+				}
+				name, ok := kv.Key.(*ast.Ident)
+				if !ok {
+					logPanic("compileStructLit failed: invalid field name -", reflect.TypeOf(kv.Key))
+				}
+				field, ok := typ.FieldByName(name.Name)
+				if !ok || len(field.Index) != 1 {
+					logPanic("compileStructLit failed: unknown field", name.Name, "in struct literal.")
+				}
+				if vals[field.Index[0]] != nil {
+					logPanic("compileStructLit failed: duplicate field name", name.Name, "in struct literal.")
+				}
+				vals[field.Index[0]] = kv.Value
+			}
+		} else { // T{v1, v2}
+			if len(v.Elts) != n {
+				logPanic("compileStructLit failed: too few or too many values in struct literal.")
+			}
+			copy(vals, v.Elts)
+		}
+	}
+	for i, val := range vals {
+		if val == nil {
+			ctx.out.Push(nil)
+		} else {
+			compileElem(ctx, val, typ.Field(i).Type)
+		}
+	}
+	ctx.out.MakeStruct(typ, n)
+}
+
+// compileElem compiles an element (or a key) of a composite literal, and checks if
+// it can be assigned to typ.
+func compileElem(ctx *blockCtx, expr ast.Expr, typ reflect.Type) {
+	if lit, ok := expr.(*ast.CompositeLit); ok && lit.Type == nil {
+		if typ.Kind() == reflect.Ptr { // &T{...} is elided to {...}
+			compileCompositeLit(ctx, lit, typ.Elem(), 0)
+			ctx.out.MakePtr(typ.Elem())
+			ctx.infer.Ret(1, &goValue{t: typ})
+		} else {
+			compileCompositeLit(ctx, lit, typ, 0)
+		}
+	} else {
+		compileExpr(ctx, expr, 0)
+	}
+	in := ctx.infer.Pop().(iValue)
+	if in.NumValues() != 1 {
+		logPanic("compileElem failed: element of composite literal isn't a single value.")
+	}
+	checkElem(typ, in, ctx.out)
+}
+
+func checkElem(typ reflect.Type, in iValue, b *exec.Builder) {
+	if cons, ok := in.(*constVal); ok {
+		cons.bound(typ, b)
+	} else if t := in.Type(); !t.AssignableTo(typ) {
+		logPanic("compileElem failed: cannot use", t, "as", typ, "value in composite literal.")
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+// isAutoType checks if a type is `_`, whose real type will be inferred.
+func isAutoType(typ ast.Expr) bool {
+	ident, ok := typ.(*ast.Ident)
+	return ok && ident.Name == "_"
+}
+
+// compileSliceLitAuto compiles a qlang-style slice literal `[a, b, c]`.
+func compileSliceLitAuto(ctx *blockCtx, v *ast.CompositeLit, mode compleMode) {
+	n := len(v.Elts)
+	for _, elt := range v.Elts {
+		if _, ok := elt.(*ast.KeyValueExpr); ok {
+			logPanic("compileSliceLitAuto failed: unexpected key in slice literal.")
+		}
+		compileAutoElem(ctx, elt, mode)
+	}
+	elts := make([]iValue, n)
+	for i, elt := range ctx.infer.GetArgs(uint32(n)) {
+		elts[i] = elt.(iValue)
+	}
+	elem := inferElemType(elts)
+	typ := reflect.SliceOf(elem)
+	if mode != inferOnly {
+		for _, elt := range elts {
+			checkElem(elem, elt, ctx.out)
+		}
+		ctx.out.MakeArray(typ, n)
+	}
+	ctx.infer.Ret(uint32(n), &goValue{t: typ})
+}
+
+// compileMapLitAuto compiles a qlang-style map literal `{k1: v1, k2: v2}`.
+func compileMapLitAuto(ctx *blockCtx, v *ast.CompositeLit, mode compleMode) {
+	n := len(v.Elts)
+	for _, elt := range v.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			logPanic("compileMapLitAuto failed: missing key in map literal.")
+		}
+		compileAutoElem(ctx, kv.Key, mode)
+		compileAutoElem(ctx, kv.Value, mode)
+	}
+	keys := make([]iValue, n)
+	vals := make([]iValue, n)
+	for i, elt := range ctx.infer.GetArgs(uint32(n << 1)) {
+		if i&1 == 0 {
+			keys[i>>1] = elt.(iValue)
+		} else {
+			vals[i>>1] = elt.(iValue)
+		}
+	}
+	var typ reflect.Type
+	if n == 0 {
+		typ = reflect.MapOf(exec.TyString, exec.TyEmptyInterface)
+	} else {
+		typ = reflect.MapOf(inferElemType(keys), inferElemType(vals))
+	}
+	if mode != inferOnly {
+		tkey, tval := typ.Key(), typ.Elem()
+		for i := 0; i < n; i++ {
+			checkElem(tkey, keys[i], ctx.out)
+			checkElem(tval, vals[i], ctx.out)
+		}
+		ctx.out.MakeMap(typ, n)
+	}
+	ctx.infer.Ret(uint32(n<<1), &goValue{t: typ})
+}
+
+func compileAutoElem(ctx *blockCtx, expr ast.Expr, mode compleMode) {
+	if lit, ok := expr.(*ast.CompositeLit); ok && lit.Type == nil {
+		logPanic("compileAutoElem failed: missing type in composite literal.")
+	}
+	compileExpr(ctx, expr, mode)
+	if ctx.infer.Get(-1).(iValue).NumValues() != 1 {
+		logPanic("compileAutoElem failed: element of composite literal isn't a single value.")
+	}
+}
+
+// inferElemType infers element type of a qlang-style slice/map literal:
+//   - all elements are untyped numbers: the widest one of int, float64 and complex128.
+//   - all elements have the same type T, and untyped constants can be converted to T: T.
+//   - otherwise: interface{}.
+func inferElemType(elts []iValue) reflect.Type {
+	var typ reflect.Type
+	var kind iKind // the widest kind of untyped constants
+	for _, elt := range elts {
+		if cons, ok := elt.(*constVal); ok && !astutil.IsConstBound(cons.kind) {
+			if cons.kind > kind {
+				kind = cons.kind
+			}
+			continue
+		}
+		if t := elt.Type(); typ == nil {
+			typ = t
+		} else if typ != t {
+			return exec.TyEmptyInterface
+		}
+	}
+	if typ == nil {
+		switch kind {
+		case astutil.ConstUnboundInt:
+			return exec.TyInt
+		case astutil.ConstUnboundFloat:
+			return exec.TyFloat64
+		case astutil.ConstUnboundComplex:
+			return exec.TyComplex128
+		}
+		return exec.TyEmptyInterface
+	}
+	if kind != 0 {
+		for _, elt := range elts {
+			if cons, ok := elt.(*constVal); ok && !astutil.IsConstBound(cons.kind) {
+				if _, ok := boundConst(cons.v, typ); !ok {
+					return exec.TyEmptyInterface
+				}
+			}
+		}
+	}
+	return typ
+}
+
+// -----------------------------------------------------------------------------
+
+// evalConstInt evaluates a constant expression which must be an integer.
+func evalConstInt(ctx *blockCtx, expr ast.Expr) int {
+	compileExpr(ctx, expr, inferOnly)
+	cons, ok := ctx.infer.Pop().(*constVal)
+	if !ok {
+		logPanic("evalConstInt failed: not a constant expression.")
+	}
+	v, ok := boundConst(cons.v, exec.TyInt)
+	if !ok {
+		logPanic("evalConstInt failed: constant isn't an integer -", cons.v)
+	}
+	return v.(int)
+}
+
+// -----------------------------------------------------------------------------
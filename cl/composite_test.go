@@ -0,0 +1,94 @@
+package cl
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/qiniu/qlang/ast/asttest"
+	"github.com/qiniu/qlang/exec"
+	"github.com/qiniu/qlang/parser"
+	"github.com/qiniu/qlang/token"
+)
+
+// -----------------------------------------------------------------------------
+
+var fsTestSliceLitAuto = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	import "fmt"
+
+	a := [1, 2, 3.4]
+	b := {"a": 1, "b": 3.0}
+	c := {"a": 1, "b": "Hello"}
+	d := [[1, 2], [3]]
+	e, f := [], {}
+	g := [a, [], "x"]
+	fmt.Printf("%T %T %T %T %T %T %T\n", a, b, c, d, e, f, g)
+	fmt.Println(a, b, c, d, e, f, g)
+`)
+
+func TestSliceLitAuto(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestSliceLitAuto, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(78) {
+		t.Fatal("n:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestCompositeLit = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	a := []int{1, 2, 5: 6}
+	b := [...]string{"x", 3: "y"}
+	c := map[string][]float64{"a": {1, 2}, "b": {}}
+	d := [2]struct {
+		X    int
+		name string
+	}{{1, "a"}, {X: 2}}
+	e := []interface{}{1, "a", a}
+	println(a, b, c, d, e)
+`)
+
+func TestCompositeLit(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestCompositeLit, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(73) {
+		t.Fatal("n:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
@@ -0,0 +1,75 @@
+package cl
+
+import (
+	"reflect"
+
+	"github.com/qiniu/qlang/ast"
+)
+
+// -----------------------------------------------------------------------------
+
+// A constDecl represents a constant declaration. It is evaluated when it is used
+// the first time, so a package-level constant can refer to constants declared
+// after it.
+type constDecl struct {
+	typ  ast.Expr // nil if it is an untyped constant
+	expr ast.Expr
+	iota int
+	ctx  *blockCtx
+	val  *constVal
+	busy bool // it is being evaluated
+}
+
+func newConstDecl(ctx *blockCtx, typ, expr ast.Expr, iota int) *constDecl {
+	return &constDecl{typ: typ, expr: expr, iota: iota, ctx: newNormBlockCtx(ctx)}
+}
+
+func (p *constDecl) eval() *constVal {
+	if p.val != nil {
+		return p.val
+	}
+	if p.busy {
+		logPanic("constDecl.eval failed: initialization loop for constant.")
+	}
+	p.busy = true
+	ctx := p.ctx
+	iota := ctx.iota
+	defer func() { p.busy, ctx.iota = false, iota }()
+	ctx.iota = p.iota
+	compileExpr(ctx, p.expr, inferOnly)
+	cons, ok := ctx.infer.Pop().(*constVal)
+	if !ok {
+		logPanic("constDecl.eval failed: const initializer is not a constant.")
+	}
+	var decl *typeDecl
+	if p.typ != nil {
+		t := toType(ctx, p.typ)
+		if t == nil {
+			logPanic("constDecl.eval failed: unknown type -", reflect.TypeOf(p.typ))
+		}
+		v, ok := boundConst(cons.v, t)
+		if !ok {
+			if msg := boundConstErr(cons.v, t); msg != "" {
+				logPanic("constDecl.eval failed:", msg)
+			}
+			logPanic("constDecl.eval failed: cannot use", cons.v, "as", t, "value in const declaration.")
+		}
+		cons = &constVal{v: v, kind: t.Kind()}
+		decl = toNamedDecl(ctx, p.typ)
+	} else {
+		decl = cons.decl
+	}
+	p.val = &constVal{v: cons.v, kind: cons.kind, reserve: -1, decl: decl}
+	return p.val
+}
+
+// compileConst pushes a copy of the constant c.
+func compileConst(ctx *blockCtx, c *constVal, mode compleMode) {
+	ret := &constVal{v: c.v, kind: c.kind, reserve: -1, decl: c.decl}
+	ctx.infer.Push(ret)
+	if mode != inferOnly {
+		ret.push(ctx.out)
+	}
+}
+
+// -----------------------------------------------------------------------------
@@ -0,0 +1,256 @@
+package cl
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/qiniu/qlang/ast/asttest"
+	"github.com/qiniu/qlang/exec"
+	"github.com/qiniu/qlang/parser"
+	"github.com/qiniu/qlang/token"
+)
+
+// -----------------------------------------------------------------------------
+
+var fsTestConst = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	const (
+		a = iota
+		b
+		c
+		_
+		e
+	)
+
+	const (
+		KB = 1 << (10 * (iota + 1))
+		MB
+		GB
+	)
+
+	const x, y int32 = iota + 'a', iota + 'b'
+	const sum = pi + z
+	const pi = 3.5
+	const z = 1
+	const name = "q" + "lang"
+	const typed float64 = 3
+
+	func area(r float64) float64 {
+		return pi * r * r
+	}
+
+	println(a, b, c, e, KB, MB, GB, x, y, sum, name, typed, area(2))
+`)
+
+func TestConst(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestConst, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(53) {
+		t.Fatal("n:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestConstInBlock = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	const n = 2
+
+	func foo() int {
+		const (
+			p, q = iota * 10, iota + n
+			r, s
+		)
+		return p + q + r + s
+	}
+
+	for i := 0; i < 1; i++ {
+		const n = "shadow"
+		println(n, foo())
+	}
+	println(n)
+`)
+
+func TestConstInBlock(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestConstInBlock, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(2) {
+		t.Fatal("n:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestTypedConst = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	type Weekday int
+
+	func (d Weekday) Weekend() bool {
+		return d == Saturday || d == Sunday
+	}
+
+	const (
+		Monday Weekday = iota
+		Tuesday
+		Wednesday
+		Thursday
+		Friday
+		Saturday
+		Sunday
+	)
+
+	const Today = Sunday
+
+	d := Today
+	next := Monday + 5
+	println(Tuesday.Weekend(), d.Weekend(), next.Weekend(), (Sunday - 1).Weekend())
+`)
+
+func TestTypedConst(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestTypedConst, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(21) {
+		t.Fatal("n:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestExactConst = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	const huge = 1 << 100
+	const big = 1 << 62
+	const fl = 2.5
+	const m = 1267650600228229401496703205376 >> 99
+
+	var i int = fl * 2
+	println(huge>>98, big*4/8, i, m, -9223372036854775808 / -1 / 2, ^0)
+`)
+
+func TestExactConst(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestExactConst, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(49) { // 4 2305843009213693952 5 2 4611686018427387904 -1
+		t.Fatal("n:", v)
+	}
+}
+
+var fsTestConstOverflow = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	const big = 1 << 62
+	const c uint8 = 255
+	const d uint8 = 255 + 1
+	const fl = 2.5
+
+	println(big * 4)
+	println(c + 1)
+	var i int = fl * 3
+	println(1 / 0)
+	println(d, i)
+`)
+
+func TestConstOverflow(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestConstOverflow, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	errs, ok := err.(ErrorList)
+	if !ok {
+		t.Fatal("NewPackage: not an ErrorList -", err)
+	}
+	fmt.Println(errs)
+	expected := []struct {
+		line int
+		msg  string
+	}{
+		{4, "constant 256 overflows uint8"},
+		{7, "constant 18446744073709551616 overflows int"},
+		{8, "constant 256 overflows uint8"},
+		{9, "constant 7.5 truncated to integer"},
+		{10, "division by zero"},
+		{11, "constant 256 overflows uint8"},
+	}
+	if len(errs) != len(expected) {
+		t.Fatal("NewPackage: unexpected errors -", len(errs), errs)
+	}
+	for i, e := range errs {
+		if e.Pos.Line != expected[i].line || !strings.Contains(e.Msg, expected[i].msg) {
+			t.Fatal("NewPackage: unexpected error -", e)
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
@@ -0,0 +1,152 @@
+package cl
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/qiniu/qlang/ast"
+	"github.com/qiniu/qlang/token"
+)
+
+// -----------------------------------------------------------------------------
+
+// A CompileError represents a compile error at a position of the source code.
+type CompileError struct {
+	Pos token.Position
+	Msg string
+}
+
+func (p *CompileError) Error() string {
+	if p.Pos.Filename != "" || p.Pos.IsValid() {
+		return p.Pos.String() + ": " + p.Msg
+	}
+	return p.Msg
+}
+
+// ErrorList is a list of *CompileError, like go/scanner.ErrorList.
+type ErrorList []*CompileError
+
+// Add adds a CompileError with the given position and message to the list.
+func (p *ErrorList) Add(pos token.Position, msg string) {
+	*p = append(*p, &CompileError{Pos: pos, Msg: msg})
+}
+
+func (p ErrorList) Len() int      { return len(p) }
+func (p ErrorList) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+func (p ErrorList) Less(i, j int) bool {
+	e, f := &p[i].Pos, &p[j].Pos
+	if e.Filename != f.Filename {
+		return e.Filename < f.Filename
+	}
+	if e.Line != f.Line {
+		return e.Line < f.Line
+	}
+	return e.Column < f.Column
+}
+
+// Sort sorts the list by positions of the errors.
+func (p ErrorList) Sort() {
+	sort.Stable(p)
+}
+
+// RemoveMultiples sorts the list, and removes all but the first of errors with the
+// same position and message, like go/scanner.ErrorList.RemoveMultiples. An error
+// is reported repeatedly if the code that causes it is compiled more than once.
+func (p *ErrorList) RemoveMultiples() {
+	p.Sort()
+	var last *CompileError
+	i := 0
+	for _, e := range *p {
+		if last == nil || e.Pos != last.Pos || e.Msg != last.Msg {
+			last = e
+			(*p)[i] = e
+			i++
+		}
+	}
+	*p = (*p)[:i]
+}
+
+func (p ErrorList) Error() string {
+	switch len(p) {
+	case 0:
+		return "no errors"
+	case 1:
+		return p[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", p[0], len(p)-1)
+}
+
+// Err returns an error equivalent to this error list. If the list is empty, Err
+// returns nil.
+func (p ErrorList) Err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return p
+}
+
+// -----------------------------------------------------------------------------
+
+// compileErr is the value that the compiler panics with when it meets an error.
+// It is located by the innermost node being compiled, if pos isn't specified.
+type compileErr struct {
+	pos token.Pos
+	msg string
+}
+
+// logPanic reports a compile error. Its arguments are handled like fmt.Sprintln.
+func logPanic(args ...interface{}) {
+	panic(&compileErr{msg: strings.TrimSuffix(fmt.Sprintln(args...), "\n")})
+}
+
+// logPanicAt reports a compile error located at node.
+func logPanicAt(node ast.Node, args ...interface{}) {
+	panic(&compileErr{pos: node.Pos(), msg: strings.TrimSuffix(fmt.Sprintln(args...), "\n")})
+}
+
+// toCompileErr converts a value recovered from a panic into a compile error, and
+// locates it at node if it isn't located yet. A runtime error (eg. caused by a bug
+// of the compiler) is reported as an internal compiler error.
+func toCompileErr(e interface{}, node ast.Node) *compileErr {
+	var err *compileErr
+	switch v := e.(type) {
+	case *compileErr:
+		err = v
+	case string: // log.Panicln of exec.Builder, etc.
+		err = &compileErr{msg: strings.TrimSuffix(v, "\n")}
+	case runtime.Error:
+		err = &compileErr{msg: "internal compiler error: " + v.Error()}
+	case error:
+		err = &compileErr{msg: v.Error()}
+	default:
+		err = &compileErr{msg: fmt.Sprint("internal compiler error: ", v)}
+	}
+	if err.pos == token.NoPos && node != nil {
+		err.pos = node.Pos()
+	}
+	return err
+}
+
+// locateErr is deferred by a compile function of the node, to locate compile errors
+// it panics with.
+func locateErr(node ast.Node) {
+	if e := recover(); e != nil {
+		panic(toCompileErr(e, node))
+	}
+}
+
+// recoverErr is deferred by a compile function of the node, to record compile
+// errors it panics with, so that the compiler can go on with the next node. base
+// is the depth of the infer stack to restore.
+func (p *pkgCtx) recoverErr(node ast.Node, base int) {
+	if e := recover(); e != nil {
+		err := toCompileErr(e, node)
+		p.errs.Add(p.position(err.pos), err.msg)
+		p.infer.SetLen(base)
+	}
+}
+
+// -----------------------------------------------------------------------------
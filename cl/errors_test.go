@@ -0,0 +1,409 @@
+package cl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qiniu/qlang/ast"
+	"github.com/qiniu/qlang/ast/asttest"
+	"github.com/qiniu/qlang/exec"
+	"github.com/qiniu/qlang/parser"
+	"github.com/qiniu/qlang/token"
+)
+
+// -----------------------------------------------------------------------------
+
+var fsTestErrors = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	type T struct {
+		a Unknown
+	}
+
+	func add(x, y int) int {
+		return x + z
+	}
+
+	x := 1
+	y := undefined + x
+	println(add(x, "2"))
+	x = "hello"
+	println(x)
+	if add(x, 1) {
+		println(x)
+	}
+`)
+
+func TestErrors(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestErrors, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	errs, ok := err.(ErrorList)
+	if !ok {
+		t.Fatal("NewPackage: not an ErrorList -", err)
+	}
+	fmt.Println(errs)
+	expected := []token.Position{
+		{Filename: "/foo/bar.ql", Line: 3, Column: 5},
+		{Filename: "/foo/bar.ql", Line: 7, Column: 14},
+		{Filename: "/foo/bar.ql", Line: 11, Column: 7},
+		{Filename: "/foo/bar.ql", Line: 12, Column: 10},
+		{Filename: "/foo/bar.ql", Line: 13, Column: 2},
+		{Filename: "/foo/bar.ql", Line: 15, Column: 5},
+	}
+	if len(errs) != len(expected) {
+		t.Fatal("NewPackage: unexpected errors -", len(errs), errs)
+	}
+	for i, e := range errs {
+		pos := e.Pos
+		pos.Offset = 0
+		if pos != expected[i] {
+			t.Fatal("NewPackage: unexpected error position -", e)
+		}
+	}
+}
+
+var fsTestErrorMultiples = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	type T struct {
+		a Unknown
+	}
+
+	var x T
+	var y T
+	println(x, y)
+`)
+
+func TestErrorMultiples(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestErrorMultiples, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	errs, ok := err.(ErrorList)
+	if !ok || len(errs) != 2 || errs[0].Pos.Line != 3 || errs[1].Pos.Line != 8 {
+		t.Fatal("NewPackage: unexpected errors -", err)
+	}
+}
+
+func TestInternalError(t *testing.T) {
+	node := &ast.Ident{NamePos: 10, Name: "x"}
+	err := func() (err *compileErr) {
+		defer func() {
+			err = toCompileErr(recover(), node)
+		}()
+		var m map[string]int
+		m["x"] = 1 // a bug of the compiler
+		return
+	}()
+	if err == nil || err.pos != node.Pos() || !strings.HasPrefix(err.msg, "internal compiler error: ") {
+		t.Fatal("toCompileErr: unexpected error -", err)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestRuntimeError = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	func div(a, b int) int {
+		return a / b
+	}
+
+	func calc(n int) int {
+		f := func(x int) int {
+			return div(10, x)
+		}
+		return f(n)
+	}
+
+	println(calc(2))
+	println(calc(0))
+`)
+
+func TestRuntimeError(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestRuntimeError, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	err = ctx.Run(0, code.Len())
+	fmt.Println(err)
+	rtErr, ok := err.(*exec.RuntimeError)
+	if !ok {
+		t.Fatal("Run: not a RuntimeError -", err)
+	}
+	expected := []struct {
+		fun  string
+		line int
+	}{
+		{"div", 3}, {"calc.func1", 8}, {"calc", 10}, {"main", 14},
+	}
+	if len(rtErr.Frames) != len(expected) {
+		t.Fatal("Run: unexpected stack trace -", rtErr.Frames)
+	}
+	for i, f := range rtErr.Frames {
+		if f.Func != expected[i].fun || f.Pos.Line != expected[i].line {
+			t.Fatal("Run: unexpected frame -", f)
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestLimits = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	func spin() {
+		defer func() {
+			recover()
+		}()
+		for {
+		}
+	}
+
+	go spin()
+	spin()
+`)
+
+func TestLimits(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestLimits, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.SetLimits(context.Background(), exec.Limits{MaxSteps: 100000})
+	err = ctx.Run(0, code.Len())
+	fmt.Println(err)
+	if !errors.Is(err, exec.ErrStepLimit) {
+		t.Fatal("Run: not ErrStepLimit -", err)
+	}
+}
+
+var fsTestCancelRecursion = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	func fib(n int) int {
+		if n < 2 {
+			return n
+		}
+		return fib(n-1) + fib(n-2)
+	}
+
+	println(fib(32))
+`)
+
+func TestCancelRecursion(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestCancelRecursion, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	c, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+	ctx.SetLimits(c, exec.Limits{})
+	start := time.Now()
+	err = ctx.Run(0, code.Len())
+	fmt.Println(err, time.Since(start))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatal("Run: not Canceled -", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatal("Run: cancelled too late -", d)
+	}
+}
+
+var fsTestMemoryLimit = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	s := "x"
+	for {
+		s += s
+	}
+`)
+
+func TestMemoryLimit(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestMemoryLimit, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.SetLimits(nil, exec.Limits{MaxMemory: 1 << 20})
+	err = ctx.Run(0, code.Len())
+	fmt.Println(err)
+	if !errors.Is(err, exec.ErrMemoryLimit) {
+		t.Fatal("Run: not ErrMemoryLimit -", err)
+	}
+}
+
+var fsTestDepthGoroutines = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	func send(ready, start, c chan int, n int) {
+		ready <- 1
+		<-start
+		c <- n
+	}
+
+	ready, start, c := make(chan int), make(chan int), make(chan int)
+	for i := 1; i <= 20; i++ {
+		go send(ready, start, c, i)
+	}
+	for i := 0; i < 20; i++ {
+		<-ready
+	}
+	close(start) // 20 goroutines are calling send
+	sum := 0
+	for i := 0; i < 20; i++ {
+		sum += <-c
+	}
+	println(sum)
+`)
+
+func TestDepthLimitGoroutines(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestDepthGoroutines, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.SetLimits(context.Background(), exec.Limits{MaxDepth: 15})
+	err = ctx.Run(0, code.Len())
+	if err != nil {
+		t.Fatal("Run failed:", err)
+	}
+	if v := ctx.Get(-2); v != int(4) {
+		t.Fatal("println(sum):", v)
+	}
+}
+
+var fsTestGoPanic = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	c := make(chan int)
+	go func() {
+		panic("boom")
+	}()
+	<-c
+`)
+
+func TestGoPanic(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestGoPanic, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	for _, limits := range []bool{false, true} {
+		ctx := exec.NewContext(code)
+		if limits {
+			ctx.SetLimits(context.Background(), exec.Limits{MaxSteps: 100000})
+		}
+		err = ctx.Run(0, code.Len())
+		rtErr, ok := err.(*exec.RuntimeError)
+		if !ok || rtErr.Value != "boom" {
+			t.Fatal("Run: unexpected error -", limits, err)
+		}
+	}
+}
+
+var fsTestImporter = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	import "fmt"
+	import "strings"
+
+	fmt.Println(strings.NewReplacer("a", "b").Replace("abc"))
+`)
+
+func TestImporter(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestImporter, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	conf := &Config{Importer: exec.AllowPackages(exec.GoPackages, "fmt")}
+	_, err = NewPackageEx(b, bar, fset, conf)
+	fmt.Println(err)
+	errs, ok := err.(ErrorList)
+	if !ok || len(errs) == 0 {
+		t.Fatal("NewPackageEx: not an ErrorList -", err)
+	}
+	pos := errs[0].Pos
+	if pos.Line != 3 || pos.Column != 9 || !strings.Contains(errs[0].Msg, "not allowed") {
+		t.Fatal("NewPackageEx: unexpected error -", errs[0])
+	}
+
+	b = exec.NewBuilder(nil)
+	conf = &Config{Importer: exec.AllowPackages(exec.GoPackages, "fmt", "strings")}
+	_, err = NewPackageEx(b, bar, fset, conf)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	if v := ctx.Get(-2); v != int(4) { // bbc
+		t.Fatal("n:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
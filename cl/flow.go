@@ -0,0 +1,595 @@
+package cl
+
+import (
+	"reflect"
+
+	"github.com/qiniu/qlang/ast"
+	"github.com/qiniu/qlang/exec"
+	"github.com/qiniu/qlang/token"
+)
+
+// -----------------------------------------------------------------------------
+
+func compileIfStmt(ctx *blockCtx, v *ast.IfStmt) {
+	ctxIf := newNormBlockCtx(ctx)
+	if v.Init != nil {
+		compileStmt(ctxIf, v.Init)
+	}
+	compileCond(ctxIf, v.Cond)
+	out := ctx.out
+	done := exec.NewLabel("")
+	if v.Else == nil {
+		out.JmpIfFalse(done)
+		compileNewBlock(ctxIf, v.Body)
+	} else {
+		elseL := exec.NewLabel("")
+		out.JmpIfFalse(elseL)
+		compileNewBlock(ctxIf, v.Body)
+		out.Jmp(done)
+		out.Label(elseL)
+		switch e := v.Else.(type) {
+		case *ast.BlockStmt:
+			compileNewBlock(ctxIf, e)
+		case *ast.IfStmt: // else if
+			compileIfStmt(ctxIf, e)
+		default:
+			logPanic("compileIfStmt failed: unknown else -", reflect.TypeOf(e))
+		}
+	}
+	out.Label(done)
+}
+
+// compileCond compiles a condition expression, and checks if it is a bool value.
+func compileCond(ctx *blockCtx, cond ast.Expr) {
+	defer locateErr(cond)
+	compileExpr(ctx, cond, 0)
+	v := ctx.infer.Pop().(iValue)
+	if v.NumValues() != 1 || v.Kind() != reflect.Bool {
+		logPanic("compileCond failed: non-bool used as condition.")
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+// A flowCtx represents a for/switch statement that break/continue can jump out of.
+type flowCtx struct {
+	parent *flowCtx
+	name   string // label name of this statement
+	brk    *exec.Label
+	cont   *exec.Label // nil if it isn't a loop
+	nstk   int         // count of values this statement keeps on the stack, eg. iterator of a for range
+}
+
+func newFlowCtx(parent *flowCtx, name string, brk, cont *exec.Label) *flowCtx {
+	return &flowCtx{parent: parent, name: name, brk: brk, cont: cont}
+}
+
+func compileForStmt(ctx *blockCtx, v *ast.ForStmt, label string) {
+	ctx = newNormBlockCtx(ctx)
+	ctx.captured = closureRefNames(v) // variables of the init statement are declared in each iteration too
+	if v.Init != nil {
+		compileStmt(ctx, v.Init)
+	}
+	var cells []*execVar
+	for _, c := range ctx.loopCells(ctx.captured) {
+		if ctx.syms[c.Name()] == c { // not a cell of outer loops
+			cells = append(cells, c)
+		}
+	}
+	out := ctx.out
+	start := exec.NewLabel("")
+	post := exec.NewLabel("")
+	done := exec.NewLabel("")
+	out.Label(start)
+	if v.Cond != nil {
+		compileCond(ctx, v.Cond)
+		out.JmpIfFalse(done)
+	}
+	ctx.flow = newFlowCtx(ctx.flow, label, done, post)
+	compileLoopBody(ctx, v.Body)
+	ctx.flow = ctx.flow.parent
+	out.Label(post)
+	for _, c := range cells { // copy the cells, so closures of this iteration keep their values
+		out.LoadVar(c.Var).AddrOp(c.getType().Kind(), exec.OpAddrVal)
+		ctx.storeCell(c)
+	}
+	if v.Post != nil {
+		compileStmt(ctx, v.Post)
+	}
+	out.Jmp(start)
+	out.Label(done)
+}
+
+// compileLoopBody compiles body of a for statement. Variables declared in it, that
+// closures in it refer to, are cells (see execVar.cell), so each iteration has its
+// own variables.
+func compileLoopBody(ctx *blockCtx, body *ast.BlockStmt) {
+	ctx = newNormBlockCtx(ctx)
+	ctx.captured = closureRefNames(body)
+	compileBlockStmt(ctx, body)
+}
+
+// closureRefNames returns names of the identifiers in function literals in node.
+func closureRefNames(node ast.Node) map[string]bool {
+	names := make(map[string]bool)
+	ast.Inspect(node, func(n ast.Node) bool {
+		if lit, ok := n.(*ast.FuncLit); ok {
+			for name := range refNames(lit.Body) {
+				names[name] = true
+			}
+			return false
+		}
+		return true
+	})
+	return names
+}
+
+func compileRangeStmt(ctx *blockCtx, v *ast.RangeStmt, label string) {
+	out := ctx.out
+	compileExpr(ctx, v.X, 0)
+	x := ctx.infer.Pop().(iValue)
+	if x.NumValues() != 1 {
+		logPanic("compileRangeStmt failed: range expression isn't a single value.")
+	}
+	var typ reflect.Type
+	if cons, ok := x.(*constVal); ok {
+		typ = cons.boundType()
+		cons.bound(typ, out)
+	} else {
+		typ = x.Type()
+	}
+	var tkey, tval reflect.Type
+	switch kind := typ.Kind(); kind {
+	case reflect.Slice, reflect.Array:
+		tkey, tval = exec.TyInt, typ.Elem()
+	case reflect.Ptr:
+		if typ.Elem().Kind() != reflect.Array {
+			logPanic("compileRangeStmt failed: can't range over -", typ)
+		}
+		tkey, tval = exec.TyInt, typ.Elem().Elem()
+	case reflect.String:
+		tkey, tval = exec.TyInt, exec.TyRune
+	case reflect.Map:
+		tkey, tval = typ.Key(), typ.Elem()
+	case reflect.Chan:
+		tkey = typ.Elem()
+	default:
+		if kind < reflect.Int || kind > reflect.Uintptr {
+			logPanic("compileRangeStmt failed: can't range over -", typ)
+		}
+		tkey = typ
+	}
+	if tval == nil && v.Value != nil {
+		logPanic("compileRangeStmt failed: range over", typ, "permits only one iteration variable.")
+	}
+	ctx = newNormBlockCtx(ctx)
+	ctx.captured = closureRefNames(v.Body) // the iteration variables are declared in each iteration too
+	next := exec.NewLabel("")
+	done := exec.NewLabel("")
+	out.RangeIter()
+	out.Label(next)
+	out.RangeNext(done)
+	if tval != nil {
+		storeTop(ctx, v.Value, tval, v.Tok)
+	}
+	storeTop(ctx, v.Key, tkey, v.Tok)
+	ctx.flow = newFlowCtx(ctx.flow, label, done, next)
+	ctx.flow.nstk = 1
+	compileNewBlock(ctx, v.Body)
+	ctx.flow = ctx.flow.parent
+	out.Jmp(next)
+	out.Label(done)
+	out.Pop(1)
+}
+
+// storeTop stores the value at the top of the stack (eg. key of a range iteration) to expr,
+// whose type is typ. The value is discarded if expr is nil.
+func storeTop(ctx *blockCtx, expr ast.Expr, typ reflect.Type, tok token.Token) {
+	if expr == nil {
+		ctx.out.Pop(1)
+		return
+	}
+	ctx.infer.Push(&goValue{t: typ})
+	compileExpr(ctx, expr, tok)
+}
+
+// -----------------------------------------------------------------------------
+
+func compileSwitchStmt(ctx *blockCtx, v *ast.SwitchStmt, label string) {
+	ctx = newNormBlockCtx(ctx)
+	if v.Init != nil {
+		compileStmt(ctx, v.Init)
+	}
+	out := ctx.out
+	var tag reflect.Type // nil if it is a tagless switch
+	if v.Tag != nil {
+		compileExpr(ctx, v.Tag, 0)
+		x := ctx.infer.Pop().(iValue)
+		if x.NumValues() != 1 {
+			logPanic("compileSwitchStmt failed: switch expression isn't a single value.")
+		}
+		if cons, ok := x.(*constVal); ok {
+			tag = cons.boundType()
+			cons.bound(tag, out)
+		} else {
+			tag = x.Type()
+		}
+	}
+	clauses := v.Body.List
+	bodies := make([]*exec.Label, len(clauses))
+	done := exec.NewLabel("")
+	var deflt *exec.Label
+	consts := make(map[interface{}]bool) // constant case values
+	for i, stmt := range clauses {
+		c := stmt.(*ast.CaseClause)
+		bodies[i] = exec.NewLabel("")
+		if c.List == nil {
+			if deflt != nil {
+				logPanicAt(c, "compileSwitchStmt failed: multiple defaults in switch.")
+			}
+			deflt = bodies[i]
+			continue
+		}
+		for _, e := range c.List {
+			next := exec.NewLabel("")
+			if tag == nil {
+				compileCond(ctx, e)
+				out.JmpIfFalse(next)
+			} else {
+				if v, ok := compileCaseValue(ctx, tag, e); ok {
+					if consts[v] {
+						logPanicAt(e, "compileSwitchStmt failed: duplicate case", v, "in switch.")
+					}
+					consts[v] = true
+				}
+				out.CaseNE(next)
+			}
+			out.Jmp(bodies[i])
+			out.Label(next)
+		}
+	}
+	if tag != nil {
+		out.Default()
+	}
+	if deflt == nil {
+		deflt = done
+	}
+	out.Jmp(deflt)
+	ctx.flow = newFlowCtx(ctx.flow, label, done, nil)
+	for i, stmt := range clauses {
+		c := stmt.(*ast.CaseClause)
+		body, fallthru := c.Body, false
+		if n := len(body); n > 0 {
+			if br, ok := body[n-1].(*ast.BranchStmt); ok && br.Tok == token.FALLTHROUGH {
+				if i+1 == len(clauses) {
+					logPanicAt(br, "compileSwitchStmt failed: cannot fallthrough final case in switch.")
+				}
+				body, fallthru = body[:n-1], true
+			}
+		}
+		out.Label(bodies[i])
+		compileCaseBody(newNormBlockCtx(ctx), body)
+		if !fallthru && i+1 < len(clauses) {
+			out.Jmp(done)
+		}
+	}
+	ctx.flow = ctx.flow.parent
+	out.Label(done)
+}
+
+// compileCaseValue compiles a case expression, and checks if it can be compared with
+// the switch tag. It returns the value of the expression if it's a constant.
+func compileCaseValue(ctx *blockCtx, tag reflect.Type, expr ast.Expr) (v interface{}, isConst bool) {
+	compileExpr(ctx, expr, 0)
+	x := ctx.infer.Pop().(iValue)
+	if x.NumValues() != 1 {
+		logPanicAt(expr, "compileCaseValue failed: case expression isn't a single value.")
+	}
+	if cons, ok := x.(*constVal); ok {
+		t := tag
+		if tag.Kind() == reflect.Interface { // the constant is compared with its default type
+			t = cons.boundType()
+		}
+		if cons.reserve == -1 { // a typed constant
+			if cons.kind != t.Kind() {
+				logPanicAt(expr, "compileCaseValue failed: mismatched types", cons.Type(), "and", tag)
+			}
+			v, _ = boundConst(cons.v, t)
+			return v, true
+		}
+		v, ok = boundConst(cons.v, t)
+		if !ok {
+			if msg := boundConstErr(cons.v, t); msg != "" {
+				logPanicAt(expr, "compileCaseValue failed:", msg)
+			}
+			logPanicAt(expr, "compileCaseValue failed: cannot use", cons.v, "as", tag, "value.")
+		}
+		cons.reserve.Push(ctx.out, v)
+		return v, true
+	}
+	if t := x.Type(); !t.AssignableTo(tag) && !tag.AssignableTo(t) {
+		logPanicAt(expr, "compileCaseValue failed: mismatched types", t, "and", tag)
+	}
+	return nil, false
+}
+
+func compileCaseBody(ctx *blockCtx, body []ast.Stmt) {
+	for _, stmt := range body {
+		compileStmt(ctx, stmt)
+	}
+}
+
+func compileTypeSwitchStmt(ctx *blockCtx, v *ast.TypeSwitchStmt, label string) {
+	ctx = newNormBlockCtx(ctx)
+	if v.Init != nil {
+		compileStmt(ctx, v.Init)
+	}
+	var name ast.Expr
+	var assert *ast.TypeAssertExpr
+	switch stmt := v.Assign.(type) {
+	case *ast.ExprStmt: // switch x.(type)
+		assert = stmt.X.(*ast.TypeAssertExpr)
+	case *ast.AssignStmt: // switch v := x.(type)
+		name, assert = stmt.Lhs[0], stmt.Rhs[0].(*ast.TypeAssertExpr)
+	default:
+		logPanic("compileTypeSwitchStmt failed: unknown -", reflect.TypeOf(stmt))
+	}
+	compileExpr(ctx, assert.X, 0)
+	x := ctx.infer.Pop().(iValue)
+	if x.NumValues() != 1 || x.Kind() != reflect.Interface {
+		logPanic("compileTypeSwitchStmt failed: non-interface value used as type switch.")
+	}
+	out := ctx.out
+	clauses := v.Body.List
+	bodies := make([]*exec.Label, len(clauses))
+	types := make([]reflect.Type, len(clauses)) // type of the variable declared in each clause
+	done := exec.NewLabel("")
+	var deflt *exec.Label
+	for i, stmt := range clauses {
+		c := stmt.(*ast.CaseClause)
+		bodies[i], types[i] = exec.NewLabel(""), x.Type()
+		if c.List == nil {
+			if deflt != nil {
+				logPanic("compileTypeSwitchStmt failed: multiple defaults in switch.")
+			}
+			deflt = bodies[i]
+			continue
+		}
+		for _, e := range c.List {
+			var t reflect.Type // nil means case nil
+			if ident, ok := e.(*ast.Ident); !ok || ident.Name != "nil" {
+				if t = toType(ctx, e); t == nil {
+					logPanic("compileTypeSwitchStmt failed: not a type -", reflect.TypeOf(e))
+				}
+				if decl := toNamedDecl(ctx, e); decl != nil { // see checkDeclAssign
+					logPanicAt(e, "compileTypeSwitchStmt failed: type", declString(decl, t),
+						"can't be told apart from its underlying type", typeString(t), "in a type switch.")
+				}
+			}
+			next := exec.NewLabel("")
+			out.TypeCase(t).JmpIfFalse(next).Jmp(bodies[i]).Label(next)
+			if len(c.List) == 1 && t != nil {
+				types[i] = t
+			}
+		}
+	}
+	if deflt == nil {
+		out.Pop(1)
+		deflt = done
+	}
+	out.Jmp(deflt)
+	ctx.flow = newFlowCtx(ctx.flow, label, done, nil)
+	for i, stmt := range clauses {
+		c := stmt.(*ast.CaseClause)
+		out.Label(bodies[i])
+		ctxCase := newNormBlockCtx(ctx)
+		storeTop(ctxCase, name, types[i], token.DEFINE)
+		compileCaseBody(ctxCase, c.Body)
+		if i+1 < len(clauses) {
+			out.Jmp(done)
+		}
+	}
+	ctx.flow = ctx.flow.parent
+	out.Label(done)
+}
+
+// -----------------------------------------------------------------------------
+
+func compileSelectStmt(ctx *blockCtx, v *ast.SelectStmt, label string) {
+	out := ctx.out
+	clauses := v.Body.List
+	elems := make([]reflect.Type, len(clauses)) // element types of the receive cases
+	hasDefault := false
+	for i, stmt := range clauses {
+		c := stmt.(*ast.CommClause)
+		switch comm := c.Comm.(type) {
+		case nil: // default
+			if hasDefault {
+				logPanic("compileSelectStmt failed: multiple defaults in select.")
+			}
+			hasDefault = true
+			out.Push(int(reflect.SelectDefault)).Push(nil).Push(nil)
+		case *ast.SendStmt: // case ch <- x
+			out.Push(int(reflect.SelectSend))
+			compileSendValues(ctx, comm)
+		default: // case <-ch, case x = <-ch, case x, ok := <-ch
+			recv := toCommRecv(comm)
+			out.Push(int(reflect.SelectRecv))
+			compileExpr(ctx, recv.X, 0)
+			elems[i] = checkChan(ctx.infer.Pop().(iValue), reflect.RecvDir).Elem()
+			out.Push(nil)
+		}
+	}
+	n := len(clauses)
+	out.Select(n)
+	if n == 0 { // select {} blocks forever
+		return
+	}
+	bodies := make([]*exec.Label, n)
+	for i := range bodies {
+		bodies[i] = exec.NewLabel("")
+	}
+	for i := 0; i < n-1; i++ {
+		next := exec.NewLabel("")
+		out.Push(i)
+		out.CaseNE(next)
+		out.Jmp(bodies[i])
+		out.Label(next)
+	}
+	out.Pop(1)
+	out.Jmp(bodies[n-1])
+	done := exec.NewLabel("")
+	ctx.flow = newFlowCtx(ctx.flow, label, done, nil)
+	for i, stmt := range clauses {
+		c := stmt.(*ast.CommClause)
+		out.Label(bodies[i])
+		body := newNormBlockCtx(ctx)
+		compileCommAssign(body, c.Comm, elems[i])
+		compileCaseBody(body, c.Body)
+		if i+1 < n {
+			out.Jmp(done)
+		}
+	}
+	ctx.flow = ctx.flow.parent
+	out.Label(done)
+}
+
+// toCommRecv returns the receive operation of a receive case in a select statement.
+func toCommRecv(comm ast.Stmt) *ast.UnaryExpr {
+	var expr ast.Expr
+	switch v := comm.(type) {
+	case *ast.ExprStmt: // case <-ch
+		expr = v.X
+	case *ast.AssignStmt: // case x = <-ch, case x, ok := <-ch
+		if len(v.Rhs) == 1 && len(v.Lhs) <= 2 {
+			expr = v.Rhs[0]
+		}
+	}
+	if recv, ok := toRecvExpr(expr); ok {
+		return recv
+	}
+	logPanic("toCommRecv failed: select case must be receive, send or assign recv.")
+	return nil
+}
+
+// compileCommAssign assigns the received value and ok, which are left on the stack
+// by the Select instr, to variables of a receive case `case x, ok := <-ch`. They
+// are discarded if the case doesn't assign them.
+func compileCommAssign(ctx *blockCtx, comm ast.Stmt, elem reflect.Type) {
+	assign, ok := comm.(*ast.AssignStmt)
+	if !ok {
+		ctx.out.Pop(2)
+		return
+	}
+	lhs := assign.Lhs
+	if len(lhs) == 1 {
+		ctx.out.Pop(1)
+		ctx.infer.Push(&goValue{t: elem})
+	} else {
+		ctx.infer.Push(&goValue{t: elem})
+		ctx.infer.Push(&goValue{t: exec.TyBool})
+	}
+	for i := len(lhs) - 1; i >= 0; i-- {
+		compileExpr(ctx, lhs[i], assign.Tok)
+	}
+}
+
+func compileLabeledStmt(ctx *blockCtx, v *ast.LabeledStmt) {
+	switch stmt := v.Stmt.(type) {
+	case *ast.ForStmt:
+		compileForStmt(ctx, stmt, v.Label.Name)
+	case *ast.RangeStmt:
+		compileRangeStmt(ctx, stmt, v.Label.Name)
+	case *ast.SwitchStmt:
+		compileSwitchStmt(ctx, stmt, v.Label.Name)
+	case *ast.TypeSwitchStmt:
+		compileTypeSwitchStmt(ctx, stmt, v.Label.Name)
+	case *ast.SelectStmt:
+		compileSelectStmt(ctx, stmt, v.Label.Name)
+	default:
+		compileStmt(ctx, stmt)
+	}
+}
+
+func compileBranchStmt(ctx *blockCtx, v *ast.BranchStmt) {
+	var label string
+	if v.Label != nil {
+		label = v.Label.Name
+	}
+	out := ctx.out
+	nstk := 0 // values kept on the stack by the statements we jump out of
+	switch v.Tok {
+	case token.BREAK:
+		for flow := ctx.flow; flow != nil; flow = flow.parent {
+			if label == "" || flow.name == label {
+				if nstk > 0 {
+					out.Pop(nstk)
+				}
+				out.Jmp(flow.brk)
+				return
+			}
+			nstk += flow.nstk
+		}
+		if label != "" {
+			logPanic("compileBranchStmt failed: invalid break label -", label)
+		}
+		logPanic("compileBranchStmt failed: break is not in a loop or switch.")
+	case token.CONTINUE:
+		for flow := ctx.flow; flow != nil; flow = flow.parent {
+			if flow.cont != nil && (label == "" || flow.name == label) {
+				if nstk > 0 {
+					out.Pop(nstk)
+				}
+				out.Jmp(flow.cont)
+				return
+			}
+			if label != "" && flow.name == label {
+				break
+			}
+			nstk += flow.nstk
+		}
+		if label != "" {
+			logPanic("compileBranchStmt failed: invalid continue label -", label)
+		}
+		logPanic("compileBranchStmt failed: continue is not in a loop.")
+	case token.FALLTHROUGH:
+		logPanic("compileBranchStmt failed: fallthrough statement out of place.")
+	default:
+		logPanic("compileBranchStmt failed: unsupported -", v.Tok)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+func compileDeferStmt(ctx *blockCtx, v *ast.DeferStmt) {
+	compileCallStmt(ctx, v.Call, token.DEFER)
+}
+
+func compileGoStmt(ctx *blockCtx, v *ast.GoStmt) {
+	compileCallStmt(ctx, v.Call, token.GO)
+}
+
+// compileCallStmt compiles a defer (or go) statement. The function value and
+// arguments of the call are evaluated now, and the call is executed when the
+// function returns (or by a new goroutine).
+func compileCallStmt(ctx *blockCtx, call *ast.CallExpr, tok token.Token) {
+	if ctx.deferCall != nil {
+		logPanic("compileCallStmt failed: unexpected - nested", tok, "statement?")
+	}
+	end := exec.NewLabel("")
+	ctx.deferCall, ctx.deferEnd, ctx.deferTok = call, end, tok
+	if tok == token.GO {
+		ctx.out.StartGo()
+	} else {
+		ctx.out.StartDefer()
+	}
+	compileExpr(ctx, call, 0)
+	if ctx.deferCall != nil { // the call instruction isn't generated
+		ctx.deferCall = nil
+		logPanic("compileCallStmt failed:", tok, "requires a function call -", reflect.TypeOf(call.Fun))
+	}
+	ctx.out.Label(end)
+	ctx.infer.PopN(1) // results of the call are discarded
+}
+
+// -----------------------------------------------------------------------------
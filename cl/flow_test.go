@@ -0,0 +1,877 @@
+package cl
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/qiniu/qlang/ast/asttest"
+	"github.com/qiniu/qlang/exec"
+	"github.com/qiniu/qlang/parser"
+	"github.com/qiniu/qlang/token"
+)
+
+// -----------------------------------------------------------------------------
+
+var fsTestIf = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	x := 0
+	if t := false; t {
+		x = 3
+	} else if !t {
+		x = 5
+	} else {
+		x = 7
+	}
+	println("x:", x)
+`)
+
+func TestIf(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestIf, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(5) {
+		t.Fatal("n:", v)
+	}
+}
+
+var fsTestIfScope = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	x := "Hello"
+	if x := 10; x > 3 {
+		println("x:", x)
+		if x := 1.5; x < 3 {
+			println("x:", x)
+		}
+	}
+	println(x, "world")
+`)
+
+func TestIfScope(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestIfScope, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(12) {
+		t.Fatal("n:", v)
+	}
+	if ctx.Len() != 2 {
+		t.Fatal("stack len:", ctx.Len())
+	}
+}
+
+var fsTestIfFunc = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	import "fmt"
+
+	func max(a, b int) int {
+		if a > b {
+			return a
+		}
+		return b
+	}
+
+	fmt.Println(max(3, 7), max(-9, -10))
+`)
+
+func TestIfFunc(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestIfFunc, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(5) {
+		t.Fatal("n:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestFor = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	sum := 0
+	for i := 0; i < 10; i++ {
+		if i%2 == 0 {
+			continue
+		}
+		sum += i
+	}
+	n := 1
+	for n < 100 {
+		n *= 3
+	}
+	k := 0
+	for {
+		k++
+		if k >= 7 {
+			break
+		}
+	}
+	println(sum, n, k)
+`)
+
+func TestFor(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestFor, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(9) { // 25 243 7
+		t.Fatal("n:", v)
+	}
+	if ctx.Len() != 2 {
+		t.Fatal("stack len:", ctx.Len())
+	}
+}
+
+var fsTestForLabel = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	import "fmt"
+
+	func count(n int) (c int) {
+	outer:
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				if j > i {
+					continue outer
+				}
+				if i == 3 {
+					break outer
+				}
+				c++
+			}
+		}
+		return
+	}
+
+	fmt.Println(count(10))
+`)
+
+func TestForLabel(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestForLabel, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(2) { // 6
+		t.Fatal("n:", v)
+	}
+}
+
+var fsTestForClosure = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	import "fmt"
+
+	s := ""
+	for i := 0; i < 3; i++ {
+		x := "x"
+		add := func(a string) {
+			s += a + x
+		}
+		add("a")
+	}
+	fmt.Println(s)
+`)
+
+func TestForClosure(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestForClosure, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(7) { // axaxax
+		t.Fatal("n:", v)
+	}
+}
+
+var fsTestForInitClosure = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	fs := make(chan func() int, 3)
+	for i := 0; i < 3; i++ {
+		fs <- func() int { return i }
+	}
+	s := 0
+	for i := 0; i < 6; i++ {
+		if i == 1 {
+			skip := func() { i++ }
+			skip()
+			continue
+		}
+		s += i
+	}
+	gs := make(chan func() int, 4)
+	for i := 0; i < 2; i++ {
+		for k := 0; k < 2; k++ {
+			gs <- func() int { return i*10 + k }
+		}
+	}
+	t := 0
+	for j := 0; j < 4; j++ {
+		g := <-gs
+		t = t*100 + g()
+	}
+	f0, f1, f2 := <-fs, <-fs, <-fs
+	println(f0(), f1(), f2(), s, t)
+`)
+
+func TestForInitClosure(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestForInitClosure, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(15) { // 0 1 2 12 11011
+		t.Fatal("n:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestRange = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	s := ""
+	for i, v := range []string{"a", "b", "c"} {
+		if i > 0 {
+			s += "-"
+		}
+		s += v
+	}
+	n := 0
+	for i, c := range "héllo" {
+		if c == 'l' {
+			n += i
+		}
+	}
+	sum := 0
+	for i := range 5 {
+		sum += i
+	}
+	cnt := 0
+	for range "abc" {
+		cnt++
+	}
+	println(s, n, sum, cnt)
+`)
+
+func TestRange(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestRange, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(13) { // a-b-c 7 10 3
+		t.Fatal("n:", v)
+	}
+	if ctx.Len() != 2 {
+		t.Fatal("stack len:", ctx.Len())
+	}
+}
+
+var fsTestRangeBreak = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	import "fmt"
+
+	func find(rows [][]string, x string) (row int, col int) {
+	outer:
+		for i, line := range rows {
+			for j, v := range line {
+				if v == "" {
+					continue outer
+				}
+				if v == x {
+					row, col = i, j
+					break outer
+				}
+			}
+		}
+		return
+	}
+
+	row, col := 0, 0
+	for k := range 3 {
+		row, col = find([][]string{{"a", "b"}, {"", "c"}, {"d", "e", "f"}}, "e")
+		if k == 1 {
+			break
+		}
+	}
+	fmt.Println(row, col)
+`)
+
+func TestRangeBreak(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestRangeBreak, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(4) { // 2 1
+		t.Fatal("n:", v)
+	}
+	if ctx.Len() != 2 {
+		t.Fatal("stack len:", ctx.Len())
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestSwitch = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	func grade(n int) string {
+		switch x := n / 10; x {
+		case 10, 9:
+			return "A"
+		case 8:
+			return "B"
+		case 7, 6:
+			return "C"
+		default:
+			return "D"
+		}
+	}
+
+	s, n := "", 0
+	for i := 45; i <= 100; i += 10 {
+		s += grade(i)
+	}
+	for i := 0; i < 5; i++ {
+		switch {
+		case i == 1:
+			n += 10
+			fallthrough
+		case i == 3:
+			n += 100
+		case i == 4:
+			break
+		default:
+			n++
+		}
+	}
+	println(s, n)
+`)
+
+func TestSwitch(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestSwitch, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(11) { // DDCCBA 212
+		t.Fatal("n:", v)
+	}
+}
+
+var fsTestSwitchErr = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	x := 1
+	switch x {
+	case 1:
+	case 2, 1:
+	}
+	switch x {
+	case 1:
+	case "a":
+	}
+	switch x {
+	case 1:
+		fallthrough
+	}
+	var v interface{} = x
+	switch v {
+	case 1, 1.0, "1":
+	case 1:
+	}
+	switch x {
+	case 300:
+	case 1 << 70:
+	}
+	switch x {
+	default:
+	case 1:
+	default:
+	}
+`)
+
+func TestSwitchErr(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestSwitchErr, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	errs, ok := err.(ErrorList)
+	if !ok {
+		t.Fatal("NewPackage: not an ErrorList -", err)
+	}
+	fmt.Println(errs)
+	expected := []struct {
+		pos token.Position
+		msg string
+	}{
+		{token.Position{Filename: "/foo/bar.ql", Line: 5, Column: 10}, "duplicate case 1"},
+		{token.Position{Filename: "/foo/bar.ql", Line: 9, Column: 7}, "mismatched types string and int"},
+		{token.Position{Filename: "/foo/bar.ql", Line: 13, Column: 3}, "cannot fallthrough final case"},
+		{token.Position{Filename: "/foo/bar.ql", Line: 18, Column: 7}, "duplicate case 1"},
+		{token.Position{Filename: "/foo/bar.ql", Line: 22, Column: 7}, "overflows int"},
+		{token.Position{Filename: "/foo/bar.ql", Line: 27, Column: 2}, "multiple defaults"},
+	}
+	if len(errs) != len(expected) {
+		t.Fatal("NewPackage: unexpected errors -", len(errs), errs)
+	}
+	for i, e := range errs {
+		pos := e.Pos
+		pos.Offset = 0
+		if pos != expected[i].pos || !strings.Contains(e.Msg, expected[i].msg) {
+			t.Fatal("NewPackage: unexpected error -", e)
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestTypeSwitch = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	import "fmt"
+
+	func kind(x interface{}) string {
+		switch v := x.(type) {
+		case int:
+			if v > 10 {
+				return "big"
+			}
+			return "int"
+		case string:
+			return v + "!"
+		case float64, error:
+			return "num/err"
+		case nil:
+			return "nil"
+		}
+		return "other"
+	}
+
+	_, err := fmt.Print("")
+	println(kind(1), kind(12), kind("s"), kind(1.5), kind(err), kind(true))
+`)
+
+func TestTypeSwitch(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestTypeSwitch, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(29) { // int big s! num/err nil other
+		t.Fatal("n:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestDefer = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	func f(n int) (ret int) {
+		defer func() {
+			ret *= 10
+		}()
+		for i := 1; i <= n; i++ {
+			defer print(i, " ")
+		}
+		return n + 1
+	}
+
+	x := f(3)
+	println(x)
+`)
+
+func TestDefer(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestDefer, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(3) { // 40
+		t.Fatal("n:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestPanicRecover = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	func div(a, b int) (q int, err interface{}) {
+		defer func() {
+			err = recover()
+		}()
+		q = a / b
+		return
+	}
+
+	func g() (v interface{}, tag string) {
+		defer func(x string) {
+			tag = x
+		}("g:")
+		defer func() {
+			v = recover()
+		}()
+		panic("oops")
+	}
+
+	q1, e1 := div(7, 2)
+	q2, e2 := div(7, 0)
+	v, tag := g()
+	println(q1, e1, q2, e2, tag, v, recover())
+`)
+
+func TestPanicRecover(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestPanicRecover, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(62) { // 3 <nil> 0 runtime error: integer divide by zero g: oops <nil>
+		t.Fatal("n:", v)
+	}
+}
+
+var fsTestRecoverNil = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	func safe(s string) (msg string) {
+		defer func() {
+			if r := recover(); r != nil {
+				if r == "boom" {
+					msg = "caught boom"
+				} else {
+					msg = "caught other"
+				}
+			}
+		}()
+		if s != "" {
+			panic(s)
+		}
+		return "ok"
+	}
+
+	var p *int
+	m := map[string]int{}
+	println(safe(""), safe("boom"), safe("x"), p == nil, nil != m)
+`)
+
+func TestRecoverNil(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestRecoverNil, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(38) { // ok caught boom caught other true true
+		t.Fatal("n:", v)
+	}
+}
+
+var fsTestNilCompareErr = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	s := "x"
+	println(s == nil)
+	println(nil == nil)
+`)
+
+func TestNilCompareErr(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestNilCompareErr, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	errs, ok := err.(ErrorList)
+	fmt.Println(errs)
+	if !ok || len(errs) != 2 || errs[0].Pos.Line != 3 || errs[1].Pos.Line != 4 {
+		t.Fatal("NewPackage: unexpected errors -", err)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestUncaughtPanic = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	func f() {
+		defer func() {
+			panic("second")
+		}()
+		panic("first")
+	}
+
+	f()
+`)
+
+func TestUncaughtPanic(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestUncaughtPanic, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	err = ctx.Run(0, code.Len())
+	fmt.Println(err)
+	if e, ok := err.(*exec.RuntimeError); !ok || e.Value != "second" {
+		t.Fatal("Run failed:", err)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestLoopClosure = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	func f() (n int) {
+		for i := 0; i < 3; i++ {
+			j := i
+			g := func() { n = n*10 + j }
+			defer g()
+			defer func() { n = n*10 + j + 5 }()
+		}
+		return
+	}
+
+	fs := make(chan func() int, 2)
+	for k, v := range []int{10, 20} {
+		m := 0
+		fs <- func() int { m++; return k + v + m }
+	}
+	f1, f2 := <-fs, <-fs
+	a := f1()*1000 + f1()*100 + f2()
+	println(f() == 726150, a == 12222)
+`)
+
+func TestLoopClosure(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestLoopClosure, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(10) { // true true
+		t.Fatal("n:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
@@ -0,0 +1,203 @@
+package cl
+
+import (
+	"reflect"
+
+	"github.com/qiniu/qlang/ast"
+	"github.com/qiniu/qlang/exec"
+	"github.com/qiniu/qlang/token"
+)
+
+// -----------------------------------------------------------------------------
+
+// A Session compiles a qlang package piece by piece (eg. lines of a REPL). Symbols
+// declared by a piece can be used by the later ones.
+type Session struct {
+	pkg *pkgCtx
+	ctx *blockCtx // the global blockCtx
+}
+
+// NewSession creates a compile session. fset is the file set that the pieces are
+// parsed with, and conf is the configuration of compiling (nil means the default
+// configuration).
+func NewSession(fset *token.FileSet, conf *Config) *Session {
+	pkg := newPkgCtx(nil, fset, conf)
+	ctx := newGblBlockCtx(pkg, nil)
+	ctx.file = newFileCtx(ctx)
+	return &Session{pkg: pkg, ctx: ctx}
+}
+
+// sessionState is the state of a Session before a piece is compiled, to roll back
+// the declarations of the piece if it fails to compile.
+type sessionState struct {
+	syms    map[string]iSymbol
+	imports map[string]string
+	types   map[reflect.Type]*typeDecl
+	methods map[*typeDecl]map[string]*methodDecl
+	nvars   int
+	ninits  int
+}
+
+func (p *Session) save() *sessionState {
+	ctx, pkg := p.ctx, p.pkg
+	state := &sessionState{
+		syms:    make(map[string]iSymbol, len(ctx.syms)),
+		imports: make(map[string]string, len(ctx.file.imports)),
+		types:   make(map[reflect.Type]*typeDecl, len(pkg.types)),
+		methods: make(map[*typeDecl]map[string]*methodDecl),
+		nvars:   len(pkg.vars),
+		ninits:  len(pkg.inits),
+	}
+	for name, sym := range ctx.syms {
+		state.syms[name] = sym
+		if decl, ok := sym.(*typeDecl); ok {
+			methods := make(map[string]*methodDecl, len(decl.Methods))
+			for name, m := range decl.Methods {
+				methods[name] = m
+			}
+			state.methods[decl] = methods
+		}
+	}
+	for name, pkgPath := range ctx.file.imports {
+		state.imports[name] = pkgPath
+	}
+	for t, decl := range pkg.types {
+		state.types[t] = decl
+	}
+	return state
+}
+
+func (p *Session) restore(state *sessionState) {
+	ctx, pkg := p.ctx, p.pkg
+	ctx.syms = state.syms
+	ctx.file.imports = state.imports
+	pkg.types = state.types
+	for decl, methods := range state.methods {
+		decl.Methods = methods
+	}
+	pkg.vars = pkg.vars[:state.nvars]
+	pkg.inits = pkg.inits[:state.ninits]
+}
+
+// Compile compiles a piece of the package, which is a file parsed by
+// parser.ParseFile: its declarations, and then the statements out of functions
+// (that is, the body of its main function). Global variables declared by f are
+// initialized before the statements.
+//
+// The instructions are appended by out, which must be created by exec.NewBuilder
+// with the code of the former pieces. Then the piece is executed by running the
+// code from the length of the code before Compile, with a context that has the
+// global variables of the former pieces (see exec.Context.GrowVars). Results of
+// expression statements are left on the stack.
+//
+// If there are compile errors, Compile returns them as an ErrorList, and the
+// declarations of f are discarded. The code built by out mustn't be executed
+// then, and the former code is kept by creating a new builder of it.
+func (p *Session) Compile(out *exec.Builder, f *ast.File) error {
+	pkg, ctx := p.pkg, p.ctx
+	pkg.out, pkg.errs = out.SetRegistry(pkg.reg), nil
+	state := p.save()
+	var body *ast.BlockStmt
+	for _, decl := range f.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil && fn.Name.Name == "main" {
+			body = fn.Body
+			continue
+		}
+		loadDecl(ctx, decl)
+	}
+	for name, sym := range ctx.syms {
+		if _, ok := state.syms[name]; ok {
+			if decl, ok := sym.(*typeDecl); ok && decl.typ != nil {
+				p.resolveNewMethods(decl, state.methods[decl])
+			}
+			continue
+		}
+		switch v := sym.(type) {
+		case *constDecl:
+			pkg.evalConst(v)
+		case *typeDecl:
+			pkg.resolveType(v)
+		}
+	}
+	initVars(ctx, pkg.vars[state.nvars:])
+	for _, f := range pkg.inits[state.ninits:] {
+		ctx.use(f)
+		out.CallFunc(f.getFuncInfo())
+	}
+	if body != nil {
+		compileBlockStmt(ctx, body)
+	}
+	out.Return(-1)
+	compiled := pkg.resolveFuncs()
+	if len(pkg.errs) > 0 {
+		p.restore(state)
+		for _, f := range compiled { // they will be compiled again, into a new builder
+			f.reset()
+		}
+		pkg.errs.RemoveMultiples()
+		return pkg.errs
+	}
+	return nil
+}
+
+// resolveNewMethods registers methods of a type declared by a former piece, if the
+// piece declares more methods of it. old are the methods declared before.
+func (p *Session) resolveNewMethods(decl *typeDecl, old map[string]*methodDecl) {
+	var node ast.Node // locate the error at the first new method
+	for name, m := range decl.Methods {
+		if _, ok := old[name]; !ok && (node == nil || m.typ.Pos() < node.Pos()) {
+			node = m.typ
+		}
+	}
+	if node == nil {
+		return
+	}
+	pkg := p.pkg
+	defer pkg.recoverErr(node, pkg.infer.Len())
+	pkg.registerMethods(decl.typ, decl)
+}
+
+// TypeOf returns types of the values of expression x, which can use symbols of the
+// session. The type of an untyped constant is its default type. If there are
+// compile errors, TypeOf returns them as an ErrorList.
+func (p *Session) TypeOf(x ast.Expr) (types []reflect.Type, err error) {
+	pkg, ctx := p.pkg, p.ctx
+	out, nused := pkg.out, len(pkg.usedfns)
+	defer func() {
+		pkg.out = out
+		for _, f := range pkg.usedfns[nused:] { // they aren't compiled
+			f.used = false
+		}
+		pkg.usedfns = pkg.usedfns[:nused]
+	}()
+	pkg.out, pkg.errs = exec.NewBuilder(nil), nil
+	base := pkg.infer.Len()
+	mode := compleMode(0)
+	if _, ok := x.(*ast.Ident); ok { // it may be a function, which compileIdent can only infer
+		mode = inferOnly
+	}
+	func() {
+		defer pkg.recoverErr(x, base)
+		compileExpr(ctx, x, mode)
+		v := pkg.infer.Pop().(iValue)
+		for i, n := 0, v.NumValues(); i < n; i++ {
+			types = append(types, boundType(v.Value(i)))
+		}
+	}()
+	if len(pkg.errs) > 0 {
+		pkg.errs.RemoveMultiples()
+		return nil, pkg.errs
+	}
+	return types, nil
+}
+
+// reset makes the function uncompiled, after the code it is compiled into is
+// discarded.
+func (p *funcDecl) reset() {
+	ctx := newBlockCtx(p.ctx.parent)
+	ctx.file = p.ctx.file
+	p.fi = exec.NewFunc(p.fi.Name, ctx.getNestDepth())
+	p.ctx, p.used = ctx, false
+}
+
+// -----------------------------------------------------------------------------
@@ -0,0 +1,113 @@
+package cl
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/qiniu/qlang/ast"
+	"github.com/qiniu/qlang/exec"
+	"github.com/qiniu/qlang/parser"
+	"github.com/qiniu/qlang/token"
+)
+
+// -----------------------------------------------------------------------------
+
+func TestSession(t *testing.T) {
+	fset := token.NewFileSet()
+	sess := NewSession(fset, nil)
+	code := exec.NewCode()
+	ctx := exec.NewContext(code)
+	run := func(src string) error {
+		f, err := parser.ParseFile(fset, "", src, 0)
+		if err != nil {
+			t.Fatal("ParseFile failed:", err)
+		}
+		b := exec.NewBuilder(code)
+		start := code.Len()
+		if err = sess.Compile(b, f); err != nil {
+			return err
+		}
+		b.Resolve()
+		ctx.GrowVars()
+		ctx.SetLen(0)
+		ctx.Exec(start, code.Len())
+		return nil
+	}
+
+	if err := run(`import "strings"; x := 3`); err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	if err := run(`
+		func f(s string) string {
+			r := s
+			for i := 1; i < x; i++ {
+				r += s
+			}
+			return r
+		}`); err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	if err := run(`y := f("ab"); undefined()`); err == nil {
+		t.Fatal("Compile: no error")
+	}
+	if err := run(`y := f("ab"); y; strings.NewReplacer("a", "x").Replace(y); 1 + 2`); err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	if ctx.Len() != 3 || ctx.Get(-3) != "ababab" || ctx.Get(-2) != "xbxbxb" || ctx.Get(-1) != 3 {
+		t.Fatal("results:", ctx.Len(), ctx.Get(-3), ctx.Get(-2), ctx.Get(-1))
+	}
+
+	f, err := parser.ParseFile(fset, "", `f(y) + "c"`, 0)
+	if err != nil {
+		t.Fatal("ParseFile failed:", err)
+	}
+	x := f.Decls[0].(*ast.FuncDecl).Body.List[0].(*ast.ExprStmt).X
+	types, err := sess.TypeOf(x)
+	if err != nil || len(types) != 1 || types[0] != reflect.TypeOf("") {
+		t.Fatal("TypeOf failed:", types, err)
+	}
+	types, err = sess.TypeOf(&ast.Ident{Name: "f"})
+	if err != nil || len(types) != 1 || types[0] != reflect.TypeOf(func(string) string { return "" }) {
+		t.Fatal("TypeOf failed:", types, err)
+	}
+
+	if err := run(`a := 1; pa := &a`); err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	if err := run(`b := 2; a = 5; *pa + b`); err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	if ctx.Len() != 1 || ctx.Get(-1) != 7 {
+		t.Fatal("results:", ctx.Len(), ctx.Get(-1))
+	}
+
+	if err := run(`type T struct{ A int }`); err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	if err := run(`func (t T) M() int { return t.A * 2 }`); err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	if err := run(`T{5}.M()`); err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	if ctx.Len() != 1 || ctx.Get(-1) != 10 {
+		t.Fatal("results:", ctx.Len(), ctx.Get(-1))
+	}
+	err = run(`T{5}.X()`)
+	if err == nil || !strings.Contains(err.Error(), "type T has no field or method X") {
+		t.Fatal("Compile: unexpected error", err)
+	}
+	err = run(`func (t T) A() int { return 0 }`)
+	if err == nil || !strings.Contains(err.Error(), "type T has both field and method named A") {
+		t.Fatal("Compile: unexpected error", err)
+	}
+	if err := run(`func (t T) N() int { return t.A + 1 }; T{5}.N()`); err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	if ctx.Len() != 1 || ctx.Get(-1) != 6 {
+		t.Fatal("results:", ctx.Len(), ctx.Get(-1))
+	}
+}
+
+// -----------------------------------------------------------------------------
@@ -3,10 +3,11 @@ package cl
 import (
 	"reflect"
 	"strconv"
+	"strings"
 
 	"github.com/qiniu/qlang/ast"
+	"github.com/qiniu/qlang/ast/astutil"
 	"github.com/qiniu/qlang/exec"
-	"github.com/qiniu/x/log"
 )
 
 type iType = reflect.Type
@@ -19,11 +20,12 @@ func toTypeEx(ctx *blockCtx, typ ast.Expr) (t iType, variadic bool) {
 		elem := toType(ctx, v.Elt)
 		return reflect.SliceOf(elem), true
 	}
-	log.Panicln("toType: unknown -", reflect.TypeOf(typ))
+	logPanic("toType: unknown -", reflect.TypeOf(typ))
 	return nil, false
 }
 
 func toType(ctx *blockCtx, typ ast.Expr) iType {
+	defer locateErr(typ)
 	switch v := typ.(type) {
 	case *ast.Ident:
 		return toIdentType(ctx, v.Name)
@@ -67,7 +69,9 @@ func toFuncType(ctx *blockCtx, t *ast.FuncType) iType {
 	return reflect.FuncOf(in, out, variadic)
 }
 
-func buildFuncType(fi *exec.FuncInfo, ctx *blockCtx, t *ast.FuncType) {
+// buildFuncType sets type of a function, and inserts its arguments and results
+// into ctx. It returns declared types of the results (see toNamedDecl).
+func buildFuncType(fi *exec.FuncInfo, ctx *blockCtx, t *ast.FuncType) []*typeDecl {
 	in, args, variadic := toArgTypes(ctx, t.Params)
 	rets := toReturnTypes(ctx, t.Results)
 	if variadic {
@@ -76,7 +80,10 @@ func buildFuncType(fi *exec.FuncInfo, ctx *blockCtx, t *ast.FuncType) {
 		fi.Args(in...)
 	}
 	fi.Return(rets...)
-	ctx.insertFuncVars(in, args, rets)
+	fi.ArgNames(args...)
+	results := toFieldDecls(ctx, t.Results)
+	ctx.insertFuncVars(in, args, toFieldDecls(ctx, t.Params), rets, results)
+	return results
 }
 
 func toTypes(ctx *blockCtx, fields *ast.FieldList) (types []iType) {
@@ -90,7 +97,7 @@ func toTypes(ctx *blockCtx, fields *ast.FieldList) (types []iType) {
 		}
 		typ := toType(ctx, field.Type)
 		if typ == nil {
-			log.Panicln("toType: unknown -", reflect.TypeOf(field.Type))
+			logPanic("toType: unknown -", reflect.TypeOf(field.Type))
 		}
 		for i := 0; i < n; i++ {
 			types = append(types, typ)
@@ -114,7 +121,7 @@ func toTypesEx(ctx *blockCtx, fields *ast.FieldList) ([]iType, bool) {
 		}
 		if variadic {
 			if i != last {
-				log.Panicln("toTypes failed: the variadic type isn't last argument?")
+				logPanic("toTypes failed: the variadic type isn't last argument?")
 			}
 			return types, true
 		}
@@ -131,7 +138,7 @@ func toReturnTypes(ctx *blockCtx, fields *ast.FieldList) (vars []*exec.Var) {
 		n := len(field.Names)
 		typ := toType(ctx, field.Type)
 		if typ == nil {
-			log.Panicln("toType: unknown -", reflect.TypeOf(field.Type))
+			logPanic("toType: unknown -", reflect.TypeOf(field.Type))
 		}
 		if n == 0 {
 			index++
@@ -167,7 +174,7 @@ func toArgTypes(ctx *blockCtx, fields *ast.FieldList) ([]iType, []string, bool)
 		}
 		if variadic {
 			if i != last {
-				log.Panicln("toTypes failed: the variadic type isn't last argument?")
+				logPanic("toTypes failed: the variadic type isn't last argument?")
 			}
 			return types, names, true
 		}
@@ -175,35 +182,250 @@ func toArgTypes(ctx *blockCtx, fields *ast.FieldList) ([]iType, []string, bool)
 	return types, names, false
 }
 
+// pkgPathScript is the package path of unexported fields of struct types defined by qlang scripts.
+const pkgPathScript = "main"
+
 func toStructType(ctx *blockCtx, v *ast.StructType) iType {
-	panic("toStructType: todo")
+	var fields []reflect.StructField
+	for _, field := range v.Fields.List {
+		typ := toType(ctx, field.Type)
+		if typ == nil {
+			logPanic("toStructType failed: unknown field type -", reflect.TypeOf(field.Type))
+		}
+		var tag reflect.StructTag
+		if field.Tag != nil {
+			tag = reflect.StructTag(astutil.ToString(field.Tag))
+		}
+		if len(field.Names) == 0 { // embedded field
+			name := toEmbeddedName(field.Type)
+			if !ast.IsExported(name) { // reflect.StructOf doesn't support it
+				logPanic("toStructType failed: embedded field of unexported type is unsupported -", name)
+			}
+			fields = append(fields, toStructField(name, typ, tag, true))
+			continue
+		}
+		for _, name := range field.Names {
+			fields = append(fields, toStructField(name.Name, typ, tag, false))
+		}
+	}
+	return reflect.StructOf(fields)
+}
+
+func toStructField(name string, typ iType, tag reflect.StructTag, embedded bool) reflect.StructField {
+	f := reflect.StructField{Name: name, Type: typ, Tag: tag, Anonymous: embedded}
+	if !ast.IsExported(name) {
+		f.PkgPath = pkgPathScript
+	}
+	return f
+}
+
+// toEmbeddedName returns name of an embedded field, that is, the unqualified type
+// name of T, *T, pkg.T or *pkg.T.
+func toEmbeddedName(typ ast.Expr) string {
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+	switch v := typ.(type) {
+	case *ast.Ident:
+		return v.Name
+	case *ast.SelectorExpr:
+		return v.Sel.Name
+	}
+	logPanic("toEmbeddedName failed: embedded type must be a type name -", reflect.TypeOf(typ))
+	return ""
 }
 
 func toInterfaceType(ctx *blockCtx, v *ast.InterfaceType) iType {
+	if v.Methods == nil || len(v.Methods.List) == 0 {
+		return exec.TyEmptyInterface
+	}
 	panic("toInterfaceType: todo")
 }
 
 func toExternalType(ctx *blockCtx, v *ast.SelectorExpr) iType {
-	panic("toExternalType: todo")
+	if x, ok := v.X.(*ast.Ident); ok {
+		if sym, ok := ctx.find(x.Name); ok {
+			if pkgPath, ok := sym.(string); ok {
+				pkg := ctx.findGoPackage(pkgPath)
+				if typ, ok := pkg.FindType(v.Sel.Name); ok {
+					return typ
+				}
+				logPanic("toExternalType failed: type not found -", x.Name+"."+v.Sel.Name)
+			}
+		}
+	}
+	logPanic("toExternalType failed: not a type of a Go package -", v.X)
+	return nil
+}
+
+// toNamedDecl returns the declared type T if typ is T or *T, where T is a declared
+// type that isn't a struct type. Such a type is its underlying type at runtime (a
+// declared struct type has its own identity, see newNamedStruct), so values of it
+// are tracked by the compiler, to find their methods.
+func toNamedDecl(ctx *blockCtx, typ ast.Expr) *typeDecl {
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+	ident, ok := typ.(*ast.Ident)
+	if !ok {
+		return nil
+	}
+	sym, ok := ctx.find(ident.Name)
+	if !ok {
+		return nil
+	}
+	decl, ok := sym.(*typeDecl)
+	if !ok || decl.spec == nil {
+		return nil
+	}
+	if decl.Alias {
+		return toNamedDecl(decl.ctx, decl.spec.Type)
+	}
+	if decl.getType().Kind() == reflect.Struct {
+		return nil
+	}
+	return decl
+}
+
+// toFieldDecls returns declared types (see toNamedDecl) of the values of fields, or
+// nil if none of them has one.
+func toFieldDecls(ctx *blockCtx, fields *ast.FieldList) (decls []*typeDecl) {
+	if fields == nil {
+		return
+	}
+	found := false
+	for _, field := range fields.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		decl := toNamedDecl(ctx, field.Type)
+		for i := 0; i < n; i++ {
+			decls = append(decls, decl)
+		}
+		found = found || decl != nil
+	}
+	if !found {
+		return nil
+	}
+	return
 }
 
 func toIdentType(ctx *blockCtx, ident string) iType {
+	if sym, ok := ctx.find(ident); ok {
+		if decl, ok := sym.(*typeDecl); ok {
+			return decl.getType()
+		}
+		logPanic("toIdentType failed:", ident, "is not a type")
+	}
 	if typ, ok := ctx.builtin.FindType(ident); ok {
 		return typ
 	}
-	log.Panicln("toIdentType failed: unknown ident -", ident)
+	logPanic("toIdentType failed: unknown ident -", ident)
 	return nil
 }
 
 func toArrayType(ctx *blockCtx, v *ast.ArrayType) iType {
-	panic("toArrayType: todo")
+	elem := toType(ctx, v.Elt)
+	if elem == nil {
+		logPanic("toArrayType failed: unknown element type -", reflect.TypeOf(v.Elt))
+	}
+	if v.Len == nil {
+		return reflect.SliceOf(elem)
+	}
+	if _, ok := v.Len.(*ast.Ellipsis); ok {
+		logPanic("toArrayType failed: use of [...] array outside of array literal.")
+	}
+	n := evalConstInt(ctx, v.Len)
+	if n < 0 {
+		logPanic("toArrayType failed: invalid array length -", n)
+	}
+	return reflect.ArrayOf(n, elem)
 }
 
 // -----------------------------------------------------------------------------
 
+// A typeDecl represents a type declaration. Its type is resolved when it is used
+// the first time. Note that a struct type is created by reflect.StructOf, so it
+// can't refer to itself, and it is given its own identity by newNamedStruct. Other
+// types have the same reflect.Type as their underlying types, see checkDeclAssign.
 type typeDecl struct {
 	Methods map[string]*methodDecl
 	Alias   bool
+	spec    *ast.TypeSpec // nil if methods of the type are declared, but the type isn't
+	ctx     *blockCtx
+	typ     iType
+	busy    bool // it is being resolved
+}
+
+func (p *typeDecl) getType() iType {
+	if p.typ != nil {
+		return p.typ
+	}
+	if p.spec == nil {
+		logPanic("typeDecl.getType failed: undefined type (methods are declared, but the type isn't).")
+	}
+	if p.busy {
+		logPanic("typeDecl.getType failed: invalid recursive type -", p.spec.Name.Name)
+	}
+	p.busy = true
+	defer func() { p.busy = false }()
+	typ := toType(p.ctx, p.spec.Type)
+	if typ == nil {
+		logPanic("typeDecl.getType failed: unknown type -", reflect.TypeOf(p.spec.Type))
+	}
+	if st, ok := p.spec.Type.(*ast.StructType); ok && !p.Alias {
+		typ = p.ctx.newNamedStruct(typ, p.spec.Name.Name)
+		if decls := toFieldDecls(p.ctx, st.Fields); decls != nil {
+			p.ctx.fields[typ] = decls
+		}
+	}
+	if p.Methods != nil {
+		p.ctx.registerMethods(typ, p)
+	}
+	p.typ = typ
+	return typ
+}
+
+// newNamedStruct returns a struct type that has the same fields as t, but isn't
+// identical to any other struct type, so two declared struct types with the same
+// fields are different types. The tag of its first field is marked with name and
+// the count of declared struct types (a blank field is added if t has no fields).
+func (p *pkgCtx) newNamedStruct(t iType, name string) iType {
+	p.nstructs++
+	mark := `qlang:"` + name + "#" + strconv.Itoa(p.nstructs) + `"`
+	fields := make([]reflect.StructField, t.NumField())
+	for i := range fields {
+		fields[i] = t.Field(i)
+	}
+	if len(fields) == 0 {
+		fields = append(fields, toStructField("_", tyMark, reflect.StructTag(mark), false))
+	} else if tag := fields[0].Tag; tag != "" {
+		fields[0].Tag = tag + " " + reflect.StructTag(mark)
+	} else {
+		fields[0].Tag = reflect.StructTag(mark)
+	}
+	return reflect.StructOf(fields)
+}
+
+var tyMark = reflect.TypeOf(struct{}{})
+
+// typeString returns the string of t for error messages, where a declared struct
+// type (see newNamedStruct) is represented by its name.
+func typeString(t iType) string {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return "*" + typeString(t.Elem())
+	case reflect.Struct:
+		if t.NumField() > 0 {
+			if mark, ok := t.Field(0).Tag.Lookup("qlang"); ok {
+				if pos := strings.IndexByte(mark, '#'); pos > 0 {
+					return mark[:pos]
+				}
+			}
+		}
+	}
+	return t.String()
 }
 
 type methodDecl struct {
@@ -212,14 +434,42 @@ type methodDecl struct {
 	typ     *ast.FuncType
 	body    *ast.BlockStmt
 	file    *fileCtx
+	fn      *funcDecl
+}
+
+// getFunc returns the method as a function whose first argument is the receiver.
+func (p *methodDecl) getFunc(t *typeDecl, name string) *funcDecl {
+	if p.fn != nil {
+		return p.fn
+	}
+	typeName := t.spec.Name.Name
+	var recvType ast.Expr = ast.NewIdent(typeName)
+	if p.pointer > 0 {
+		recvType = &ast.StarExpr{X: recvType}
+		name = "(*" + typeName + ")." + name
+	} else {
+		name = typeName + "." + name
+	}
+	recv := &ast.Field{Type: recvType}
+	if p.recv != "" {
+		recv.Names = []*ast.Ident{ast.NewIdent(p.recv)}
+	}
+	params := &ast.FieldList{List: append([]*ast.Field{recv}, p.typ.Params.List...)}
+	typ := &ast.FuncType{Func: p.typ.Func, Params: params, Results: p.typ.Results}
+	ctx := newBlockCtx(p.file.blockCtx)
+	ctx.file = p.file
+	p.fn = newFuncDecl(name, typ, p.body, ctx)
+	return p.fn
 }
 
 type funcDecl struct {
-	typ  *ast.FuncType
-	body *ast.BlockStmt
-	ctx  *blockCtx
-	fi   *exec.FuncInfo
-	used bool
+	typ     *ast.FuncType
+	body    *ast.BlockStmt
+	ctx     *blockCtx
+	fi      *exec.FuncInfo
+	results []*typeDecl // declared types of the results, see toNamedDecl
+	cells   []*execVar  // cells that closures of a function literal capture, see compileFuncLit
+	used    bool
 }
 
 func newFuncDecl(name string, typ *ast.FuncType, body *ast.BlockStmt, ctx *blockCtx) *funcDecl {
@@ -229,7 +479,7 @@ func newFuncDecl(name string, typ *ast.FuncType, body *ast.BlockStmt, ctx *block
 
 func (p *funcDecl) getFuncInfo() *exec.FuncInfo {
 	if !p.fi.IsTypeValid() {
-		buildFuncType(p.fi, p.ctx, p.typ)
+		p.results = buildFuncType(p.fi, p.ctx, p.typ)
 	}
 	return p.fi
 }
@@ -244,6 +494,7 @@ func (p *funcDecl) compile() {
 	out := ctx.out
 	out.DefineFunc(fun)
 	ctx.fun = fun
+	ctx.addressArgs(p.body)
 	compileBlockStmt(ctx, p.body)
 	ctx.fun = nil
 	out.EndFunc(fun)
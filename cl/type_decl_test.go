@@ -0,0 +1,1034 @@
+package cl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/qiniu/qlang/ast/asttest"
+	"github.com/qiniu/qlang/exec"
+	"github.com/qiniu/qlang/parser"
+	"github.com/qiniu/qlang/token"
+
+	_ "github.com/qiniu/qlang/lib/net/http"
+)
+
+// -----------------------------------------------------------------------------
+
+var fsTestStructType = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	import "fmt"
+
+	type Point struct {
+		X, Y int
+	}
+
+	type Rect struct {
+		Min, Max Point
+		name     string
+	}
+
+	r := Rect{Min: Point{1, 2}, Max: Point{X: 5}, name: "rect"}
+	r.Max.Y = 8
+	r.Min.X += 2
+	r.name += "!"
+	fmt.Println(r.Min.X, r.Min.Y, r.Max.X, r.Max.Y, r.name, r)
+`)
+
+func TestStructType(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestStructType, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(34) {
+		t.Fatal("n:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestStructPtr = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	import "fmt"
+
+	type Node struct {
+		Val  int
+		Next *Leaf
+	}
+
+	type Leaf struct {
+		Val int
+	}
+
+	func inc(n *Node) {
+		n.Val++
+		n.Next.Val *= 10
+	}
+
+	p := &Node{Val: 1, Next: &Leaf{2}}
+	inc(p)
+	n := *p
+	q := &n.Val
+	*q = 7
+	sum := 0
+	for _, node := range []*Node{{Val: 3}, p} {
+		sum += node.Val
+	}
+	fmt.Println(p.Val, p.Next.Val, n.Val, (*p).Val, sum)
+`)
+
+func TestStructPtr(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestStructPtr, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(11) {
+		t.Fatal("n:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestEmbeddedField = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	import "fmt"
+
+	type Base struct {
+		ID   int
+		Name string
+	}
+
+	type Extra struct {
+		Tag string
+	}
+
+	type User struct {
+		Base
+		*Extra
+		Age int
+	}
+
+	u := User{Base: Base{ID: 1, Name: "bob"}, Extra: &Extra{"admin"}, Age: 20}
+	u.ID = 2
+	u.Base.Name = "alice"
+	u.Tag += "!"
+	fmt.Println(u.ID, u.Name, u.Tag, u.Age, u.Extra.Tag)
+`)
+
+func TestEmbeddedField(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestEmbeddedField, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(25) {
+		t.Fatal("n:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestMethod = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	import "fmt"
+
+	func (c *Counter) Inc(n int) {
+		c.n += n
+	}
+
+	type Counter struct {
+		n int
+	}
+
+	func (c Counter) Get() int {
+		return c.n
+	}
+
+	func (c Counter) Sum(args ...int) int {
+		s := c.n
+		for _, a := range args {
+			s += a
+		}
+		return s
+	}
+
+	type Named struct {
+		*Counter
+		Name string
+	}
+
+	var c Counter
+	c.Inc(3)
+	p := &c
+	p.Inc(2)
+	x := Named{&Counter{}, "x"}
+	x.Inc(7)
+	fmt.Println(c.Get(), p.Get(), x.Get(), c.Sum(1, 2), x.Sum())
+`)
+
+func TestMethod(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestMethod, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(10) {
+		t.Fatal("n:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestMethodValue = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	import "fmt"
+
+	type Counter struct {
+		n int
+	}
+
+	func (c *Counter) Inc(n int) {
+		c.n += n
+	}
+
+	func (c Counter) Get() int {
+		return c.n
+	}
+
+	var c Counter
+	get := c.Get
+	inc := c.Inc
+	inc(10)
+	f := Counter.Get
+	g := (*Counter).Inc
+	g(&c, 100)
+	fmt.Println(get(), f(c), c.n)
+`)
+
+func TestMethodValue(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestMethodValue, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(10) {
+		t.Fatal("n:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestLocalType = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	func area(w, h float64) float64 {
+		type size struct {
+			w, h float64
+		}
+		var s size
+		s.w, s.h = w, h
+		return s.w * s.h
+	}
+
+	println(area(1.5, 4))
+`)
+
+func TestLocalType(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestLocalType, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(2) {
+		t.Fatal("n:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestGoInterface = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	import (
+		"fmt"
+		"io"
+	)
+
+	type Counter struct {
+		n int
+	}
+
+	func (c *Counter) Write(b []byte) (n int, err error) {
+		for range b {
+			n++
+		}
+		c.n += n
+		return
+	}
+
+	c := &Counter{}
+	fmt.Fprintln(c, "Hello", "world")
+	io.WriteString(c, "!!")
+	println(c.n)
+`)
+
+func TestGoInterface(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestGoInterface, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(3) {
+		t.Fatal("n:", v)
+	}
+}
+
+var fsTestGoInterfacePromoted = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	import "io"
+
+	type Counter struct {
+		n int
+	}
+
+	func (c *Counter) Write(b []byte) (n int, err error) {
+		for range b {
+			n++
+		}
+		c.n += n
+		return
+	}
+
+	type Logger struct {
+		prefix string
+		*Counter
+	}
+
+	type Buffer struct {
+		Counter
+	}
+
+	l := Logger{"x", &Counter{}}
+	io.WriteString(l, "abc")
+	b := &Buffer{}
+	io.WriteString(b, "de")
+	println(l.n, b.n)
+`)
+
+func TestGoInterfacePromoted(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestGoInterfacePromoted, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	data, err := b.Resolve().MarshalBinary() // index sequences of promoted methods are encoded too
+	if err != nil {
+		t.Fatal("MarshalBinary failed:", err)
+	}
+	code := new(exec.Code)
+	if err = code.UnmarshalBinary(data); err != nil {
+		t.Fatal("UnmarshalBinary failed:", err)
+	}
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(4) { // 3 2
+		t.Fatal("n:", v)
+	}
+}
+
+var fsTestGoInterfacePromotedErr = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	import "io"
+
+	type Counter struct {
+		n int
+	}
+
+	func (c *Counter) Write(b []byte) (n int, err error) {
+		return
+	}
+
+	type Buffer struct {
+		Counter
+	}
+
+	b := Buffer{}
+	io.WriteString(b, "x")
+`)
+
+func TestGoInterfacePromotedErr(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestGoInterfacePromotedErr, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err == nil || !strings.Contains(err.Error(), "method Write has pointer receiver") {
+		t.Fatal("NewPackage: unexpected error -", err)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestGoInterfaceAssign = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	import (
+		"fmt"
+		"io"
+	)
+
+	type Counter struct {
+		n int
+	}
+
+	func (c *Counter) Write(b []byte) (n int, err error) {
+		for range b {
+			n++
+		}
+		c.n += n
+		return
+	}
+
+	type Holder struct {
+		w io.Writer
+	}
+
+	func writer(c *Counter) io.Writer {
+		return c
+	}
+
+	c := &Counter{}
+	var w io.Writer = c
+	fmt.Fprintln(w, "a")
+	w = writer(c)
+	fmt.Fprintln(w, "bc")
+	h := &Holder{}
+	h.w, w = c, c
+	io.WriteString(h.w, "d")
+	println(c.n)
+`)
+
+func TestGoInterfaceAssign(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestGoInterfaceAssign, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(2) {
+		t.Fatal("n:", v)
+	}
+}
+
+var fsTestGoHandler = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	import (
+		"host"
+		"io"
+		"net/http"
+	)
+
+	type Hello struct {
+		name string
+	}
+
+	func (h *Hello) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "Hello, "+h.name)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/hello", &Hello{name: "qlang"})
+	println(host.Get(mux, "/hello"))
+`)
+
+// hostGet serves requests of path by h from many goroutines at the same time, and
+// returns the response body if all of them are the same.
+func hostGet(h http.Handler, path string) string {
+	bodies := make([]string, 8)
+	var wg sync.WaitGroup
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+				b, _ := ioutil.ReadAll(w.Body)
+				if j == 0 || bodies[i] == string(b) {
+					bodies[i] = string(b)
+				} else {
+					bodies[i] = "mismatched"
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, b := range bodies[1:] {
+		if b != bodies[0] {
+			return "mismatched"
+		}
+	}
+	return bodies[0]
+}
+
+func execHostGet(zero uint32, p *exec.Context) {
+	args := p.GetArgs(2)
+	p.Ret(2, hostGet(args[0].(http.Handler), args[1].(string)))
+}
+
+func TestGoHandler(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestGoHandler, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	reg := exec.DefaultRegistry.Clone()
+	host := reg.NewGoPackage("host")
+	host.RegisterFuncs(
+		host.Func("Get", hostGet, execHostGet),
+	)
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackageEx(b, bar, fset, &Config{Registry: reg})
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := reg.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(13) { // Hello, qlang
+		t.Fatal("n:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestStructIdentity = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	type A struct {
+		X int
+	}
+
+	type B struct {
+		X int
+	}
+
+	type Empty1 struct{}
+
+	type Empty2 struct{}
+
+	func kind(v interface{}) string {
+		switch v.(type) {
+		case A:
+			return "a"
+		case B:
+			return "bb"
+		case Empty1:
+			return "ccc"
+		case Empty2:
+			return "dddd"
+		}
+		return "?"
+	}
+
+	println(kind(B{1}) + kind(A{2}) + kind(Empty2{}) + kind(Empty1{}))
+`)
+
+func TestStructIdentity(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestStructIdentity, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(11) {
+		t.Fatal("n:", v)
+	}
+}
+
+var fsTestStructAssign = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	type A struct {
+		X int
+	}
+
+	type B struct {
+		X int
+	}
+
+	var b B
+	b = A{1}
+`)
+
+func TestStructAssign(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestStructAssign, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err == nil {
+		t.Fatal("NewPackage: no error - assign A to B")
+	}
+	if errs := err.(ErrorList); errs[0].Pos.Line != 11 {
+		t.Fatal("NewPackage: unexpected error -", errs[0])
+	}
+}
+
+var fsTestStructEqual = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	type P struct {
+		X int
+	}
+
+	func eq(ok bool, s string) string {
+		if ok {
+			return s
+		}
+		return ""
+	}
+
+	a, b, c := P{1}, P{1}, P{2}
+	var x interface{} = 1
+	var y interface{} = a
+	s := eq(a == b, "a") + eq(a != c, "bb") + eq(x == 1, "ccc") + eq(x != "1", "dddd")
+	s += eq(y == b, "eeeee") + eq(&a != &b, "ffffff") + eq(a == c, "?")
+	println(s)
+`)
+
+func TestStructEqual(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestStructEqual, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(22) {
+		t.Fatal("n:", v)
+	}
+}
+
+var fsTestStructEqualErr = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	type P struct {
+		X int
+	}
+
+	type S struct {
+		A []int
+	}
+
+	a, b := S{}, S{}
+	println(a == b)
+	println(P{1} == a)
+`)
+
+func TestStructEqualErr(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestStructEqualErr, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	errs, ok := err.(ErrorList)
+	if !ok || len(errs) != 2 || errs[0].Pos.Line != 11 || errs[1].Pos.Line != 12 {
+		t.Fatal("NewPackage: unexpected error -", err)
+	}
+}
+
+var fsTestArgAddr = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	type P struct {
+		X int
+	}
+
+	func (p *P) Set(x int) {
+		p.X = x
+	}
+
+	func mod(p P) int {
+		p.X = 99
+		return p.X
+	}
+
+	func mod2(n int, p P) int {
+		p.Set(7)
+		q := &p
+		q.X += n
+		return p.X
+	}
+
+	a := P{1}
+	println(mod(a)*100 + mod2(2, a)*10 + a.X)
+`)
+
+func TestArgAddr(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestArgAddr, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(5) { // 9991
+		t.Fatal("n:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestNamedTypeMethod = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	import "fmt"
+
+	type Celsius float64
+
+	func (c Celsius) Hot() bool {
+		return c > 40
+	}
+
+	func (c *Celsius) Warm(d float64) {
+		*c += d
+	}
+
+	type Room struct {
+		Name string
+		Temp Celsius
+	}
+
+	func hottest(a, b Celsius) Celsius {
+		if a > b {
+			return a
+		}
+		return b
+	}
+
+	type A struct {
+		X int
+	}
+
+	type B struct {
+		X int
+	}
+
+	func (a A) Name() string {
+		return "A"
+	}
+
+	func (b B) Name() string {
+		return "B"
+	}
+
+	var c Celsius = 35
+	hot := c.Hot()
+	c.Warm(10)
+	r := Room{Name: "kitchen", Temp: 20}
+	p := &c
+	d := c + 1
+	fmt.Println(hot, c.Hot(), p.Hot(), r.Temp.Hot(), hottest(r.Temp, 50).Hot(), d.Hot(), A{1}.Name()+B{2}.Name())
+`)
+
+func TestNamedTypeMethod(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestNamedTypeMethod, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(35) {
+		t.Fatal("n:", v)
+	}
+}
+
+var fsTestNamedTypeConv = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	type Weekday int
+
+	func (d Weekday) Next() Weekday {
+		return (d + 1) % 7
+	}
+
+	k := 6
+	d := Weekday(k)
+	var e Weekday = 2
+	e = d.Next()
+	println(e*10 + Weekday(3).Next())
+`)
+
+func TestNamedTypeConv(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestNamedTypeConv, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(2) { // 4
+		t.Fatal("n:", v)
+	}
+}
+
+var fsTestNamedTypeIdentity = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	type Weekday int
+
+	func kind(v interface{}) string {
+		switch v.(type) {
+		case Weekday:
+			return "weekday"
+		case int:
+			return "int"
+		}
+		return "?"
+	}
+
+	k := 3
+	var d Weekday = k
+	println(kind(d))
+`)
+
+func TestNamedTypeIdentity(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestNamedTypeIdentity, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	errs, ok := err.(ErrorList)
+	if !ok || len(errs) != 2 || errs[0].Pos.Line != 6 || errs[1].Pos.Line != 15 {
+		t.Fatal("NewPackage: unexpected errors -", err)
+	}
+}
+
+var fsTestUnderlyingMethod = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	type Celsius float64
+
+	func (c Celsius) Hot() bool {
+		return c > 40
+	}
+
+	x := 45.5
+	println(x.Hot())
+`)
+
+func TestUnderlyingMethod(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestUnderlyingMethod, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err == nil {
+		t.Fatal("NewPackage: no error - method of Celsius called on float64")
+	}
+	if errs := err.(ErrorList); errs[0].Pos.Line != 9 {
+		t.Fatal("NewPackage: unexpected error -", errs[0])
+	}
+}
+
+// -----------------------------------------------------------------------------
@@ -1,11 +1,13 @@
 package cl
 
 import (
+	"fmt"
+	"math"
+	"math/big"
 	"reflect"
 
 	"github.com/qiniu/qlang/ast/astutil"
 	"github.com/qiniu/qlang/exec"
-	"github.com/qiniu/x/log"
 )
 
 type iKind = astutil.ConstKind
@@ -26,7 +28,8 @@ type iValue interface {
 // -----------------------------------------------------------------------------
 
 type goValue struct {
-	t reflect.Type
+	t    reflect.Type
+	decl *typeDecl // declared type of the value if its type is T or *T, see toNamedDecl
 }
 
 func (p *goValue) Kind() iKind {
@@ -70,7 +73,8 @@ func (p *nonValue) Value(i int) iValue {
 // -----------------------------------------------------------------------------
 
 type funcResults struct {
-	tfn reflect.Type
+	tfn   reflect.Type
+	decls []*typeDecl // declared types of the results, see toNamedDecl
 }
 
 func (p *funcResults) Kind() iKind {
@@ -86,14 +90,34 @@ func (p *funcResults) NumValues() int {
 }
 
 func (p *funcResults) Value(i int) iValue {
-	return &goValue{t: p.tfn.Out(i)}
+	var decl *typeDecl
+	if p.decls != nil {
+		decl = p.decls[i]
+	}
+	return &goValue{t: p.tfn.Out(i), decl: decl}
 }
 
 func newFuncResults(tfn reflect.Type) iValue {
+	return newFuncResultsEx(tfn, nil)
+}
+
+func newFuncResultsEx(tfn reflect.Type, decls []*typeDecl) iValue {
+	ret := &funcResults{tfn: tfn, decls: decls}
 	if tfn.NumOut() == 1 {
-		return &goValue{t: tfn.Out(0)}
+		return ret.Value(0)
 	}
-	return &funcResults{tfn: tfn}
+	return ret
+}
+
+// declOf returns the declared type of v, see toNamedDecl.
+func declOf(v interface{}) *typeDecl {
+	switch nv := v.(type) {
+	case *goValue:
+		return nv.decl
+	case *constVal:
+		return nv.decl
+	}
+	return nil
 }
 
 // -----------------------------------------------------------------------------
@@ -125,7 +149,8 @@ func (p *qlFunc) Value(i int) iValue {
 }
 
 func (p *qlFunc) Results() iValue {
-	return newFuncResults(p.Type())
+	t := p.Type()
+	return newFuncResultsEx(t, p.results)
 }
 
 func (p *qlFunc) Proto() iFuncType {
@@ -134,6 +159,54 @@ func (p *qlFunc) Proto() iFuncType {
 
 // -----------------------------------------------------------------------------
 
+// A qlMethod represents a method of a declared type, bound to its receiver.
+type qlMethod struct {
+	fn      *funcDecl // the method as a function whose first argument is the receiver
+	pointer bool      // it has a pointer receiver
+	index   []int     // index sequence of the embedded field that the method is promoted from
+}
+
+func (p *qlMethod) FuncInfo() *exec.FuncInfo {
+	return p.fn.getFuncInfo()
+}
+
+func (p *qlMethod) Kind() iKind {
+	return reflect.Func
+}
+
+// Type returns type of the method value, that is, type of the method without its receiver.
+func (p *qlMethod) Type() reflect.Type {
+	t := p.fn.typeOf()
+	in := make([]reflect.Type, t.NumIn()-1)
+	for i := range in {
+		in[i] = t.In(i + 1)
+	}
+	out := make([]reflect.Type, t.NumOut())
+	for i := range out {
+		out[i] = t.Out(i)
+	}
+	return reflect.FuncOf(in, out, t.IsVariadic())
+}
+
+func (p *qlMethod) NumValues() int {
+	return 1
+}
+
+func (p *qlMethod) Value(i int) iValue {
+	return p
+}
+
+func (p *qlMethod) Results() iValue {
+	t := p.fn.typeOf()
+	return newFuncResultsEx(t, p.fn.results)
+}
+
+func (p *qlMethod) Proto() iFuncType {
+	return p.fn.typeOf()
+}
+
+// -----------------------------------------------------------------------------
+
 type goFunc struct {
 	v        *exec.GoFuncInfo
 	addr     uint32
@@ -141,15 +214,15 @@ type goFunc struct {
 	isMethod int // 0 - global func, 1 - method
 }
 
-func newGoFunc(addr uint32, kind exec.SymbolKind, isMethod int) *goFunc {
+func newGoFunc(reg *exec.Registry, addr uint32, kind exec.SymbolKind, isMethod int) *goFunc {
 	var fi *exec.GoFuncInfo
 	switch kind {
 	case exec.SymbolFunc:
-		fi = exec.GoFuncAddr(addr).GetInfo()
+		fi = reg.GoFuncInfo(exec.GoFuncAddr(addr))
 	case exec.SymbolFuncv:
-		fi = exec.GoFuncvAddr(addr).GetInfo()
+		fi = reg.GoFuncvInfo(exec.GoFuncvAddr(addr))
 	default:
-		log.Panicln("getGoFunc: unknown -", kind, addr)
+		logPanic("getGoFunc: unknown -", kind, addr)
 	}
 	return &goFunc{v: fi, addr: addr, kind: kind, isMethod: isMethod}
 }
@@ -184,6 +257,7 @@ type constVal struct {
 	v       interface{}
 	kind    iKind
 	reserve exec.Reserved
+	decl    *typeDecl // declared type of a typed constant, see toNamedDecl
 }
 
 func (p *constVal) Kind() iKind {
@@ -211,16 +285,19 @@ func (p *constVal) boundKind() reflect.Kind {
 	}
 	switch p.kind {
 	case astutil.ConstUnboundInt:
-		if _, ok := p.v.(int64); ok {
+		switch p.v.(type) {
+		case int64:
 			return reflect.Int
+		case uint64:
+			return reflect.Uint
 		}
-		return reflect.Uint
+		logPanic("constant", p.v, "overflows int")
 	case astutil.ConstUnboundFloat:
 		return reflect.Float64
 	case astutil.ConstUnboundComplex:
 		return reflect.Complex128
 	}
-	log.Panicln("boundKind: unexpected type kind -", p.kind)
+	logPanic("boundKind: unexpected type kind -", p.kind)
 	return reflect.Invalid
 }
 
@@ -241,23 +318,60 @@ func (p *constVal) bound(t reflect.Type, b *exec.Builder) {
 			if t == exec.TyEmptyInterface {
 				return
 			}
-			log.Panicln("function call with invalid argument type: requires", t, ", but got", p.kind)
+			logPanic("function call with invalid argument type: requires", t, ", but got", p.kind)
 		}
 		return
 	}
 	v, ok := boundConst(p.v, t)
 	if !ok {
-		log.Panicln("function call with invalid argument type: requires", t, ", but got", reflect.TypeOf(p.v))
+		if msg := boundConstErr(p.v, t); msg != "" {
+			logPanic(msg)
+		}
+		logPanic("function call with invalid argument type: requires", t, ", but got", reflect.TypeOf(p.v))
 	}
 	p.reserve.Push(b, v)
 }
 
+func (p *constVal) push(b *exec.Builder) {
+	if astutil.IsConstBound(p.kind) {
+		v, ok := boundConst(p.v, exec.TypeFromKind(p.kind))
+		if !ok {
+			logPanic("push const failed: invalid value -", p.kind, p.v)
+		}
+		b.Push(v)
+	} else {
+		p.reserve = b.Reserve()
+	}
+}
+
+func unaryOp(op exec.Operator, x *constVal) *constVal {
+	i := op.GetInfo()
+	kind, kindReal := x.kind, realKindOf(x.kind)
+	if (i.InFirst & (1 << kindReal)) == 0 {
+		logPanic("unaryOp failed: invalid argument type.")
+	}
+	t := exec.TypeFromKind(kindReal)
+	if isIntKind(kindReal) && (op == exec.OpNeg || !astutil.IsConstBound(kind)) {
+		// ^x of an unsigned typed constant depends on its size, so it isn't exact
+		vx, _ := bigIntOf(x.v)
+		return &constVal{kind: kind, v: exactInt(op, vx, nil, kind, t), reserve: -1, decl: x.decl}
+	}
+	vx, ok := boundConst(x.v, t)
+	if !ok {
+		logPanic("unaryOp failed: invalid argument type -", t)
+	}
+	v := exec.CallBuiltinOp(kindReal, op, vx)
+	return &constVal{kind: kind, v: checkFloat(v), reserve: -1, decl: x.decl}
+}
+
 func binaryOp(op exec.Operator, x, y *constVal) *constVal {
 	i := op.GetInfo()
 	xkind := x.kind
 	ykind := y.kind
 	var kind, kindReal astutil.ConstKind
-	if astutil.IsConstBound(xkind) {
+	if op == exec.OpBitSHL || op == exec.OpBitSHR { // the result is of type of x
+		kind, kindReal = xkind, realKindOf(xkind)
+	} else if astutil.IsConstBound(xkind) {
 		kind, kindReal = xkind, xkind
 	} else if astutil.IsConstBound(ykind) {
 		kind, kindReal = ykind, ykind
@@ -267,16 +381,188 @@ func binaryOp(op exec.Operator, x, y *constVal) *constVal {
 		kind, kindReal = xkind, realKindOf(xkind)
 	}
 	if (i.InFirst & (1 << kindReal)) == 0 {
-		log.Panicln("binaryOp failed: invalid first argument type.")
+		logPanic("binaryOp failed: invalid first argument type.")
+	}
+	decl := x.decl
+	if decl == nil {
+		decl = y.decl
 	}
 	t := exec.TypeFromKind(kindReal)
+	if isIntKind(kindReal) {
+		vx, xok := bigIntOf(x.v)
+		vy, yok := bigIntOf(y.v)
+		if xok && yok {
+			v := exactInt(op, vx, vy, kind, t)
+			if i.Out != exec.SameAsFirst {
+				return &constVal{kind: i.Out, v: v, reserve: -1}
+			}
+			return &constVal{kind: kind, v: v, reserve: -1, decl: decl}
+		}
+	}
 	vx, xok := boundConst(x.v, t)
 	vy, yok := boundConst(y.v, t)
 	if !xok || !yok {
-		log.Panicln("binaryOp failed: invalid argument type -", t)
+		logPanic("binaryOp failed: invalid argument type -", t)
+	}
+	if op == exec.OpDiv && vy == reflect.Zero(t).Interface() {
+		logPanic("binaryOp failed: division by zero")
 	}
-	v := exec.CallBuiltinOp(kindReal, op, vx, vy)
-	return &constVal{kind: kind, v: v, reserve: -1}
+	v := checkFloat(exec.CallBuiltinOp(kindReal, op, vx, vy))
+	if i.Out != exec.SameAsFirst {
+		return &constVal{kind: i.Out, v: v, reserve: -1}
+	}
+	return &constVal{kind: kind, v: v, reserve: -1, decl: decl}
+}
+
+// maxConstBits is the maximum size of an untyped integer constant, in bits.
+const maxConstBits = 512
+
+// exactInt computes an integer constant operation exactly. y is nil for a unary
+// operator. kind is the constant kind of the result, and t is its type (int64 for
+// untyped constants). It reports an error if the result overflows t.
+func exactInt(op exec.Operator, x, y *big.Int, kind iKind, t reflect.Type) interface{} {
+	z := new(big.Int)
+	switch op {
+	case exec.OpNeg:
+		z.Neg(x)
+	case exec.OpBitNot:
+		z.Not(x)
+	case exec.OpAdd:
+		z.Add(x, y)
+	case exec.OpSub:
+		z.Sub(x, y)
+	case exec.OpMul:
+		z.Mul(x, y)
+	case exec.OpDiv, exec.OpMod:
+		if y.Sign() == 0 {
+			logPanic("binaryOp failed: division by zero")
+		}
+		if op == exec.OpDiv {
+			z.Quo(x, y)
+		} else {
+			z.Rem(x, y)
+		}
+	case exec.OpBitAnd:
+		z.And(x, y)
+	case exec.OpBitOr:
+		z.Or(x, y)
+	case exec.OpBitXor:
+		z.Xor(x, y)
+	case exec.OpBitAndNot:
+		z.AndNot(x, y)
+	case exec.OpBitSHL, exec.OpBitSHR:
+		if y.Sign() < 0 {
+			logPanic("binaryOp failed: negative shift count", y)
+		}
+		if op == exec.OpBitSHR {
+			if !y.IsUint64() || y.Uint64() > maxConstBits {
+				y = big.NewInt(maxConstBits) // all bits are shifted out
+			}
+			z.Rsh(x, uint(y.Uint64()))
+		} else if x.Sign() != 0 {
+			if !y.IsUint64() || y.Uint64() > maxConstBits {
+				logPanic("binaryOp failed: shift count too large -", y)
+			}
+			z.Lsh(x, uint(y.Uint64()))
+		}
+	case exec.OpLT:
+		return x.Cmp(y) < 0
+	case exec.OpLE:
+		return x.Cmp(y) <= 0
+	case exec.OpGT:
+		return x.Cmp(y) > 0
+	case exec.OpGE:
+		return x.Cmp(y) >= 0
+	case exec.OpEQ:
+		return x.Cmp(y) == 0
+	case exec.OpNE:
+		return x.Cmp(y) != 0
+	default:
+		logPanic("binaryOp failed: invalid operator", op, "of integer constants")
+	}
+	if z.BitLen() > maxConstBits {
+		logPanic("constant overflow")
+	}
+	v := normInt(z)
+	if astutil.IsConstBound(kind) {
+		bv, ok := boundConst(v, t)
+		if !ok {
+			logPanic(boundConstErr(v, t))
+		}
+		return bv
+	}
+	return v
+}
+
+func isIntKind(kind reflect.Kind) bool {
+	return kind >= reflect.Int && kind <= reflect.Uintptr
+}
+
+// bigIntOf converts an integer constant (of any integer type, or an untyped int64,
+// uint64 or *big.Int) into a *big.Int.
+func bigIntOf(v interface{}) (*big.Int, bool) {
+	if n, ok := v.(*big.Int); ok {
+		return n, true
+	}
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return big.NewInt(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return new(big.Int).SetUint64(rv.Uint()), true
+	}
+	return nil, false
+}
+
+// normInt returns an untyped integer constant of value n: an int64, or an uint64
+// or a *big.Int if it overflows int64.
+func normInt(n *big.Int) interface{} {
+	if n.IsInt64() {
+		return n.Int64()
+	}
+	if n.IsUint64() {
+		return n.Uint64()
+	}
+	return n
+}
+
+// checkFloat reports an error if a float constant v overflows.
+func checkFloat(v interface{}) interface{} {
+	switch nv := v.(type) {
+	case float64:
+		if math.IsInf(nv, 0) || math.IsNaN(nv) {
+			logPanic("constant overflow")
+		}
+	case float32:
+		if math.IsInf(float64(nv), 0) || math.IsNaN(float64(nv)) {
+			logPanic("constant overflow")
+		}
+	}
+	return v
+}
+
+// boundConstErr returns the reason why a numeric constant v can't be converted into
+// type t by boundConst, or "" if it isn't a numeric constant converted into a number.
+func boundConstErr(v interface{}, t reflect.Type) string {
+	kind := t.Kind()
+	if t == exec.TyEmptyInterface { // it's converted into its default type
+		kind, t = reflect.Int, exec.TyInt
+		if _, ok := v.(float64); ok {
+			kind, t = reflect.Float64, exec.TyFloat64
+		}
+	}
+	if kind < reflect.Int || kind > reflect.Complex128 {
+		return ""
+	}
+	switch nv := v.(type) {
+	case float64:
+		if isIntKind(kind) && nv != math.Trunc(nv) {
+			return fmt.Sprint("constant ", nv, " truncated to integer")
+		}
+	case int64, uint64, *big.Int:
+	default:
+		return ""
+	}
+	return fmt.Sprint("constant ", v, " overflows ", t)
 }
 
 func boundConst(v interface{}, t reflect.Type) (ret interface{}, ok bool) {
@@ -286,16 +572,51 @@ func boundConst(v interface{}, t reflect.Type) (ret interface{}, ok bool) {
 			return int(nv), true
 		case uint64:
 			return uint(nv), true
+		case *big.Int:
+			return nil, false
 		}
 		return v, true
 	}
+	if n, ok := v.(*big.Int); ok { // an untyped integer that overflows int64 and uint64
+		f, _ := new(big.Float).SetInt(n).Float64()
+		v = f
+		if nkind := t.Kind(); nkind < reflect.Float32 || nkind > reflect.Complex128 || math.IsInf(f, 0) {
+			return nil, false
+		}
+	}
+	switch rv := reflect.ValueOf(v); rv.Kind() { // normalize a typed constant
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		v = rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uintptr:
+		v = rv.Uint()
+	case reflect.Float32:
+		v = rv.Float()
+	case reflect.Complex64:
+		v = rv.Complex()
+	}
 	nkind := t.Kind()
 	nv := reflect.New(t).Elem()
+	if f, ok := v.(float64); ok && isIntKind(nkind) { // a float constant of an integer value
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxUint64 {
+			return nil, false
+		}
+		if f < 0 {
+			v = int64(f)
+		} else {
+			v = uint64(f)
+		}
+	}
 	if nkind >= reflect.Int && nkind <= reflect.Int64 {
 		switch ov := v.(type) {
 		case int64:
+			if nv.OverflowInt(ov) {
+				return nil, false
+			}
 			nv.SetInt(ov)
 		case uint64:
+			if ov > math.MaxInt64 || nv.OverflowInt(int64(ov)) {
+				return nil, false
+			}
 			nv.SetInt(int64(ov))
 		default:
 			return nil, false
@@ -303,8 +624,14 @@ func boundConst(v interface{}, t reflect.Type) (ret interface{}, ok bool) {
 	} else if nkind >= reflect.Uint && nkind <= reflect.Uintptr {
 		switch ov := v.(type) {
 		case int64:
+			if ov < 0 || nv.OverflowUint(uint64(ov)) {
+				return nil, false
+			}
 			nv.SetUint(uint64(ov))
 		case uint64:
+			if nv.OverflowUint(ov) {
+				return nil, false
+			}
 			nv.SetUint(ov)
 		default:
 			return nil, false
@@ -312,6 +639,9 @@ func boundConst(v interface{}, t reflect.Type) (ret interface{}, ok bool) {
 	} else if nkind == reflect.Float64 || nkind == reflect.Float32 {
 		switch ov := v.(type) {
 		case float64:
+			if nv.OverflowFloat(ov) {
+				return nil, false
+			}
 			nv.SetFloat(ov)
 		case int64:
 			nv.SetFloat(float64(ov))
@@ -340,6 +670,13 @@ func boundConst(v interface{}, t reflect.Type) (ret interface{}, ok bool) {
 		default:
 			return nil, false
 		}
+	} else if nkind == reflect.Bool {
+		switch ov := v.(type) {
+		case bool:
+			nv.SetBool(ov)
+		default:
+			return nil, false
+		}
 	} else {
 		return nil, false
 	}
@@ -0,0 +1,231 @@
+package cl
+
+import (
+	"github.com/qiniu/qlang/ast"
+	"github.com/qiniu/qlang/token"
+)
+
+// -----------------------------------------------------------------------------
+
+// compileVarSpec defines variables of a var declaration, and initializes them.
+func compileVarSpec(ctx *blockCtx, spec *ast.ValueSpec) {
+	if spec.Type != nil {
+		typ := toType(ctx, spec.Type)
+		if typ == nil {
+			logPanic("compileVarSpec failed: unknown type -", spec.Type)
+		}
+		for _, name := range spec.Names {
+			if name.Name == "_" {
+				continue
+			}
+			v := ctx.insertVar(name.Name, typ)
+			v.decl = toNamedDecl(ctx, spec.Type)
+			if v.cell {
+				ctx.out.Push(nil)
+				ctx.storeCell(v)
+			} else if spec.Values == nil { // reset it to zero value, the declaration may be executed repeatedly in a loop
+				ctx.out.Push(nil).StoreVar(v.Var)
+			}
+		}
+	} else if spec.Values == nil {
+		logPanic("compileVarSpec failed: missing type or init expr for var declaration.")
+	}
+	if spec.Values != nil {
+		lhs := make([]ast.Expr, len(spec.Names))
+		for i, name := range spec.Names {
+			lhs[i] = name
+		}
+		compileAssignStmt(ctx, &ast.AssignStmt{Lhs: lhs, TokPos: spec.Pos(), Tok: token.DEFINE, Rhs: spec.Values})
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+// A varDecl represents a package-level var declaration. Its variables are defined
+// when they are initialized, in the order of their dependencies.
+type varDecl struct {
+	spec *ast.ValueSpec
+	file *fileCtx
+	deps map[*varDecl]bool // variables that initialization of this one depends on
+}
+
+func loadVar(ctx *blockCtx, spec *ast.ValueSpec) {
+	defer locateErr(spec)
+	v := &varDecl{spec: spec, file: ctx.file}
+	for _, name := range spec.Names {
+		if name.Name == "_" {
+			continue
+		}
+		if ctx.exists(name.Name) {
+			logPanic("loadVar failed: symbol exists -", name.Name)
+		}
+		ctx.syms[name.Name] = v
+	}
+	ctx.vars = append(ctx.vars, v)
+}
+
+// initVars initializes package-level variables. It repeatedly selects the earliest
+// variable in declaration order that has no dependencies on uninitialized ones.
+func initVars(ctx *blockCtx, vars []*varDecl) {
+	for _, v := range vars {
+		v.deps = make(map[*varDecl]bool)
+		visited := make(map[*ast.BlockStmt]bool)
+		for _, val := range v.spec.Values {
+			v.collectDeps(ctx, v.file, val, visited)
+		}
+	}
+	inited := make(map[*varDecl]bool, len(vars))
+	for len(vars) > 0 {
+		i := 0
+		for ; i < len(vars); i++ {
+			if vars[i].ready(inited) {
+				break
+			}
+		}
+		if i == len(vars) {
+			ctx.errs.Add(ctx.position(vars[0].spec.Pos()), "initVars failed: initialization cycle - "+vars[0].spec.Names[0].Name)
+			return
+		}
+		v := vars[i]
+		v.compile(ctx)
+		inited[v] = true
+		vars = append(vars[:i:i], vars[i+1:]...)
+	}
+}
+
+func (p *varDecl) ready(inited map[*varDecl]bool) bool {
+	for dep := range p.deps {
+		if !inited[dep] {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *varDecl) compile(ctx *blockCtx) {
+	defer ctx.recoverErr(p.spec, ctx.infer.Len())
+	for _, name := range p.spec.Names {
+		delete(ctx.syms, name.Name)
+	}
+	ctx.file = p.file
+	compileVarSpec(ctx, p.spec)
+}
+
+// collectDeps collects package-level variables that expr of file refers to, directly
+// or by calling package-level functions and methods.
+func (p *varDecl) collectDeps(ctx *blockCtx, file *fileCtx, expr ast.Node, visited map[*ast.BlockStmt]bool) {
+	ast.Inspect(expr, func(node ast.Node) bool {
+		switch v := node.(type) {
+		case *ast.SelectorExpr:
+			p.collectDeps(ctx, file, v.X, visited)
+			for _, m := range findMethods(ctx, file, v) {
+				if !visited[m.body] {
+					visited[m.body] = true
+					p.collectDeps(ctx, m.file, m.body, visited)
+				}
+			}
+			return false
+		case *ast.Ident:
+			switch sym := findPkgSym(ctx, v).(type) {
+			case *varDecl:
+				p.deps[sym] = true
+			case *funcDecl:
+				if !visited[sym.body] {
+					visited[sym.body] = true
+					p.collectDeps(ctx, sym.ctx.file, sym.body, visited)
+				}
+			}
+		}
+		return true
+	})
+}
+
+// findMethods returns methods of package-level types that the selector x.Sel may
+// refer to. x isn't compiled yet, so its type is known only if x is T, T{}, &T{},
+// or a package-level variable declared as T, *T, T{} or &T{}. Otherwise, methods
+// named Sel of all types are returned, unless x is an imported package.
+func findMethods(ctx *blockCtx, file *fileCtx, v *ast.SelectorExpr) []*methodDecl {
+	name := v.Sel.Name
+	if x, ok := v.X.(*ast.Ident); ok && ctx.syms[x.Name] == nil {
+		if _, ok := file.imports[x.Name]; ok {
+			return nil
+		}
+	}
+	if t := findRecvType(ctx, v.X, true); t != nil && !t.Alias {
+		if m, ok := t.Methods[name]; ok {
+			return []*methodDecl{m}
+		}
+		if t.spec != nil {
+			if _, ok := t.spec.Type.(*ast.StructType); !ok {
+				return nil // a field or a promoted method is of a struct type only
+			}
+		}
+	}
+	var methods []*methodDecl
+	for _, sym := range ctx.syms {
+		if t, ok := sym.(*typeDecl); ok {
+			if m, ok := t.Methods[name]; ok {
+				methods = append(methods, m)
+			}
+		}
+	}
+	return methods
+}
+
+// findRecvType returns the package-level type of x by its form, see findMethods.
+// vars reports whether a package-level variable is allowed.
+func findRecvType(ctx *blockCtx, x ast.Expr, vars bool) *typeDecl {
+	switch v := x.(type) {
+	case *ast.ParenExpr:
+		return findRecvType(ctx, v.X, vars)
+	case *ast.StarExpr:
+		return findRecvType(ctx, v.X, vars)
+	case *ast.UnaryExpr:
+		if v.Op == token.AND {
+			return findRecvType(ctx, v.X, vars)
+		}
+	case *ast.CompositeLit:
+		return findRecvType(ctx, v.Type, false)
+	case *ast.Ident:
+		switch sym := findPkgSym(ctx, v).(type) {
+		case *typeDecl:
+			return sym
+		case *varDecl:
+			if !vars {
+				break
+			}
+			if sym.spec.Type != nil {
+				return findRecvType(ctx, sym.spec.Type, false)
+			}
+			if len(sym.spec.Values) == len(sym.spec.Names) {
+				for i, name := range sym.spec.Names {
+					if name.Name == v.Name {
+						return findRecvType(ctx, sym.spec.Values[i], false)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// findPkgSym returns the package-level symbol that ident refers to, or nil if ident
+// refers to a local symbol.
+func findPkgSym(ctx *blockCtx, ident *ast.Ident) iSymbol {
+	sym := ctx.syms[ident.Name]
+	if obj := ident.Obj; obj != nil { // the ident is resolved by the parser
+		switch v := sym.(type) {
+		case *varDecl:
+			if obj.Decl != v.spec {
+				return nil
+			}
+		case *funcDecl:
+			if d, ok := obj.Decl.(*ast.FuncDecl); !ok || d.Body != v.body {
+				return nil
+			}
+		}
+	}
+	return sym
+}
+
+// -----------------------------------------------------------------------------
@@ -0,0 +1,223 @@
+package cl
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/qiniu/qlang/ast/asttest"
+	"github.com/qiniu/qlang/exec"
+	"github.com/qiniu/qlang/parser"
+	"github.com/qiniu/qlang/token"
+)
+
+// -----------------------------------------------------------------------------
+
+var fsTestVarInitOrder = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	import "fmt"
+
+	var (
+		a = c + b // == 9
+		b = f()   // == 4
+		c = f()   // == 5
+		d = 3     // == 5 after initialization has finished
+	)
+
+	var total int
+
+	func f() int {
+		d++
+		return d
+	}
+
+	func init() {
+		total += a
+	}
+
+	func init() {
+		total *= 2
+	}
+
+	fmt.Println(a, b, c, d, total)
+`)
+
+func TestVarInitOrder(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestVarInitOrder, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(11) {
+		t.Fatal("n:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestVarInitMethod = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	import "fmt"
+
+	type T struct {
+		k int
+	}
+
+	func (t *T) M() int {
+		return b * t.k
+	}
+
+	func (t T) N() int {
+		return c + 1
+	}
+
+	func g() int {
+		x := &T{k: 1}
+		return x.M()
+	}
+
+	var (
+		a = t.M() // == 6
+		d = u.N() // == 5
+		e = g()   // == 3
+		t = &T{k: 2}
+		u = T{k: 1}
+		b = 3
+		c = 4
+	)
+
+	fmt.Println(a*100 + d*10 + e)
+`)
+
+func TestVarInitMethod(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestVarInitMethod, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(4) { // 653
+		t.Fatal("n:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestVarInBlock = asttest.NewSingleFileFS("/foo", "bar.ql", `
+	func sum(n int) int {
+		var s int
+		for i := 0; i < n; i++ {
+			var x int
+			x += i
+			s += x
+		}
+		var t, u = s, 1
+		var v int = 2
+		return t + u + v
+	}
+
+	println(sum(4))
+`)
+
+func TestVarInBlock(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestVarInBlock, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(2) {
+		t.Fatal("n:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestMultiFiles = asttest.NewMemFS(map[string][]string{
+	"/foo": {"a.ql", "b.ql"},
+}, map[string]string{
+	"/foo/a.ql": `
+	var x = 21
+
+	func init() {
+		println("init a")
+	}
+
+	println("main:", y)
+`,
+	"/foo/b.ql": `
+	var y = x * 2
+
+	func init() {
+		println("init b:", y)
+	}
+`,
+})
+
+func TestMultiFiles(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseFSDir(fset, fsTestMultiFiles, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+
+	bar := pkgs["main"]
+	b := exec.NewBuilder(nil)
+	_, err = NewPackage(b, bar, fset)
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	ctx := exec.NewContext(code)
+	ctx.Exec(0, code.Len())
+	fmt.Println("results:", ctx.Get(-2), ctx.Get(-1))
+	if v := ctx.Get(-1); v != nil {
+		t.Fatal("error:", v)
+	}
+	if v := ctx.Get(-2); v != int(9) {
+		t.Fatal("n:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
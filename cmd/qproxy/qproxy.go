@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/qiniu/x/log"
+)
+
+// -----------------------------------------------------------------------------
+
+const usage = `Usage:
+  qproxy [-o file] [-p package] <pkgPath> <interface>...
+
+qproxy generates proxies of Go interfaces of a package, that make qlang objects
+implement them. The proxies are registered by GoPackage.RegisterInterfaces, eg.
+
+  I.Interface("Handler", reflect.TypeOf((*http.Handler)(nil)).Elem(), newHandlerProxy)
+
+Flags:
+  -o file       write the code to a file instead of stdout
+  -p package    package name of the code, it's the last element of pkgPath by default
+`
+
+var (
+	flagOutput  = flag.String("o", "", "write the code to a file instead of stdout")
+	flagPackage = flag.String("p", "", "package name of the code")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	name := *flagPackage
+	if name == "" {
+		name = path.Base(args[0])
+	}
+	pkg, err := importer.ForCompiler(token.NewFileSet(), "source", nil).Import(args[0])
+	if err != nil {
+		log.Fatalln("import failed:", err)
+	}
+	code, err := generate(name, pkg, args[1:])
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if *flagOutput == "" {
+		os.Stdout.Write(code)
+		return
+	}
+	if err = ioutil.WriteFile(*flagOutput, code, 0666); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+// generate returns the code of proxies of interfaces of pkg, in a package named name.
+func generate(name string, pkg *types.Package, ifaces []string) ([]byte, error) {
+	g := &generator{imports: make(map[string]bool)}
+	for _, iface := range ifaces {
+		obj, ok := pkg.Scope().Lookup(iface).(*types.TypeName)
+		if !ok {
+			return nil, fmt.Errorf("type %s.%s not found", pkg.Path(), iface)
+		}
+		t, ok := obj.Type().Underlying().(*types.Interface)
+		if !ok {
+			return nil, fmt.Errorf("%s.%s isn't an interface", pkg.Path(), iface)
+		}
+		if err := g.proxy(iface, t); err != nil {
+			return nil, fmt.Errorf("%s.%s: %v", pkg.Path(), iface, err)
+		}
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by qproxy; DO NOT EDIT.\n\npackage %s\n\n", name)
+	if len(g.imports) > 0 {
+		paths := make([]string, 0, len(g.imports))
+		for pkgPath := range g.imports {
+			paths = append(paths, pkgPath)
+		}
+		sort.Strings(paths)
+		b.WriteString("import (\n")
+		for _, pkgPath := range paths {
+			fmt.Fprintf(&b, "\t%q\n", pkgPath)
+		}
+		b.WriteString(")\n\n")
+	}
+	b.WriteString(separator)
+	b.Write(g.body.Bytes())
+	b.WriteString(separator)
+	return format.Source(b.Bytes())
+}
+
+const separator = "// -----------------------------------------------------------------------------\n\n"
+
+type generator struct {
+	body    bytes.Buffer
+	imports map[string]bool // paths of packages that the code uses
+}
+
+// proxy generates the proxy of interface iface, in the form of:
+//
+//	type xxxProxy struct {
+//		method func(args) results
+//	}
+//
+//	func (p *xxxProxy) Method(args) results {
+//		return p.method(args)
+//	}
+//
+//	func newXxxProxy(methods []interface{}) interface{} {
+//		return &xxxProxy{
+//			method: methods[0].(func(args) results),
+//		}
+//	}
+//
+// Methods are in the same order as methods of reflect.Type, that is sorted by name.
+func (p *generator) proxy(iface string, t *types.Interface) error {
+	n := t.NumMethods()
+	if n == 0 {
+		return errors.New("empty interface doesn't need a proxy")
+	}
+	typName := lowerName(iface) + "Proxy"
+	methods := make([]*types.Func, n)
+	for i := range methods {
+		methods[i] = t.Method(i)
+		if !methods[i].Exported() {
+			return fmt.Errorf("unexported method %s can't be implemented", methods[i].Name())
+		}
+	}
+	sort.Slice(methods, func(i, j int) bool {
+		return methods[i].Name() < methods[j].Name()
+	})
+
+	w := &p.body
+	sigs := make([]string, n)
+	fmt.Fprintf(w, "type %s struct {\n", typName)
+	for i, m := range methods {
+		sigs[i] = p.signature(m.Type().(*types.Signature))
+		fmt.Fprintf(w, "\t%s func%s\n", fieldName(m.Name()), sigs[i])
+	}
+	w.WriteString("}\n\n")
+	for i, m := range methods {
+		sig := m.Type().(*types.Signature)
+		fmt.Fprintf(w, "func (p *%s) %s%s {\n\t", typName, m.Name(), sigs[i])
+		if sig.Results().Len() > 0 {
+			w.WriteString("return ")
+		}
+		fmt.Fprintf(w, "p.%s(", fieldName(m.Name()))
+		params := sig.Params()
+		for j := 0; j < params.Len(); j++ {
+			if j > 0 {
+				w.WriteString(", ")
+			}
+			w.WriteString(paramName(params.At(j), j))
+		}
+		if sig.Variadic() {
+			w.WriteString("...")
+		}
+		w.WriteString(")\n}\n\n")
+	}
+	fmt.Fprintf(w, "func new%sProxy(methods []interface{}) interface{} {\n", upperName(iface))
+	fmt.Fprintf(w, "\treturn &%s{\n", typName)
+	for i, m := range methods {
+		fmt.Fprintf(w, "\t\t%s: methods[%d].(func%s),\n", fieldName(m.Name()), i, sigs[i])
+	}
+	w.WriteString("\t}\n}\n\n")
+	return nil
+}
+
+// signature returns params and results of sig, eg. `(b []byte) (n int, err error)`.
+func (p *generator) signature(sig *types.Signature) string {
+	var b strings.Builder
+	params := sig.Params()
+	b.WriteByte('(')
+	for i := 0; i < params.Len(); i++ {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		v := params.At(i)
+		b.WriteString(paramName(v, i))
+		b.WriteByte(' ')
+		if sig.Variadic() && i == params.Len()-1 {
+			b.WriteString("..." + p.typeString(v.Type().(*types.Slice).Elem()))
+		} else {
+			b.WriteString(p.typeString(v.Type()))
+		}
+	}
+	b.WriteByte(')')
+
+	results := sig.Results()
+	named := results.Len() > 0
+	for i := 0; i < results.Len(); i++ {
+		if name := results.At(i).Name(); name == "" || name == "_" || name == "p" {
+			named = false
+		}
+	}
+	if results.Len() == 1 && !named {
+		b.WriteString(" " + p.typeString(results.At(0).Type()))
+	} else if results.Len() > 0 {
+		b.WriteString(" (")
+		for i := 0; i < results.Len(); i++ {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			if named {
+				b.WriteString(results.At(i).Name() + " ")
+			}
+			b.WriteString(p.typeString(results.At(i).Type()))
+		}
+		b.WriteByte(')')
+	}
+	return b.String()
+}
+
+func (p *generator) typeString(t types.Type) string {
+	return types.TypeString(t, func(pkg *types.Package) string {
+		p.imports[pkg.Path()] = true
+		return pkg.Name()
+	})
+}
+
+// paramName returns name of the i-th parameter, that is its name in the source if it
+// has one, and doesn't conflict with the receiver p of proxy methods.
+func paramName(v *types.Var, i int) string {
+	if name := v.Name(); name != "" && name != "_" && name != "p" {
+		return name
+	}
+	return fmt.Sprintf("a%d", i)
+}
+
+// fieldName returns name of the proxy field of a method, eg. ServeHTTP => serveHTTP.
+func fieldName(method string) string {
+	name := lowerName(method)
+	if token.IsKeyword(name) {
+		name += "_"
+	}
+	return name
+}
+
+// lowerName lowers the leading upper case letters of name, eg. URLPath => urlPath.
+func lowerName(name string) string {
+	r := []rune(name)
+	for i := 0; i < len(r) && unicode.IsUpper(r[i]); i++ {
+		if i > 0 && i+1 < len(r) && unicode.IsLower(r[i+1]) {
+			break
+		}
+		r[i] = unicode.ToLower(r[i])
+	}
+	return string(r)
+}
+
+func upperName(name string) string {
+	r := []rune(name)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+// -----------------------------------------------------------------------------
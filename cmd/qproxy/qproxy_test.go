@@ -0,0 +1,80 @@
+package main
+
+import (
+	"go/importer"
+	"go/token"
+	"io/ioutil"
+	"testing"
+)
+
+// -----------------------------------------------------------------------------
+
+const sortProxies = `// Code generated by qproxy; DO NOT EDIT.
+
+package sort
+
+// -----------------------------------------------------------------------------
+
+type interfaceProxy struct {
+	len  func() int
+	less func(i int, j int) bool
+	swap func(i int, j int)
+}
+
+func (p *interfaceProxy) Len() int {
+	return p.len()
+}
+
+func (p *interfaceProxy) Less(i int, j int) bool {
+	return p.less(i, j)
+}
+
+func (p *interfaceProxy) Swap(i int, j int) {
+	p.swap(i, j)
+}
+
+func newInterfaceProxy(methods []interface{}) interface{} {
+	return &interfaceProxy{
+		len:  methods[0].(func() int),
+		less: methods[1].(func(i int, j int) bool),
+		swap: methods[2].(func(i int, j int)),
+	}
+}
+
+// -----------------------------------------------------------------------------
+`
+
+func TestGenerate(t *testing.T) {
+	imp := importer.ForCompiler(token.NewFileSet(), "source", nil)
+	pkg, err := imp.Import("sort")
+	if err != nil {
+		t.Fatal("Import failed:", err)
+	}
+	code, err := generate("sort", pkg, []string{"Interface"})
+	if err != nil {
+		t.Fatal("generate failed:", err)
+	}
+	if string(code) != sortProxies {
+		t.Fatal("generate:\n" + string(code))
+	}
+	if _, err = generate("sort", pkg, []string{"Sort"}); err == nil {
+		t.Fatal("generate: Sort isn't an interface")
+	}
+
+	pkg, err = imp.Import("net/http")
+	if err != nil {
+		t.Fatal("Import failed:", err)
+	}
+	if code, err = generate("http", pkg, []string{"Handler", "ResponseWriter"}); err != nil {
+		t.Fatal("generate failed:", err)
+	}
+	expected, err := ioutil.ReadFile("../../lib/net/http/proxies.go")
+	if err != nil {
+		t.Fatal("ReadFile failed:", err)
+	}
+	if string(code) != string(expected) {
+		t.Fatal("lib/net/http/proxies.go is out of date, run go generate")
+	}
+}
+
+// -----------------------------------------------------------------------------
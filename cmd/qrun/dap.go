@@ -0,0 +1,581 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/qiniu/qlang/cl"
+	"github.com/qiniu/qlang/exec"
+	"github.com/qiniu/qlang/token"
+
+	qos "github.com/qiniu/qlang/lib/os"
+)
+
+// -----------------------------------------------------------------------------
+
+// A dapServer is a debug adapter of the Debug Adapter Protocol, which is used by
+// editors (eg. VS Code) to debug qlang programs. It reads requests from stdin and
+// writes responses and events to stdout, so output of the program is sent by
+// output events. See https://microsoft.github.io/debug-adapter-protocol/.
+//
+// A program is launched by the launch request, with arguments:
+//
+//	program      the main package directory, or a single file
+//	args         arguments passed to the program as os.Args[1:]
+//	cwd          the working directory of the program
+//	stopOnEntry  stop at the first statement
+//	noDebug      run the program without debugging
+//
+// The program is a single thread to the editor: when any goroutine of it stops,
+// the stop is shown as the thread's. The other goroutines go on running, so the
+// stopped event doesn't say all threads are stopped, and global variables may be
+// changing when they are inspected.
+//
+// If the program calls os.Exit, the debug session ends with the exit code, but the
+// adapter keeps serving until the editor disconnects.
+type dapServer struct {
+	r   *bufio.Reader
+	w   io.Writer
+	wmu sync.Mutex // protects w and seq
+	seq int
+
+	stdout, stderr *capturedOutput // output of the program
+	exitOnce       sync.Once       // the program exits only once, see terminate
+
+	code *exec.Code
+	ctx  *exec.Context
+	d    *exec.Debugger
+
+	mu     sync.Mutex         // protects the fields below
+	frames []*exec.Context    // the call stack where the program stops, nil if it's running
+	entry  bool               // the program stops on entry, and doesn't stop yet
+	resume chan exec.StepMode // how the program goes on after a stop
+}
+
+const dapThreadID = 1
+
+var errNotStopped = errors.New("the program isn't stopped")
+
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type dapResponse struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type dapEvent struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+type dapSource struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type dapStackFrame struct {
+	ID     int        `json:"id"`
+	Name   string     `json:"name"`
+	Source *dapSource `json:"source,omitempty"`
+	Line   int        `json:"line"`
+	Column int        `json:"column"`
+}
+
+type dapVariable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	Type               string `json:"type,omitempty"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+type dapBreakpoint struct {
+	Verified bool       `json:"verified"`
+	Line     int        `json:"line,omitempty"`
+	Source   *dapSource `json:"source,omitempty"`
+	Message  string     `json:"message,omitempty"`
+}
+
+// runDAP runs a debug adapter on stdio until the editor disconnects. Output of
+// the program is captured and sent to the editor, and its stdin is empty.
+func runDAP() {
+	in, out := os.Stdin, os.Stdout
+	stdout, stderr := captureOutput(), captureOutput()
+	os.Stdout, os.Stderr = stdout.w, stderr.w
+	if null, err := os.Open(os.DevNull); err == nil {
+		os.Stdin = null
+	}
+	newDAPServer(in, out, stdout, stderr).serve()
+	os.Exit(0)
+}
+
+// newDAPServer creates a debug adapter, which reads requests from in and writes
+// responses and events to out. stdout and stderr are where the program's output is
+// written to.
+func newDAPServer(in io.Reader, out io.Writer, stdout, stderr *capturedOutput) *dapServer {
+	p := &dapServer{
+		r: bufio.NewReader(in), w: out, stdout: stdout, stderr: stderr, resume: make(chan exec.StepMode, 1),
+	}
+	go stdout.forward(p, "stdout")
+	go stderr.forward(p, "stderr")
+	return p
+}
+
+// -----------------------------------------------------------------------------
+
+// A capturedOutput is a pipe that replaces os.Stdout or os.Stderr of the program.
+type capturedOutput struct {
+	r, w *os.File
+	done chan struct{} // closed after all the output is forwarded
+}
+
+func captureOutput() *capturedOutput {
+	r, w, err := os.Pipe()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "qrun dap:", err)
+		os.Exit(1)
+	}
+	return &capturedOutput{r: r, w: w, done: make(chan struct{})}
+}
+
+func (p *capturedOutput) forward(s *dapServer, category string) {
+	defer close(p.done)
+	buf := make([]byte, 4096)
+	for {
+		n, err := p.r.Read(buf)
+		if n > 0 {
+			s.output(category, string(buf[:n]))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// close closes the pipe, and waits until the output is forwarded.
+func (p *capturedOutput) close() {
+	p.w.Close()
+	<-p.done
+}
+
+// -----------------------------------------------------------------------------
+
+// serve serves requests until the editor disconnects, or terminates the program.
+func (p *dapServer) serve() {
+	for {
+		req, err := p.read()
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintln(p.stderr.w, "qrun dap:", err)
+			}
+			return
+		}
+		body, err := p.handle(req)
+		if err != nil {
+			p.send(&dapResponse{
+				Type: "response", RequestSeq: req.Seq, Command: req.Command, Message: err.Error()})
+			continue
+		}
+		p.send(&dapResponse{
+			Type: "response", RequestSeq: req.Seq, Success: true, Command: req.Command, Body: body})
+		switch req.Command {
+		case "initialize":
+			// the initialized event is sent after launch, so breakpoints can be set
+		case "launch":
+			p.event("initialized", nil)
+		case "continue", "next", "stepIn", "stepOut":
+			p.resume <- dapSteps[req.Command] // after the response, before the next stop
+		case "terminate":
+			p.event("terminated", nil)
+			return
+		case "disconnect":
+			return
+		}
+	}
+}
+
+func (p *dapServer) handle(req *dapRequest) (interface{}, error) {
+	switch req.Command {
+	case "initialize":
+		return map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsEvaluateForHovers":        true,
+			"supportsTerminateRequest":         true,
+		}, nil
+	case "launch":
+		return nil, p.launch(req.Arguments)
+	case "configurationDone":
+		if p.code == nil {
+			return nil, errors.New("no program is launched")
+		}
+		go p.run()
+		return nil, nil
+	case "setBreakpoints":
+		return p.setBreakpoints(req.Arguments)
+	case "setExceptionBreakpoints":
+		return nil, nil
+	case "threads":
+		return map[string]interface{}{
+			"threads": []interface{}{map[string]interface{}{"id": dapThreadID, "name": "main"}},
+		}, nil
+	case "stackTrace":
+		return p.stackTrace(req.Arguments)
+	case "scopes":
+		return p.scopes(req.Arguments)
+	case "variables":
+		return p.variables(req.Arguments)
+	case "evaluate":
+		return p.evaluate(req.Arguments)
+	case "continue", "next", "stepIn", "stepOut":
+		if err := p.leaveStop(); err != nil {
+			return nil, err
+		}
+		if req.Command == "continue" {
+			return map[string]interface{}{"allThreadsContinued": true}, nil
+		}
+		return nil, nil
+	case "pause":
+		if p.d == nil {
+			return nil, errors.New("the program isn't debugged")
+		}
+		p.d.Pause()
+		return nil, nil
+	case "disconnect", "terminate":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unsupported command: %s", req.Command)
+}
+
+func (p *dapServer) launch(arguments json.RawMessage) error {
+	var args struct {
+		Program     string   `json:"program"`
+		Args        []string `json:"args"`
+		Cwd         string   `json:"cwd"`
+		StopOnEntry bool     `json:"stopOnEntry"`
+		NoDebug     bool     `json:"noDebug"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return err
+	}
+	if p.code != nil {
+		return errors.New("the program is launched")
+	}
+	if args.Program == "" {
+		return errors.New("no program to launch")
+	}
+	if args.Cwd != "" {
+		if err := os.Chdir(args.Cwd); err != nil {
+			return err
+		}
+	}
+	program, err := filepath.Abs(args.Program)
+	if err != nil {
+		return err
+	}
+
+	// the frames show positions by absolute paths, as breakpoints are set
+	fset := token.NewFileSet()
+	pkg, err := parsePath(fset, program)
+	if err == nil {
+		b := exec.NewBuilder(nil)
+		if _, err = cl.NewPackage(b, pkg, fset); err == nil {
+			p.code = b.Resolve()
+		}
+	}
+	if err != nil {
+		var msg bytes.Buffer
+		printErrs(&msg, err)
+		return errors.New(strings.TrimSpace(msg.String()))
+	}
+
+	os.Args = append([]string{args.Program}, args.Args...)
+	qos.Exit = p.exit
+	p.ctx = exec.NewContext(p.code)
+	if !args.NoDebug {
+		p.d = exec.NewDebugger(p.code, p.stop)
+		p.ctx.SetDebugger(p.d)
+		if args.StopOnEntry {
+			p.entry = true
+			p.d.Pause()
+		}
+	}
+	return nil
+}
+
+func (p *dapServer) run() {
+	exitCode := 0
+	if err := p.ctx.Run(0, p.code.Len()); err != nil {
+		fmt.Fprintln(p.stderr.w, "panic:", err)
+		exitCode = 2
+	}
+	p.terminate(exitCode)
+}
+
+// exit is os.Exit of the program. Like os.Exit, it never returns, but the adapter
+// goes on serving the editor.
+func (p *dapServer) exit(code int) {
+	p.terminate(code)
+	select {}
+}
+
+// terminate sends the output of the program, and then tells the editor that the
+// program exits with code.
+func (p *dapServer) terminate(code int) {
+	p.exitOnce.Do(func() {
+		p.stdout.close()
+		p.stderr.close()
+		p.event("exited", map[string]interface{}{"exitCode": code})
+		p.event("terminated", nil)
+	})
+}
+
+// stop is the stop handler of the debugger. It waits until a request lets the
+// program go on.
+func (p *dapServer) stop(ctx *exec.Context, reason exec.StopReason) exec.StepMode {
+	var frames []*exec.Context
+	for c := ctx; c != nil; c = c.Caller() {
+		frames = append(frames, c)
+	}
+	p.mu.Lock()
+	p.frames = frames
+	desc := reason.String()
+	if p.entry && reason == exec.StopPause {
+		desc = "entry"
+	}
+	p.entry = false
+	p.mu.Unlock()
+	p.event("stopped", map[string]interface{}{
+		"reason": desc, "threadId": dapThreadID, "allThreadsStopped": false,
+	})
+	return <-p.resume
+}
+
+var dapSteps = map[string]exec.StepMode{
+	"continue": exec.StepContinue,
+	"next":     exec.StepOver,
+	"stepIn":   exec.StepInto,
+	"stepOut":  exec.StepOut,
+}
+
+// leaveStop is called before the program goes on, so the frames of the stop can't
+// be inspected then.
+func (p *dapServer) leaveStop() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.frames == nil {
+		return errNotStopped
+	}
+	p.frames = nil
+	return nil
+}
+
+// frame returns the context of a stack frame where the program stops.
+func (p *dapServer) frame(id int) (*exec.Context, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.frames == nil {
+		return nil, errNotStopped
+	}
+	if id < 0 || id >= len(p.frames) {
+		return nil, fmt.Errorf("invalid frame: %d", id)
+	}
+	return p.frames[id], nil
+}
+
+func (p *dapServer) setBreakpoints(arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		Source      dapSource `json:"source"`
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	file := args.Source.Path
+	if p.d != nil {
+		p.d.ClearBreakpoints(file, 0)
+	}
+	bps := make([]dapBreakpoint, len(args.Breakpoints))
+	for i, v := range args.Breakpoints {
+		bps[i] = dapBreakpoint{Line: v.Line, Message: "no statement at the line or after it"}
+		if p.d == nil {
+			bps[i].Message = "the program isn't debugged"
+		} else if line, ok := p.d.SetBreakpoint(file, v.Line); ok {
+			bps[i] = dapBreakpoint{Verified: true, Line: line, Source: &args.Source}
+		}
+	}
+	return map[string]interface{}{"breakpoints": bps}, nil
+}
+
+func (p *dapServer) stackTrace(arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		StartFrame int `json:"startFrame"`
+		Levels     int `json:"levels"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	ctxs := p.frames
+	p.mu.Unlock()
+	if ctxs == nil {
+		return nil, errNotStopped
+	}
+	frames := []dapStackFrame{}
+	for i := args.StartFrame; i < len(ctxs) && (args.Levels <= 0 || i < args.StartFrame+args.Levels); i++ {
+		f := ctxs[i].Frame()
+		frame := dapStackFrame{ID: i, Name: f.Func, Line: f.Pos.Line, Column: f.Pos.Column}
+		if f.Pos.Filename != "" {
+			frame.Source = &dapSource{Name: filepath.Base(f.Pos.Filename), Path: f.Pos.Filename}
+		}
+		frames = append(frames, frame)
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(ctxs)}, nil
+}
+
+// scopes returns scopes of a frame, whose variables are referenced by 2*id+1
+// (locals) and 2*id+2 (globals).
+func (p *dapServer) scopes(arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		FrameID int `json:"frameId"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	if _, err := p.frame(args.FrameID); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"scopes": []interface{}{
+		map[string]interface{}{"name": "Locals", "variablesReference": 2*args.FrameID + 1, "expensive": false},
+		map[string]interface{}{"name": "Globals", "variablesReference": 2*args.FrameID + 2, "expensive": false},
+	}}, nil
+}
+
+func (p *dapServer) variables(arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	ref := args.VariablesReference - 1
+	ctx, err := p.frame(ref / 2)
+	if err != nil {
+		return nil, err
+	}
+	var vars []exec.Variable
+	if ref%2 == 0 {
+		vars = ctx.Locals()
+	} else {
+		vars = ctx.Globals()
+	}
+	ret := make([]dapVariable, len(vars))
+	for i, v := range vars {
+		ret[i] = dapVariable{Name: v.Name, Value: formatValue(v.Value), Type: fmt.Sprint(v.Type)}
+	}
+	return map[string]interface{}{"variables": ret}, nil
+}
+
+// evaluate evaluates a variable, which is a local or global variable of a frame.
+func (p *dapServer) evaluate(arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		Expression string `json:"expression"`
+		FrameID    int    `json:"frameId"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	ctx, err := p.frame(args.FrameID)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSpace(args.Expression)
+	for _, vars := range [][]exec.Variable{ctx.Locals(), ctx.Globals()} {
+		for _, v := range vars {
+			if v.Name == name {
+				return map[string]interface{}{
+					"result": formatValue(v.Value), "type": fmt.Sprint(v.Type), "variablesReference": 0,
+				}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("variable not found: %s", name)
+}
+
+// -----------------------------------------------------------------------------
+
+// read reads a request, which is a JSON object with a Content-Length header.
+func (p *dapServer) read() (*dapRequest, error) {
+	header, err := textproto.NewReader(p.r).ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid Content-Length: %q", header.Get("Content-Length"))
+	}
+	data := make([]byte, n)
+	if _, err = io.ReadFull(p.r, data); err != nil {
+		return nil, err
+	}
+	req := new(dapRequest)
+	if err = json.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+	if len(req.Arguments) == 0 {
+		req.Arguments = json.RawMessage("{}")
+	}
+	return req, nil
+}
+
+// send sends a response or an event, whose seq is set by send.
+func (p *dapServer) send(msg interface{}) {
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	p.seq++
+	switch v := msg.(type) {
+	case *dapResponse:
+		v.Seq = p.seq
+	case *dapEvent:
+		v.Seq = p.seq
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(p.w, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+func (p *dapServer) event(event string, body interface{}) {
+	p.send(&dapEvent{Type: "event", Event: event, Body: body})
+}
+
+func (p *dapServer) output(category, output string) {
+	p.event("output", map[string]interface{}{"category": category, "output": output})
+}
+
+// -----------------------------------------------------------------------------
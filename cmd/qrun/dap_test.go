@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	qos "github.com/qiniu/qlang/lib/os"
+)
+
+// -----------------------------------------------------------------------------
+
+type dapMessage struct {
+	Type    string          `json:"type"`
+	Command string          `json:"command"`
+	Event   string          `json:"event"`
+	Success bool            `json:"success"`
+	Message string          `json:"message"`
+	Body    json.RawMessage `json:"body"`
+}
+
+// A dapClient sends requests to a dapServer, and reads its responses and events.
+type dapClient struct {
+	t      *testing.T
+	w      io.Writer
+	r      *bufio.Reader
+	seq    int
+	output string // output of the program
+}
+
+func (c *dapClient) request(command string, args interface{}) {
+	c.seq++
+	data, err := json.Marshal(map[string]interface{}{
+		"seq": c.seq, "type": "request", "command": command, "arguments": args,
+	})
+	if err != nil {
+		c.t.Fatal("Marshal failed:", err)
+	}
+	fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+// expect reads messages until a response of command or an event (if command is
+// empty), and then decodes its body into body. Output events are collected.
+func (c *dapClient) expect(command, event string, body interface{}) {
+	for {
+		header, err := textproto.NewReader(c.r).ReadMIMEHeader()
+		if err != nil {
+			c.t.Fatal("ReadMIMEHeader failed:", err)
+		}
+		n, _ := strconv.Atoi(header.Get("Content-Length"))
+		data := make([]byte, n)
+		if _, err = io.ReadFull(c.r, data); err != nil {
+			c.t.Fatal("ReadFull failed:", err)
+		}
+		var msg dapMessage
+		if err = json.Unmarshal(data, &msg); err != nil {
+			c.t.Fatal("Unmarshal failed:", err)
+		}
+		if msg.Event == "output" {
+			var v struct{ Output string }
+			json.Unmarshal(msg.Body, &v)
+			c.output += v.Output
+		}
+		if msg.Type == "response" && msg.Command == command && command != "" ||
+			msg.Type == "event" && msg.Event == event && command == "" {
+			if msg.Type == "response" && !msg.Success {
+				c.t.Fatal(command, "failed:", msg.Message)
+			}
+			if body != nil {
+				if err = json.Unmarshal(msg.Body, body); err != nil {
+					c.t.Fatal("Unmarshal body failed:", err, string(msg.Body))
+				}
+			}
+			return
+		}
+	}
+}
+
+const dapTestSrc = `import "os"
+
+func add(a, b int) int {
+	s := a + b
+	return s
+}
+
+x := add(1, 2)
+println(x)
+os.Exit(3)
+`
+
+func TestDAP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qrun")
+	if err != nil {
+		t.Fatal("TempDir failed:", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "a.ql")
+	if err = ioutil.WriteFile(file, []byte(dapTestSrc), 0644); err != nil {
+		t.Fatal("WriteFile failed:", err)
+	}
+
+	stdout, stderr := captureOutput(), captureOutput()
+	oldStdout, oldArgs, oldExit := os.Stdout, os.Args, qos.Exit
+	os.Stdout = stdout.w
+	defer func() {
+		os.Stdout, os.Args, qos.Exit = oldStdout, oldArgs, oldExit
+	}()
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	done := make(chan bool)
+	go func() {
+		newDAPServer(inR, outW, stdout, stderr).serve()
+		close(done)
+	}()
+	c := &dapClient{t: t, w: inW, r: bufio.NewReader(outR)}
+
+	c.request("initialize", map[string]interface{}{"adapterID": "qlang"})
+	c.expect("initialize", "", nil)
+	c.request("launch", map[string]interface{}{"program": file})
+	c.expect("launch", "", nil)
+	c.expect("", "initialized", nil)
+
+	var bps struct{ Breakpoints []dapBreakpoint }
+	c.request("setBreakpoints", map[string]interface{}{
+		"source": map[string]interface{}{"path": file}, "breakpoints": []interface{}{map[string]int{"line": 5}},
+	})
+	c.expect("setBreakpoints", "", &bps)
+	if len(bps.Breakpoints) != 1 || !bps.Breakpoints[0].Verified || bps.Breakpoints[0].Line != 5 {
+		t.Fatal("setBreakpoints:", bps)
+	}
+	c.request("configurationDone", nil)
+	c.expect("configurationDone", "", nil)
+
+	var stopped struct {
+		Reason            string
+		AllThreadsStopped bool
+	}
+	c.expect("", "stopped", &stopped)
+	if stopped.Reason != "breakpoint" || stopped.AllThreadsStopped {
+		t.Fatal("stopped:", stopped)
+	}
+	var trace struct{ StackFrames []dapStackFrame }
+	c.request("stackTrace", map[string]interface{}{"threadId": dapThreadID})
+	c.expect("stackTrace", "", &trace)
+	if len(trace.StackFrames) != 2 || trace.StackFrames[0].Name != "add" || trace.StackFrames[0].Line != 5 ||
+		trace.StackFrames[1].Name != "main" || trace.StackFrames[1].Line != 8 {
+		t.Fatal("stackTrace:", trace)
+	}
+	var vars struct{ Variables []dapVariable }
+	c.request("variables", map[string]interface{}{"variablesReference": 1})
+	c.expect("variables", "", &vars)
+	expected := []dapVariable{{"a", "1", "int", 0}, {"b", "2", "int", 0}, {"s", "3", "int", 0}}
+	if !reflect.DeepEqual(vars.Variables, expected) {
+		t.Fatal("variables:", vars)
+	}
+
+	c.request("continue", map[string]interface{}{"threadId": dapThreadID})
+	c.expect("continue", "", nil)
+	var exited struct{ ExitCode int }
+	c.expect("", "exited", &exited)
+	if exited.ExitCode != 3 || c.output != "3\n" {
+		t.Fatal("exited:", exited.ExitCode, c.output)
+	}
+	c.expect("", "terminated", nil)
+	c.request("disconnect", nil)
+	c.expect("disconnect", "", nil)
+	<-done
+}
+
+// -----------------------------------------------------------------------------
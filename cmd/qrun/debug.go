@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/qiniu/qlang/exec"
+)
+
+// -----------------------------------------------------------------------------
+
+const debugHelp = `Commands:
+  b [file:]line    set a breakpoint (file is the current file if omitted)
+  clear [file[:line]]
+                   clear breakpoints of a file, or all files
+  c                continue
+  s                step into
+  n                step over
+  o                step out
+  bt               print the call stack
+  locals           print arguments and variables of the current function
+  globals          print global variables
+  p <name>         print a local or global variable
+  l                list source lines around the current line
+  q                quit
+`
+
+// A debugCLI is a command line front-end of exec.Debugger. Commands are read from
+// in when the code stops.
+type debugCLI struct {
+	d       *exec.Debugger
+	in      *bufio.Scanner
+	out     io.Writer
+	sources map[string][]byte // sources that aren't files, eg. the script of -e
+	files   map[string][][]byte
+	eof     bool // no more commands, the code runs to the end
+}
+
+func newDebugCLI(code *exec.Code, in io.Reader, out io.Writer, sources map[string][]byte) *debugCLI {
+	p := &debugCLI{in: bufio.NewScanner(in), out: out, sources: sources}
+	p.d = exec.NewDebugger(code, p.stop)
+	p.d.Pause() // stop at the first statement
+	return p
+}
+
+func (p *debugCLI) stop(ctx *exec.Context, reason exec.StopReason) exec.StepMode {
+	if p.eof {
+		return exec.StepContinue
+	}
+	f := ctx.Frame()
+	fmt.Fprintf(p.out, "%s in %s at %s:%d\n", reason, f.Func, f.Pos.Filename, f.Pos.Line)
+	p.list(f.Pos.Filename, f.Pos.Line, 0)
+	for {
+		fmt.Fprint(p.out, "(qdb) ")
+		if !p.in.Scan() {
+			fmt.Fprintln(p.out)
+			p.eof = true
+			return exec.StepContinue
+		}
+		args := strings.Fields(p.in.Text())
+		if len(args) == 0 {
+			continue
+		}
+		switch args[0] {
+		case "c", "continue":
+			return exec.StepContinue
+		case "s", "step":
+			return exec.StepInto
+		case "n", "next":
+			return exec.StepOver
+		case "o", "out":
+			return exec.StepOut
+		case "b", "break":
+			p.setBreakpoint(f.Pos.Filename, args[1:])
+		case "clear":
+			p.clearBreakpoints(args[1:])
+		case "bt":
+			for i, c := 0, ctx; c != nil; i, c = i+1, c.Caller() {
+				f := c.Frame()
+				fmt.Fprintf(p.out, "#%d %s at %s:%d\n", i, f.Func, f.Pos.Filename, f.Pos.Line)
+			}
+		case "locals":
+			p.printVars(ctx.Locals())
+		case "globals":
+			p.printVars(ctx.Globals())
+		case "p", "print":
+			p.printVar(ctx, args[1:])
+		case "l", "list":
+			p.list(f.Pos.Filename, f.Pos.Line, 5)
+		case "q", "quit":
+			os.Exit(0)
+		case "h", "help":
+			fmt.Fprint(p.out, debugHelp)
+		default:
+			fmt.Fprintln(p.out, "unknown command:", args[0], "(type h for help)")
+		}
+	}
+}
+
+func (p *debugCLI) setBreakpoint(file string, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(p.out, "usage: b [file:]line")
+		return
+	}
+	arg := args[0]
+	if i := strings.LastIndex(arg, ":"); i >= 0 {
+		file, arg = arg[:i], arg[i+1:]
+	}
+	line, err := strconv.Atoi(arg)
+	if err != nil {
+		fmt.Fprintln(p.out, "invalid line:", arg)
+		return
+	}
+	if line, ok := p.d.SetBreakpoint(file, line); ok {
+		fmt.Fprintf(p.out, "breakpoint at %s:%d\n", file, line)
+	} else {
+		fmt.Fprintf(p.out, "no statement at %s:%d or after it\n", file, line)
+	}
+}
+
+func (p *debugCLI) clearBreakpoints(args []string) {
+	if len(args) == 0 {
+		p.d.ClearBreakpoints("", 0)
+		return
+	}
+	file, line := args[0], 0
+	if i := strings.LastIndex(file, ":"); i >= 0 {
+		n, err := strconv.Atoi(file[i+1:])
+		if err != nil {
+			fmt.Fprintln(p.out, "invalid line:", file[i+1:])
+			return
+		}
+		file, line = file[:i], n
+	}
+	p.d.ClearBreakpoints(file, line)
+}
+
+func (p *debugCLI) printVars(vars []exec.Variable) {
+	for _, v := range vars {
+		fmt.Fprintf(p.out, "%s %v = %s\n", v.Name, v.Type, formatValue(v.Value))
+	}
+}
+
+func (p *debugCLI) printVar(ctx *exec.Context, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(p.out, "usage: p <name>")
+		return
+	}
+	for _, vars := range [][]exec.Variable{ctx.Locals(), ctx.Globals()} {
+		for _, v := range vars {
+			if v.Name == args[0] {
+				fmt.Fprintln(p.out, formatValue(v.Value))
+				return
+			}
+		}
+	}
+	fmt.Fprintln(p.out, "variable not found:", args[0])
+}
+
+// list prints source lines around a line of a file, n lines before and after it.
+func (p *debugCLI) list(filename string, line, n int) {
+	lines := p.sourceLines(filename)
+	for i := line - n; i <= line+n; i++ {
+		if i < 1 || i > len(lines) {
+			continue
+		}
+		mark := "  "
+		if i == line {
+			mark = "=>"
+		}
+		fmt.Fprintf(p.out, "%s %4d: %s\n", mark, i, lines[i-1])
+	}
+}
+
+func (p *debugCLI) sourceLines(filename string) [][]byte {
+	if p.files == nil {
+		p.files = make(map[string][][]byte)
+	}
+	lines, ok := p.files[filename]
+	if !ok {
+		src, ok := p.sources[filename]
+		if !ok {
+			src, _ = ioutil.ReadFile(filename)
+		}
+		if src != nil {
+			lines = bytes.Split(src, []byte{'\n'})
+		}
+		p.files[filename] = lines
+	}
+	return lines
+}
+
+// -----------------------------------------------------------------------------
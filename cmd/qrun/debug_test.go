@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/qiniu/qlang/cl"
+	"github.com/qiniu/qlang/exec"
+	"github.com/qiniu/qlang/token"
+)
+
+// -----------------------------------------------------------------------------
+
+const debugTestSrc = `func add(a, b int) int {
+	s := a + b
+	return s
+}
+
+x := add(1, 2)
+x = add(x, 3)
+`
+
+func TestDebugCLI(t *testing.T) {
+	fset := token.NewFileSet()
+	pkg, err := parseFile(fset, "/foo/a.ql", debugTestSrc)
+	if err != nil {
+		t.Fatal("parseFile failed:", err)
+	}
+	b := exec.NewBuilder(nil)
+	if _, err = cl.NewPackage(b, pkg, fset); err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	code := b.Resolve()
+
+	var out bytes.Buffer
+	cmds := "b 3\nb x\nc\nlocals\nbt\np s\np y\nclear\nn\nglobals\nfoo\nc\n"
+	sources := map[string][]byte{"/foo/a.ql": []byte(debugTestSrc)}
+	ctx := exec.NewContext(code)
+	ctx.SetDebugger(newDebugCLI(code, strings.NewReader(cmds), &out, sources).d)
+	if err = ctx.Run(0, code.Len()); err != nil {
+		t.Fatal("Run failed:", err)
+	}
+	expected := `pause in main at /foo/a.ql:6
+=>    6: x := add(1, 2)
+(qdb) breakpoint at /foo/a.ql:3
+(qdb) invalid line: x
+(qdb) breakpoint in add at /foo/a.ql:3
+=>    3: 	return s
+(qdb) a int = 1
+b int = 2
+s int = 3
+(qdb) #0 add at /foo/a.ql:3
+#1 main at /foo/a.ql:6
+(qdb) 3
+(qdb) variable not found: y
+(qdb) (qdb) step in main at /foo/a.ql:7
+=>    7: x = add(x, 3)
+(qdb) x int = 3
+(qdb) unknown command: foo (type h for help)
+(qdb) `
+	if v := out.String(); v != expected {
+		t.Fatal("output:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
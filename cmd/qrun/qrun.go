@@ -1,9 +1,14 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"go/scanner"
+	"io"
+	"io/ioutil"
 	"os"
 
+	"github.com/qiniu/qlang/ast"
 	"github.com/qiniu/qlang/cl"
 	"github.com/qiniu/qlang/exec"
 	"github.com/qiniu/qlang/parser"
@@ -12,31 +17,144 @@ import (
 
 	_ "github.com/qiniu/qlang/lib/builtin"
 	_ "github.com/qiniu/qlang/lib/fmt"
+	_ "github.com/qiniu/qlang/lib/io"
+	_ "github.com/qiniu/qlang/lib/net/http"
+	_ "github.com/qiniu/qlang/lib/os"
+	_ "github.com/qiniu/qlang/lib/sort"
 	_ "github.com/qiniu/qlang/lib/strings"
 )
 
 // -----------------------------------------------------------------------------
 
+const usage = `Usage:
+  qrun [-i]                       start an interactive REPL
+  qrun <qlangSrcDir> [args...]    run the main package in a directory
+  qrun <file.ql> [args...]        run a single file
+  qrun - [args...]                run a script read from stdin
+  qrun -e <script> [args...]      run a script given in the command line
+  qrun -debug <file|dir> [args...]
+                                  debug a script, or the main package in a directory
+  qrun dap                        start a Debug Adapter Protocol server on stdio,
+                                  which editors launch programs to debug with
+
+The args are passed to the script as os.Args[1:].
+`
+
+var (
+	flagInteractive = flag.Bool("i", false, "start an interactive REPL")
+	flagScript      = flag.String("e", "", "run a script given in the command line")
+	flagDebug       = flag.Bool("debug", false, "debug the script, commands are read from stdin")
+)
+
 func main() {
-	if len(os.Args) <= 1 {
-		fmt.Println("Usage: qrun <qlangSrcDir>")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 1 && args[0] == "dap" {
+		runDAP()
+		return
+	}
+	if *flagInteractive || !*flagDebug && *flagScript == "" && len(args) == 0 {
+		newREPL(os.Stdout).run(os.Stdin)
 		return
 	}
+
 	fset := token.NewFileSet()
-	pkgs, err := parser.ParseDir(fset, os.Args[1], nil, 0)
+	var pkg *ast.Package
+	var name string // the script name, that is os.Args[0] of the script
+	var err error
+	sources := make(map[string][]byte) // sources that aren't read from files
+	switch {
+	case *flagScript != "":
+		name = "-e"
+		sources[name] = []byte(*flagScript)
+		pkg, err = parseFile(fset, name, *flagScript)
+	case *flagDebug && (len(args) == 0 || args[0] == "-"):
+		log.Fatalln("-debug needs a file or directory, stdin is used by debugger commands")
+	case args[0] == "-":
+		name, args = args[0], args[1:]
+		var src []byte
+		if src, err = ioutil.ReadAll(os.Stdin); err != nil {
+			log.Fatalln("read stdin failed:", err)
+		}
+		pkg, err = parseFile(fset, "<stdin>", src)
+	default:
+		name, args = args[0], args[1:]
+		pkg, err = parsePath(fset, name)
+	}
 	if err != nil {
-		log.Fatalln("ParseDir failed:", err)
+		exitOnErr(err)
 	}
 
 	b := exec.NewBuilder(nil)
-	_, err = cl.NewPackage(b, pkgs["main"])
-	if err != nil {
-		log.Fatalln("cl.NewPackage failed:", err)
+	if _, err = cl.NewPackage(b, pkg, fset); err != nil {
+		exitOnErr(err)
 	}
 	code := b.Resolve()
 
+	os.Args = append([]string{name}, args...)
 	ctx := exec.NewContext(code)
-	ctx.Exec(0, code.Len())
+	if *flagDebug {
+		ctx.SetDebugger(newDebugCLI(code, os.Stdin, os.Stdout, sources).d)
+	}
+	if err = ctx.Run(0, code.Len()); err != nil {
+		fmt.Fprintln(os.Stderr, "panic:", err)
+		os.Exit(2)
+	}
+}
+
+// parsePath parses the main package in a directory, or a single file.
+func parsePath(fset *token.FileSet, path string) (*ast.Package, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return parseFile(fset, path, nil)
+	}
+	pkgs, err := parser.ParseDir(fset, path, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	pkg, ok := pkgs["main"]
+	if !ok {
+		return nil, fmt.Errorf("no main package in %s", path)
+	}
+	return pkg, nil
+}
+
+// parseFile parses a single file as a package. src is the source, or nil if it is
+// read from the file.
+func parseFile(fset *token.FileSet, filename string, src interface{}) (*ast.Package, error) {
+	f, err := parser.ParseFile(fset, filename, src, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &ast.Package{Name: f.Name.Name, Files: map[string]*ast.File{filename: f}}, nil
+}
+
+// exitOnErr prints errors of parsing or compiling, and exits with code 2.
+func exitOnErr(err error) {
+	printErrs(os.Stderr, err)
+	os.Exit(2)
+}
+
+// printErrs prints errors of parsing or compiling, one per line.
+func printErrs(w io.Writer, err error) {
+	switch errs := err.(type) {
+	case cl.ErrorList:
+		for _, e := range errs {
+			fmt.Fprintln(w, e)
+		}
+	case scanner.ErrorList:
+		for _, e := range errs {
+			fmt.Fprintln(w, e)
+		}
+	default:
+		fmt.Fprintln(w, err)
+	}
 }
 
 // -----------------------------------------------------------------------------
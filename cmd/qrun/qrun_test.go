@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// -----------------------------------------------------------------------------
+
+// TestMain runs main instead of the tests if QRUN_TEST_MAIN is set, so the tests
+// can run qrun by running the test binary.
+func TestMain(m *testing.M) {
+	if os.Getenv("QRUN_TEST_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// qrun runs qrun with args, and returns its stdout, stderr and exit code.
+func qrun(t *testing.T, stdin string, args ...string) (string, string, int) {
+	cmd := osexec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "QRUN_TEST_MAIN=1")
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	code := 0
+	if e, ok := err.(*osexec.ExitError); ok {
+		code = e.ExitCode()
+	} else if err != nil {
+		t.Fatal("Run qrun failed:", err)
+	}
+	return stdout.String(), stderr.String(), code
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qrun")
+	if err != nil {
+		t.Fatal("TempDir failed:", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "a.ql")
+	script := `import "os"; for _, arg := range os.Args { println(arg) }; os.Exit(3)`
+	if err = ioutil.WriteFile(file, []byte(script), 0644); err != nil {
+		t.Fatal("WriteFile failed:", err)
+	}
+
+	cases := []struct {
+		stdin  string
+		args   []string
+		stdout string
+		stderr string
+		code   int
+	}{
+		{"", []string{"-e", script, "x"}, "-e\nx\n", "", 3},
+		{script, []string{"-", "x", "y"}, "-\nx\ny\n", "", 3},
+		{"", []string{file, "x"}, file + "\nx\n", "", 3},
+		{"", []string{dir, "x"}, dir + "\nx\n", "", 3},
+		{"", []string{"-e", `panic("oops")`}, "", "panic: oops\n", 2},
+		{"", []string{"-e", `x := 1; x = "a"`}, "", "-e:1:9: ", 2},
+		{"", []string{filepath.Join(dir, "b.ql")}, "", "stat ", 2},
+		{"1 + 2\n", nil, ">>> 3\n>>> \n", "", 0},
+	}
+	for _, c := range cases {
+		stdout, stderr, code := qrun(t, c.stdin, c.args...)
+		if stdout != c.stdout || !strings.HasPrefix(stderr, c.stderr) || code != c.code {
+			t.Fatal("qrun", c.args, "-", stdout, stderr, code)
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/qiniu/qlang/ast"
+	"github.com/qiniu/qlang/cl"
+	"github.com/qiniu/qlang/exec"
+	"github.com/qiniu/qlang/parser"
+)
+
+// -----------------------------------------------------------------------------
+
+// A repl is a read-eval-print loop. Each input is compiled into the same code, and
+// executed in the same context, so it can use the symbols declared by the former
+// inputs.
+type repl struct {
+	fset    *token.FileSet
+	sess    *cl.Session
+	code    *exec.Code
+	ctx     *exec.Context
+	sources map[string][]byte // source of each input, to dump the code
+	out     io.Writer
+}
+
+func newREPL(out io.Writer) *repl {
+	p := &repl{out: out}
+	p.reset()
+	return p
+}
+
+func (p *repl) reset() {
+	p.fset = token.NewFileSet()
+	p.sess = cl.NewSession(p.fset, nil)
+	p.code = exec.NewCode()
+	p.ctx = exec.NewContext(p.code)
+	p.sources = make(map[string][]byte)
+}
+
+// run reads inputs from in until EOF. An input is continued in the next lines if
+// it has unclosed brackets.
+func (p *repl) run(in io.Reader) {
+	s := bufio.NewScanner(in)
+	var src string
+	for {
+		if src == "" {
+			fmt.Fprint(p.out, ">>> ")
+		} else {
+			fmt.Fprint(p.out, "... ")
+		}
+		if !s.Scan() {
+			fmt.Fprintln(p.out)
+			return
+		}
+		line := s.Text()
+		if src == "" && strings.HasPrefix(strings.TrimSpace(line), ":") {
+			p.command(strings.TrimSpace(line))
+			continue
+		}
+		src += line + "\n"
+		if isIncomplete(src) {
+			continue
+		}
+		if strings.TrimSpace(src) != "" {
+			p.eval(src)
+		}
+		src = ""
+	}
+}
+
+func (p *repl) command(cmd string) {
+	name, arg := cmd, ""
+	if i := strings.IndexAny(cmd, " \t"); i >= 0 {
+		name, arg = cmd[:i], strings.TrimSpace(cmd[i+1:])
+	}
+	switch name {
+	case ":type":
+		p.typeOf(arg)
+	case ":dump":
+		p.code.DumpEx(p.out, &exec.DumpConfig{ReadFile: p.readFile})
+	case ":reset":
+		p.reset()
+	default:
+		fmt.Fprintln(p.out, "unknown command:", name, "(commands are :type <expr>, :dump and :reset)")
+	}
+}
+
+// parse parses an input, which is named by its index, eg. in[3] for the third one.
+func (p *repl) parse(src string) (*ast.File, error) {
+	filename := "in[" + strconv.Itoa(len(p.sources)+1) + "]"
+	p.sources[filename] = []byte(src)
+	return parser.ParseFile(p.fset, filename, src, 0)
+}
+
+func (p *repl) readFile(filename string) ([]byte, error) {
+	if src, ok := p.sources[filename]; ok {
+		return src, nil
+	}
+	return nil, errors.New("source not found: " + filename)
+}
+
+func (p *repl) eval(src string) {
+	f, err := p.parse(src)
+	if err != nil {
+		printErrs(p.out, err)
+		return
+	}
+	b := exec.NewBuilder(p.code)
+	start := p.code.Len()
+	if err = p.sess.Compile(b, f); err != nil {
+		printErrs(p.out, err)
+		return
+	}
+	b.Resolve()
+
+	ctx := p.ctx
+	ctx.GrowVars()
+	ctx.SetLen(0)
+	if err = ctx.Run(start, p.code.Len()); err != nil {
+		fmt.Fprintln(p.out, "panic:", err)
+		return
+	}
+	for i, n := 0, ctx.Len(); i < n; i++ {
+		fmt.Fprintln(p.out, formatValue(ctx.Get(i-n)))
+	}
+	ctx.SetLen(0)
+}
+
+func (p *repl) typeOf(src string) {
+	f, err := p.parse(src)
+	if err != nil {
+		printErrs(p.out, err)
+		return
+	}
+	x, ok := exprOf(f)
+	if !ok {
+		fmt.Fprintln(p.out, "not an expression:", src)
+		return
+	}
+	types, err := p.sess.TypeOf(x)
+	if err != nil {
+		printErrs(p.out, err)
+		return
+	}
+	if len(types) == 0 {
+		fmt.Fprintln(p.out, "no value")
+		return
+	}
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = t.String()
+	}
+	fmt.Fprintln(p.out, strings.Join(names, ", "))
+}
+
+// exprOf returns the expression if f is parsed from a single expression.
+func exprOf(f *ast.File) (ast.Expr, bool) {
+	if len(f.Decls) != 1 {
+		return nil, false
+	}
+	fn, ok := f.Decls[0].(*ast.FuncDecl)
+	if !ok || fn.Name.Name != "main" || len(fn.Body.List) != 1 {
+		return nil, false
+	}
+	stmt, ok := fn.Body.List[0].(*ast.ExprStmt)
+	if !ok {
+		return nil, false
+	}
+	return stmt.X, true
+}
+
+func formatValue(v interface{}) string {
+	if v != nil && reflect.TypeOf(v).Kind() == reflect.String {
+		return strconv.Quote(reflect.ValueOf(v).String())
+	}
+	return fmt.Sprint(v)
+}
+
+// isIncomplete checks if src has unclosed brackets, or an unterminated raw string
+// or comment, so more lines are expected.
+func isIncomplete(src string) bool {
+	var s scanner.Scanner
+	var incomplete bool
+	fset := token.NewFileSet()
+	file := fset.AddFile("", -1, len(src))
+	s.Init(file, []byte(src), func(pos token.Position, msg string) {
+		if strings.HasSuffix(msg, "not terminated") {
+			incomplete = true
+		}
+	}, 0)
+	depth := 0
+	for {
+		_, tok, _ := s.Scan()
+		switch tok {
+		case token.LPAREN, token.LBRACK, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			depth--
+		case token.EOF:
+			return incomplete || depth > 0
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
@@ -0,0 +1,128 @@
+package exec
+
+import (
+	"reflect"
+
+	"github.com/qiniu/x/log"
+)
+
+// -----------------------------------------------------------------------------
+
+// ChanOperator type.
+type ChanOperator uint32
+
+const (
+	// OpChanSend `ch <- v`
+	OpChanSend ChanOperator = iota
+	// OpChanRecv `<-ch`
+	OpChanRecv
+	// OpChanRecvOk `v, ok := <-ch`
+	OpChanRecvOk
+	// OpChanClose `close(ch)`
+	OpChanClose
+)
+
+func execMakeChan(i Instr, p *Context) {
+	typ := p.code.types[i&bitsOperand]
+	n := len(p.data)
+	size := p.data[n-1].(int)
+	p.alloc(size, typ.Elem().Size())
+	p.data[n-1] = reflect.MakeChan(typ, size).Interface()
+}
+
+func execChanOp(i Instr, p *Context) {
+	n := len(p.data)
+	switch op := ChanOperator(i & bitsOperand); op {
+	case OpChanSend:
+		ch := reflect.ValueOf(p.data[n-2])
+		v := getValueOf(p.data[n-1], ch.Type().Elem())
+		if p.limits == nil {
+			ch.Send(v)
+		} else if !ch.TrySend(v) {
+			p.limits.wait([]reflect.SelectCase{{Dir: reflect.SelectSend, Chan: ch, Send: v}}, p.Stack)
+		}
+		p.data = p.data[:n-2]
+	case OpChanRecv, OpChanRecvOk:
+		ch := reflect.ValueOf(p.data[n-1])
+		var x reflect.Value
+		var ok bool
+		if p.limits == nil {
+			x, ok = ch.Recv()
+		} else if x, ok = ch.TryRecv(); !x.IsValid() { // it would block
+			_, x, ok = p.limits.wait([]reflect.SelectCase{{Dir: reflect.SelectRecv, Chan: ch}}, p.Stack)
+		}
+		p.data[n-1] = x.Interface()
+		if op == OpChanRecvOk {
+			p.Push(ok)
+		}
+	case OpChanClose:
+		reflect.ValueOf(p.data[n-1]).Close()
+		p.data = p.data[:n-1]
+	default:
+		log.Panicln("execChanOp failed: unknown operator -", op)
+	}
+}
+
+func execSelect(i Instr, p *Context) {
+	n := int(i & bitsOperand)
+	base := len(p.data) - n*3
+	cases := make([]reflect.SelectCase, n)
+	for i := range cases {
+		args := p.data[base+i*3:]
+		c := &cases[i]
+		c.Dir = reflect.SelectDir(args[0].(int))
+		if c.Dir == reflect.SelectDefault {
+			continue
+		}
+		c.Chan = reflect.ValueOf(args[1])
+		if c.Dir == reflect.SelectSend {
+			c.Send = getValueOf(args[2], c.Chan.Type().Elem())
+		}
+	}
+	var chosen int
+	var recv reflect.Value
+	var recvOK bool
+	if p.limits == nil {
+		chosen, recv, recvOK = reflect.Select(cases)
+	} else {
+		chosen, recv, recvOK = p.limits.wait(cases, p.Stack)
+	}
+	var x interface{}
+	if recv.IsValid() {
+		x = recv.Interface()
+	}
+	p.data = append(p.data[:base], x, recvOK, chosen)
+}
+
+// MakeChan instr. It replaces the buffer size (an int) on the top of the stack
+// with a new channel of typ.
+func (p *Builder) MakeChan(typ reflect.Type) *Builder {
+	i := (opMakeChan << bitsOpShift) | p.newType(typ)
+	p.code.data = append(p.code.data, i)
+	return p
+}
+
+// ChanOp instr. OpChanSend pops the channel and the value to send. OpChanRecv
+// replaces the channel on the top of the stack with the received value, and
+// OpChanRecvOk pushes whether the value is sent before the channel is closed too.
+// OpChanClose pops the channel and closes it.
+func (p *Builder) ChanOp(op ChanOperator) *Builder {
+	p.code.data = append(p.code.data, (opChanOp<<bitsOpShift)|uint32(op))
+	return p
+}
+
+// Select instr. It executes a select statement of n cases, like reflect.Select.
+// Each case is 3 values pushed before it: the direction (a reflect.SelectDir as
+// int), the channel, and the value to send (nil if it isn't a send case). It pops
+// them, and then pushes the received value (nil if the chosen case isn't a receive
+// case), whether the value is sent before the channel is closed, and index of the
+// chosen case.
+func (p *Builder) Select(n int) *Builder {
+	if n < 0 || n > bitsOperand {
+		log.Panicln("Select failed: invalid count of cases -", n)
+	}
+	p.code.data = append(p.code.data, (opSelect<<bitsOpShift)|uint32(n))
+	return p
+}
+
+// -----------------------------------------------------------------------------
@@ -0,0 +1,96 @@
+package exec
+
+import (
+	"reflect"
+	"testing"
+)
+
+// -----------------------------------------------------------------------------
+
+var tyChanInt = reflect.ChanOf(reflect.BothDir, TyInt)
+
+func TestGoChan(t *testing.T) {
+	ch := NewVar(tyChanInt, "ch")
+	x := NewVar(TyInt, "x")
+	send := NewFunc("send", 1)
+	l1 := NewLabel("")
+	code := NewBuilder(nil).
+		DefineVar(ch, x).
+		Push(0).
+		MakeChan(tyChanInt).
+		StoreVar(ch). // ch = make(chan int)
+		StartGo().
+		LoadVar(ch).
+		Push(5).
+		Go(l1).
+		CallFunc(send). // go send(ch, 5)
+		Label(l1).
+		LoadVar(ch).
+		ChanOp(OpChanRecv).
+		StoreVar(x). // x = <-ch
+		LoadVar(ch).
+		ChanOp(OpChanClose). // close(ch)
+		LoadVar(ch).
+		ChanOp(OpChanRecvOk). // <-ch, ok
+		Return(-1).
+		DefineFunc(
+			send.Return().
+				Args(tyChanInt, TyInt)).
+		Load(-2).
+		Load(-1).
+		ChanOp(OpChanSend). // c <- v
+		Return(-1).
+		EndFunc(send).
+		Resolve()
+
+	ctx := NewContext(code)
+	ctx.Exec(0, code.Len())
+	if v := ctx.GetVar(x); v != 5 {
+		t.Fatal("x != 5, ret =", v)
+	}
+	if v := ctx.Get(-1); v != false {
+		t.Fatal("ok != false, ret =", v)
+	}
+	if v := ctx.Get(-2); v != 0 {
+		t.Fatal("<-ch != 0, ret =", v)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	ch := NewVar(tyChanInt, "ch")
+	code := NewBuilder(nil).
+		DefineVar(ch).
+		Push(1).
+		MakeChan(tyChanInt).
+		StoreVar(ch). // ch = make(chan int, 1)
+		Push(int(reflect.SelectRecv)).
+		LoadVar(ch).
+		Push(nil).
+		Push(int(reflect.SelectDefault)).
+		Push(nil).
+		Push(nil).
+		Select(2). // select { case <-ch: default: }
+		Push(int(reflect.SelectSend)).
+		LoadVar(ch).
+		Push(7).
+		Select(1). // select { case ch <- 7: }
+		Push(int(reflect.SelectRecv)).
+		LoadVar(ch).
+		Push(nil).
+		Select(1). // select { case v, ok := <-ch: }
+		Resolve()
+
+	ctx := NewContext(code)
+	ctx.Exec(0, code.Len())
+	if v := ctx.Get(-7); v != 1 {
+		t.Fatal("select default: chosen != 1, ret =", v)
+	}
+	if v := ctx.Get(-4); v != 0 {
+		t.Fatal("select send: chosen != 0, ret =", v)
+	}
+	if v, ok, chosen := ctx.Get(-3), ctx.Get(-2), ctx.Get(-1); v != 7 || ok != true || chosen != 0 {
+		t.Fatal("select recv failed:", v, ok, chosen)
+	}
+}
+
+// -----------------------------------------------------------------------------
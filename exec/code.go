@@ -1,9 +1,9 @@
 package exec
 
 import (
-	"bufio"
-	"io"
-	"strconv"
+	"reflect"
+
+	"github.com/qiniu/x/log"
 )
 
 // -----------------------------------------------------------------------------
@@ -81,6 +81,21 @@ const (
 	opCallClosure   = 28 // arity(26)
 	opGoClosure     = 29 // funcKind(2) addr(24)
 	opCallGoClosure = 30 // arity(26)
+	opRangeIter     = 31 // reserved(26)
+	opRangeNext     = 32 // offset(26)
+	opTypeCase      = 33 // typeIdx(26)
+	opMakeArray     = 34 // arity(10) typeIdx(16)
+	opMakeMap       = 35 // arity(10) typeIdx(16)
+	opMakeStruct    = 36 // arity(10) typeIdx(16)
+	opLoadField     = 37 // index(26)
+	opAddrField     = 38 // index(26)
+	opMakePtr       = 39 // typeIdx(26)
+	opMakeIface     = 40 // ifaceIdx(26)
+	opDefer         = 41 // offset(26) - offset is 0 if it starts a defer statement
+	opGo            = 42 // offset(26) - offset is 0 if it starts a go statement
+	opMakeChan      = 43 // typeIdx(26)
+	opChanOp        = 44 // reserved(24) chanOp(2)
+	opSelect        = 45 // n(26)
 )
 
 const (
@@ -93,8 +108,9 @@ const (
 )
 
 const (
-	ipInvalid = 0x7fffffff
-	ipReturnN = ipInvalid - 1
+	ipInvalid    = 0x7fffffff
+	ipReturnN    = ipInvalid - 1
+	ipReturnVars = ipInvalid - 2 // results are stored in the variables
 )
 
 // DecodeInstr returns
@@ -150,6 +166,21 @@ var instrInfos = []InstrInfo{
 	opCallClosure:   {"callClosure", "", "arity", 26},                       // arity(26)
 	opGoClosure:     {"closureGo", "funcKind", "addr", (2 << 8) | 24},       // funcKind(2) addr(24)
 	opCallGoClosure: {"callGoClosure", "", "arity", 26},                     // arity(26)
+	opRangeIter:     {"rangeIter", "", "", 0},                               // reserved(26)
+	opRangeNext:     {"rangeNext", "", "offset", 26},                        // offset(26)
+	opTypeCase:      {"typeCase", "", "typeIdx", 26},                        // typeIdx(26)
+	opMakeArray:     {"makeArray", "arity", "typeIdx", (10 << 8) | 16},      // arity(10) typeIdx(16)
+	opMakeMap:       {"makeMap", "arity", "typeIdx", (10 << 8) | 16},        // arity(10) typeIdx(16)
+	opMakeStruct:    {"makeStruct", "arity", "typeIdx", (10 << 8) | 16},     // arity(10) typeIdx(16)
+	opLoadField:     {"loadField", "", "index", 26},                         // index(26)
+	opAddrField:     {"addrField", "", "index", 26},                         // index(26)
+	opMakePtr:       {"makePtr", "", "typeIdx", 26},                         // typeIdx(26)
+	opMakeIface:     {"makeIface", "", "ifaceIdx", 26},                      // ifaceIdx(26)
+	opDefer:         {"defer", "", "offset", 26},                            // offset(26) - offset is 0 if it starts a defer statement
+	opGo:            {"go", "", "offset", 26},                               // offset(26) - offset is 0 if it starts a go statement
+	opMakeChan:      {"makeChan", "", "typeIdx", 26},                        // typeIdx(26)
+	opChanOp:        {"chanOp", "", "op", 26},                               // reserved(24) chanOp(2)
+	opSelect:        {"select", "", "n", 26},                                // n(26)
 }
 
 // -----------------------------------------------------------------------------
@@ -164,12 +195,24 @@ type Code struct {
 	funs         []*FuncInfo
 	funvs        []*FuncInfo
 	structs      []StructInfo
+	types        []reflect.Type
+	ifaces       []ifaceInfo
+	lines        []posInfo // source positions of instructions, sorted by ip
+	reg          *Registry // the registry that the code is compiled with, see Builder.SetRegistry
 	varManager
 }
 
 // NewCode returns a new Code object.
 func NewCode() *Code {
-	return &Code{data: make([]Instr, 0, 64)}
+	return &Code{data: make([]Instr, 0, 64), reg: DefaultRegistry}
+}
+
+// Registry returns the registry of Go packages that the code is compiled with.
+func (p *Code) Registry() *Registry {
+	if p.reg == nil {
+		return DefaultRegistry
+	}
+	return p.reg
 }
 
 // Len returns code length.
@@ -177,29 +220,6 @@ func (p *Code) Len() int {
 	return len(p.data)
 }
 
-// Dump dumps code.
-func (p *Code) Dump(w io.Writer) {
-	b := bufio.NewWriter(w)
-	for _, i := range p.data {
-		v, p1, p2 := DecodeInstr(i)
-		b.WriteString(v.Name)
-		b.WriteByte(' ')
-		if (v.Params & 0xff00) != 0 {
-			b.WriteString(v.Arg1)
-			b.WriteByte('=')
-			b.WriteString(strconv.Itoa(int(p1)))
-			b.WriteByte(' ')
-		}
-		if (v.Params & 0xff) != 0 {
-			b.WriteString(v.Arg2)
-			b.WriteByte('=')
-			b.WriteString(strconv.Itoa(int(p2)))
-		}
-		b.WriteByte('\n')
-	}
-	b.Flush()
-}
-
 // -----------------------------------------------------------------------------
 
 type anyUnresolved struct {
@@ -217,21 +237,44 @@ type Builder struct {
 	valConsts map[interface{}]*valUnresolved
 	labels    map[*Label]int
 	funcs     map[*FuncInfo]int
+	types     map[reflect.Type]uint32
 	*varManager
 }
 
-// NewBuilder creates a new Code Builder instance.
+// NewBuilder creates a new Code Builder instance. If code isn't nil, the builder
+// appends instructions to it, and functions defined in it can be called by the
+// new instructions. So a code can be built piece by piece, with a new builder
+// for each piece (eg. a line of a REPL).
 func NewBuilder(code *Code) *Builder {
 	if code == nil {
 		code = NewCode()
 	}
-	return &Builder{
+	p := &Builder{
 		code:       code,
 		valConsts:  make(map[interface{}]*valUnresolved),
 		labels:     make(map[*Label]int),
 		funcs:      make(map[*FuncInfo]int),
+		types:      make(map[reflect.Type]uint32),
 		varManager: &code.varManager,
 	}
+	for i, fun := range code.funs {
+		p.funcs[fun] = i
+	}
+	for i, fun := range code.funvs {
+		p.funcs[fun] = i
+	}
+	return p
+}
+
+// SetRegistry sets the registry of Go packages that the code is compiled with, that
+// is DefaultRegistry by default. The code is executed with it (see NewContext).
+func (p *Builder) SetRegistry(reg *Registry) *Builder {
+	code := p.code
+	if code.Registry() != reg && len(code.data) > 0 {
+		log.Panicln("SetRegistry failed: the code is compiled with another registry.")
+	}
+	code.reg = reg
+	return p
 }
 
 // Resolve resolves all unresolved labels/functions/consts/etc.
@@ -244,6 +287,20 @@ func (p *Builder) Resolve() *Code {
 
 // -----------------------------------------------------------------------------
 
+// newType returns index of a type in the types table of the code.
+func (p *Builder) newType(t reflect.Type) uint32 {
+	if idx, ok := p.types[t]; ok {
+		return idx
+	}
+	code := p.code
+	idx := uint32(len(code.types))
+	code.types = append(code.types, t)
+	p.types[t] = idx
+	return idx
+}
+
+// -----------------------------------------------------------------------------
+
 // Reserved represents a reserved instruction position.
 type Reserved int
 
@@ -0,0 +1,98 @@
+package exec
+
+import (
+	"reflect"
+
+	"github.com/qiniu/x/log"
+)
+
+// -----------------------------------------------------------------------------
+
+func popArity(i Instr, p *Context) int {
+	arity := int((i >> bitsOpCallFuncvShift) & bitsFuncvArityOperand)
+	if arity == bitsFuncvArityMax {
+		arity = p.Pop().(int) + bitsFuncvArityMax
+	}
+	return arity
+}
+
+func execMakeArray(i Instr, p *Context) {
+	typ := p.code.types[i&bitsOpCallFuncvOperand]
+	arity := popArity(i, p)
+	var ret reflect.Value
+	if typ.Kind() == reflect.Slice {
+		p.alloc(arity, typ.Elem().Size())
+		ret = reflect.MakeSlice(typ, arity, arity)
+	} else {
+		p.alloc(1, typ.Size())
+		ret = reflect.New(typ).Elem()
+	}
+	args := p.GetArgs(uint32(arity))
+	for i, arg := range args {
+		setValue(ret.Index(i), arg)
+	}
+	p.Ret(uint32(arity), ret.Interface())
+}
+
+func execMakeMap(i Instr, p *Context) {
+	typ := p.code.types[i&bitsOpCallFuncvOperand]
+	arity := popArity(i, p)
+	p.alloc(arity, typ.Key().Size()+typ.Elem().Size())
+	ret := reflect.MakeMapWithSize(typ, arity)
+	args := p.GetArgs(uint32(arity << 1))
+	for i := 0; i < len(args); i += 2 {
+		key := getValueOf(args[i], typ.Key())
+		val := getValueOf(args[i+1], typ.Elem())
+		ret.SetMapIndex(key, val)
+	}
+	p.Ret(uint32(arity<<1), ret.Interface())
+}
+
+func execMakeStruct(i Instr, p *Context) {
+	typ := p.code.types[i&bitsOpCallFuncvOperand]
+	arity := popArity(i, p)
+	p.alloc(1, typ.Size())
+	ret := reflect.New(typ).Elem()
+	args := p.GetArgs(uint32(arity))
+	for i, arg := range args {
+		if arg != nil {
+			setValue(fieldOf(ret, i), arg)
+		}
+	}
+	p.Ret(uint32(arity), ret.Interface())
+}
+
+func (p *Builder) makeOp(op int, typ reflect.Type, arity int) *Builder {
+	code := p.code
+	if arity >= bitsFuncvArityMax {
+		p.Push(arity - bitsFuncvArityMax)
+		arity = bitsFuncvArityMax
+	}
+	idx := p.newType(typ)
+	if idx > bitsOpCallFuncvOperand {
+		log.Panicln("makeOp failed: too many types -", idx)
+	}
+	i := (uint32(op) << bitsOpShift) | (uint32(arity) << bitsOpCallFuncvShift) | idx
+	code.data = append(code.data, i)
+	return p
+}
+
+// MakeArray instr. It creates a slice or an array of typ from the top arity values
+// on the stack. If typ is an array type, arity can be less than its length.
+func (p *Builder) MakeArray(typ reflect.Type, arity int) *Builder {
+	return p.makeOp(opMakeArray, typ, arity)
+}
+
+// MakeMap instr. It creates a map of typ from the top arity key-value pairs on
+// the stack (that is, 2*arity values).
+func (p *Builder) MakeMap(typ reflect.Type, arity int) *Builder {
+	return p.makeOp(opMakeMap, typ, arity)
+}
+
+// MakeStruct instr. It creates a struct of typ from the top arity values on the
+// stack, one value per field. A nil value means zero value of the field.
+func (p *Builder) MakeStruct(typ reflect.Type, arity int) *Builder {
+	return p.makeOp(opMakeStruct, typ, arity)
+}
+
+// -----------------------------------------------------------------------------
@@ -0,0 +1,99 @@
+package exec
+
+import (
+	"reflect"
+	"testing"
+)
+
+// -----------------------------------------------------------------------------
+
+func TestMakeArray(t *testing.T) {
+	typ := reflect.TypeOf([]float64(nil))
+	code := NewBuilder(nil).
+		Push(1.0).
+		Push(2.0).
+		Push(nil).
+		MakeArray(typ, 3).
+		Resolve()
+
+	ctx := NewContext(code)
+	ctx.Exec(0, code.Len())
+	if v := checkPop(ctx); !reflect.DeepEqual(v, []float64{1, 2, 0}) {
+		t.Fatal("[]float64{1, 2, 0} != ret:", v)
+	}
+}
+
+func TestMakeArray2(t *testing.T) {
+	typ := reflect.TypeOf([4]string{})
+	code := NewBuilder(nil).
+		Push("a").
+		Push("b").
+		MakeArray(typ, 2).
+		Resolve()
+
+	ctx := NewContext(code)
+	ctx.Exec(0, code.Len())
+	if v := checkPop(ctx); v != [4]string{"a", "b"} {
+		t.Fatal(`[4]string{"a", "b"} != ret:`, v)
+	}
+}
+
+func TestMakeArrayLarge(t *testing.T) {
+	typ := reflect.TypeOf([]int(nil))
+	b := NewBuilder(nil)
+	n := bitsFuncvArityMax + 10
+	for i := 0; i < n; i++ {
+		b.Push(i)
+	}
+	code := b.MakeArray(typ, n).Resolve()
+
+	ctx := NewContext(code)
+	ctx.Exec(0, code.Len())
+	v := checkPop(ctx).([]int)
+	if len(v) != n || v[n-1] != n-1 {
+		t.Fatal("MakeArray failed:", len(v))
+	}
+}
+
+func TestMakeMap(t *testing.T) {
+	typ := reflect.TypeOf(map[string]interface{}(nil))
+	code := NewBuilder(nil).
+		Push("a").
+		Push(1).
+		Push("b").
+		Push("Hello").
+		Push("c").
+		Push(nil).
+		MakeMap(typ, 3).
+		Resolve()
+
+	ctx := NewContext(code)
+	ctx.Exec(0, code.Len())
+	expected := map[string]interface{}{"a": 1, "b": "Hello", "c": nil}
+	if v := checkPop(ctx); !reflect.DeepEqual(v, expected) {
+		t.Fatal("MakeMap failed:", v)
+	}
+}
+
+func TestMakeStruct(t *testing.T) {
+	typ := reflect.StructOf([]StructField{
+		{Name: "X", Type: TyInt},
+		{Name: "y", Type: TyString, PkgPath: "main"},
+		{Name: "Z", Type: TyFloat64},
+	})
+	code := NewBuilder(nil).
+		Push(1).
+		Push("Hello").
+		Push(nil).
+		MakeStruct(typ, 3).
+		Resolve()
+
+	ctx := NewContext(code)
+	ctx.Exec(0, code.Len())
+	v := reflect.ValueOf(checkPop(ctx))
+	if v.Type() != typ || v.Field(0).Int() != 1 || v.Field(1).String() != "Hello" || v.Field(2).Float() != 0 {
+		t.Fatal("MakeStruct failed:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
@@ -1,6 +1,9 @@
 package exec
 
 import (
+	"reflect"
+	"sync/atomic"
+
 	"github.com/qiniu/x/log"
 )
 
@@ -8,7 +11,8 @@ import (
 
 // A Stack represents a FILO container.
 type Stack struct {
-	data []interface{}
+	data    []interface{}
+	charged int // capacity charged to the memory budget, see Limits.MaxMemory
 }
 
 // NewStack creates a Stack instance.
@@ -19,6 +23,7 @@ func NewStack() (p *Stack) {
 // Init initializes this Stack object.
 func (p *Stack) Init() {
 	p.data = make([]interface{}, 0, 64)
+	p.charged = 0
 }
 
 // Get returns the value at specified index.
@@ -76,20 +81,45 @@ type Context struct {
 	*Stack
 	code   *Code
 	parent *Context
+	fun    *FuncInfo // the function being executed, or nil if it is the main code
 	vars   varsContext
 	ip     int
 	base   int
+
+	defers      []deferredCall
+	callBase    int         // stack depth when the current defer (or go) statement starts
+	deferring   *panicState // the panic that deferred calls are handling
+	recoverable *panicState // the panic that recover stops, if the function is called by a deferred call
+
+	limits   *execLimits // the execution budget, see SetLimits
+	reg      *Registry   // the registry of Go functions that the code calls
+	caller   *Context    // the context that calls the function, nil if it's main or called by Go
+	debugger *Debugger   // the debugger of the code, or nil if it isn't debugged
+	goCalls  int32       // count of Go calls in progress when debugging, read atomically
+	depth    int         // count of nested qlang function calls in the goroutine
 }
 
 func newSimpleContext(data []interface{}) *Context {
 	return &Context{Stack: &Stack{data: data}}
 }
 
-// NewContext returns a new context of an executor.
+// NewContext returns a new context of an executor, which executes code with the
+// registry that it's compiled with.
 func NewContext(code *Code) *Context {
+	return code.Registry().NewContext(code)
+}
+
+// NewContext returns a new context of an executor, which executes code with this
+// registry. code must be compiled with the same registry.
+func (r *Registry) NewContext(code *Code) *Context {
+	if code.Registry() != r {
+		log.Panicln("NewContext failed: the code is compiled with another registry.")
+	}
 	p := &Context{
-		Stack: NewStack(),
-		code:  code,
+		Stack:  NewStack(),
+		code:   code,
+		limits: newExecLimits(),
+		reg:    r,
 	}
 	if len(code.vlist) > 0 {
 		p.vars = makeVarsContext(code.vlist, p)
@@ -97,6 +127,26 @@ func NewContext(code *Code) *Context {
 	return p
 }
 
+// GrowVars makes room for global variables defined after the context is created,
+// when more instructions are appended to its code (see NewBuilder). The existing
+// variables aren't moved, so pointers to them, and the goroutines running already,
+// still refer to them.
+func (p *Context) GrowVars() {
+	vlist := p.code.vlist
+	if !p.vars.IsValid() {
+		if len(vlist) > 0 {
+			p.vars = makeVarsContext(vlist, p)
+		}
+		return
+	}
+	n := p.vars.NumField()
+	if len(vlist) == n {
+		return
+	}
+	t := Struct(makeVarList(vlist[n:])).Type()
+	p.vars.grown = append(p.vars.grown, reflect.New(t).Elem())
+}
+
 // NewContextEx creates a closure context, with some local variables.
 func NewContextEx(parent *Context, stk *Stack, code *Code, vars ...*Var) *Context {
 	p := &Context{
@@ -104,6 +154,12 @@ func NewContextEx(parent *Context, stk *Stack, code *Code, vars ...*Var) *Contex
 		code:   code,
 		parent: parent,
 		base:   len(stk.data),
+		reg:    DefaultRegistry,
+	}
+	if parent != nil {
+		p.limits, p.reg, p.debugger = parent.limits, parent.reg, parent.debugger
+	} else {
+		p.limits = newExecLimits()
 	}
 	if len(vars) > 0 {
 		p.vars = makeVarsContext(vars, p)
@@ -118,13 +174,35 @@ func (ctx *Context) globalCtx() *Context {
 	return ctx
 }
 
-// Exec executes a code block from ip to ipEnd.
+// Exec executes a code block from ip to ipEnd, and then executes the deferred calls.
+// If the code panics, Exec panics with a *RuntimeError.
 func (ctx *Context) Exec(ip, ipEnd int) {
+	defer ctx.onExit()
+	ctx.run(ip, ipEnd)
+}
+
+func (ctx *Context) run(ip, ipEnd int) {
 	data := ctx.code.data
 	ctx.ip = ip
+	lim, steps := ctx.limits, 0
+	if lim != nil && lim.maxSteps > 0 {
+		defer func() {
+			atomic.AddInt64(&lim.steps, int64(steps))
+		}()
+	}
+	dbg := ctx.debugger
 	for ctx.ip < ipEnd {
 		i := data[ctx.ip]
 		ctx.ip++
+		if dbg != nil {
+			dbg.check(ctx)
+		}
+		if lim != nil {
+			if steps++; steps == stepsPerCheck {
+				steps = 0
+				lim.check(stepsPerCheck, ctx.Stack)
+			}
+		}
 		switch i >> bitsOpShift {
 		case opBuiltinOp:
 			execBuiltinOp(i, ctx)
@@ -179,6 +257,9 @@ var _execTable = [...]func(i Instr, p *Context){
 	opLoadVar:       execLoadVar,
 	opStoreVar:      execStoreVar,
 	opAddrVar:       execAddrVar,
+	opLoadGoVar:     execLoadGoVar,
+	opStoreGoVar:    execStoreGoVar,
+	opAddrGoVar:     execAddrGoVar,
 	opAddrOp:        execAddrOp,
 	opLoad:          execLoad,
 	opStore:         execStore,
@@ -186,6 +267,21 @@ var _execTable = [...]func(i Instr, p *Context){
 	opCallClosure:   execCallClosure,
 	opGoClosure:     execGoClosure,
 	opCallGoClosure: execCallGoClosure,
+	opRangeIter:     execRangeIter,
+	opRangeNext:     execRangeNext,
+	opTypeCase:      execTypeCase,
+	opMakeArray:     execMakeArray,
+	opMakeMap:       execMakeMap,
+	opMakeStruct:    execMakeStruct,
+	opLoadField:     execLoadField,
+	opAddrField:     execAddrField,
+	opMakePtr:       execMakePtr,
+	opMakeIface:     execMakeIface,
+	opDefer:         execDefer,
+	opGo:            execGo,
+	opMakeChan:      execMakeChan,
+	opChanOp:        execChanOp,
+	opSelect:        execSelect,
 }
 
 var execTable []func(i Instr, p *Context)
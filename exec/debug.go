@@ -0,0 +1,329 @@
+package exec
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// -----------------------------------------------------------------------------
+
+// A StepMode represents how a Debugger goes on after the code stops.
+type StepMode int
+
+const (
+	// StepContinue runs the code until it stops at a breakpoint.
+	StepContinue StepMode = iota
+	// StepInto stops at the next statement, maybe in a function that is called.
+	StepInto
+	// StepOver stops at the next statement of the function, or its callers.
+	StepOver
+	// StepOut stops after the function returns to its caller.
+	StepOut
+)
+
+// A StopReason represents why the code stops.
+type StopReason int
+
+const (
+	// StopBreakpoint means the code stops at a breakpoint.
+	StopBreakpoint StopReason = iota
+	// StopStep means the code stops after a step.
+	StopStep
+	// StopPause means the code stops because Debugger.Pause is called.
+	StopPause
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StopBreakpoint:
+		return "breakpoint"
+	case StopStep:
+		return "step"
+	case StopPause:
+		return "pause"
+	}
+	return "unknown"
+}
+
+// A StopHandler is called by a Debugger when the code stops in ctx, before the
+// instruction of ctx.Frame() is executed. It can inspect the context (see
+// Context.Locals, Context.Globals and Context.Caller), and returns how to go on.
+// The code is blocked until it returns.
+type StopHandler = func(ctx *Context, reason StopReason) StepMode
+
+// A Debugger stops code at breakpoints, or after steps, and calls its handler. A
+// breakpoint or a step stops at the first instruction of a statement, which is
+// located by source positions of the code (see Builder.SetPos).
+//
+// Goroutines of the code are debugged too: they stop at breakpoints, but a step
+// only stops in the goroutine where it starts. When a goroutine stops, the others
+// go on running, and stops of them wait until the handler returns.
+//
+// A qlang function called by Go (eg. a closure passed to sort.Slice) is a callee
+// of the qlang code that calls the Go function, so steps go across such calls.
+type Debugger struct {
+	code    *Code
+	handler StopHandler
+	stmts   []bool       // if an instruction is the first one of a statement
+	breaks  atomic.Value // map[int]bool, the instructions that have breakpoints
+
+	mu        sync.Mutex // protects the fields below
+	stepping  int32      // step or pause is in progress, read atomically
+	step      StepMode
+	stepRoot  *Context // the context that starts the goroutine where the step starts
+	stepDepth int      // depth of the function call where the step starts
+
+	stopMu sync.Mutex // serializes calls of handler
+}
+
+// NewDebugger creates a debugger of code. The code must be resolved, and not be
+// changed later. handler is called when the code stops.
+func NewDebugger(code *Code, handler StopHandler) *Debugger {
+	d := &Debugger{code: code, handler: handler}
+	d.stmts = make([]bool, len(code.data))
+	lines := code.lines
+	for i, line := range lines {
+		if line.ip >= len(code.data) {
+			break
+		}
+		if i == 0 || line.pos.Line != lines[i-1].pos.Line || line.pos.Filename != lines[i-1].pos.Filename {
+			d.stmts[line.ip] = true
+		}
+	}
+	d.breaks.Store(map[int]bool{})
+	return d
+}
+
+// SetDebugger makes the context, and contexts derived from it (function calls,
+// goroutines, etc.), debugged by d.
+func (ctx *Context) SetDebugger(d *Debugger) {
+	ctx.debugger = d
+}
+
+// matchFile checks if filename (a file of the code) matches file, which is the
+// same path, or a suffix of it (eg. "bar.ql" matches "/foo/bar.ql").
+func matchFile(filename, file string) bool {
+	filename, file = filepath.Clean(filename), filepath.Clean(file)
+	return filename == file || strings.HasSuffix(filename, string(filepath.Separator)+file)
+}
+
+// SetBreakpoint sets a breakpoint at a line of a source file. If there isn't a
+// statement starting at the line, the breakpoint is moved to the next line that
+// has one. It returns the line of the breakpoint, or false if not found.
+func (d *Debugger) SetBreakpoint(file string, line int) (int, bool) {
+	found := 0
+	for _, v := range d.code.lines {
+		if d.isStmt(v.ip) && v.pos.Line >= line && (found == 0 || v.pos.Line < found) && matchFile(v.pos.Filename, file) {
+			found = v.pos.Line
+		}
+	}
+	if found == 0 {
+		return 0, false
+	}
+	d.updateBreaks(func(breaks map[int]bool) {
+		for _, v := range d.code.lines {
+			if d.isStmt(v.ip) && v.pos.Line == found && matchFile(v.pos.Filename, file) {
+				breaks[v.ip] = true
+			}
+		}
+	})
+	return found, true
+}
+
+// ClearBreakpoints clears breakpoints of a source file, or all files if file is
+// "". If line > 0, only the breakpoint at the line is cleared.
+func (d *Debugger) ClearBreakpoints(file string, line int) {
+	d.updateBreaks(func(breaks map[int]bool) {
+		for ip := range breaks {
+			if pos, ok := d.code.Pos(ip); ok && (line <= 0 || pos.Line == line) && (file == "" || matchFile(pos.Filename, file)) {
+				delete(breaks, ip)
+			}
+		}
+	})
+}
+
+// updateBreaks updates breakpoints by copy on write, so check can read them
+// without locks.
+func (d *Debugger) updateBreaks(update func(breaks map[int]bool)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	old := d.breaks.Load().(map[int]bool)
+	breaks := make(map[int]bool, len(old))
+	for ip := range old {
+		breaks[ip] = true
+	}
+	update(breaks)
+	d.breaks.Store(breaks)
+}
+
+// Pause makes the code stop at the next statement, in any goroutine.
+func (d *Debugger) Pause() {
+	d.mu.Lock()
+	d.setStep(StepInto, nil, 0)
+	d.mu.Unlock()
+}
+
+func (d *Debugger) setStep(step StepMode, root *Context, depth int) {
+	d.step, d.stepRoot, d.stepDepth = step, root, depth
+	if step == StepContinue {
+		atomic.StoreInt32(&d.stepping, 0)
+	} else {
+		atomic.StoreInt32(&d.stepping, 1)
+	}
+}
+
+func (d *Debugger) isStmt(ip int) bool {
+	return ip < len(d.stmts) && d.stmts[ip]
+}
+
+// check is called before an instruction is executed, that is ctx.ip-1.
+func (d *Debugger) check(ctx *Context) {
+	ip := ctx.ip - 1
+	if ctx.code != d.code {
+		return
+	}
+	stmt := d.isStmt(ip)
+	if stmt && d.breaks.Load().(map[int]bool)[ip] {
+		d.stop(ctx, StopBreakpoint)
+		return
+	}
+	if atomic.LoadInt32(&d.stepping) == 0 {
+		return
+	}
+	d.mu.Lock()
+	reason, ok := d.stepDone(ctx, stmt)
+	d.mu.Unlock()
+	if ok {
+		d.stop(ctx, reason)
+	}
+}
+
+// stepDone checks if the step (or pause) in progress is done.
+func (d *Debugger) stepDone(ctx *Context, stmt bool) (StopReason, bool) {
+	if d.step == StepContinue {
+		return 0, false
+	}
+	if d.stepRoot == nil { // pause
+		return StopPause, stmt
+	}
+	depth, root := ctx.callDepth()
+	if root != d.stepRoot {
+		return 0, false
+	}
+	switch d.step {
+	case StepInto:
+		return StopStep, stmt
+	case StepOver:
+		return StopStep, stmt && depth <= d.stepDepth
+	default: // StepOut
+		return StopStep, depth < d.stepDepth
+	}
+}
+
+func (d *Debugger) stop(ctx *Context, reason StopReason) {
+	d.stopMu.Lock()
+	defer d.stopMu.Unlock()
+	d.mu.Lock()
+	d.setStep(StepContinue, nil, 0) // no other goroutines stop by the step
+	d.mu.Unlock()
+
+	step := d.handler(ctx, reason)
+	depth, root := ctx.callDepth()
+	d.mu.Lock()
+	if d.step == StepContinue { // Pause may be called by the handler
+		d.setStep(step, root, depth)
+	}
+	d.mu.Unlock()
+}
+
+// enterGoCall is called before ctx calls a Go function, which may call qlang
+// closures that ctx makes, see Closure.goCaller.
+func enterGoCall(ctx *Context) *Context {
+	atomic.AddInt32(&ctx.goCalls, 1)
+	return ctx
+}
+
+func leaveGoCall(ctx *Context) {
+	atomic.AddInt32(&ctx.goCalls, -1)
+}
+
+// goCaller returns the context that calls a Go function, which calls the closure.
+// A closure is passed to Go functions by the qlang code that makes it, eg.
+// sort.Slice(a, func(i, j int) bool {...}), so it's the maker while the maker is
+// calling Go functions, and nil otherwise (eg. the closure is stored and called by
+// Go later). The caller is known only when debugging.
+func (p *Closure) goCaller() *Context {
+	if c := p.maker; c != nil && atomic.LoadInt32(&c.goCalls) > 0 {
+		return c
+	}
+	return nil
+}
+
+// callDepth returns count of the callers of ctx, and the context that starts the
+// goroutine (or the main code) that ctx is in.
+func (ctx *Context) callDepth() (depth int, root *Context) {
+	for root = ctx; root.caller != nil; root = root.caller {
+		depth++
+	}
+	return
+}
+
+// -----------------------------------------------------------------------------
+
+// A Variable represents a variable of the code being debugged.
+type Variable struct {
+	Name  string
+	Type  reflect.Type
+	Value interface{}
+}
+
+// Caller returns the context of the function call that calls the function of ctx.
+// It returns nil if ctx executes the main code, or a function started by a go
+// statement. If the function is a closure called by Go, which is called by the
+// qlang code that makes the closure, the caller is that context when the code is
+// debugged, and nil otherwise.
+func (ctx *Context) Caller() *Context {
+	return ctx.caller
+}
+
+// Locals returns arguments and variables of the function that ctx executes. They
+// are global variables if ctx executes the main code.
+func (ctx *Context) Locals() []Variable {
+	fun := ctx.fun
+	if fun == nil {
+		return ctx.Globals()
+	}
+	var vars []Variable
+	if n := len(fun.in); ctx.base >= n { // arguments are on the stack, unless it is a goroutine
+		for i, name := range fun.argNames {
+			if name != "" && i < n {
+				vars = append(vars, Variable{Name: name, Type: fun.in[i], Value: ctx.data[ctx.base-n+i]})
+			}
+		}
+	}
+	return appendVars(vars, fun.vlist, ctx.vars)
+}
+
+// Globals returns global variables of the code.
+func (ctx *Context) Globals() []Variable {
+	return appendVars(nil, ctx.code.vlist, ctx.globalCtx().vars)
+}
+
+func appendVars(vars []Variable, vlist []*Var, values varsContext) []Variable {
+	if !values.IsValid() {
+		return vars
+	}
+	for i, v := range vlist {
+		name := v.Name()
+		if name == "" || name[0] >= '0' && name[0] <= '9' || i >= values.NumField() { // unnamed results
+			continue
+		}
+		vars = append(vars, Variable{Name: name, Type: v.Type, Value: values.Field(i).Interface()})
+	}
+	return vars
+}
+
+// -----------------------------------------------------------------------------
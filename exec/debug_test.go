@@ -0,0 +1,213 @@
+package exec
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/qiniu/qlang/token"
+)
+
+// -----------------------------------------------------------------------------
+
+func newDebugCode() *Code {
+	pos := func(line int) token.Position {
+		return token.Position{Filename: "/foo/bar.ql", Line: line, Column: 1}
+	}
+	x := NewVar(TyInt, "x")
+	s := NewVar(TyInt, "s")
+	add := NewFunc("add", 1)
+	return NewBuilder(nil).
+		DefineVar(x).
+		SetPos(pos(1)).
+		Push(1).
+		Push(2).
+		CallFunc(add).
+		StoreVar(x). // x = add(1, 2)
+		SetPos(pos(2)).
+		LoadVar(x).
+		Push(3).
+		CallFunc(add).
+		StoreVar(x). // x = add(x, 3)
+		SetPos(pos(3)).
+		Return(-1).
+		DefineFunc(
+			add.Return(NewVar(TyInt, "1")).
+				Args(TyInt, TyInt).
+				ArgNames("a", "b")).
+		DefineVar(s).
+		SetPos(pos(10)).
+		Load(-2).
+		Load(-1).
+		BuiltinOp(Int, OpAdd).
+		StoreVar(s). // s = a + b
+		SetPos(pos(11)).
+		LoadVar(s).
+		Return(1).
+		EndFunc(add).
+		Resolve()
+}
+
+// debugRun runs code, and returns the stops as "func:line" strings. The steps are
+// returned by the handler one by one, and then StepContinue.
+func debugRun(t *testing.T, code *Code, init func(d *Debugger), steps ...StepMode) []string {
+	var stops []string
+	d := NewDebugger(code, func(ctx *Context, reason StopReason) StepMode {
+		f := ctx.Frame()
+		stops = append(stops, fmt.Sprintf("%s:%d", f.Func, f.Pos.Line))
+		if len(steps) == 0 {
+			return StepContinue
+		}
+		step := steps[0]
+		steps = steps[1:]
+		return step
+	})
+	init(d)
+	ctx := NewContext(code)
+	ctx.SetDebugger(d)
+	if err := ctx.Run(0, code.Len()); err != nil {
+		t.Fatal("Run failed:", err)
+	}
+	if v := ctx.getVar(0); v != 6 {
+		t.Fatal("x != 6, ret =", v)
+	}
+	return stops
+}
+
+func TestDebugStep(t *testing.T) {
+	code := newDebugCode()
+	pause := func(d *Debugger) { d.Pause() }
+	cases := []struct {
+		steps []StepMode
+		stops string
+	}{
+		{[]StepMode{StepInto, StepInto, StepInto, StepInto, StepInto, StepInto},
+			"main:1 add:10 add:11 main:2 add:10 add:11 main:3"},
+		{[]StepMode{StepOver, StepOver, StepOver}, "main:1 main:2 main:3"},
+		{[]StepMode{StepInto, StepOut, StepOver}, "main:1 add:10 main:1 main:2"},
+	}
+	for _, c := range cases {
+		stops := debugRun(t, code, pause, c.steps...)
+		if v := strings.Join(stops, " "); v != c.stops {
+			t.Fatal("steps", c.steps, "stop at", v)
+		}
+	}
+}
+
+func TestDebugBreakpoint(t *testing.T) {
+	code := newDebugCode()
+	stops := debugRun(t, code, func(d *Debugger) {
+		if line, ok := d.SetBreakpoint("bar.ql", 9); !ok || line != 10 {
+			t.Fatal("SetBreakpoint failed:", line, ok)
+		}
+		if _, ok := d.SetBreakpoint("/foo/bar.ql", 2); !ok {
+			t.Fatal("SetBreakpoint failed: line 2")
+		}
+		if _, ok := d.SetBreakpoint("bar.ql", 12); ok {
+			t.Fatal("SetBreakpoint: line 12 found")
+		}
+		if _, ok := d.SetBreakpoint("ar.ql", 1); ok {
+			t.Fatal("SetBreakpoint: ar.ql found")
+		}
+		d.ClearBreakpoints("bar.ql", 2)
+	})
+	if v := strings.Join(stops, " "); v != "add:10 add:10" {
+		t.Fatal("stop at", v)
+	}
+
+	stops = debugRun(t, code, func(d *Debugger) {
+		d.SetBreakpoint("bar.ql", 11)
+		d.SetBreakpoint("bar.ql", 3)
+		d.ClearBreakpoints("bar.ql", 0)
+		d.SetBreakpoint("bar.ql", 10)
+		d.ClearBreakpoints("", 0)
+	})
+	if len(stops) != 0 {
+		t.Fatal("stop at", stops)
+	}
+}
+
+func TestDebugVars(t *testing.T) {
+	code := newDebugCode()
+	var locals, globals, callers []string
+	d := NewDebugger(code, func(ctx *Context, reason StopReason) StepMode {
+		if reason != StopBreakpoint {
+			t.Fatal("stop reason:", reason)
+		}
+		for _, v := range ctx.Locals() {
+			locals = append(locals, fmt.Sprintf("%s=%v", v.Name, v.Value))
+		}
+		for _, v := range ctx.Globals() {
+			globals = append(globals, fmt.Sprintf("%s=%v", v.Name, v.Value))
+			if v.Type != TyInt {
+				t.Fatal("type of", v.Name, "-", v.Type)
+			}
+		}
+		for c := ctx; c != nil; c = c.Caller() {
+			callers = append(callers, c.Frame().Func)
+		}
+		return StepContinue
+	})
+	d.SetBreakpoint("bar.ql", 11)
+	ctx := NewContext(code)
+	ctx.SetDebugger(d)
+	ctx.Exec(0, code.Len())
+	expected := []string{"a=1", "b=2", "s=3", "a=3", "b=3", "s=6"}
+	if !reflect.DeepEqual(locals, expected) {
+		t.Fatal("locals:", locals)
+	}
+	if !reflect.DeepEqual(globals, []string{"x=0", "x=3"}) {
+		t.Fatal("globals:", globals)
+	}
+	if !reflect.DeepEqual(callers, []string{"add", "main", "add", "main"}) {
+		t.Fatal("callers:", callers)
+	}
+}
+
+func TestDebugGoCaller(t *testing.T) {
+	pos := func(line int) token.Position {
+		return token.Position{Filename: "/foo/bar.ql", Line: line, Column: 1}
+	}
+	f := NewFunc("f", 1)
+	code := NewBuilder(nil).
+		SetPos(pos(1)).
+		Push(2).
+		GoClosure(f).
+		CallGoClosure(1). // func(a int) int { return a }(2), called by Go
+		SetPos(pos(2)).
+		GoClosure(f).
+		Return(-1).
+		DefineFunc(
+			f.Return(NewVar(TyInt, "1")).
+				Args(TyInt).
+				ArgNames("a")).
+		SetPos(pos(10)).
+		Load(-1).
+		Return(1).
+		EndFunc(f).
+		Resolve()
+
+	var stops []string
+	d := NewDebugger(code, func(ctx *Context, reason StopReason) StepMode {
+		var callers []string
+		for c := ctx; c != nil; c = c.Caller() {
+			callers = append(callers, c.Frame().Func)
+		}
+		stops = append(stops, strings.Join(callers, " "))
+		return StepContinue
+	})
+	d.SetBreakpoint("bar.ql", 10)
+	ctx := NewContext(code)
+	ctx.SetDebugger(d)
+	ctx.Exec(0, code.Len())
+	fn := ctx.Get(-1).(func(int) int)
+	if v := fn(3); v != 3 { // the maker doesn't call Go functions now
+		t.Fatal("fn(3) =", v)
+	}
+	if !reflect.DeepEqual(stops, []string{"f main", "f"}) {
+		t.Fatal("stops:", stops)
+	}
+}
+
+// -----------------------------------------------------------------------------
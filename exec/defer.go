@@ -0,0 +1,137 @@
+package exec
+
+// -----------------------------------------------------------------------------
+
+// A deferredCall represents a call deferred by a defer statement.
+type deferredCall struct {
+	ip, end int           // the call instructions
+	args    []interface{} // function value and arguments, evaluated by the defer statement
+}
+
+// A panicState represents a panic that deferred calls of a function are handling.
+type panicState struct {
+	value     interface{} // the value recovered from the panic (maybe a *RuntimeError)
+	recovered bool
+}
+
+func execDefer(i Instr, p *Context) {
+	off := int(i & bitsOperand)
+	if off == 0 { // start a defer statement
+		p.callBase = len(p.data)
+		return
+	}
+	n := len(p.data)
+	args := make([]interface{}, n-p.callBase)
+	copy(args, p.data[p.callBase:])
+	p.data = p.data[:p.callBase]
+	p.defers = append(p.defers, deferredCall{ip: p.ip, end: p.ip + off, args: args})
+	p.ip += off
+}
+
+// onExit is deferred by Exec. It executes deferred calls of the function, and then
+// converts a panic that isn't recovered into a *RuntimeError.
+func (ctx *Context) onExit() {
+	e := recover()
+	if len(ctx.defers) > 0 {
+		if e == nil && ctx.ip == ipReturnN && ctx.fun != nil {
+			ctx.storeResults()
+		}
+		e = ctx.execDefers(e)
+	}
+	if e != nil {
+		err, ok := e.(*RuntimeError)
+		if !ok {
+			err = &RuntimeError{Value: e}
+		}
+		err.Frames = append(err.Frames, ctx.Frame())
+		panic(err)
+	}
+}
+
+// storeResults stores values returned by a return statement into the result
+// variables, so that deferred calls can change them.
+func (ctx *Context) storeResults() {
+	n := ctx.fun.numOut
+	base := len(ctx.data) - n
+	for i := 0; i < n; i++ {
+		ctx.setVar(uint32(i), ctx.data[base+i])
+	}
+	ctx.data = ctx.data[:base]
+	ctx.ip = ipReturnVars
+}
+
+// execDefers executes deferred calls in the reverse order. e is the value that the
+// function panics with, or nil if the function returns normally. It returns the
+// value that the function panics with finally, or nil if the panic is recovered.
+func (ctx *Context) execDefers(e interface{}) interface{} {
+	ip := ctx.ip
+	for n := len(ctx.defers); n > 0; n = len(ctx.defers) {
+		d := ctx.defers[n-1]
+		ctx.defers = ctx.defers[:n-1]
+		var state *panicState
+		if e != nil {
+			state = &panicState{value: e}
+		}
+		ctx.deferring = state
+		if ePanic := ctx.execDeferredCall(&d); ePanic != nil { // a deferred call panics
+			e = ePanic
+		} else if state != nil && state.recovered {
+			e = nil
+		}
+	}
+	ctx.deferring = nil
+	if ip == ipReturnN && e == nil { // results are stored in the variables
+		ip = ipReturnVars
+	}
+	ctx.ip = ip
+	return e
+}
+
+func (ctx *Context) execDeferredCall(d *deferredCall) (e interface{}) {
+	n := len(ctx.data)
+	defer func() {
+		e = recover()
+		ctx.data = ctx.data[:n] // results of the deferred call are discarded
+	}()
+	ctx.data = append(ctx.data, d.args...)
+	ctx.run(d.ip, d.end)
+	return
+}
+
+// Recover implements the builtin function recover. It stops the panicking and
+// returns the value that the code panics with, if it is called by a function that
+// is called directly by a deferred call of the panicking function. Otherwise, it
+// returns nil.
+//
+// NOTE: a function called by Go (eg. a qlang closure that is stored in a variable
+// and called through reflect) doesn't know its caller, so it can't recover.
+func (ctx *Context) Recover() interface{} {
+	state := ctx.recoverable
+	if state == nil || state.recovered {
+		return nil
+	}
+	if isLimitErr(state.value) || ctx.limits.stopped() { // the execution budget is exceeded
+		return nil
+	}
+	state.recovered = true
+	if err, ok := state.value.(*RuntimeError); ok {
+		return err.Value
+	}
+	return state.value
+}
+
+// StartDefer instr. It starts a defer statement: the function value and arguments
+// of the deferred call are evaluated after it, and then the Defer instr is called.
+func (p *Builder) StartDefer() *Builder {
+	p.code.data = append(p.code.data, opDefer<<bitsOpShift)
+	return p
+}
+
+// Defer instr. It saves the values evaluated after StartDefer, and defers the call
+// instructions between it and the label end, to execute them when the function
+// returns. The call instructions are skipped now.
+func (p *Builder) Defer(end *Label) *Builder {
+	return p.labelOp(opDefer, end)
+}
+
+// -----------------------------------------------------------------------------
@@ -0,0 +1,52 @@
+package exec
+
+import (
+	"testing"
+)
+
+// -----------------------------------------------------------------------------
+
+func TestDefer(t *testing.T) {
+	strcat, ok := I.FindFunc("strcat")
+	if !ok {
+		t.Fatal("FindFunc failed: strcat")
+	}
+
+	x := NewVar(TyString, "x")
+	appendX := NewFunc("appendX", 1)
+	l1 := NewLabel("")
+	l2 := NewLabel("")
+	code := NewBuilder(nil).
+		DefineVar(x).
+		StartDefer().
+		Push("a").
+		Defer(l1).
+		CallFunc(appendX). // defer appendX("a")
+		Label(l1).
+		StartDefer().
+		Push("b").
+		Defer(l2).
+		CallFunc(appendX). // defer appendX("b")
+		Label(l2).
+		Push("c").
+		CallFunc(appendX). // appendX("c")
+		Return(-1).
+		DefineFunc(
+			appendX.Return().
+				Args(TyString)).
+		LoadVar(x).
+		Load(-1).
+		CallGoFunc(strcat).
+		StoreVar(x). // x = strcat(x, s)
+		Return(-1).
+		EndFunc(appendX).
+		Resolve()
+
+	ctx := NewContext(code)
+	ctx.Exec(0, code.Len())
+	if v := ctx.GetVar(x); v != "cba" {
+		t.Fatal("x != cba, ret =", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
@@ -0,0 +1,359 @@
+package exec
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/qiniu/qlang/token"
+)
+
+// -----------------------------------------------------------------------------
+
+// A DumpConfig represents options of Code.DumpEx.
+type DumpConfig struct {
+	// Registry resolves addresses of Go functions and variables. If it is nil,
+	// DefaultRegistry is used.
+	Registry *Registry
+
+	// ReadFile reads a source file of the code, to interleave source lines with
+	// instructions. If it is nil, source lines aren't dumped.
+	ReadFile func(filename string) ([]byte, error)
+}
+
+// Dump dumps code, with operands resolved by DefaultRegistry.
+func (p *Code) Dump(w io.Writer) {
+	p.DumpEx(w, nil)
+}
+
+// DumpEx dumps code with symbolic operands: Go functions and variables by their
+// names, constants by their values, variables and named arguments (see
+// FuncInfo.ArgNames) by their names, jump targets by labels (L<ip>), and qlang
+// functions by their names. Each qlang function starts with a `func <name>:` line,
+// and the main code with a `main:` line.
+func (p *Code) DumpEx(w io.Writer, conf *DumpConfig) {
+	d := &disasm{code: p, reg: DefaultRegistry}
+	if conf != nil {
+		if conf.Registry != nil {
+			d.reg = conf.Registry
+		}
+		d.readFile = conf.ReadFile
+	}
+	d.init()
+	b := bufio.NewWriter(w)
+	d.dump(b)
+	b.Flush()
+}
+
+// -----------------------------------------------------------------------------
+
+type disasm struct {
+	code     *Code
+	reg      *Registry
+	readFile func(filename string) ([]byte, error)
+	funcAt   []*FuncInfo // the innermost function of each instruction, nil means main
+	parents  map[*FuncInfo]*FuncInfo
+	labels   map[int]bool
+	files    map[string][][]byte // lines of source files
+}
+
+func (p *disasm) init() {
+	code := p.code
+	funs := make([]*FuncInfo, 0, len(code.funs)+len(code.funvs))
+	funs = append(append(funs, code.funs...), code.funvs...)
+	sort.Slice(funs, func(i, j int) bool {
+		return funs[i].FunEntry < funs[j].FunEntry
+	})
+	p.funcAt = make([]*FuncInfo, len(code.data))
+	var stk []*FuncInfo
+	for ip := range code.data {
+		for len(stk) > 0 && stk[len(stk)-1].FunEnd <= ip {
+			stk = stk[:len(stk)-1]
+		}
+		for len(funs) > 0 && funs[0].FunEntry <= ip {
+			stk, funs = append(stk, funs[0]), funs[1:]
+		}
+		if len(stk) > 0 {
+			p.funcAt[ip] = stk[len(stk)-1]
+		}
+	}
+
+	// A closure is defined in the function that creates it.
+	p.parents = make(map[*FuncInfo]*FuncInfo)
+	p.labels = make(map[int]bool)
+	for ip, i := range code.data {
+		switch op := i >> bitsOpShift; op {
+		case opClosure, opGoClosure:
+			if fun := p.closure(i); fun != nil {
+				if _, ok := p.parents[fun]; !ok {
+					p.parents[fun] = p.funcAt[ip]
+				}
+			}
+		case opJmp, opJmpIfFalse, opCaseNE, opRangeNext, opDefer, opGo:
+			if off := int32(i&bitsOperand) << bitsOp >> bitsOp; off != 0 || op != opDefer && op != opGo {
+				p.labels[ip+1+int(off)] = true
+			}
+		}
+	}
+}
+
+func (p *disasm) dump(b *bufio.Writer) {
+	var lastPos token.Position
+	for ip, i := range p.code.data {
+		fun := p.funcAt[ip]
+		if fun != nil && fun.FunEntry == ip {
+			fmt.Fprintf(b, "\nfunc %s:\n", fun.Name)
+		} else if ip == 0 || fun == nil && p.funcAt[ip-1] != nil {
+			b.WriteString("\nmain:\n")
+		}
+		if p.readFile != nil {
+			if pos, ok := p.code.Pos(ip); ok && (pos.Line != lastPos.Line || pos.Filename != lastPos.Filename) {
+				lastPos = pos
+				fmt.Fprintf(b, "\t// %s:%d: %s\n", pos.Filename, pos.Line, p.sourceLine(pos))
+			}
+		}
+		if p.labels[ip] {
+			fmt.Fprintf(b, "L%d:\n", ip)
+		}
+		v := instrInfos[i>>bitsOpShift]
+		fmt.Fprintf(b, "\t%04d  %s", ip, v.Name)
+		if args := p.operands(ip, i); args != "" {
+			b.WriteByte(' ')
+			b.WriteString(args)
+		}
+		b.WriteByte('\n')
+	}
+}
+
+func (p *disasm) sourceLine(pos token.Position) []byte {
+	if p.files == nil {
+		p.files = make(map[string][][]byte)
+	}
+	lines, ok := p.files[pos.Filename]
+	if !ok {
+		if src, err := p.readFile(pos.Filename); err == nil {
+			lines = bytes.Split(src, []byte{'\n'})
+		}
+		p.files[pos.Filename] = lines
+	}
+	if pos.Line < 1 || pos.Line > len(lines) {
+		return nil
+	}
+	return bytes.TrimSpace(lines[pos.Line-1])
+}
+
+func (p *disasm) operands(ip int, i Instr) string {
+	code := p.code
+	switch op := i >> bitsOpShift; op {
+	case opPushInt:
+		kind := reflect.Int + reflect.Kind((i>>bitsOpIntShift)&7)
+		v := int32(i) << bitsOpInt >> bitsOpInt
+		return kind.String() + " " + strconv.Itoa(int(v))
+	case opPushUint:
+		kind := reflect.Uint + reflect.Kind((i>>bitsOpIntShift)&7)
+		return kind.String() + " " + strconv.FormatUint(uint64(i&bitsOpIntOperand), 10)
+	case opPushIntR:
+		kind := reflect.Int + reflect.Kind((i>>bitsOpIntShift)&7)
+		return kind.String() + " " + strconv.FormatInt(code.intConsts[i&bitsOpIntOperand], 10)
+	case opPushUintR:
+		kind := reflect.Uint + reflect.Kind((i>>bitsOpIntShift)&7)
+		return kind.String() + " " + strconv.FormatUint(code.uintConsts[i&bitsOpIntOperand], 10)
+	case opPushFloatR:
+		v := code.valConsts[i&bitsOpFloatOperand]
+		return fmt.Sprintf("%v %v", reflect.TypeOf(v), v)
+	case opPushStringR:
+		return strconv.Quote(code.stringConsts[i&bitsOperand])
+	case opPushValSpec:
+		return fmt.Sprint(valSpecs[i&bitsOperand])
+	case opBuiltinOp:
+		idx := i & bitsOperand
+		return Kind(idx>>bitsOperator).String() + " " + Operator(idx&(1<<bitsOperator-1)).String()
+	case opAddrOp:
+		idx := i & bitsOperand
+		return AddrOperator(idx>>bitsKind).String() + " " + Kind(idx&(1<<bitsKind-1)).String()
+	case opJmp, opJmpIfFalse, opCaseNE, opRangeNext:
+		return p.label(ip, i)
+	case opDefer, opGo:
+		if i&bitsOperand == 0 {
+			return "start"
+		}
+		return p.label(ip, i)
+	case opCallGoFunc:
+		return p.goSymbol(p.reg.GoFuncInfo(GoFuncAddr(i & bitsOperand)))
+	case opCallGoFuncv:
+		fi := p.reg.GoFuncvInfo(GoFuncvAddr(i & bitsOpCallFuncvOperand))
+		return p.goSymbol(fi) + " " + funcvArity(i)
+	case opLoadGoVar, opStoreGoVar, opAddrGoVar:
+		if vi := p.reg.GoVarInfo(GoVarAddr(i & bitsOperand)); vi != nil {
+			return goSymbolName(vi.Pkg, vi.Name)
+		}
+		return "<unknown>"
+	case opLoadVar, opStoreVar, opAddrVar:
+		return p.variable(p.funcAt[ip], i&bitsOperand)
+	case opLoad, opStore:
+		if name := p.argName(p.funcAt[ip], int32(i)<<bitsOp>>bitsOp); name != "" {
+			return name
+		}
+	case opCallFunc:
+		return p.funcName(code.funs, int(i&bitsOperand))
+	case opCallFuncv:
+		return p.funcName(code.funvs, int(i&bitsOpCallFuncvOperand)) + " " + funcvArity(i)
+	case opClosure, opGoClosure:
+		var ret string
+		if fun := p.closure(i); fun != nil {
+			ret = fun.Name
+		} else {
+			ret = "<unknown>"
+		}
+		if i&closureMethodFlag != 0 {
+			ret += " method"
+		}
+		return ret
+	case opTypeCase, opMakePtr, opMakeChan:
+		return fmt.Sprint(code.types[i&bitsOperand])
+	case opMakeArray, opMakeMap, opMakeStruct:
+		return fmt.Sprint(code.types[i&bitsOpCallFuncvOperand]) + " " + funcvArity(i)
+	case opMakeIface:
+		return fmt.Sprint(code.ifaces[i&bitsOperand].typ)
+	case opChanOp:
+		return chanOpNames[ChanOperator(i&bitsOperand)]
+	case opRangeIter:
+		return ""
+	}
+	v, p1, p2 := DecodeInstr(i)
+	var ret string
+	if (v.Params & 0xff00) != 0 {
+		ret = v.Arg1 + "=" + strconv.Itoa(int(p1)) + " "
+	}
+	if (v.Params & 0xff) != 0 {
+		ret += v.Arg2 + "=" + strconv.Itoa(int(p2))
+	}
+	return ret
+}
+
+func (p *disasm) label(ip int, i Instr) string {
+	off := int32(i&bitsOperand) << bitsOp >> bitsOp
+	return "L" + strconv.Itoa(ip+1+int(off))
+}
+
+func (p *disasm) closure(i Instr) *FuncInfo {
+	funs := p.code.funs
+	if i&closureVariadicFlag != 0 {
+		funs = p.code.funvs
+	}
+	if idx := int(i & bitsOpClosureOperand); idx < len(funs) {
+		return funs[idx]
+	}
+	return nil
+}
+
+func (p *disasm) funcName(funs []*FuncInfo, idx int) string {
+	if idx < len(funs) {
+		return funs[idx].Name
+	}
+	return "<unknown>"
+}
+
+// variable returns name of the variable at the address of an instruction of fun.
+// The scope of a variable of an outer function is appended to its name.
+func (p *disasm) variable(fun *FuncInfo, addr uint32) string {
+	scope, idx := addr>>bitsOpVarShift, addr&bitsOpVarOperand
+	env := false // the variable is in the environment of fun (see FuncInfo.Env)
+	for n := scope; n > 0 && fun != nil; n-- {
+		nestDepth := fun.nestDepth
+		if len(fun.env.vlist) > 0 {
+			if !env {
+				env = true
+				continue
+			}
+			nestDepth--
+		}
+		env = false
+		if nestDepth <= 1 {
+			fun = nil
+		} else if parent, ok := p.parents[fun]; ok {
+			fun = parent
+		} else {
+			return fmt.Sprintf("scope=%d addr=%d", scope, idx)
+		}
+	}
+	vlist := p.code.vlist
+	if env {
+		vlist = fun.env.vlist
+	} else if fun != nil {
+		vlist = fun.vlist
+	}
+	name := "<unknown>"
+	if idx < uint32(len(vlist)) {
+		name = vlist[idx].Name()
+	}
+	if scope > 0 {
+		name += " (scope " + strconv.Itoa(int(scope)) + ")"
+	}
+	return name
+}
+
+// argName returns name of an argument of fun, which is loaded or stored by index
+// idx relative to the stack base, or "" if it's unknown.
+func (p *disasm) argName(fun *FuncInfo, idx int32) string {
+	if fun == nil {
+		return ""
+	}
+	i := len(fun.in) + int(idx)
+	if idx >= 0 || i < 0 || i >= len(fun.argNames) {
+		return ""
+	}
+	return fun.argNames[i]
+}
+
+func (p *disasm) goSymbol(fi *GoFuncInfo) string {
+	if fi == nil {
+		return "<unknown>"
+	}
+	return goSymbolName(fi.Pkg, fi.Name)
+}
+
+func goSymbolName(pkg *GoPackage, name string) string {
+	if pkg == nil || pkg.PkgPath == "" {
+		return name
+	}
+	return pkg.PkgPath + "." + name
+}
+
+func funcvArity(i Instr) string {
+	switch arity := (i >> bitsOpCallFuncvShift) & bitsFuncvArityOperand; arity {
+	case bitsFuncvArityVar:
+		return "arity=..."
+	case bitsFuncvArityMax:
+		return "arity=" + strconv.Itoa(bitsFuncvArityMax) + "+"
+	default:
+		return "arity=" + strconv.Itoa(int(arity))
+	}
+}
+
+func (op AddrOperator) String() string {
+	switch op {
+	case OpAddrVal:
+		return "*"
+	case OpAssign:
+		return "="
+	case OpInc:
+		return "++"
+	case OpDec:
+		return "--"
+	}
+	return Operator(op).String() + "="
+}
+
+var chanOpNames = [...]string{
+	OpChanSend:   "send",
+	OpChanRecv:   "recv",
+	OpChanRecvOk: "recvOk",
+	OpChanClose:  "close",
+}
+
+// -----------------------------------------------------------------------------
@@ -0,0 +1,76 @@
+package exec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/qiniu/qlang/token"
+)
+
+// -----------------------------------------------------------------------------
+
+func TestDump(t *testing.T) {
+	strcat, ok := I.FindFunc("strcat")
+	if !ok {
+		t.Fatal("FindFunc failed: strcat")
+	}
+
+	foo := NewFunc("foo", 1)
+	ret := NewVar(TyString, "1")
+	x := NewVar(TyString, "x")
+	done := NewLabel("")
+	code := NewBuilder(nil).
+		DefineVar(x).
+		SetPos(token.Position{Filename: "bar.ql", Line: 1}).
+		Push("hello").
+		StoreVar(x).
+		SetPos(token.Position{Filename: "bar.ql", Line: 2}).
+		Push(true).
+		JmpIfFalse(done).
+		Push(", world").
+		Closure(foo).
+		CallClosure(1).
+		StoreVar(x).
+		Label(done).
+		Return(-1).
+		DefineFunc(
+			foo.Return(ret).
+				Args(TyString).
+				ArgNames("s")).
+		LoadVar(x).
+		Load(-1).
+		CallGoFunc(strcat).
+		StoreVar(ret).
+		EndFunc(foo).
+		Resolve()
+
+	var b bytes.Buffer
+	code.DumpEx(&b, &DumpConfig{
+		ReadFile: func(filename string) ([]byte, error) {
+			if filename != "bar.ql" {
+				return nil, errors.New("file not found")
+			}
+			return []byte("x := \"hello\"\nif true {\n\tx = foo(\", world\")\n}"), nil
+		},
+	})
+	fmt.Print(b.String())
+	for _, line := range []string{
+		"main:\n\t// bar.ql:1: x := \"hello\"\n\t0000  pushStringR \"hello\"\n\t0001  storeVar x\n",
+		"\t// bar.ql:2: if true {\n",
+		"\t0003  jmpIfFalse L8\n",
+		"\t0005  closure foo\n",
+		"L8:\n\t0008  return n=-1\n",
+		"\nfunc foo:\n\t0009  loadVar x (scope 1)\n",
+		"\t0010  load s\n",
+		"\t0011  callGoFunc strcat\n",
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Fatal("DumpEx: not found -", line)
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
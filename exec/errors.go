@@ -0,0 +1,116 @@
+package exec
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/qiniu/qlang/token"
+)
+
+// -----------------------------------------------------------------------------
+
+type posInfo struct {
+	ip  int
+	pos token.Position
+}
+
+// SetPos sets the source position of instructions generated after it, until the
+// next SetPos is called.
+func (p *Builder) SetPos(pos token.Position) *Builder {
+	code := p.code
+	ip := len(code.data)
+	if n := len(code.lines); n > 0 && code.lines[n-1].ip == ip {
+		code.lines[n-1].pos = pos
+	} else {
+		code.lines = append(code.lines, posInfo{ip: ip, pos: pos})
+	}
+	return p
+}
+
+// Pos returns the source position of the instruction at ip. It returns false if
+// the position is unknown.
+func (p *Code) Pos(ip int) (pos token.Position, ok bool) {
+	lines := p.lines
+	i := sort.Search(len(lines), func(i int) bool {
+		return lines[i].ip > ip
+	})
+	if i == 0 {
+		return
+	}
+	return lines[i-1].pos, true
+}
+
+// -----------------------------------------------------------------------------
+
+// A Frame represents a function call in the stack trace of a RuntimeError.
+type Frame struct {
+	Func string         // name of the qlang function
+	IP   int            // the instruction being executed
+	Pos  token.Position // source position of the instruction
+}
+
+// A RuntimeError represents a panic occurred when executing qlang code, eg. a
+// runtime error of Go, or a Go function called by the code panics.
+type RuntimeError struct {
+	Value  interface{} // the value that the code panics with
+	Frames []Frame     // stack trace of the qlang code, the innermost call first
+}
+
+func (p *RuntimeError) Error() string {
+	var b strings.Builder
+	fmt.Fprint(&b, p.Value)
+	b.WriteByte('\n')
+	for _, f := range p.Frames {
+		b.WriteString("\n")
+		b.WriteString(f.Func)
+		b.WriteString("()\n\t")
+		if f.Pos.IsValid() {
+			b.WriteString(f.Pos.String())
+		} else {
+			fmt.Fprintf(&b, "ip=%d", f.IP)
+		}
+	}
+	return b.String()
+}
+
+// Unwrap returns the value that the code panics with, if it is an error.
+func (p *RuntimeError) Unwrap() error {
+	err, _ := p.Value.(error)
+	return err
+}
+
+// Frame returns the function call that the context executes, and the instruction
+// being executed.
+func (ctx *Context) Frame() Frame {
+	name := "main"
+	if ctx.fun != nil {
+		name = ctx.fun.Name
+	}
+	ip := ctx.ip - 1 // ctx.ip points to the next instruction
+	if ctx.ip >= ipReturnVars {
+		ip = -1 // the function is returning
+	}
+	pos, _ := ctx.code.Pos(ip)
+	return Frame{Func: name, IP: ip, Pos: pos}
+}
+
+// Run executes a code block from ip to ipEnd, like Exec. But it returns the
+// *RuntimeError if the code panics, instead of panicking. It also returns the
+// *RuntimeError if a goroutine started by the code panics before Run returns.
+func (ctx *Context) Run(ip, ipEnd int) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			rtErr, ok := e.(*RuntimeError)
+			if !ok {
+				panic(e)
+			}
+			err = rtErr
+		}
+	}()
+	ctx.Exec(ip, ipEnd)
+	ctx.limits.checkFailed()
+	return
+}
+
+// -----------------------------------------------------------------------------
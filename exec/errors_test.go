@@ -0,0 +1,53 @@
+package exec
+
+import (
+	"testing"
+
+	"github.com/qiniu/qlang/token"
+)
+
+// -----------------------------------------------------------------------------
+
+func TestRuntimeError(t *testing.T) {
+	pos1 := token.Position{Filename: "foo.ql", Line: 1, Column: 1}
+	pos2 := token.Position{Filename: "foo.ql", Line: 2, Column: 5}
+	foo := NewFunc("foo", 1)
+	ret := NewVar(TyInt, "1")
+	code := NewBuilder(nil).
+		SetPos(pos1).
+		Push(1).
+		Push(0).
+		CallFunc(foo).
+		Return(-1).
+		DefineFunc(
+			foo.Return(ret).
+				Args(TyInt, TyInt)).
+		Load(-2).
+		SetPos(pos2).
+		Load(-1).
+		BuiltinOp(Int, OpDiv).
+		Return(1).
+		EndFunc(foo).
+		Resolve()
+
+	if pos, ok := code.Pos(5); !ok || pos != pos2 {
+		t.Fatal("Pos failed:", pos, ok)
+	}
+	ctx := NewContext(code)
+	err := ctx.Run(0, code.Len())
+	e, ok := err.(*RuntimeError)
+	if !ok || len(e.Frames) != 2 {
+		t.Fatal("Run failed:", err)
+	}
+	if f := e.Frames[0]; f.Func != "foo" || f.Pos != pos2 {
+		t.Fatal("Run failed: frame 0 -", f)
+	}
+	if f := e.Frames[1]; f.Func != "main" || f.Pos != pos1 {
+		t.Fatal("Run failed: frame 1 -", f)
+	}
+	if e.Unwrap() == nil {
+		t.Fatal("Run failed: not a runtime error -", e.Value)
+	}
+}
+
+// -----------------------------------------------------------------------------
@@ -1,6 +1,8 @@
 package exec
 
 import (
+	"reflect"
+
 	"github.com/qiniu/x/log"
 )
 
@@ -27,6 +29,21 @@ func execCaseNE(i Instr, ctx *Context) {
 	}
 }
 
+func execTypeCase(i Instr, ctx *Context) {
+	t := ctx.code.types[i&bitsOperand]
+	x := ctx.Get(-1)
+	switch {
+	case t == nil: // case nil
+		ctx.Push(x == nil)
+	case x == nil:
+		ctx.Push(false)
+	case t.Kind() == reflect.Interface:
+		ctx.Push(reflect.TypeOf(x).Implements(t))
+	default:
+		ctx.Push(reflect.TypeOf(x) == t)
+	}
+}
+
 // -----------------------------------------------------------------------------
 
 // Label represents a label.
@@ -87,6 +104,15 @@ func (p *Builder) CaseNE(l *Label) *Builder {
 	return p.labelOp(opCaseNE, l)
 }
 
+// TypeCase instr. It checks if dynamic type of the value at the top of the stack
+// is typ (or implements typ if typ is an interface), and pushes the result. The
+// value itself is kept on the stack. TypeCase(nil) checks if the value is nil.
+func (p *Builder) TypeCase(typ reflect.Type) *Builder {
+	i := (opTypeCase << bitsOpShift) | p.newType(typ)
+	p.code.data = append(p.code.data, i)
+	return p
+}
+
 // Default instr
 func (p *Builder) Default() *Builder {
 	return p.Pop(1)
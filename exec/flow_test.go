@@ -1,6 +1,8 @@
 package exec
 
 import (
+	"errors"
+	"reflect"
 	"testing"
 )
 
@@ -153,3 +155,58 @@ func TestDefault(t *testing.T) {
 }
 
 // -----------------------------------------------------------------------------
+
+func TestTypeCase(t *testing.T) {
+	tyError := reflect.TypeOf((*error)(nil)).Elem()
+	cases := []struct {
+		x    interface{}
+		kind int
+	}{
+		{1, 1}, {"hi", 2}, {errors.New("err"), 2}, {nil, 3}, {1.2, 4},
+	}
+	for _, c := range cases {
+		done := NewLabel("done")
+		label1 := NewLabel("a")
+		label2 := NewLabel("b")
+		label3 := NewLabel("c")
+		label4 := NewLabel("d")
+		code := NewBuilder(nil).
+			TypeCase(TyInt).
+			JmpIfFalse(label1).
+			Pop(1).
+			Push(1).
+			Jmp(done).
+			Label(label1).
+			TypeCase(TyString).
+			JmpIfFalse(label2).
+			Jmp(label3).
+			Label(label2).
+			TypeCase(tyError).
+			JmpIfFalse(label4).
+			Label(label3).
+			Pop(1).
+			Push(2).
+			Jmp(done).
+			Label(label4).
+			TypeCase(nil).
+			JmpIfFalse(done).
+			Pop(1).
+			Push(3).
+			Label(done).
+			Resolve()
+
+		ctx := NewContext(code)
+		ctx.Push(c.x)
+		ctx.Exec(0, code.Len())
+		v := checkPop(ctx)
+		if c.kind == 4 {
+			if v != c.x {
+				t.Fatal("TypeCase default:", c.x, v)
+			}
+		} else if v != c.kind {
+			t.Fatal("TypeCase:", c.x, c.kind, v)
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
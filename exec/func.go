@@ -18,6 +18,7 @@ func execStore(i Instr, p *Context) {
 
 const (
 	closureVariadicFlag = (1 << bitsOpClosureShift)
+	closureMethodFlag   = (2 << bitsOpClosureShift)
 )
 
 func makeClosure(i Instr, p *Context) Closure {
@@ -31,16 +32,36 @@ func makeClosure(i Instr, p *Context) Closure {
 	if fun.nestDepth == 1 {
 		p = p.globalCtx()
 	}
+	if n := len(fun.env.vlist); n > 0 { // see FuncInfo.Env
+		env := NewContextEx(p, p.Stack, p.code, fun.env.vlist...)
+		for i, v := range p.GetArgs(uint32(n)) {
+			env.setVar(uint32(i), v)
+		}
+		p.PopN(n)
+		p = env
+	}
 	return Closure{fun: fun, parent: p}
 }
 
 func execGoClosure(i Instr, p *Context) {
 	closure := makeClosure(i, p)
-	v := reflect.MakeFunc(closure.fun.Type(), closure.Call)
+	if p.debugger != nil {
+		closure.maker = p
+	}
+	closure.depth = p.depth
+	t := closure.fun.Type()
+	if (i & closureMethodFlag) != 0 { // a method value: bind the receiver
+		closure.recv, closure.hasRecv = p.Pop(), true
+		t = closure.fun.methodType()
+	}
+	v := reflect.MakeFunc(t, closure.Call)
 	p.Push(v.Interface())
 }
 
 func execCallGoClosure(i Instr, p *Context) {
+	if p.debugger != nil {
+		defer leaveGoCall(enterGoCall(p))
+	}
 	arity := i & bitsOperand
 	fn := reflect.ValueOf(p.Pop())
 	t := fn.Type()
@@ -77,9 +98,9 @@ func execCallClosure(i Instr, p *Context) {
 	c := p.Pop().(*Closure)
 	fun, stk, parent := c.fun, p.Stack, c.parent
 	if fun.IsVariadic() && arity != bitsOperand { // not is: args...
-		fun.execVariadic(arity, stk, parent)
+		fun.execVariadic(arity, stk, parent, p)
 	} else {
-		fun.exec(stk, parent)
+		fun.exec(stk, parent, p)
 	}
 }
 
@@ -87,27 +108,28 @@ func execFunc(i Instr, p *Context) {
 	idx := i & bitsOperand
 	fun := p.code.funs[idx]
 	stk := p.Stack
+	parent := p
 	if fun.nestDepth == 1 {
-		p = p.globalCtx()
+		parent = p.globalCtx()
 	}
-	fun.exec(stk, p)
+	fun.exec(stk, parent, p)
 }
 
 func execFuncv(i Instr, p *Context) {
 	idx := i & bitsOpCallFuncvOperand
 	arity := (i >> bitsOpCallFuncvShift) & bitsFuncvArityOperand
 	fun := p.code.funvs[idx]
-	stk := p.Stack
+	stk, parent := p.Stack, p
 	if fun.nestDepth == 1 {
-		p = p.globalCtx()
+		parent = p.globalCtx()
 	}
 	if arity == bitsFuncvArityVar { // args...
-		fun.exec(stk, p)
+		fun.exec(stk, parent, p)
 	} else {
 		if arity == bitsFuncvArityMax {
 			arity = uint32(p.Pop().(int) + bitsFuncvArityMax)
 		}
-		fun.execVariadic(arity, stk, p)
+		fun.execVariadic(arity, stk, parent, p)
 	}
 }
 
@@ -119,19 +141,25 @@ type Package struct {
 
 // Closure represents a qlang closure.
 type Closure struct {
-	fun    *FuncInfo
-	recv   interface{}
-	parent *Context
+	fun     *FuncInfo
+	recv    interface{}
+	parent  *Context
+	maker   *Context // the context that makes the closure when debugging, see goCaller
+	depth   int      // call depth of the context that makes the closure, if it's called by Go
+	hasRecv bool     // recv is passed to fun as the first argument
 }
 
 // Call calls a closure.
 func (p *Closure) Call(in []reflect.Value) (out []reflect.Value) {
 	stk := NewStack()
+	if p.hasRecv {
+		stk.Push(p.recv)
+	}
 	for _, v := range in {
 		stk.Push(v.Interface())
 	}
 	fun := p.fun
-	fun.exec(stk, p.parent)
+	fun.execEx(stk, p.parent, p.goCaller(), p.depth+1, false)
 	n := len(stk.data)
 	if n > 0 {
 		out = make([]reflect.Value, n)
@@ -156,6 +184,8 @@ type FuncInfo struct {
 	FunEnd   int
 	t        reflect.Type
 	in       []reflect.Type
+	argNames []string   // names of the arguments, for debuggers and Code.Dump
+	env      varManager // variables of the environments of its closures, see Env
 	anyUnresolved
 	numOut int
 	varManager
@@ -171,6 +201,18 @@ func NewFunc(name string, nestDepth uint32) *FuncInfo {
 	return f
 }
 
+// Env sets variables of the environments of closures of the function. When a
+// closure is created, a new context that has these variables is created as parent
+// of the closure, and they are initialized by the values on the top of the stack.
+// So a closure can capture values when it is created, eg. pointers to variables of
+// a loop iteration. The function must be nested in a scope for the variables, that
+// is, its nestDepth is one more than the one that it's created in.
+func (p *FuncInfo) Env(vars ...*Var) *FuncInfo {
+	p.env.nestDepth = p.nestDepth - 1
+	p.env.addVars(vars...)
+	return p
+}
+
 // NumOut returns a function type's output parameter count.
 // It panics if the type's Kind is not Func.
 func (p *FuncInfo) NumOut() int {
@@ -216,6 +258,13 @@ func (p *FuncInfo) Vargs(in ...reflect.Type) *FuncInfo {
 	return p
 }
 
+// ArgNames sets names of the arguments of a qlang function, which are shown by
+// debuggers and Code.Dump. The name of an unnamed argument is "".
+func (p *FuncInfo) ArgNames(names ...string) *FuncInfo {
+	p.argNames = names
+	return p
+}
+
 // Return sets return types of a qlang function.
 func (p *FuncInfo) Return(out ...*Var) *FuncInfo {
 	if p.vlist != nil {
@@ -254,8 +303,37 @@ func (p *FuncInfo) Type() reflect.Type {
 	return p.t
 }
 
-func (p *FuncInfo) exec(stk *Stack, parent *Context) {
+// methodType returns type of a method value, that is, type of the function without
+// its first argument (the receiver).
+func (p *FuncInfo) methodType() reflect.Type {
+	out := make([]reflect.Type, p.numOut)
+	for i := range out {
+		out[i] = p.vlist[i].Type
+	}
+	return reflect.FuncOf(p.in[1:], out, p.IsVariadic())
+}
+
+// exec executes the function. parent is the context that the function is defined
+// in, and caller is the context that calls the function (nil if it is called by Go).
+func (p *FuncInfo) exec(stk *Stack, parent, caller *Context) {
+	p.execEx(stk, parent, caller, caller.depth+1, true)
+}
+
+// execEx executes the function at call depth depth. direct reports whether it's
+// called by caller, the qlang code; otherwise it's called by a Go function that
+// caller calls, or by Go if caller is nil.
+func (p *FuncInfo) execEx(stk *Stack, parent, caller *Context, depth int, direct bool) {
+	if lim := parent.limits; lim != nil {
+		lim.check(1, stk) // the function may execute less than stepsPerCheck instructions
+		if lim.maxDepth > 0 && depth > lim.maxDepth {
+			panic(ErrDepthLimit)
+		}
+	}
 	ctx := NewContextEx(parent, stk, parent.code, p.vlist...)
+	ctx.fun, ctx.caller, ctx.depth = p, caller, depth
+	if direct && caller != nil {
+		ctx.recoverable = caller.deferring
+	}
 	ctx.Exec(p.FunEntry, p.FunEnd)
 	if ctx.ip == ipReturnN {
 		n := len(stk.data)
@@ -269,24 +347,24 @@ func (p *FuncInfo) exec(stk *Stack, parent *Context) {
 	}
 }
 
-func (p *FuncInfo) execVariadic(arity uint32, stk *Stack, parent *Context) {
+func (p *FuncInfo) execVariadic(arity uint32, stk *Stack, parent, caller *Context) {
 	var n = uint32(len(p.in) - 1)
-	if arity > n {
+	if arity >= n { // arity == n if there are no variadic args
 		tVariadic := p.in[n]
 		nVariadic := arity - n
 		if tVariadic == tyEmptyInterfaceSlice {
 			var empty []interface{}
-			parent.Ret(nVariadic, append(empty, parent.GetArgs(nVariadic)...))
+			stk.Ret(nVariadic, append(empty, stk.GetArgs(nVariadic)...))
 		} else {
 			variadic := reflect.MakeSlice(tVariadic, int(nVariadic), int(nVariadic))
-			items := parent.GetArgs(nVariadic)
+			items := stk.GetArgs(nVariadic)
 			for i, item := range items {
 				setValue(variadic.Index(i), item)
 			}
-			parent.Ret(nVariadic, variadic.Interface())
+			stk.Ret(nVariadic, variadic.Interface())
 		}
 	}
-	p.exec(stk, parent)
+	p.exec(stk, parent, caller)
 }
 
 var tyEmptyInterfaceSlice = reflect.SliceOf(TyEmptyInterface)
@@ -307,6 +385,9 @@ func (p *Builder) resolveFuncs() {
 			}
 		}
 		fun.offs = nil
+		if fun.IsTypeValid() {
+			fun.Type() // the type is cached now, so goroutines can read it concurrently
+		}
 	}
 }
 
@@ -357,6 +438,15 @@ func (p *Builder) GoClosure(fun *FuncInfo) *Builder {
 	return p
 }
 
+// GoMethodClosure instr. It binds the value on the top of the stack to fun as the
+// receiver, and creates a Go function value (a method value).
+func (p *Builder) GoMethodClosure(fun *FuncInfo) *Builder {
+	code := p.code
+	fun.offs = append(fun.offs, len(code.data))
+	code.data = append(code.data, (opGoClosure<<bitsOpShift)|closureMethodFlag)
+	return p
+}
+
 // CallClosure instr
 func (p *Builder) CallClosure(arity int) *Builder {
 	p.code.data = append(p.code.data, (opCallClosure<<bitsOpShift)|(uint32(arity)&bitsOperand))
@@ -273,4 +273,35 @@ func TestGoClosure(t *testing.T) {
 	}
 }
 
+func TestGoMethodClosure(t *testing.T) {
+	strcat, ok := I.FindFunc("strcat")
+	if !ok {
+		t.Fatal("FindFunc failed: strcat")
+	}
+
+	foo := NewFunc("foo", 1)
+	ret := NewVar(TyString, "1")
+	code := NewBuilder(nil).
+		Push("world").
+		Push("Hello, ").
+		GoMethodClosure(foo). // f := recv.foo
+		CallGoClosure(1).     // f("world")
+		Return(-1).
+		DefineFunc(
+			foo.Return(ret).
+				Args(TyString, TyString)).
+		Load(-2).
+		Load(-1).
+		CallGoFunc(strcat).
+		StoreVar(ret).
+		EndFunc(foo).
+		Resolve()
+
+	ctx := NewContext(code)
+	ctx.Exec(0, code.Len())
+	if v := checkPop(ctx); v != "Hello, world" {
+		t.Fatal(`"Hello, ".foo("world") != "Hello, world", ret =`, v)
+	}
+}
+
 // -----------------------------------------------------------------------------
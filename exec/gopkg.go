@@ -2,19 +2,26 @@ package exec
 
 import (
 	"reflect"
+	"unicode"
 
 	"github.com/qiniu/x/log"
 )
 
 func execGoFunc(i Instr, p *Context) {
+	if p.debugger != nil {
+		defer leaveGoCall(enterGoCall(p))
+	}
 	idx := i & bitsOperand
-	gofuns[idx].exec(0, p)
+	p.reg.funs[idx].exec(0, p)
 }
 
 func execGoFuncv(i Instr, p *Context) {
+	if p.debugger != nil {
+		defer leaveGoCall(enterGoCall(p))
+	}
 	idx := i & bitsOpCallFuncvOperand
 	arity := (i >> bitsOpCallFuncvShift) & bitsFuncvArityOperand
-	fun := gofunvs[idx]
+	fun := &p.reg.funvs[idx]
 	if arity == bitsFuncvArityVar {
 		v := p.Pop()
 		args := reflect.ValueOf(v)
@@ -29,6 +36,20 @@ func execGoFuncv(i Instr, p *Context) {
 	fun.exec(arity, p)
 }
 
+func execLoadGoVar(i Instr, p *Context) {
+	addr := p.reg.vars[i&bitsOperand].Addr
+	p.Push(reflect.ValueOf(addr).Elem().Interface())
+}
+
+func execStoreGoVar(i Instr, p *Context) {
+	addr := p.reg.vars[i&bitsOperand].Addr
+	setValue(reflect.ValueOf(addr).Elem(), p.Pop())
+}
+
+func execAddrGoVar(i Instr, p *Context) {
+	p.Push(p.reg.vars[i&bitsOperand].Addr)
+}
+
 // -----------------------------------------------------------------------------
 
 // SymbolKind represents symbol kind.
@@ -48,25 +69,23 @@ type GoPackage struct {
 	PkgPath string
 	syms    map[string]uint32
 	types   map[string]reflect.Type
+	reg     *Registry
 }
 
-// NewGoPackage creates a new builtin Go Package.
+// NewGoPackage creates a new builtin Go Package in DefaultRegistry.
 func NewGoPackage(pkgPath string) *GoPackage {
-	if _, ok := gopkgs[pkgPath]; ok {
-		log.Panicln("NewPackage failed: package exists -", pkgPath)
-	}
-	pkg := &GoPackage{
-		PkgPath: pkgPath,
-		syms:    make(map[string]uint32),
-		types:   make(map[string]reflect.Type),
-	}
-	gopkgs[pkgPath] = pkg
-	return pkg
+	return DefaultRegistry.NewGoPackage(pkgPath)
 }
 
-// FindGoPackage lookups a Go package by pkgPath. It returns nil if not found.
+// FindGoPackage lookups a Go package in DefaultRegistry by pkgPath. It returns nil
+// if not found.
 func FindGoPackage(pkgPath string) *GoPackage {
-	return gopkgs[pkgPath]
+	return DefaultRegistry.FindGoPackage(pkgPath)
+}
+
+// Registry returns the registry that this package is registered in.
+func (p *GoPackage) Registry() *Registry {
+	return p.reg
 }
 
 // Find lookups a symbol by specified its name.
@@ -151,8 +170,9 @@ func (p *GoPackage) Rtype(typ reflect.Type) GoTypeInfo {
 
 // RegisterVars registers all exported Go variables of this package.
 func (p *GoPackage) RegisterVars(vars ...GoVarInfo) (base GoVarAddr) {
-	base = GoVarAddr(len(govars))
-	govars = append(govars, vars...)
+	reg := p.reg
+	base = GoVarAddr(len(reg.vars))
+	reg.vars = append(reg.vars, vars...)
 	for i, v := range vars {
 		p.syms[v.Name] = uint32(base) + uint32(i)
 	}
@@ -171,10 +191,12 @@ func (p *GoPackage) RegisterFuncs(funs ...GoFuncInfo) (base GoFuncAddr) {
 			}
 		}
 	}
-	base = GoFuncAddr(len(gofuns))
-	gofuns = append(gofuns, funs...)
+	reg := p.reg
+	base = GoFuncAddr(len(reg.funs))
+	reg.funs = append(reg.funs, funs...)
 	for i, v := range funs {
 		p.syms[v.Name] = (uint32(base) + uint32(i)) | (opCallGoFunc << bitsOpShift)
+		p.registerResultTypes(v.This)
 	}
 	return
 }
@@ -191,14 +213,40 @@ func (p *GoPackage) RegisterFuncvs(funs ...GoFuncvInfo) (base GoFuncvAddr) {
 			}
 		}
 	}
-	base = GoFuncvAddr(len(gofunvs))
-	gofunvs = append(gofunvs, funs...)
+	reg := p.reg
+	base = GoFuncvAddr(len(reg.funvs))
+	reg.funvs = append(reg.funvs, funs...)
 	for i, v := range funs {
 		p.syms[v.Name] = (uint32(base) + uint32(i)) | (opCallGoFuncv << bitsOpShift)
+		p.registerResultTypes(v.This)
 	}
 	return
 }
 
+// registerResultTypes registers exported types defined by this package that are
+// results of the Go function fn (or pointers to them), eg. *strings.Replacer of
+// strings.NewReplacer. So the code that holds the results can be encoded by
+// MarshalCode.
+func (p *GoPackage) registerResultTypes(fn interface{}) {
+	if fn == nil {
+		return
+	}
+	t := reflect.TypeOf(fn)
+	for i, n := 0, t.NumOut(); i < n; i++ {
+		out := t.Out(i)
+		for out.Kind() == reflect.Ptr {
+			out = out.Elem()
+		}
+		name := out.Name()
+		if out.PkgPath() != p.PkgPath || name == "" || !unicode.IsUpper([]rune(name)[0]) {
+			continue
+		}
+		if _, ok := p.types[name]; !ok {
+			p.types[name] = out
+		}
+	}
+}
+
 // RegisterTypes registers all exported Go types defined by this package.
 func (p *GoPackage) RegisterTypes(typinfos ...GoTypeInfo) {
 	for _, ti := range typinfos {
@@ -208,7 +256,7 @@ func (p *GoPackage) RegisterTypes(typinfos ...GoTypeInfo) {
 		if ti.Name == "" {
 			log.Panicln("RegisterTypes failed: unnamed type? -", ti.Type)
 		}
-		if _, ok := p.types[ti.Name]; ok {
+		if t, ok := p.types[ti.Name]; ok && t != ti.Type { // it may be registered as a result type
 			log.Panicln("RegisterTypes failed: register an existed type -", p.PkgPath, ti.Name)
 		}
 		p.types[ti.Name] = ti.Type
@@ -217,13 +265,6 @@ func (p *GoPackage) RegisterTypes(typinfos ...GoTypeInfo) {
 
 // -----------------------------------------------------------------------------
 
-var (
-	gopkgs  = make(map[string]*GoPackage)
-	gofuns  []GoFuncInfo
-	gofunvs []GoFuncvInfo
-	govars  []GoVarInfo
-)
-
 // GoFuncAddr represents a Go function address.
 type GoFuncAddr uint32
 
@@ -268,28 +309,19 @@ type GoVarInfo struct {
 	Addr interface{}
 }
 
-// GetInfo retuns a Go function info.
+// GetInfo retuns a Go function info in DefaultRegistry.
 func (i GoFuncAddr) GetInfo() *GoFuncInfo {
-	if i < GoFuncAddr(len(gofuns)) {
-		return &gofuns[i]
-	}
-	return nil
+	return DefaultRegistry.GoFuncInfo(i)
 }
 
-// GetInfo retuns a Go function info.
+// GetInfo retuns a Go function info in DefaultRegistry.
 func (i GoFuncvAddr) GetInfo() *GoFuncInfo {
-	if i < GoFuncvAddr(len(gofunvs)) {
-		return &gofunvs[i].GoFuncInfo
-	}
-	return nil
+	return DefaultRegistry.GoFuncvInfo(i)
 }
 
-// GetInfo retuns a Go variable info.
+// GetInfo retuns a Go variable info in DefaultRegistry.
 func (i GoVarAddr) GetInfo() *GoVarInfo {
-	if i < GoVarAddr(len(govars)) {
-		return &govars[i]
-	}
-	return nil
+	return DefaultRegistry.GoVarInfo(i)
 }
 
 // CallGoFunc instr
@@ -312,4 +344,22 @@ func (p *Builder) CallGoFuncv(fun GoFuncvAddr, arity int) *Builder {
 	return p
 }
 
+// LoadGoVar instr
+func (p *Builder) LoadGoVar(addr GoVarAddr) *Builder {
+	p.code.data = append(p.code.data, (opLoadGoVar<<bitsOpShift)|uint32(addr))
+	return p
+}
+
+// StoreGoVar instr
+func (p *Builder) StoreGoVar(addr GoVarAddr) *Builder {
+	p.code.data = append(p.code.data, (opStoreGoVar<<bitsOpShift)|uint32(addr))
+	return p
+}
+
+// AddrGoVar instr
+func (p *Builder) AddrGoVar(addr GoVarAddr) *Builder {
+	p.code.data = append(p.code.data, (opAddrGoVar<<bitsOpShift)|uint32(addr))
+	return p
+}
+
 // -----------------------------------------------------------------------------
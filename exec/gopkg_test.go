@@ -8,6 +8,30 @@ import (
 	"github.com/qiniu/x/log"
 )
 
+func TestGoVar(t *testing.T) {
+	x, ok := I.FindVar("x")
+	if !ok {
+		t.Fatal("FindVar failed: x")
+	}
+
+	code := NewBuilder(nil).
+		Push(3).
+		StoreGoVar(x). // x = 3
+		LoadGoVar(x).
+		Push(4).
+		AddrGoVar(x).
+		AddrOp(Int, OpAssign). // *&x = 4
+		LoadGoVar(x).
+		BuiltinOp(Int, OpAdd). // 3 + x
+		Resolve()
+
+	ctx := NewContext(code)
+	ctx.Exec(0, code.Len())
+	if v := checkPop(ctx); v != 7 {
+		t.Fatal("x != 7, ret =", v)
+	}
+}
+
 // -----------------------------------------------------------------------------
 
 func init() {
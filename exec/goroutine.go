@@ -0,0 +1,69 @@
+package exec
+
+// -----------------------------------------------------------------------------
+
+// A goroutine executes the call of a go statement in its own Context and Stack.
+// It shares variables with the Context that executes the go statement.
+//
+// Variables of a Context are fields of a struct created by makeVarsContext, and
+// the Context holds them by a reflect.Value that is never reassigned. So copying
+// the reflect.Value to a new Context shares the variables without data races on
+// the Context itself. Like Go, different variables can be accessed concurrently,
+// and concurrent accesses to the same variable need synchronization (eg. by
+// channels).
+//
+// Other states shared by goroutines (Code, FuncInfo, GoPackage, etc.) are read
+// only after Builder.Resolve is called.
+
+func execGo(i Instr, p *Context) {
+	off := int(i & bitsOperand)
+	if off == 0 { // start a go statement
+		p.callBase = len(p.data)
+		return
+	}
+	stk := NewStack()
+	stk.data = append(stk.data, p.data[p.callBase:]...)
+	p.data = p.data[:p.callBase]
+	ctx := &Context{
+		Stack:    stk,
+		code:     p.code,
+		parent:   p.parent,
+		fun:      p.fun,
+		vars:     p.vars,
+		limits:   p.limits,
+		reg:      p.reg,
+		debugger: p.debugger,
+	}
+	ip := p.ip
+	go ctx.goExec(ip, ip+off)
+	p.ip += off
+}
+
+func (ctx *Context) goExec(ip, ipEnd int) {
+	defer func() {
+		if e := recover(); e != nil { // e is a *RuntimeError
+			ctx.limits.fail(e)
+		}
+	}()
+	ctx.Exec(ip, ipEnd)
+}
+
+// StartGo instr. It starts a go statement: the function value and arguments of the
+// call are evaluated after it, and then the Go instr is called.
+func (p *Builder) StartGo() *Builder {
+	p.code.data = append(p.code.data, opGo<<bitsOpShift)
+	return p
+}
+
+// Go instr. It moves the values evaluated after StartGo to a new goroutine, which
+// executes the call instructions between it and the label end. The call
+// instructions are skipped by the current goroutine.
+//
+// If the new goroutine panics, the code that starts it stops with the *RuntimeError,
+// instead of crashing the program: other goroutines panic with it when they check
+// the execution budget (see SetLimits) or block on channels, and Run returns it.
+func (p *Builder) Go(end *Label) *Builder {
+	return p.labelOp(opGo, end)
+}
+
+// -----------------------------------------------------------------------------
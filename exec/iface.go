@@ -0,0 +1,163 @@
+package exec
+
+import (
+	"reflect"
+
+	"github.com/qiniu/x/log"
+)
+
+// -----------------------------------------------------------------------------
+
+// An InterfaceProxy creates a value that implements an interface by calling the
+// given methods. methods are function values (without receivers), in the order of
+// methods of the interface (that is, sorted by name).
+//
+// reflect can't create types with methods, so a qlang object can't be used as a
+// value of a Go interface directly. Instead, a Go package registers a proxy type
+// for each interface that qlang objects may implement, eg.
+//
+//	type writerProxy struct {
+//		write func(b []byte) (n int, err error)
+//	}
+//
+//	func (p *writerProxy) Write(b []byte) (n int, err error) {
+//		return p.write(b)
+//	}
+//
+//	func newWriterProxy(methods []interface{}) interface{} {
+//		return &writerProxy{write: methods[0].(func(b []byte) (n int, err error))}
+//	}
+//
+// Such proxies can be generated by cmd/qproxy, see lib/net/http.
+type InterfaceProxy = func(methods []interface{}) interface{}
+
+// GoInterfaceInfo represents a Go interface information.
+type GoInterfaceInfo struct {
+	Pkg   *GoPackage
+	Name  string
+	Type  reflect.Type
+	Proxy InterfaceProxy
+}
+
+// Interface creates a GoInterfaceInfo instance. typ must be an interface type.
+func (p *GoPackage) Interface(name string, typ reflect.Type, proxy InterfaceProxy) GoInterfaceInfo {
+	return GoInterfaceInfo{Pkg: p, Name: name, Type: typ, Proxy: proxy}
+}
+
+// RegisterInterfaces registers Go interfaces defined by this package, and proxies
+// that make qlang objects implement them. The interfaces are registered as types
+// of the package too.
+func (p *GoPackage) RegisterInterfaces(ifaces ...GoInterfaceInfo) {
+	for _, v := range ifaces {
+		if v.Pkg != p {
+			log.Panicln("RegisterInterfaces failed: unmatched package instance.")
+		}
+		if v.Type.Kind() != reflect.Interface {
+			log.Panicln("RegisterInterfaces failed: not an interface -", v.Type)
+		}
+		if _, ok := goifaces[v.Type]; ok {
+			log.Panicln("RegisterInterfaces failed: register an existed interface -", v.Type)
+		}
+		goifaces[v.Type] = v.Proxy
+		p.RegisterTypes(p.Type(v.Name, v.Type))
+	}
+}
+
+// FindInterfaceProxy lookups the proxy of a Go interface.
+func FindInterfaceProxy(typ reflect.Type) (proxy InterfaceProxy, ok bool) {
+	proxy, ok = goifaces[typ]
+	return
+}
+
+var goifaces = make(map[reflect.Type]InterfaceProxy)
+
+// -----------------------------------------------------------------------------
+
+type ifaceInfo struct {
+	typ     reflect.Type
+	proxy   InterfaceProxy
+	methods []*FuncInfo
+	index   [][]int // index sequences of embedded fields that methods are promoted from
+}
+
+func execMakeIface(i Instr, p *Context) {
+	info := &p.code.ifaces[i&bitsOperand]
+	n := len(p.data)
+	recv := p.data[n-1]
+	parent := p.globalCtx()
+	var maker *Context
+	if p.debugger != nil {
+		maker = p
+	}
+	depth := p.depth
+	methods := make([]interface{}, len(info.methods))
+	for i, fun := range info.methods {
+		var index []int
+		if info.index != nil {
+			index = info.index[i]
+		}
+		fun, deref := fun, reflect.TypeOf(recv) != fun.in[0] // recv is *T, but the method has a receiver of T
+		fn := func(in []reflect.Value) []reflect.Value {
+			// The proxy may be called by many goroutines at the same time, so nothing
+			// but the global context is shared: each call has its own closure, stack
+			// and context (see FuncInfo.exec).
+			c := Closure{fun: fun, recv: recv, parent: parent, maker: maker, depth: depth, hasRecv: true}
+			if index != nil {
+				c.recv = promotedRecv(recv, index, fun.in[0])
+			} else if deref {
+				c.recv = reflect.ValueOf(recv).Elem().Interface()
+			}
+			return c.Call(in)
+		}
+		methods[i] = reflect.MakeFunc(fun.methodType(), fn).Interface()
+	}
+	p.data[n-1] = info.proxy(methods)
+}
+
+// promotedRecv returns the receiver of a method promoted from an embedded field of
+// recv, by the index sequence of the field. t is the receiver type of the method.
+// Like Go, embedded pointers are dereferenced when the method is called.
+func promotedRecv(recv interface{}, index []int, t reflect.Type) interface{} {
+	v := reflect.ValueOf(recv)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	} else { // an addressable copy, so unexported fields can be read
+		x := reflect.New(v.Type()).Elem()
+		x.Set(v)
+		v = x
+	}
+	for i, idx := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			v = v.Elem()
+		}
+		v = fieldOf(v, idx)
+	}
+	if t.Kind() == reflect.Ptr && v.Kind() != reflect.Ptr {
+		v = v.Addr()
+	} else if t.Kind() != reflect.Ptr && v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	return v.Interface()
+}
+
+// MakeIface instr. It converts the value on the top of the stack into a value of
+// the Go interface typ, by the proxy registered by RegisterInterfaces. methods are
+// methods of the value that implement methods of typ, in the same order. If some
+// methods are promoted from embedded fields, index are the index sequences of the
+// fields (nil for methods of the value itself).
+func (p *Builder) MakeIface(typ reflect.Type, methods []*FuncInfo, index ...[]int) *Builder {
+	proxy, ok := FindInterfaceProxy(typ)
+	if !ok {
+		log.Panicln("MakeIface failed: proxy of interface not found -", typ)
+	}
+	if len(methods) != typ.NumMethod() || (index != nil && len(index) != len(methods)) {
+		log.Panicln("MakeIface failed: unmatched methods count -", typ)
+	}
+	code := p.code
+	idx := uint32(len(code.ifaces))
+	code.ifaces = append(code.ifaces, ifaceInfo{typ: typ, proxy: proxy, methods: methods, index: index})
+	code.data = append(code.data, (opMakeIface<<bitsOpShift)|idx)
+	return p
+}
+
+// -----------------------------------------------------------------------------
@@ -0,0 +1,111 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// -----------------------------------------------------------------------------
+
+type stringerProxy struct {
+	str func() string
+}
+
+func (p *stringerProxy) String() string {
+	return p.str()
+}
+
+func newStringerProxy(methods []interface{}) interface{} {
+	return &stringerProxy{str: methods[0].(func() string)}
+}
+
+var tyStringer = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+
+func init() {
+	pkg := NewGoPackage("iface")
+	pkg.RegisterInterfaces(
+		pkg.Interface("Stringer", tyStringer, newStringerProxy),
+	)
+}
+
+func TestMakeIface(t *testing.T) {
+	strcat, ok := I.FindFunc("strcat")
+	if !ok {
+		t.Fatal("FindFunc failed: strcat")
+	}
+
+	foo := NewFunc("String", 1)
+	ret := NewVar(TyString, "1")
+	code := NewBuilder(nil).
+		Push("world").
+		MakeIface(tyStringer, []*FuncInfo{foo}).
+		Return(-1).
+		DefineFunc(
+			foo.Return(ret).
+				Args(TyString)).
+		Push("Hello, ").
+		Load(-1).
+		CallGoFunc(strcat).
+		StoreVar(ret).
+		EndFunc(foo).
+		Resolve()
+
+	ctx := NewContext(code)
+	ctx.Exec(0, code.Len())
+	v, ok := checkPop(ctx).(fmt.Stringer)
+	if !ok {
+		t.Fatal("MakeIface failed: not a fmt.Stringer")
+	}
+	if s := v.String(); s != "Hello, world" {
+		t.Fatal(`String() != "Hello, world", ret =`, s)
+	}
+}
+
+func TestMakeIfaceConcurrent(t *testing.T) {
+	strcat, _ := I.FindFunc("strcat")
+	foo := NewFunc("String", 1)
+	ret := NewVar(TyString, "1")
+	code := NewBuilder(nil).
+		Push("world").
+		MakeIface(tyStringer, []*FuncInfo{foo}).
+		Return(-1).
+		DefineFunc(
+			foo.Return(ret).
+				Args(TyString)).
+		Push("Hello, ").
+		Load(-1).
+		CallGoFunc(strcat).
+		StoreVar(ret).
+		EndFunc(foo).
+		Resolve()
+
+	ctx := NewContext(code)
+	ctx.SetLimits(context.Background(), Limits{MaxDepth: 100})
+	ctx.Exec(0, code.Len())
+	v := checkPop(ctx).(fmt.Stringer)
+
+	var wg sync.WaitGroup
+	errs := make(chan string, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if s := v.String(); s != "Hello, world" {
+					errs <- s
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for s := range errs {
+		t.Fatal(`String() != "Hello, world", ret =`, s)
+	}
+}
+
+// -----------------------------------------------------------------------------
@@ -0,0 +1,57 @@
+package exec
+
+import (
+	"errors"
+)
+
+// -----------------------------------------------------------------------------
+
+var (
+	// ErrPackageNotFound is returned by Importer.Import if the package isn't
+	// registered.
+	ErrPackageNotFound = errors.New("package not found")
+
+	// ErrPackageDenied is returned by Importer.Import if the package is registered,
+	// but qlang code isn't allowed to import it.
+	ErrPackageDenied = errors.New("package is not allowed")
+)
+
+// An Importer finds Go packages that qlang code imports.
+type Importer interface {
+	// Import returns the Go package of pkgPath. The builtin package is "".
+	Import(pkgPath string) (*GoPackage, error)
+}
+
+// GoPackages is the Importer of all Go packages registered by NewGoPackage, that
+// is, DefaultRegistry.
+var GoPackages Importer = DefaultRegistry
+
+type allowedPackages struct {
+	imp     Importer
+	allowed map[string]bool
+}
+
+func (p *allowedPackages) Import(pkgPath string) (*GoPackage, error) {
+	pkg, err := p.imp.Import(pkgPath)
+	if err == nil && !p.allowed[pkgPath] {
+		return nil, ErrPackageDenied
+	}
+	return pkg, err
+}
+
+// AllowPackages returns an Importer that only allows qlang code to import Go
+// packages in pkgPaths (eg. "strings" and "fmt" but not "os"), which are found by
+// imp. The builtin package is always allowed.
+//
+// Methods of a Go type can be called only if the package that defines the type is
+// allowed too.
+func AllowPackages(imp Importer, pkgPaths ...string) Importer {
+	allowed := make(map[string]bool, len(pkgPaths)+1)
+	allowed[""] = true
+	for _, pkgPath := range pkgPaths {
+		allowed[pkgPath] = true
+	}
+	return &allowedPackages{imp: imp, allowed: allowed}
+}
+
+// -----------------------------------------------------------------------------
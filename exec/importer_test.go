@@ -0,0 +1,28 @@
+package exec
+
+import (
+	"testing"
+)
+
+// -----------------------------------------------------------------------------
+
+func TestAllowPackages(t *testing.T) {
+	NewGoPackage("allowed")
+	NewGoPackage("denied")
+
+	imp := AllowPackages(GoPackages, "allowed", "unknown")
+	if pkg, err := imp.Import(""); err != nil || pkg != FindGoPackage("") {
+		t.Fatal("Import builtin failed:", pkg, err)
+	}
+	if pkg, err := imp.Import("allowed"); err != nil || pkg.PkgPath != "allowed" {
+		t.Fatal("Import allowed failed:", pkg, err)
+	}
+	if _, err := imp.Import("denied"); err != ErrPackageDenied {
+		t.Fatal("Import denied: not ErrPackageDenied -", err)
+	}
+	if _, err := imp.Import("unknown"); err != ErrPackageNotFound {
+		t.Fatal("Import unknown: not ErrPackageNotFound -", err)
+	}
+}
+
+// -----------------------------------------------------------------------------
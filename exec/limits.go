@@ -0,0 +1,220 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// -----------------------------------------------------------------------------
+
+var (
+	// ErrStepLimit is the error that the code panics with if it executes more
+	// instructions than Limits.MaxSteps.
+	ErrStepLimit = errors.New("instruction limit exceeded")
+
+	// ErrTimeLimit is the error that the code panics with if it runs longer than
+	// Limits.MaxTime.
+	ErrTimeLimit = errors.New("time limit exceeded")
+
+	// ErrDepthLimit is the error that the code panics with if there are more nested
+	// qlang function calls in a goroutine than Limits.MaxDepth.
+	ErrDepthLimit = errors.New("call depth limit exceeded")
+
+	// ErrMemoryLimit is the error that the code panics with if it allocates more
+	// memory than Limits.MaxMemory.
+	ErrMemoryLimit = errors.New("memory limit exceeded")
+)
+
+// Limits represents the execution budget of a Context. A zero field means no limit.
+type Limits struct {
+	MaxSteps int64         // max count of instructions to execute
+	MaxTime  time.Duration // max wall time, since SetLimits is called
+	MaxDepth int           // max depth of nested qlang function calls in a goroutine
+
+	// MaxMemory is max bytes of memory allocated by the code, including slices,
+	// arrays, maps, structs, channels, results of string concatenation and growth
+	// of stacks. It counts allocations, not memory in use: memory collected by GC
+	// isn't given back to the budget.
+	//
+	// NOTE: memory allocated by Go functions called by the code isn't counted.
+	MaxMemory int64
+}
+
+// stepsPerCheck is count of instructions that a Context executes between checks
+// of its budget. So MaxSteps and MaxTime are checked approximately. The budget is
+// also checked when a qlang function is called, since each call has its own Context.
+const stepsPerCheck = 256
+
+// execLimits is shared by a Context, and all contexts (function calls, goroutines,
+// etc.) derived from it. A Context created by NewContext always has one, with no
+// limit, so that a goroutine that panics can stop the execution (see fail).
+type execLimits struct {
+	steps    int64 // count of instructions executed, updated atomically if maxSteps > 0
+	maxSteps int64
+	mem      int64 // bytes of memory allocated, updated atomically
+	maxMem   int64
+	maxDepth int
+	deadline time.Time
+	ctx      context.Context
+	failed   atomic.Value  // the error that stops the execution, see fail
+	done     chan struct{} // closed when the execution fails
+	once     sync.Once
+}
+
+func newExecLimits() *execLimits {
+	return &execLimits{done: make(chan struct{})}
+}
+
+type failure struct {
+	err interface{}
+}
+
+// SetLimits sets the execution budget of the context. The code panics with
+// ErrStepLimit, ErrTimeLimit, ErrDepthLimit, ErrMemoryLimit, or the error of c
+// (eg. context.Canceled) if c is done, and Run returns it as a *RuntimeError.
+// These errors can't be recovered by the code.
+//
+// The budget is shared by all goroutines started by the code, so SetLimits must
+// be called before the code runs. A channel operation or a select statement that
+// blocks is interrupted when the budget is exceeded.
+//
+// NOTE: a Go function that blocks isn't interrupted. The budget is checked after
+// it returns.
+func (p *Context) SetLimits(c context.Context, limits Limits) {
+	lim := newExecLimits()
+	lim.maxSteps = limits.MaxSteps
+	lim.maxDepth = limits.MaxDepth
+	lim.maxMem = limits.MaxMemory
+	lim.ctx = c
+	if limits.MaxTime > 0 {
+		lim.deadline = time.Now().Add(limits.MaxTime)
+	}
+	p.limits = lim
+}
+
+// fail stops the execution with err. It is called if a goroutine panics. The
+// contexts sharing p panic with err when they check the budget next time, or
+// immediately if they are blocked by a channel operation.
+func (p *execLimits) fail(err interface{}) {
+	p.once.Do(func() {
+		p.failed.Store(failure{err})
+		close(p.done)
+	})
+}
+
+func (p failure) value() interface{} {
+	if err, ok := p.err.(*RuntimeError); ok { // copy it, since frames are appended to it
+		return &RuntimeError{Value: err.Value, Frames: append([]Frame(nil), err.Frames...)}
+	}
+	return p.err
+}
+
+func (p *execLimits) stopped() bool {
+	return p != nil && p.failed.Load() != nil
+}
+
+// checkFailed panics with the error that stops the execution, if any.
+func (p *execLimits) checkFailed() {
+	if p == nil {
+		return
+	}
+	if v := p.failed.Load(); v != nil {
+		panic(v.(failure).value())
+	}
+}
+
+func (p *execLimits) check(steps int, stk *Stack) {
+	p.checkFailed()
+	if p.maxSteps > 0 && atomic.AddInt64(&p.steps, int64(steps)) > p.maxSteps {
+		panic(ErrStepLimit)
+	}
+	if !p.deadline.IsZero() && time.Now().After(p.deadline) {
+		panic(ErrTimeLimit)
+	}
+	if p.ctx != nil {
+		if err := p.ctx.Err(); err != nil {
+			panic(err)
+		}
+	}
+	if p.maxMem > 0 { // charge growth of the stack
+		if n := cap(stk.data); n > stk.charged {
+			p.alloc(n-stk.charged, sizeofValue)
+			stk.charged = n
+		}
+	}
+}
+
+// sizeofValue is size of a value on the stack.
+const sizeofValue = unsafe.Sizeof(interface{}(nil))
+
+// alloc charges n items of size bytes to the memory budget.
+func (p *execLimits) alloc(n int, size uintptr) {
+	if n <= 0 || size == 0 {
+		return
+	}
+	if uintptr(n) > uintptr(p.maxMem)/size {
+		panic(ErrMemoryLimit)
+	}
+	if atomic.AddInt64(&p.mem, int64(uintptr(n)*size)) > p.maxMem {
+		panic(ErrMemoryLimit)
+	}
+}
+
+// alloc charges memory allocated by an instruction to the budget of the context.
+// It is called before the memory is allocated.
+func (ctx *Context) alloc(n int, size uintptr) {
+	if lim := ctx.limits; lim != nil && lim.maxMem > 0 {
+		lim.alloc(n, size)
+	}
+}
+
+// wait executes a select statement that may block, like reflect.Select. But it's
+// interrupted if the execution fails, or the budget is exceeded.
+func (p *execLimits) wait(cases []reflect.SelectCase, stk *Stack) (chosen int, recv reflect.Value, recvOK bool) {
+	n := len(cases)
+	for _, c := range cases {
+		if c.Dir == reflect.SelectDefault { // it never blocks
+			return reflect.Select(cases)
+		}
+	}
+	cases = append(cases, reflect.SelectCase{Dir: reflect.SelectDefault})
+	if chosen, recv, recvOK = reflect.Select(cases); chosen < n {
+		return
+	}
+	cases[n] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(p.done)}
+	if p.ctx != nil {
+		if done := p.ctx.Done(); done != nil {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)})
+		}
+	}
+	if !p.deadline.IsZero() {
+		timer := time.NewTimer(time.Until(p.deadline))
+		defer timer.Stop()
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(timer.C)})
+	}
+	if chosen, recv, recvOK = reflect.Select(cases); chosen >= n {
+		p.check(0, stk)
+		panic(ErrTimeLimit) // only the timer is left
+	}
+	return
+}
+
+// isLimitErr checks if e is an error of exceeding the execution budget, which can't
+// be recovered by the code.
+func isLimitErr(e interface{}) bool {
+	if err, ok := e.(*RuntimeError); ok {
+		e = err.Value
+	}
+	switch e {
+	case ErrStepLimit, ErrTimeLimit, ErrDepthLimit, ErrMemoryLimit, context.Canceled, context.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// -----------------------------------------------------------------------------
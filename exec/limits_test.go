@@ -0,0 +1,172 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+
+func newLoopForever() *Code {
+	l := NewLabel("")
+	return NewBuilder(nil).
+		Label(l).
+		Jmp(l). // for {}
+		Resolve()
+}
+
+func TestStepLimit(t *testing.T) {
+	code := newLoopForever()
+	ctx := NewContext(code)
+	ctx.SetLimits(nil, Limits{MaxSteps: 10000})
+	err := ctx.Run(0, code.Len())
+	if !errors.Is(err, ErrStepLimit) {
+		t.Fatal("Run: not ErrStepLimit -", err)
+	}
+}
+
+func TestStepLimitRecursion(t *testing.T) {
+	f := NewFunc("f", 1)
+	code := NewBuilder(nil).
+		CallFunc(f).
+		Return(-1).
+		DefineFunc(
+				f.Return().
+					Args()).
+		CallFunc(f). // f()
+		Return(-1).
+		EndFunc(f).
+		Resolve()
+
+	ctx := NewContext(code)
+	ctx.SetLimits(nil, Limits{MaxSteps: 10000})
+	err := ctx.Run(0, code.Len())
+	if !errors.Is(err, ErrStepLimit) {
+		t.Fatal("Run: not ErrStepLimit -", err)
+	}
+}
+
+func TestTimeLimit(t *testing.T) {
+	code := newLoopForever()
+	ctx := NewContext(code)
+	ctx.SetLimits(nil, Limits{MaxTime: 10 * time.Millisecond})
+	err := ctx.Run(0, code.Len())
+	if !errors.Is(err, ErrTimeLimit) {
+		t.Fatal("Run: not ErrTimeLimit -", err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	code := newLoopForever()
+	ctx := NewContext(code)
+	c, cancel := context.WithCancel(context.Background())
+	ctx.SetLimits(c, Limits{})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	err := ctx.Run(0, code.Len())
+	if !errors.Is(err, context.Canceled) {
+		t.Fatal("Run: not context.Canceled -", err)
+	}
+}
+
+func TestDepthLimit(t *testing.T) {
+	f := NewFunc("f", 1)
+	code := NewBuilder(nil).
+		CallFunc(f).
+		Return(-1).
+		DefineFunc(
+				f.Return().
+					Args()).
+		CallFunc(f). // f()
+		Return(-1).
+		EndFunc(f).
+		Resolve()
+
+	ctx := NewContext(code)
+	ctx.SetLimits(nil, Limits{MaxDepth: 100})
+	err := ctx.Run(0, code.Len())
+	if !errors.Is(err, ErrDepthLimit) {
+		t.Fatal("Run: not ErrDepthLimit -", err)
+	}
+	if n := len(err.(*RuntimeError).Frames); n != 101 {
+		t.Fatal("Run: unexpected count of frames -", n)
+	}
+}
+
+func TestTimeLimitChan(t *testing.T) {
+	code := NewBuilder(nil).
+		Push(0).
+		MakeChan(tyChanInt).
+		ChanOp(OpChanRecv). // <-make(chan int)
+		Resolve()
+
+	ctx := NewContext(code)
+	ctx.SetLimits(nil, Limits{MaxTime: 300 * time.Millisecond})
+	start := time.Now()
+	err := ctx.Run(0, code.Len())
+	if !errors.Is(err, ErrTimeLimit) {
+		t.Fatal("Run: not ErrTimeLimit -", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatal("Run: stopped too late -", d)
+	}
+}
+
+func TestCancelSelect(t *testing.T) {
+	code := NewBuilder(nil).
+		Push(int(reflect.SelectRecv)).
+		Push(0).
+		MakeChan(tyChanInt).
+		Push(nil).
+		Select(1). // select { case <-make(chan int): }
+		Resolve()
+
+	ctx := NewContext(code)
+	c, cancel := context.WithCancel(context.Background())
+	ctx.SetLimits(c, Limits{})
+	time.AfterFunc(10*time.Millisecond, cancel)
+	err := ctx.Run(0, code.Len())
+	if !errors.Is(err, context.Canceled) {
+		t.Fatal("Run: not context.Canceled -", err)
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	code := NewBuilder(nil).
+		Push(1 << 40).
+		MakeChan(tyChanInt). // make(chan int, 1<<40)
+		Resolve()
+
+	ctx := NewContext(code)
+	ctx.SetLimits(nil, Limits{MaxMemory: 1 << 20})
+	err := ctx.Run(0, code.Len())
+	if !errors.Is(err, ErrMemoryLimit) {
+		t.Fatal("Run: not ErrMemoryLimit -", err)
+	}
+}
+
+func TestMemoryLimitStack(t *testing.T) {
+	l := NewLabel("")
+	code := NewBuilder(nil).
+		Label(l).
+		Push(1).
+		Jmp(l). // push values forever
+		Resolve()
+
+	ctx := NewContext(code)
+	ctx.SetLimits(nil, Limits{MaxMemory: 1 << 20})
+	err := ctx.Run(0, code.Len())
+	if !errors.Is(err, ErrMemoryLimit) {
+		t.Fatal("Run: not ErrMemoryLimit -", err)
+	}
+	if n := ctx.Len(); n > 1<<17 {
+		t.Fatal("Run: stack is too large -", n)
+	}
+}
+
+// -----------------------------------------------------------------------------
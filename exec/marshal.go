@@ -0,0 +1,779 @@
+package exec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/qiniu/qlang/token"
+)
+
+// -----------------------------------------------------------------------------
+
+// A bytecode file starts with codeMagic and codeVersion. codeVersion must be
+// increased if the instruction set or the format of a released version is changed.
+const (
+	codeMagic   = "QLBC"
+	codeVersion = 1
+)
+
+var (
+	// ErrInvalidCode is returned by UnmarshalCode if the data isn't valid bytecode.
+	ErrInvalidCode = errors.New("invalid bytecode")
+
+	// ErrCodeVersion is returned by UnmarshalCode if the bytecode is encoded by an
+	// incompatible version.
+	ErrCodeVersion = errors.New("unsupported bytecode version")
+)
+
+// codeError is the value that encoding or decoding panics with.
+type codeError struct {
+	err error
+}
+
+func failCode(format string, args ...interface{}) {
+	panic(codeError{fmt.Errorf(format, args...)})
+}
+
+func recoverCode(err *error) {
+	if e := recover(); e != nil {
+		ce, ok := e.(codeError)
+		if !ok {
+			panic(e)
+		}
+		*err = ce.err
+	}
+}
+
+// recoverInvalidCode is like recoverCode, but other panics (eg. reflect panics if
+// a type is invalid) are reported as ErrInvalidCode too.
+func recoverInvalidCode(err *error) {
+	if e := recover(); e != nil {
+		if ce, ok := e.(codeError); ok {
+			*err = ce.err
+		} else {
+			*err = fmt.Errorf("UnmarshalCode failed: %w - %v", ErrInvalidCode, e)
+		}
+	}
+}
+
+// MarshalBinary encodes the code into bytecode, see Registry.MarshalCode.
+func (p *Code) MarshalBinary() ([]byte, error) {
+	return p.Registry().MarshalCode(p)
+}
+
+// UnmarshalBinary decodes bytecode encoded by MarshalBinary, and links it against
+// DefaultRegistry.
+func (p *Code) UnmarshalBinary(data []byte) error {
+	code, err := DefaultRegistry.UnmarshalCode(data)
+	if err != nil {
+		return err
+	}
+	*p = *code
+	return nil
+}
+
+// MarshalCode encodes the code compiled with this registry into bytecode. Go
+// functions and Go types that the code refers to are stored by their package paths
+// and names, so the bytecode can be loaded by another process that registers the
+// same Go packages.
+//
+// NOTE: a named Go type that isn't registered by a Go package can't be encoded. The
+// result types of the registered functions are registered with them.
+func (r *Registry) MarshalCode(code *Code) (data []byte, err error) {
+	defer recoverCode(&err)
+	if code.Registry() != r {
+		failCode("MarshalCode failed: the code is compiled with another registry")
+	}
+	e := &codeEncoder{reg: r, code: code, syms: make(map[goSymbol]uint32)}
+	e.encode()
+	return e.Bytes(), nil
+}
+
+// UnmarshalCode decodes bytecode encoded by MarshalCode, and links Go functions and
+// Go types that the code refers to against this registry.
+func (r *Registry) UnmarshalCode(data []byte) (code *Code, err error) {
+	defer recoverInvalidCode(&err)
+	d := &codeDecoder{reg: r, data: data}
+	return d.decode(), nil
+}
+
+// -----------------------------------------------------------------------------
+
+// A goSymbol is a Go function or variable that the code refers to.
+type goSymbol struct {
+	kind    SymbolKind
+	pkgPath string
+	name    string
+}
+
+// isGoSymbolOp checks if operand of the instruction is address of a Go symbol, and
+// returns bits of the address.
+func isGoSymbolOp(op uint32) (kind SymbolKind, mask uint32, ok bool) {
+	switch op {
+	case opCallGoFunc:
+		return SymbolFunc, bitsOperand, true
+	case opCallGoFuncv:
+		return SymbolFuncv, bitsOpCallFuncvOperand, true
+	case opLoadGoVar, opStoreGoVar, opAddrGoVar:
+		return SymbolVar, bitsOperand, true
+	}
+	return
+}
+
+const (
+	typeNil = iota // TypeCase(nil)
+	typeBasic
+	typeNamed
+	typeEmptyIface
+	typePtr
+	typeSlice
+	typeArray
+	typeMap
+	typeChan
+	typeFunc
+	typeStruct
+)
+
+func isBasicType(t reflect.Type) bool {
+	kind := t.Kind()
+	if kind < reflect.Bool || kind > reflect.Complex128 && kind != reflect.String {
+		return false
+	}
+	return t.PkgPath() == "" && t.Name() == kind.String()
+}
+
+// -----------------------------------------------------------------------------
+
+type codeEncoder struct {
+	bytes.Buffer
+	reg     *Registry
+	code    *Code
+	syms    map[goSymbol]uint32
+	symList []goSymbol
+	named   map[reflect.Type]goSymbol // named Go types registered in reg
+	funcs   map[*FuncInfo]uint64
+}
+
+func (p *codeEncoder) uint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	p.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (p *codeEncoder) int(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	p.Write(b[:binary.PutVarint(b[:], v)])
+}
+
+func (p *codeEncoder) string(v string) {
+	p.uint(uint64(len(v)))
+	p.WriteString(v)
+}
+
+func (p *codeEncoder) encode() {
+	code := p.code
+	data := make([]Instr, len(code.data))
+	for ip, i := range code.data {
+		if kind, mask, ok := isGoSymbolOp(i >> bitsOpShift); ok {
+			i = (i &^ mask) | p.symbol(kind, i&mask)
+		}
+		data[ip] = i
+	}
+
+	p.WriteString(codeMagic)
+	p.uint(codeVersion)
+	p.uint(uint64(len(p.symList)))
+	for _, sym := range p.symList {
+		p.uint(uint64(sym.kind))
+		p.string(sym.pkgPath)
+		p.string(sym.name)
+	}
+	p.uint(uint64(len(data)))
+	for _, i := range data {
+		p.uint(uint64(i))
+	}
+	p.uint(uint64(len(code.stringConsts)))
+	for _, v := range code.stringConsts {
+		p.string(v)
+	}
+	p.uint(uint64(len(code.intConsts)))
+	for _, v := range code.intConsts {
+		p.int(v)
+	}
+	p.uint(uint64(len(code.uintConsts)))
+	for _, v := range code.uintConsts {
+		p.uint(v)
+	}
+	p.uint(uint64(len(code.valConsts)))
+	for _, v := range code.valConsts {
+		p.value(v)
+	}
+	p.uint(uint64(len(code.types)))
+	for _, t := range code.types {
+		p.typ(t)
+	}
+	p.uint(uint64(len(code.structs)))
+	for _, v := range code.structs {
+		p.typ(v.typ)
+	}
+	p.vars(&code.varManager)
+	p.funcs = make(map[*FuncInfo]uint64, len(code.funs)+len(code.funvs))
+	p.funcList(code.funs, 0)
+	p.funcList(code.funvs, 1)
+	p.uint(uint64(len(code.ifaces)))
+	for _, v := range code.ifaces {
+		p.iface(v.typ, v.methods, v.index)
+	}
+	p.uint(uint64(len(code.lines)))
+	for _, v := range code.lines {
+		p.uint(uint64(v.ip))
+		p.pos(v.pos)
+	}
+}
+
+func (p *codeEncoder) symbol(kind SymbolKind, addr uint32) uint32 {
+	var pkg *GoPackage
+	var name string
+	switch kind {
+	case SymbolFunc:
+		if fi := p.reg.GoFuncInfo(GoFuncAddr(addr)); fi != nil {
+			pkg, name = fi.Pkg, fi.Name
+		}
+	case SymbolFuncv:
+		if fi := p.reg.GoFuncvInfo(GoFuncvAddr(addr)); fi != nil {
+			pkg, name = fi.Pkg, fi.Name
+		}
+	default:
+		if vi := p.reg.GoVarInfo(GoVarAddr(addr)); vi != nil {
+			pkg, name = vi.Pkg, vi.Name
+		}
+	}
+	if pkg == nil {
+		failCode("MarshalCode failed: Go symbol not found in the registry - %d", addr)
+	}
+	sym := goSymbol{kind: kind, pkgPath: pkg.PkgPath, name: name}
+	idx, ok := p.syms[sym]
+	if !ok {
+		idx = uint32(len(p.symList))
+		p.syms[sym] = idx
+		p.symList = append(p.symList, sym)
+	}
+	return idx
+}
+
+func (p *codeEncoder) value(v interface{}) {
+	val := reflect.ValueOf(v)
+	p.typ(val.Type())
+	switch val.Kind() {
+	case reflect.Float32, reflect.Float64:
+		p.uint(math.Float64bits(val.Float()))
+	case reflect.Complex64, reflect.Complex128:
+		c := val.Complex()
+		p.uint(math.Float64bits(real(c)))
+		p.uint(math.Float64bits(imag(c)))
+	default:
+		failCode("MarshalCode failed: unsupported constant - %v", v)
+	}
+}
+
+func (p *codeEncoder) typ(t reflect.Type) {
+	if t == nil {
+		p.uint(typeNil)
+		return
+	}
+	if isBasicType(t) {
+		p.uint(typeBasic)
+		p.uint(uint64(t.Kind()))
+		return
+	}
+	if t.Name() != "" {
+		sym, ok := p.namedType(t)
+		if !ok {
+			failCode("MarshalCode failed: type isn't registered - %v", t)
+		}
+		p.uint(typeNamed)
+		p.string(sym.pkgPath)
+		p.string(sym.name)
+		return
+	}
+	switch t.Kind() {
+	case reflect.Interface:
+		if t.NumMethod() != 0 {
+			failCode("MarshalCode failed: unnamed interface is unsupported - %v", t)
+		}
+		p.uint(typeEmptyIface)
+	case reflect.Ptr:
+		p.uint(typePtr)
+		p.typ(t.Elem())
+	case reflect.Slice:
+		p.uint(typeSlice)
+		p.typ(t.Elem())
+	case reflect.Array:
+		p.uint(typeArray)
+		p.uint(uint64(t.Len()))
+		p.typ(t.Elem())
+	case reflect.Map:
+		p.uint(typeMap)
+		p.typ(t.Key())
+		p.typ(t.Elem())
+	case reflect.Chan:
+		p.uint(typeChan)
+		p.uint(uint64(t.ChanDir()))
+		p.typ(t.Elem())
+	case reflect.Func:
+		p.uint(typeFunc)
+		p.uint(uint64(t.NumIn()))
+		for i := 0; i < t.NumIn(); i++ {
+			p.typ(t.In(i))
+		}
+		p.uint(uint64(t.NumOut()))
+		for i := 0; i < t.NumOut(); i++ {
+			p.typ(t.Out(i))
+		}
+		p.bool(t.IsVariadic())
+	case reflect.Struct:
+		p.uint(typeStruct)
+		p.uint(uint64(t.NumField()))
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			p.string(f.Name)
+			p.string(f.PkgPath)
+			p.typ(f.Type)
+			p.string(string(f.Tag))
+			p.bool(f.Anonymous)
+		}
+	default:
+		failCode("MarshalCode failed: unsupported type - %v", t)
+	}
+}
+
+// namedType finds a named Go type registered in the registry.
+func (p *codeEncoder) namedType(t reflect.Type) (sym goSymbol, ok bool) {
+	if p.named == nil {
+		p.named = make(map[reflect.Type]goSymbol)
+		for pkgPath, pkg := range p.reg.pkgs {
+			for name, typ := range pkg.types {
+				v := goSymbol{pkgPath: pkgPath, name: name}
+				if old, ok := p.named[typ]; ok && !symbolLess(v, old) {
+					continue // a type may be registered by several names
+				}
+				p.named[typ] = v
+			}
+		}
+	}
+	sym, ok = p.named[t]
+	return
+}
+
+func symbolLess(a, b goSymbol) bool {
+	if a.pkgPath != b.pkgPath {
+		return a.pkgPath < b.pkgPath
+	}
+	return a.name < b.name
+}
+
+func (p *codeEncoder) bool(v bool) {
+	if v {
+		p.uint(1)
+	} else {
+		p.uint(0)
+	}
+}
+
+func (p *codeEncoder) vars(vm *varManager) {
+	p.uint(uint64(vm.nestDepth))
+	p.uint(uint64(len(vm.vlist)))
+	for _, v := range vm.vlist {
+		p.typ(v.Type)
+		p.string(v.name)
+		p.uint(uint64(v.nestDepth))
+		p.uint(uint64(v.idx))
+	}
+}
+
+func (p *codeEncoder) funcList(funs []*FuncInfo, variadic uint64) {
+	p.uint(uint64(len(funs)))
+	for i, fun := range funs {
+		p.funcs[fun] = uint64(i)<<1 | variadic
+		p.string(fun.Name)
+		p.uint(uint64(fun.FunEntry))
+		p.uint(uint64(fun.FunEnd))
+		p.uint(uint64(fun.nVariadic))
+		p.uint(uint64(fun.numOut))
+		p.uint(uint64(len(fun.in)))
+		for _, t := range fun.in {
+			p.typ(t)
+		}
+		p.uint(uint64(len(fun.argNames)))
+		for _, name := range fun.argNames {
+			p.string(name)
+		}
+		p.vars(&fun.varManager)
+		p.vars(&fun.env)
+	}
+}
+
+func (p *codeEncoder) iface(typ reflect.Type, methods []*FuncInfo, index [][]int) {
+	p.typ(typ)
+	p.uint(uint64(len(methods)))
+	for i, fun := range methods {
+		ref, ok := p.funcs[fun]
+		if !ok {
+			failCode("MarshalCode failed: method isn't defined - %s", fun.Name)
+		}
+		p.uint(ref)
+		if index == nil {
+			p.uint(0)
+			continue
+		}
+		p.uint(uint64(len(index[i])))
+		for _, idx := range index[i] {
+			p.uint(uint64(idx))
+		}
+	}
+}
+
+func (p *codeEncoder) pos(pos token.Position) {
+	p.string(pos.Filename)
+	p.uint(uint64(pos.Offset))
+	p.uint(uint64(pos.Line))
+	p.uint(uint64(pos.Column))
+}
+
+// -----------------------------------------------------------------------------
+
+type codeDecoder struct {
+	reg  *Registry
+	data []byte
+}
+
+func (p *codeDecoder) invalid() {
+	failCode("UnmarshalCode failed: %w", ErrInvalidCode)
+}
+
+func (p *codeDecoder) uint() uint64 {
+	v, n := binary.Uvarint(p.data)
+	if n <= 0 {
+		p.invalid()
+	}
+	p.data = p.data[n:]
+	return v
+}
+
+func (p *codeDecoder) int() int64 {
+	v, n := binary.Varint(p.data)
+	if n <= 0 {
+		p.invalid()
+	}
+	p.data = p.data[n:]
+	return v
+}
+
+func (p *codeDecoder) uint32() uint32 {
+	v := p.uint()
+	if v > math.MaxUint32 {
+		p.invalid()
+	}
+	return uint32(v)
+}
+
+func (p *codeDecoder) intVal() int {
+	v := p.uint()
+	if v > math.MaxInt32 {
+		p.invalid()
+	}
+	return int(v)
+}
+
+// len reads length of a list. Each item of the list takes one byte at least.
+func (p *codeDecoder) len() int {
+	n := p.uint()
+	if n > uint64(len(p.data)) {
+		p.invalid()
+	}
+	return int(n)
+}
+
+func (p *codeDecoder) string() string {
+	n := p.len()
+	v := string(p.data[:n])
+	p.data = p.data[n:]
+	return v
+}
+
+func (p *codeDecoder) bool() bool {
+	return p.uint() != 0
+}
+
+func (p *codeDecoder) decode() *Code {
+	if !bytes.HasPrefix(p.data, []byte(codeMagic)) {
+		p.invalid()
+	}
+	p.data = p.data[len(codeMagic):]
+	if v := p.uint(); v != codeVersion {
+		failCode("UnmarshalCode failed: %w - %d", ErrCodeVersion, v)
+	}
+	syms := make([]uint32, p.len())
+	for i := range syms {
+		syms[i] = p.symbol()
+	}
+	code := &Code{data: make([]Instr, p.len()), reg: p.reg}
+	for ip := range code.data {
+		i := p.uint32()
+		if kind, mask, ok := isGoSymbolOp(i >> bitsOpShift); ok {
+			idx := i & mask
+			if idx >= uint32(len(syms)) || SymbolKind(syms[idx]>>bitsOpShift) != kind {
+				p.invalid()
+			}
+			addr := syms[idx] & bitsOperand
+			if addr > mask {
+				failCode("UnmarshalCode failed: address of Go symbol is out of range - %d", addr)
+			}
+			i = (i &^ mask) | addr
+		}
+		code.data[ip] = i
+	}
+	code.stringConsts = make([]string, p.len())
+	for i := range code.stringConsts {
+		code.stringConsts[i] = p.string()
+	}
+	code.intConsts = make([]int64, p.len())
+	for i := range code.intConsts {
+		code.intConsts[i] = p.int()
+	}
+	code.uintConsts = make([]uint64, p.len())
+	for i := range code.uintConsts {
+		code.uintConsts[i] = p.uint()
+	}
+	code.valConsts = make([]interface{}, p.len())
+	for i := range code.valConsts {
+		code.valConsts[i] = p.value()
+	}
+	code.types = make([]reflect.Type, p.len())
+	for i := range code.types {
+		code.types[i] = p.typ()
+	}
+	code.structs = make([]StructInfo, p.len())
+	for i := range code.structs {
+		t := p.typ()
+		if t.Kind() != reflect.Struct {
+			p.invalid()
+		}
+		fields := make([]StructField, t.NumField())
+		for i := range fields {
+			fields[i] = t.Field(i)
+		}
+		code.structs[i] = StructInfo{Fields: fields, typ: t}
+	}
+	p.vars(&code.varManager)
+	code.funs = p.funcList(false)
+	code.funvs = p.funcList(true)
+	code.ifaces = make([]ifaceInfo, p.len())
+	for i := range code.ifaces {
+		code.ifaces[i] = p.iface(code)
+	}
+	code.lines = make([]posInfo, p.len())
+	for i := range code.lines {
+		code.lines[i] = posInfo{ip: p.intVal(), pos: p.pos()}
+	}
+	if len(p.data) != 0 {
+		p.invalid()
+	}
+	return code
+}
+
+// symbol links a Go symbol against the registry, and returns its kind and address
+// like values of GoPackage.syms.
+func (p *codeDecoder) symbol() uint32 {
+	kind := SymbolKind(p.uint())
+	pkgPath, name := p.string(), p.string()
+	pkg := p.reg.FindGoPackage(pkgPath)
+	if pkg == nil {
+		failCode("UnmarshalCode failed: package not found - %s", pkgPath)
+	}
+	addr, k, ok := pkg.Find(name)
+	if !ok || k != kind {
+		failCode("UnmarshalCode failed: Go symbol not found - %s.%s", pkgPath, name)
+	}
+	return uint32(kind)<<bitsOpShift | addr
+}
+
+func (p *codeDecoder) value() interface{} {
+	t := p.typ()
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(math.Float64frombits(p.uint()))
+	case reflect.Complex64, reflect.Complex128:
+		re := math.Float64frombits(p.uint())
+		im := math.Float64frombits(p.uint())
+		v.SetComplex(complex(re, im))
+	default:
+		p.invalid()
+	}
+	return v.Interface()
+}
+
+func (p *codeDecoder) typ() reflect.Type {
+	switch p.uint() {
+	case typeNil:
+		return nil
+	case typeBasic:
+		kind := reflect.Kind(p.uint())
+		t, ok := basicTypes[kind]
+		if !ok {
+			p.invalid()
+		}
+		return t
+	case typeNamed:
+		pkgPath, name := p.string(), p.string()
+		if pkg := p.reg.FindGoPackage(pkgPath); pkg != nil {
+			if t, ok := pkg.FindType(name); ok {
+				return t
+			}
+		}
+		failCode("UnmarshalCode failed: type not found - %s.%s", pkgPath, name)
+	case typeEmptyIface:
+		return TyEmptyInterface
+	case typePtr:
+		return reflect.PtrTo(p.typ())
+	case typeSlice:
+		return reflect.SliceOf(p.typ())
+	case typeArray:
+		n := p.intVal()
+		return reflect.ArrayOf(n, p.typ())
+	case typeMap:
+		key := p.typ()
+		return reflect.MapOf(key, p.typ())
+	case typeChan:
+		dir := reflect.ChanDir(p.uint())
+		return reflect.ChanOf(dir, p.typ())
+	case typeFunc:
+		in := make([]reflect.Type, p.len())
+		for i := range in {
+			in[i] = p.typ()
+		}
+		out := make([]reflect.Type, p.len())
+		for i := range out {
+			out[i] = p.typ()
+		}
+		return reflect.FuncOf(in, out, p.bool())
+	case typeStruct:
+		fields := make([]StructField, p.len())
+		for i := range fields {
+			f := &fields[i]
+			f.Name, f.PkgPath = p.string(), p.string()
+			f.Type = p.typ()
+			f.Tag = reflect.StructTag(p.string())
+			f.Anonymous = p.bool()
+		}
+		return reflect.StructOf(fields)
+	}
+	p.invalid()
+	return nil
+}
+
+var basicTypes = map[reflect.Kind]reflect.Type{
+	reflect.Bool:       TyBool,
+	reflect.Int:        TyInt,
+	reflect.Int8:       TyInt8,
+	reflect.Int16:      TyInt16,
+	reflect.Int32:      TyInt32,
+	reflect.Int64:      TyInt64,
+	reflect.Uint:       TyUint,
+	reflect.Uint8:      TyUint8,
+	reflect.Uint16:     TyUint16,
+	reflect.Uint32:     TyUint32,
+	reflect.Uint64:     TyUint64,
+	reflect.Uintptr:    TyUintptr,
+	reflect.Float32:    TyFloat32,
+	reflect.Float64:    TyFloat64,
+	reflect.Complex64:  TyComplex64,
+	reflect.Complex128: TyComplex128,
+	reflect.String:     TyString,
+}
+
+func (p *codeDecoder) vars(vm *varManager) {
+	vm.nestDepth = p.uint32()
+	vm.vlist = make([]*Var, p.len())
+	for i := range vm.vlist {
+		v := &Var{Type: p.typ(), name: p.string()}
+		if v.name == "" {
+			p.invalid()
+		}
+		v.nestDepth, v.idx = p.uint32(), p.uint32()
+		vm.vlist[i] = v
+	}
+}
+
+func (p *codeDecoder) funcList(variadic bool) []*FuncInfo {
+	funs := make([]*FuncInfo, p.len())
+	for i := range funs {
+		fun := &FuncInfo{Name: p.string()}
+		fun.FunEntry, fun.FunEnd = p.intVal(), p.intVal()
+		fun.nVariadic = uint16(p.uint())
+		fun.numOut = p.intVal()
+		fun.in = make([]reflect.Type, p.len())
+		for i := range fun.in {
+			fun.in[i] = p.typ()
+		}
+		if n := p.len(); n > 0 {
+			fun.argNames = make([]string, n)
+			for i := range fun.argNames {
+				fun.argNames[i] = p.string()
+			}
+		}
+		p.vars(&fun.varManager)
+		p.vars(&fun.env)
+		if fun.numOut > len(fun.vlist) || fun.nVariadic > nVariadicVariadicArgs ||
+			fun.nVariadic == nVariadicVariadicArgs != variadic {
+			p.invalid()
+		}
+		if fun.IsTypeValid() {
+			fun.Type() // cache the type, see Builder.resolveFuncs
+		}
+		funs[i] = fun
+	}
+	return funs
+}
+
+func (p *codeDecoder) iface(code *Code) ifaceInfo {
+	typ := p.typ()
+	proxy, ok := FindInterfaceProxy(typ)
+	if !ok {
+		failCode("UnmarshalCode failed: proxy of interface not found - %v", typ)
+	}
+	methods := make([]*FuncInfo, p.len())
+	var index [][]int
+	for i := range methods {
+		ref := p.uint()
+		funs := code.funs
+		if ref&1 != 0 {
+			funs = code.funvs
+		}
+		if ref>>1 >= uint64(len(funs)) {
+			p.invalid()
+		}
+		methods[i] = funs[ref>>1]
+		if n := p.len(); n > 0 { // a promoted method
+			if index == nil {
+				index = make([][]int, len(methods))
+			}
+			index[i] = make([]int, n)
+			for j := range index[i] {
+				index[i][j] = p.intVal()
+			}
+		}
+	}
+	return ifaceInfo{typ: typ, proxy: proxy, methods: methods, index: index}
+}
+
+func (p *codeDecoder) pos() token.Position {
+	filename := p.string()
+	return token.Position{Filename: filename, Offset: p.intVal(), Line: p.intVal(), Column: p.intVal()}
+}
+
+// -----------------------------------------------------------------------------
@@ -0,0 +1,94 @@
+package exec
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// -----------------------------------------------------------------------------
+
+func newTestMarshalCode(t *testing.T) *Code {
+	sprintf, ok := I.FindFuncv("Sprintf")
+	if !ok {
+		t.Fatal("FindFunc failed: Sprintf")
+	}
+
+	tyInterfaceSlice := reflect.SliceOf(TyEmptyInterface)
+
+	foo := NewFunc("foo", 1)
+	ret := NewVar(TyString, "1")
+	x := NewVar(reflect.MapOf(TyString, TyFloat64), "x")
+	return NewBuilder(nil).
+		DefineVar(x).
+		Push(nil).
+		TypeCase(nil). // case nil
+		Pop(2).
+		Push("pi").
+		Push(3.14).
+		MakeMap(x.Type, 1).
+		StoreVar(x).
+		Push("Hello, %v, %d, %v").
+		LoadVar(x).
+		Push(1<<40).
+		Push(2+3i).
+		CallFuncv(foo, 4).
+		Return(-1).
+		DefineFunc(
+			foo.Return(ret).
+				Vargs(TyString, tyInterfaceSlice)).
+		Load(-2).
+		Load(-1).
+		CallGoFuncv(sprintf, -1). // sprintf(format, args...)
+		StoreVar(ret).
+		EndFunc(foo).
+		Resolve()
+}
+
+func TestMarshalCode(t *testing.T) {
+	b, err := newTestMarshalCode(t).MarshalBinary()
+	if err != nil {
+		t.Fatal("MarshalBinary failed:", err)
+	}
+
+	// Sprintf has another address in reg.
+	reg := NewRegistry()
+	pkg := reg.NewGoPackage("")
+	pkg.RegisterFuncvs(
+		pkg.Funcv("Sprint", fmt.Sprint, execSprint),
+		pkg.Funcv("Sprintf", fmt.Sprintf, execSprintf),
+	)
+	code, err := reg.UnmarshalCode(b)
+	if err != nil {
+		t.Fatal("UnmarshalCode failed:", err)
+	}
+
+	ctx := reg.NewContext(code)
+	ctx.Exec(0, code.Len())
+	if v := checkPop(ctx); v != "Hello, map[pi:3.14], 1099511627776, (2+3i)" {
+		t.Fatal("sprintf != `Hello, map[pi:3.14], 1099511627776, (2+3i)`, ret =", v)
+	}
+}
+
+func TestUnmarshalCodeErrors(t *testing.T) {
+	b, err := newTestMarshalCode(t).MarshalBinary()
+	if err != nil {
+		t.Fatal("MarshalBinary failed:", err)
+	}
+
+	var code Code
+	if err = code.UnmarshalBinary(b[:len(b)-1]); !errors.Is(err, ErrInvalidCode) {
+		t.Fatal("UnmarshalBinary: not ErrInvalidCode -", err)
+	}
+	b[len(codeMagic)]++
+	if err = code.UnmarshalBinary(b); !errors.Is(err, ErrCodeVersion) {
+		t.Fatal("UnmarshalBinary: not ErrCodeVersion -", err)
+	}
+	b[len(codeMagic)]--
+	if _, err = NewRegistry().UnmarshalCode(b); err == nil {
+		t.Fatal("UnmarshalCode: Sprintf is found in an empty registry")
+	}
+}
+
+// -----------------------------------------------------------------------------
@@ -314,14 +314,88 @@ func toUint(v interface{}) uint {
 	}
 }
 
+// iAddString is the operand of BuiltinOp instr of string concatenation.
+const iAddString = (int(String) << bitsOperator) | int(OpAdd)
+
 func execBuiltinOp(i Instr, p *Context) {
-	if fn := builtinOps[int(i&bitsOperand)]; fn != nil {
+	idx := int(i & bitsOperand)
+	if idx == iAddString && p.limits != nil && p.limits.maxMem > 0 {
+		n := len(p.data)
+		p.alloc(len(p.data[n-2].(string))+len(p.data[n-1].(string)), 1)
+	}
+	if fn := getBuiltinOp(idx); fn != nil {
 		fn(0, p)
 	} else {
 		panic("execBuiltinOp: invalid builtinOp")
 	}
 }
 
+// getBuiltinOp returns the function of a BuiltinOp instr, or nil if it's invalid.
+func getBuiltinOp(idx int) func(i Instr, p *Context) {
+	if idx < len(builtinOps) {
+		if fn := builtinOps[idx]; fn != nil {
+			return fn
+		}
+	}
+	return compareOps[idx]
+}
+
+// compareOps are comparison operators of kinds that builtinOps doesn't support.
+// Values of these kinds (eg. structs, interfaces and pointers) are compared as
+// interface{}, like Go: it panics if their dynamic types aren't comparable.
+var compareOps = map[int]func(i Instr, p *Context){}
+
+func init() {
+	for _, kind := range []Kind{Bool, reflect.Array, reflect.Chan, reflect.Interface, reflect.Ptr, reflect.Struct, UnsafePointer} {
+		compareOps[(int(kind)<<bitsOperator)|int(OpEQ)] = execEQAny
+		compareOps[(int(kind)<<bitsOperator)|int(OpNE)] = execNEAny
+	}
+	for _, kind := range []Kind{reflect.Chan, reflect.Func, reflect.Map, reflect.Ptr, reflect.Slice, UnsafePointer} {
+		compareOps[(int(kind)<<bitsOperator)|int(OpEQNil)] = execEQNil
+		compareOps[(int(kind)<<bitsOperator)|int(OpNENil)] = execNENil
+	}
+	compareOps[(int(reflect.Interface)<<bitsOperator)|int(OpEQNil)] = execEQNilIface
+	compareOps[(int(reflect.Interface)<<bitsOperator)|int(OpNENil)] = execNENilIface
+}
+
+func execEQAny(i Instr, p *Context) {
+	n := len(p.data)
+	p.data[n-2] = p.data[n-2] == p.data[n-1]
+	p.data = p.data[:n-1]
+}
+
+func execNEAny(i Instr, p *Context) {
+	n := len(p.data)
+	p.data[n-2] = p.data[n-2] != p.data[n-1]
+	p.data = p.data[:n-1]
+}
+
+// isNil checks if v is a nil value of a pointer, channel, func, map or slice.
+func isNil(v interface{}) bool {
+	return v == nil || reflect.ValueOf(v).IsNil()
+}
+
+func execEQNil(i Instr, p *Context) {
+	n := len(p.data)
+	p.data[n-1] = isNil(p.data[n-1])
+}
+
+func execNENil(i Instr, p *Context) {
+	n := len(p.data)
+	p.data[n-1] = !isNil(p.data[n-1])
+}
+
+// An interface holding a nil pointer isn't nil, so the value itself is checked.
+func execEQNilIface(i Instr, p *Context) {
+	n := len(p.data)
+	p.data[n-1] = p.data[n-1] == nil
+}
+
+func execNENilIface(i Instr, p *Context) {
+	n := len(p.data)
+	p.data[n-1] = p.data[n-1] != nil
+}
+
 // -----------------------------------------------------------------------------
 
 const (
@@ -331,7 +405,7 @@ const (
 
 func (p *Code) builtinOp(kind Kind, op Operator) error {
 	i := (int(kind) << bitsOperator) | int(op)
-	if fn := builtinOps[i]; fn != nil {
+	if fn := getBuiltinOp(i); fn != nil {
 		p.data = append(p.data, (opBuiltinOp<<bitsOpShift)|uint32(i))
 		return nil
 	}
@@ -349,7 +423,7 @@ func (ctx *Builder) BuiltinOp(kind Kind, op Operator) *Builder {
 
 // CallBuiltinOp calls BuiltinOp
 func CallBuiltinOp(kind Kind, op Operator, data ...interface{}) interface{} {
-	if fn := builtinOps[(int(kind)<<bitsOperator)|int(op)]; fn != nil {
+	if fn := getBuiltinOp((int(kind) << bitsOperator) | int(op)); fn != nil {
 		ctx := newSimpleContext(data)
 		fn(0, ctx)
 		return ctx.Get(-1)
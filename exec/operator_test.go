@@ -505,4 +505,32 @@ func TestCallBuiltinOp(t *testing.T) {
 	}
 }
 
+func TestCallCompareOp(t *testing.T) {
+	type point struct{ X, Y int }
+	if ret := CallBuiltinOp(reflect.Struct, OpEQ, point{1, 2}, point{1, 2}); ret != true {
+		t.Fatal("CallBuiltinOp failed: ret =", ret)
+	}
+	if ret := CallBuiltinOp(reflect.Interface, OpNE, 1, "1"); ret != true {
+		t.Fatal("CallBuiltinOp failed: ret =", ret)
+	}
+	if ret := CallBuiltinOp(Bool, OpEQ, true, false); ret != false {
+		t.Fatal("CallBuiltinOp failed: ret =", ret)
+	}
+}
+
+func TestCallNilOp(t *testing.T) {
+	if ret := CallBuiltinOp(reflect.Ptr, OpEQNil, (*int)(nil)); ret != true {
+		t.Fatal("CallBuiltinOp failed: ret =", ret)
+	}
+	if ret := CallBuiltinOp(reflect.Map, OpNENil, map[string]int{}); ret != true {
+		t.Fatal("CallBuiltinOp failed: ret =", ret)
+	}
+	if ret := CallBuiltinOp(reflect.Interface, OpEQNil, nil); ret != true {
+		t.Fatal("CallBuiltinOp failed: ret =", ret)
+	}
+	if ret := CallBuiltinOp(reflect.Interface, OpNENil, (*int)(nil)); ret != true { // a non-nil interface
+		t.Fatal("CallBuiltinOp failed: ret =", ret)
+	}
+}
+
 // -----------------------------------------------------------------------------
@@ -0,0 +1,151 @@
+package exec
+
+import (
+	"reflect"
+	"unicode/utf8"
+
+	"github.com/qiniu/x/log"
+)
+
+// -----------------------------------------------------------------------------
+
+// An iterator represents the state of a for range statement.
+type iterator interface {
+	// next pushes the next key and value (or only one value, for chan and
+	// integer iterators) into the stack. It returns false if there is no more
+	// element.
+	next(p *Context) bool
+}
+
+type sliceIter struct {
+	v    reflect.Value
+	i, n int
+}
+
+func (it *sliceIter) next(p *Context) bool {
+	if it.i >= it.n {
+		return false
+	}
+	p.Push(it.i)
+	p.Push(it.v.Index(it.i).Interface())
+	it.i++
+	return true
+}
+
+type stringIter struct {
+	s string
+	i int
+}
+
+func (it *stringIter) next(p *Context) bool {
+	if it.i >= len(it.s) {
+		return false
+	}
+	c, size := utf8.DecodeRuneInString(it.s[it.i:])
+	p.Push(it.i)
+	p.Push(c)
+	it.i += size
+	return true
+}
+
+type mapIter struct {
+	it *reflect.MapIter
+}
+
+func (it *mapIter) next(p *Context) bool {
+	if !it.it.Next() {
+		return false
+	}
+	p.Push(it.it.Key().Interface())
+	p.Push(it.it.Value().Interface())
+	return true
+}
+
+type chanIter struct {
+	v reflect.Value
+}
+
+func (it *chanIter) next(p *Context) bool {
+	x, ok := it.v.Recv()
+	if !ok {
+		return false
+	}
+	p.Push(x.Interface())
+	return true
+}
+
+type intIter struct {
+	t    reflect.Type
+	i, n int64
+}
+
+func (it *intIter) next(p *Context) bool {
+	if it.i >= it.n {
+		return false
+	}
+	if it.t == TyInt {
+		p.Push(int(it.i))
+	} else {
+		p.Push(reflect.ValueOf(it.i).Convert(it.t).Interface())
+	}
+	it.i++
+	return true
+}
+
+func newIterator(x interface{}) iterator {
+	if s, ok := x.(string); ok {
+		return &stringIter{s: s}
+	}
+	v := reflect.ValueOf(x)
+	switch kind := v.Kind(); kind {
+	case reflect.Slice, reflect.Array:
+		return &sliceIter{v: v, n: v.Len()}
+	case reflect.Ptr:
+		if v.Type().Elem().Kind() == reflect.Array { // *[N]T
+			return &sliceIter{v: v.Elem(), n: v.Type().Elem().Len()}
+		}
+	case reflect.Map:
+		return &mapIter{it: v.MapRange()}
+	case reflect.Chan:
+		return &chanIter{v: v}
+	case reflect.String:
+		return &stringIter{s: v.String()}
+	default:
+		if kind >= reflect.Int && kind <= reflect.Int64 {
+			return &intIter{t: v.Type(), n: v.Int()}
+		}
+		if kind >= reflect.Uint && kind <= reflect.Uintptr {
+			return &intIter{t: v.Type(), n: int64(v.Uint())}
+		}
+	}
+	log.Panicln("range failed: can't range over -", v.Type())
+	return nil
+}
+
+func execRangeIter(i Instr, p *Context) {
+	n := len(p.data)
+	p.data[n-1] = newIterator(p.data[n-1])
+}
+
+func execRangeNext(i Instr, p *Context) {
+	it := p.data[len(p.data)-1].(iterator)
+	if !it.next(p) {
+		execJmp(i, p)
+	}
+}
+
+// RangeIter instr. It pops a slice, array, pointer to array, string, map,
+// chan or integer, and pushes an iterator of it.
+func (p *Builder) RangeIter() *Builder {
+	p.code.data = append(p.code.data, opRangeIter<<bitsOpShift)
+	return p
+}
+
+// RangeNext instr. If the iterator at the top of the stack has more elements,
+// it pushes the next key and value (only one value for chan and integer);
+// otherwise it jumps to label l. The iterator is left on the stack.
+func (p *Builder) RangeNext(l *Label) *Builder {
+	return p.labelOp(opRangeNext, l)
+}
+
+// -----------------------------------------------------------------------------
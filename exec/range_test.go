@@ -0,0 +1,140 @@
+package exec
+
+import (
+	"reflect"
+	"testing"
+)
+
+// -----------------------------------------------------------------------------
+
+func TestRangeSlice(t *testing.T) {
+	x := NewVar(reflect.TypeOf([]int(nil)), "x")
+	sum := NewVar(TyInt, "sum")
+	next := NewLabel("next")
+	done := NewLabel("done")
+	code := NewBuilder(nil).
+		DefineVar(x, sum).
+		LoadVar(x).
+		RangeIter().
+		Label(next).
+		RangeNext(done).
+		LoadVar(sum).
+		BuiltinOp(Int, OpAdd).
+		BuiltinOp(Int, OpAdd).
+		StoreVar(sum). // sum += k + v
+		Jmp(next).
+		Label(done).
+		Pop(1).
+		Resolve()
+
+	ctx := NewContext(code)
+	ctx.SetVar(x, []int{10, 20, 30})
+	ctx.Exec(0, code.Len())
+	if v := ctx.GetVar(sum); v != 63 {
+		t.Fatal("sum != 63, ret =", v)
+	}
+	if ctx.Len() != 0 {
+		t.Fatal("stack len:", ctx.Len())
+	}
+}
+
+func TestRangeString(t *testing.T) {
+	keys := NewVar(TyInt, "keys")
+	runes := NewVar(TyString, "runes")
+	next := NewLabel("next")
+	done := NewLabel("done")
+	code := NewBuilder(nil).
+		DefineVar(keys, runes).
+		Push("h中a").
+		RangeIter().
+		Label(next).
+		RangeNext(done).
+		Pop(1). // drop rune value
+		LoadVar(keys).
+		BuiltinOp(Int, OpAdd).
+		StoreVar(keys). // keys += k
+		Jmp(next).
+		Label(done).
+		Pop(1).
+		Resolve()
+
+	ctx := NewContext(code)
+	ctx.Exec(0, code.Len())
+	if v := ctx.GetVar(keys); v != 5 { // 0 + 1 + 4
+		t.Fatal("keys != 5, ret =", v)
+	}
+}
+
+func TestRangeMap(t *testing.T) {
+	x := NewVar(reflect.TypeOf(map[string]int(nil)), "x")
+	sum := NewVar(TyInt, "sum")
+	next := NewLabel("next")
+	done := NewLabel("done")
+	code := NewBuilder(nil).
+		DefineVar(x, sum).
+		LoadVar(x).
+		RangeIter().
+		Label(next).
+		RangeNext(done).
+		LoadVar(sum).
+		BuiltinOp(Int, OpAdd).
+		StoreVar(sum). // sum += v
+		Pop(1).
+		Jmp(next).
+		Label(done).
+		Pop(1).
+		Resolve()
+
+	ctx := NewContext(code)
+	ctx.SetVar(x, map[string]int{"a": 1, "b": 2, "c": 3})
+	ctx.Exec(0, code.Len())
+	if v := ctx.GetVar(sum); v != 6 {
+		t.Fatal("sum != 6, ret =", v)
+	}
+}
+
+func TestRangeChanAndInt(t *testing.T) {
+	x := NewVar(reflect.TypeOf((chan int)(nil)), "x")
+	sum := NewVar(TyInt, "sum")
+	next1 := NewLabel("next1")
+	done1 := NewLabel("done1")
+	next2 := NewLabel("next2")
+	done2 := NewLabel("done2")
+	code := NewBuilder(nil).
+		DefineVar(x, sum).
+		LoadVar(x).
+		RangeIter().
+		Label(next1).
+		RangeNext(done1).
+		LoadVar(sum).
+		BuiltinOp(Int, OpAdd).
+		StoreVar(sum). // sum += v
+		Jmp(next1).
+		Label(done1).
+		Pop(1).
+		Push(5).
+		RangeIter().
+		Label(next2).
+		RangeNext(done2).
+		LoadVar(sum).
+		BuiltinOp(Int, OpAdd).
+		StoreVar(sum). // sum += i
+		Jmp(next2).
+		Label(done2).
+		Pop(1).
+		Resolve()
+
+	ch := make(chan int, 3)
+	ch <- 100
+	ch <- 200
+	close(ch)
+
+	ctx := NewContext(code)
+	ctx.SetVar(x, ch)
+	ctx.Exec(0, code.Len())
+	if v := ctx.GetVar(sum); v != 310 {
+		t.Fatal("sum != 310, ret =", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
@@ -0,0 +1,130 @@
+package exec
+
+import (
+	"reflect"
+
+	"github.com/qiniu/x/log"
+)
+
+// -----------------------------------------------------------------------------
+
+// A Registry owns Go packages, and Go functions and variables registered by them.
+// Addresses of Go functions (GoFuncAddr, etc.) are indexes in a Registry, so code
+// must be executed with the registry that it's compiled with (see cl.Config and
+// Registry.NewContext).
+//
+// Several isolated interpreters with different bindings can run in one process by
+// using different registries.
+//
+// NOTE: proxies of Go interfaces (see RegisterInterfaces) are shared by all
+// registries, since they depend on Go types only.
+type Registry struct {
+	pkgs  map[string]*GoPackage
+	funs  []GoFuncInfo
+	funvs []GoFuncvInfo
+	vars  []GoVarInfo
+}
+
+// NewRegistry creates an empty registry. The builtin package "" isn't registered in
+// it, see Clone.
+func NewRegistry() *Registry {
+	return &Registry{pkgs: make(map[string]*GoPackage)}
+}
+
+// DefaultRegistry is the registry that NewGoPackage registers Go packages in, and
+// that NewContext executes code with.
+var DefaultRegistry = NewRegistry()
+
+// NewGoPackage creates a new builtin Go Package in this registry.
+func (r *Registry) NewGoPackage(pkgPath string) *GoPackage {
+	if _, ok := r.pkgs[pkgPath]; ok {
+		log.Panicln("NewPackage failed: package exists -", pkgPath)
+	}
+	pkg := &GoPackage{
+		PkgPath: pkgPath,
+		syms:    make(map[string]uint32),
+		types:   make(map[string]reflect.Type),
+		reg:     r,
+	}
+	r.pkgs[pkgPath] = pkg
+	return pkg
+}
+
+// FindGoPackage lookups a Go package by pkgPath. It returns nil if not found.
+func (r *Registry) FindGoPackage(pkgPath string) *GoPackage {
+	return r.pkgs[pkgPath]
+}
+
+// Import finds a Go package by pkgPath, so a Registry is an Importer of all its
+// packages.
+func (r *Registry) Import(pkgPath string) (*GoPackage, error) {
+	if pkg, ok := r.pkgs[pkgPath]; ok {
+		return pkg, nil
+	}
+	return nil, ErrPackageNotFound
+}
+
+// Clone returns a copy of the registry, so that more Go packages can be registered
+// in the copy without affecting r. Eg. DefaultRegistry.Clone() is a registry of
+// the builtin package and standard packages imported by the program.
+func (r *Registry) Clone() *Registry {
+	ret := &Registry{
+		pkgs:  make(map[string]*GoPackage, len(r.pkgs)),
+		funs:  append([]GoFuncInfo(nil), r.funs...),
+		funvs: append([]GoFuncvInfo(nil), r.funvs...),
+		vars:  append([]GoVarInfo(nil), r.vars...),
+	}
+	pkgs := make(map[*GoPackage]*GoPackage, len(r.pkgs))
+	for pkgPath, pkg := range r.pkgs {
+		cloned := &GoPackage{
+			PkgPath: pkg.PkgPath,
+			syms:    make(map[string]uint32, len(pkg.syms)),
+			types:   make(map[string]reflect.Type, len(pkg.types)),
+			reg:     ret,
+		}
+		for name, v := range pkg.syms {
+			cloned.syms[name] = v
+		}
+		for name, t := range pkg.types {
+			cloned.types[name] = t
+		}
+		ret.pkgs[pkgPath] = cloned
+		pkgs[pkg] = cloned
+	}
+	for i := range ret.funs {
+		ret.funs[i].Pkg = pkgs[ret.funs[i].Pkg]
+	}
+	for i := range ret.funvs {
+		ret.funvs[i].Pkg = pkgs[ret.funvs[i].Pkg]
+	}
+	for i := range ret.vars {
+		ret.vars[i].Pkg = pkgs[ret.vars[i].Pkg]
+	}
+	return ret
+}
+
+// GoFuncInfo retuns a Go function info. It returns nil if not found.
+func (r *Registry) GoFuncInfo(addr GoFuncAddr) *GoFuncInfo {
+	if addr < GoFuncAddr(len(r.funs)) {
+		return &r.funs[addr]
+	}
+	return nil
+}
+
+// GoFuncvInfo retuns a variadic Go function info. It returns nil if not found.
+func (r *Registry) GoFuncvInfo(addr GoFuncvAddr) *GoFuncInfo {
+	if addr < GoFuncvAddr(len(r.funvs)) {
+		return &r.funvs[addr].GoFuncInfo
+	}
+	return nil
+}
+
+// GoVarInfo retuns a Go variable info. It returns nil if not found.
+func (r *Registry) GoVarInfo(addr GoVarAddr) *GoVarInfo {
+	if addr < GoVarAddr(len(r.vars)) {
+		return &r.vars[addr]
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------------------
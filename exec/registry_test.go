@@ -0,0 +1,73 @@
+package exec
+
+import (
+	"testing"
+)
+
+// -----------------------------------------------------------------------------
+
+func execStrlen(arity uint32, p *Context) {
+	args := p.GetArgs(1)
+	p.Ret(1, len(args[0].(string)))
+}
+
+func TestRegistry(t *testing.T) {
+	reg := NewRegistry()
+	pkg := reg.NewGoPackage("") // the builtin package exists in DefaultRegistry too
+	pkg.RegisterFuncs(
+		pkg.Func("strlen", func(s string) int { return len(s) }, execStrlen),
+	)
+	strlen, ok := pkg.FindFunc("strlen")
+	if !ok || pkg.Registry() != reg || reg.FindGoPackage("") != pkg {
+		t.Fatal("FindFunc failed: strlen")
+	}
+	if _, ok := I.FindFunc("strlen"); ok {
+		t.Fatal("FindFunc: strlen is registered in DefaultRegistry")
+	}
+
+	code := NewBuilder(nil).
+		SetRegistry(reg).
+		Push("hello").
+		CallGoFunc(strlen).
+		Resolve()
+
+	ctx := NewContext(code) // the code is executed with reg
+	ctx.Exec(0, code.Len())
+	if v := checkPop(ctx); v != 5 {
+		t.Fatal("strlen(`hello`) != 5, ret =", v)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("DefaultRegistry.NewContext: no panic")
+		}
+	}()
+	DefaultRegistry.NewContext(code)
+}
+
+func TestRegistryClone(t *testing.T) {
+	reg := DefaultRegistry.Clone()
+	pkg := reg.FindGoPackage("")
+	strcat, ok := pkg.FindFunc("strcat")
+	if !ok || pkg == I || reg.GoFuncInfo(strcat).Pkg != pkg {
+		t.Fatal("Clone failed: strcat")
+	}
+	reg.NewGoPackage("cloned")
+	if FindGoPackage("cloned") != nil {
+		t.Fatal("Clone: cloned is registered in DefaultRegistry")
+	}
+
+	code := NewBuilder(nil).
+		SetRegistry(reg).
+		Push("Hello, ").
+		Push("qlang").
+		CallGoFunc(strcat).
+		Resolve()
+
+	ctx := reg.NewContext(code)
+	ctx.Exec(0, code.Len())
+	if v := checkPop(ctx); v != "Hello, qlang" {
+		t.Fatal("strcat != `Hello, qlang`, ret =", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
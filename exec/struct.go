@@ -2,6 +2,8 @@ package exec
 
 import (
 	"reflect"
+	"strconv"
+	"unsafe"
 
 	"github.com/qiniu/x/log"
 )
@@ -32,13 +34,120 @@ func (p *StructInfo) Type() reflect.Type {
 
 // -----------------------------------------------------------------------------
 
-type varsContext = reflect.Value
+func execLoadField(i Instr, p *Context) {
+	idx := int(i & bitsOperand)
+	n := len(p.data)
+	v := reflect.ValueOf(p.data[n-1])
+	if v.Kind() == reflect.Ptr { // auto dereference: p.x means (*p).x
+		p.data[n-1] = fieldOf(v.Elem(), idx).Interface()
+		return
+	}
+	f := v.Field(idx)
+	if !f.CanInterface() { // an unexported field: read it from an addressable copy
+		x := reflect.New(v.Type()).Elem()
+		x.Set(v)
+		f = fieldOf(x, idx)
+	}
+	p.data[n-1] = f.Interface()
+}
+
+func execAddrField(i Instr, p *Context) {
+	idx := int(i & bitsOperand)
+	n := len(p.data)
+	v := reflect.ValueOf(p.data[n-1]).Elem()
+	p.data[n-1] = fieldOf(v, idx).Addr().Interface()
+}
+
+func execMakePtr(i Instr, p *Context) {
+	typ := p.code.types[i&bitsOperand]
+	n := len(p.data)
+	p.alloc(1, typ.Size())
+	ret := reflect.New(typ)
+	setValue(ret.Elem(), p.data[n-1])
+	p.data[n-1] = ret.Interface()
+}
+
+// LoadField instr. It replaces the struct (or the pointer to a struct) on the top
+// of the stack with its idx-th field.
+func (p *Builder) LoadField(idx int) *Builder {
+	return p.fieldOp(opLoadField, idx)
+}
+
+// AddrField instr. It replaces the pointer to a struct on the top of the stack
+// with the address of its idx-th field.
+func (p *Builder) AddrField(idx int) *Builder {
+	return p.fieldOp(opAddrField, idx)
+}
+
+func (p *Builder) fieldOp(op int, idx int) *Builder {
+	if idx < 0 || idx > bitsOperand {
+		log.Panicln("fieldOp failed: invalid field index -", idx)
+	}
+	p.code.data = append(p.code.data, (uint32(op)<<bitsOpShift)|uint32(idx))
+	return p
+}
+
+// MakePtr instr. It replaces the value on the top of the stack with a pointer to
+// a new variable of typ that holds a copy of the value. A nil value means zero
+// value of typ.
+func (p *Builder) MakePtr(typ reflect.Type) *Builder {
+	i := (opMakePtr << bitsOpShift) | p.newType(typ)
+	p.code.data = append(p.code.data, i)
+	return p
+}
+
+// -----------------------------------------------------------------------------
+
+// varsContext holds the variables of a context, as fields of a struct. Variables
+// added by Context.GrowVars are held by other structs, so that the existing ones
+// aren't moved.
+type varsContext struct {
+	reflect.Value                 // the struct, or an invalid value if there are no variables
+	grown         []reflect.Value // structs of the variables added by GrowVars
+}
+
+// Field returns the i-th variable.
+func (p varsContext) Field(i int) reflect.Value {
+	if p.grown != nil {
+		if n := p.Value.NumField(); i >= n {
+			return p.grownField(i - n)
+		}
+	}
+	return p.Value.Field(i)
+}
+
+func (p varsContext) grownField(i int) reflect.Value {
+	for _, v := range p.grown {
+		n := v.NumField()
+		if i < n {
+			return v.Field(i)
+		}
+		i -= n
+	}
+	log.Panicln("varsContext.Field failed: index out of range -", i)
+	return reflect.Value{}
+}
+
+// NumField returns the number of the variables.
+func (p varsContext) NumField() int {
+	n := p.Value.NumField()
+	for _, v := range p.grown {
+		n += v.NumField()
+	}
+	return n
+}
 
 func makeVarList(vars []*Var) []StructField {
 	items := make([]StructField, len(vars))
+	names := make(map[string]bool, len(vars))
 	for i, v := range vars {
+		name := v.name
+		if names[name] { // a variable shadows another one with the same name
+			name += "_" + strconv.Itoa(i)
+		}
+		names[name] = true
 		items[i].Type = v.Type
-		items[i].Name = v.name
+		items[i].Name = name
 	}
 	return items
 }
@@ -53,7 +162,7 @@ func makeVarsContext(vars []*Var, ctx *Context) varsContext {
 			}
 		}
 	}
-	return reflect.New(t).Elem()
+	return varsContext{Value: reflect.New(t).Elem()}
 }
 
 func (ctx *Context) addrVar(idx uint32) interface{} {
@@ -77,6 +186,16 @@ func setValue(x reflect.Value, v interface{}) {
 	x.Set(reflect.Zero(x.Type()))
 }
 
+// fieldOf returns the i-th field of an addressable struct value. The field is
+// settable even if it is unexported.
+func fieldOf(v reflect.Value, i int) reflect.Value {
+	f := v.Field(i)
+	if f.CanSet() {
+		return f
+	}
+	return reflect.NewAt(f.Type(), unsafe.Pointer(f.UnsafeAddr())).Elem()
+}
+
 func getValueOf(v interface{}, t reflect.Type) reflect.Value {
 	if v != nil {
 		return reflect.ValueOf(v)
@@ -0,0 +1,87 @@
+package exec
+
+import (
+	"reflect"
+	"testing"
+)
+
+// -----------------------------------------------------------------------------
+
+var tyPoint = reflect.StructOf([]StructField{
+	{Name: "X", Type: TyInt},
+	{Name: "y", Type: TyString, PkgPath: "main"},
+})
+
+func TestLoadField(t *testing.T) {
+	x := NewVar(tyPoint, "x")
+	code := NewBuilder(nil).
+		DefineVar(x).
+		Push(3).
+		Push("Hello").
+		MakeStruct(tyPoint, 2).
+		StoreVar(x).
+		LoadVar(x).
+		LoadField(0). // x.X
+		LoadVar(x).
+		LoadField(1). // x.y
+		AddrVar(x).
+		LoadField(1). // (&x).y
+		Resolve()
+
+	ctx := NewContext(code)
+	ctx.Exec(0, code.Len())
+	if v := ctx.Get(-3); v != 3 {
+		t.Fatal("x.X != 3, ret =", v)
+	}
+	if v := ctx.Get(-2); v != "Hello" {
+		t.Fatal("x.y != Hello, ret =", v)
+	}
+	if v := ctx.Get(-1); v != "Hello" {
+		t.Fatal("(&x).y != Hello, ret =", v)
+	}
+}
+
+func TestAddrField(t *testing.T) {
+	x := NewVar(tyPoint, "x")
+	code := NewBuilder(nil).
+		DefineVar(x).
+		Push(5).
+		AddrVar(x).
+		AddrField(0).
+		AddrOp(Int, OpAssign). // x.X = 5
+		Push("world").
+		AddrVar(x).
+		AddrField(1).
+		AddrOp(String, OpAssign). // x.y = "world"
+		Resolve()
+
+	ctx := NewContext(code)
+	ctx.Exec(0, code.Len())
+	v := reflect.ValueOf(ctx.GetVar(x))
+	if v.Field(0).Int() != 5 || v.Field(1).String() != "world" {
+		t.Fatal("AddrField failed:", v)
+	}
+}
+
+func TestMakePtr(t *testing.T) {
+	code := NewBuilder(nil).
+		Push(7).
+		Push(nil).
+		MakeStruct(tyPoint, 2).
+		MakePtr(tyPoint). // &T{7, ""}
+		Push(nil).
+		MakePtr(TyInt). // new(int)
+		Resolve()
+
+	ctx := NewContext(code)
+	ctx.Exec(0, code.Len())
+	if v, ok := ctx.Get(-1).(*int); !ok || *v != 0 {
+		t.Fatal("MakePtr failed: new(int)")
+	}
+	v := reflect.ValueOf(ctx.Get(-2))
+	if v.Type() != reflect.PtrTo(tyPoint) || v.Elem().Field(0).Int() != 7 {
+		t.Fatal("MakePtr failed:", v)
+	}
+}
+
+// -----------------------------------------------------------------------------
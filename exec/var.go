@@ -55,8 +55,7 @@ func execOpAddrVal(i Instr, p *Context) {
 
 func execOpAssign(i Instr, p *Context) {
 	n := len(p.data)
-	v := reflect.ValueOf(p.data[n-2])
-	reflect.ValueOf(p.data[n-1]).Elem().Set(v)
+	setValue(reflect.ValueOf(p.data[n-1]).Elem(), p.data[n-2])
 	p.data = p.data[:n-2]
 }
 
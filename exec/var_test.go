@@ -106,4 +106,56 @@ func TestAddrVar(t *testing.T) {
 	}
 }
 
+func TestGrowVars(t *testing.T) {
+	strcat, ok := I.FindFunc("strcat")
+	if !ok {
+		t.Fatal("FindFunc failed: strcat")
+	}
+
+	x := NewVar(TyString, "x")
+	f := NewFunc("f", 1)
+	ret := NewVar(TyString, "1")
+	code := NewBuilder(nil).
+		DefineVar(x).
+		Push("78").
+		StoreVar(x). // x = "78"
+		Return(-1).
+		DefineFunc(
+			f.Return(ret).
+				Args(TyString)).
+		Load(-1).
+		Push("32").
+		CallGoFunc(strcat).
+		Return(1).
+		EndFunc(f).
+		Resolve()
+
+	ctx := NewContext(code)
+	ctx.Exec(0, code.Len())
+	px := ctx.addrVar(0).(*string)
+
+	y := NewVar(TyString, "y")
+	start := code.Len()
+	NewBuilder(code).
+		DefineVar(y).
+		LoadVar(x).
+		CallFunc(f).
+		StoreVar(y). // y = f(x)
+		LoadVar(y).
+		Resolve()
+
+	ctx.GrowVars()
+	ctx.Exec(start, code.Len())
+	if v := checkPop(ctx); v != "7832" {
+		t.Fatal("y != 7832, ret =", v)
+	}
+	if v := ctx.GetVar(x); v != "78" {
+		t.Fatal("x != 78, ret =", v)
+	}
+	*px = "79" // x isn't moved
+	if v := ctx.GetVar(x); v != "79" {
+		t.Fatal("x != 79, ret =", v)
+	}
+}
+
 // -----------------------------------------------------------------------------
@@ -38,6 +38,25 @@ func QexecFprintln(arity uint32, p *qlang.Context) {
 	p.Ret(arity, n, err)
 }
 
+// QexecPanic instr
+func QexecPanic(zero uint32, p *qlang.Context) {
+	args := p.GetArgs(1)
+	panic(args[0])
+}
+
+// QexecRecover instr
+func QexecRecover(zero uint32, p *qlang.Context) {
+	p.Ret(0, p.Recover())
+}
+
+func panicFn(v interface{}) {
+	panic(v)
+}
+
+func recoverFn() interface{} {
+	return nil // recover is implemented by qlang.Context.Recover
+}
+
 // -----------------------------------------------------------------------------
 
 // I is a Go package instance.
@@ -50,6 +69,10 @@ func init() {
 		I.Funcv("println", fmt.Println, QexecPrintln),
 		I.Funcv("fprintln", fmt.Fprintln, QexecFprintln),
 	)
+	I.RegisterFuncs(
+		I.Func("panic", panicFn, QexecPanic),
+		I.Func("recover", recoverFn, QexecRecover),
+	)
 	I.RegisterTypes(
 		I.Type("bool", exec.TyBool),
 		I.Type("int", exec.TyInt),
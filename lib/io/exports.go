@@ -0,0 +1,35 @@
+package io
+
+import (
+	"io"
+	"reflect"
+
+	qlang "github.com/qiniu/qlang/spec"
+)
+
+//go:generate go run ../../cmd/qproxy -o proxies.go io Reader Writer
+
+// -----------------------------------------------------------------------------
+
+func execWriteString(zero uint32, p *qlang.Context) {
+	args := p.GetArgs(2)
+	n, err := io.WriteString(args[0].(io.Writer), args[1].(string))
+	p.Ret(2, n, err)
+}
+
+// -----------------------------------------------------------------------------
+
+// I is a Go package instance.
+var I = qlang.NewGoPackage("io")
+
+func init() {
+	I.RegisterFuncs(
+		I.Func("WriteString", io.WriteString, execWriteString),
+	)
+	I.RegisterInterfaces(
+		I.Interface("Reader", reflect.TypeOf((*io.Reader)(nil)).Elem(), newReaderProxy),
+		I.Interface("Writer", reflect.TypeOf((*io.Writer)(nil)).Elem(), newWriterProxy),
+	)
+}
+
+// -----------------------------------------------------------------------------
@@ -0,0 +1,35 @@
+// Code generated by qproxy; DO NOT EDIT.
+
+package io
+
+// -----------------------------------------------------------------------------
+
+type readerProxy struct {
+	read func(a0 []byte) (n int, err error)
+}
+
+func (p *readerProxy) Read(a0 []byte) (n int, err error) {
+	return p.read(a0)
+}
+
+func newReaderProxy(methods []interface{}) interface{} {
+	return &readerProxy{
+		read: methods[0].(func(a0 []byte) (n int, err error)),
+	}
+}
+
+type writerProxy struct {
+	write func(a0 []byte) (n int, err error)
+}
+
+func (p *writerProxy) Write(a0 []byte) (n int, err error) {
+	return p.write(a0)
+}
+
+func newWriterProxy(methods []interface{}) interface{} {
+	return &writerProxy{
+		write: methods[0].(func(a0 []byte) (n int, err error)),
+	}
+}
+
+// -----------------------------------------------------------------------------
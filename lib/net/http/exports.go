@@ -0,0 +1,66 @@
+package http
+
+import (
+	"net/http"
+	"reflect"
+
+	qlang "github.com/qiniu/qlang/spec"
+)
+
+//go:generate go run ../../../cmd/qproxy -o proxies.go net/http Handler ResponseWriter
+
+// -----------------------------------------------------------------------------
+
+func execHandle(zero uint32, p *qlang.Context) {
+	args := p.GetArgs(2)
+	http.Handle(args[0].(string), args[1].(http.Handler))
+	p.Ret(2)
+}
+
+func execListenAndServe(zero uint32, p *qlang.Context) {
+	args := p.GetArgs(2)
+	handler, _ := args[1].(http.Handler) // nil means http.DefaultServeMux
+	err := http.ListenAndServe(args[0].(string), handler)
+	p.Ret(2, err)
+}
+
+func execNewServeMux(zero uint32, p *qlang.Context) {
+	p.Ret(0, http.NewServeMux())
+}
+
+func execServeMuxHandle(zero uint32, p *qlang.Context) {
+	args := p.GetArgs(3)
+	args[0].(*http.ServeMux).Handle(args[1].(string), args[2].(http.Handler))
+	p.Ret(3)
+}
+
+func execResponseWriterWriteHeader(zero uint32, p *qlang.Context) {
+	args := p.GetArgs(2)
+	args[0].(http.ResponseWriter).WriteHeader(args[1].(int))
+	p.Ret(2)
+}
+
+// -----------------------------------------------------------------------------
+
+// I is a Go package instance.
+var I = qlang.NewGoPackage("net/http")
+
+func init() {
+	I.RegisterFuncs(
+		I.Func("Handle", http.Handle, execHandle),
+		I.Func("ListenAndServe", http.ListenAndServe, execListenAndServe),
+		I.Func("NewServeMux", http.NewServeMux, execNewServeMux),
+		I.Func("(*ServeMux).Handle", (*http.ServeMux).Handle, execServeMuxHandle),
+		I.Func("(ResponseWriter).WriteHeader", http.ResponseWriter.WriteHeader, execResponseWriterWriteHeader),
+	)
+	I.RegisterTypes(
+		I.Type("Request", reflect.TypeOf((*http.Request)(nil)).Elem()),
+		I.Type("ServeMux", reflect.TypeOf((*http.ServeMux)(nil)).Elem()),
+	)
+	I.RegisterInterfaces(
+		I.Interface("Handler", reflect.TypeOf((*http.Handler)(nil)).Elem(), newHandlerProxy),
+		I.Interface("ResponseWriter", reflect.TypeOf((*http.ResponseWriter)(nil)).Elem(), newResponseWriterProxy),
+	)
+}
+
+// -----------------------------------------------------------------------------
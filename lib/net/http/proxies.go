@@ -0,0 +1,51 @@
+// Code generated by qproxy; DO NOT EDIT.
+
+package http
+
+import (
+	"net/http"
+)
+
+// -----------------------------------------------------------------------------
+
+type handlerProxy struct {
+	serveHTTP func(a0 http.ResponseWriter, a1 *http.Request)
+}
+
+func (p *handlerProxy) ServeHTTP(a0 http.ResponseWriter, a1 *http.Request) {
+	p.serveHTTP(a0, a1)
+}
+
+func newHandlerProxy(methods []interface{}) interface{} {
+	return &handlerProxy{
+		serveHTTP: methods[0].(func(a0 http.ResponseWriter, a1 *http.Request)),
+	}
+}
+
+type responseWriterProxy struct {
+	header      func() http.Header
+	write       func(a0 []byte) (int, error)
+	writeHeader func(statusCode int)
+}
+
+func (p *responseWriterProxy) Header() http.Header {
+	return p.header()
+}
+
+func (p *responseWriterProxy) Write(a0 []byte) (int, error) {
+	return p.write(a0)
+}
+
+func (p *responseWriterProxy) WriteHeader(statusCode int) {
+	p.writeHeader(statusCode)
+}
+
+func newResponseWriterProxy(methods []interface{}) interface{} {
+	return &responseWriterProxy{
+		header:      methods[0].(func() http.Header),
+		write:       methods[1].(func(a0 []byte) (int, error)),
+		writeHeader: methods[2].(func(statusCode int)),
+	}
+}
+
+// -----------------------------------------------------------------------------
@@ -0,0 +1,45 @@
+package os
+
+import (
+	"os"
+
+	qlang "github.com/qiniu/qlang/spec"
+)
+
+// -----------------------------------------------------------------------------
+
+// Exit is called by os.Exit of qlang code. A host that must not exit when the code
+// exits, eg. a debugger, can replace it.
+var Exit = os.Exit
+
+func exit(code int) {
+	Exit(code)
+}
+
+func execExit(zero uint32, p *qlang.Context) {
+	args := p.GetArgs(1)
+	Exit(args[0].(int))
+}
+
+func execGetenv(zero uint32, p *qlang.Context) {
+	args := p.GetArgs(1)
+	ret := os.Getenv(args[0].(string))
+	p.Ret(1, ret)
+}
+
+// -----------------------------------------------------------------------------
+
+// I is a Go package instance.
+var I = qlang.NewGoPackage("os")
+
+func init() {
+	I.RegisterFuncs(
+		I.Func("Exit", exit, execExit),
+		I.Func("Getenv", os.Getenv, execGetenv),
+	)
+	I.RegisterVars(
+		I.Var("Args", &os.Args),
+	)
+}
+
+// -----------------------------------------------------------------------------
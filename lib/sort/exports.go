@@ -0,0 +1,55 @@
+package sort
+
+import (
+	"reflect"
+	"sort"
+
+	qlang "github.com/qiniu/qlang/spec"
+)
+
+//go:generate go run ../../cmd/qproxy -o proxies.go sort Interface
+
+// -----------------------------------------------------------------------------
+
+func execSort(zero uint32, p *qlang.Context) {
+	args := p.GetArgs(1)
+	sort.Sort(args[0].(sort.Interface))
+	p.Ret(1)
+}
+
+func execStable(zero uint32, p *qlang.Context) {
+	args := p.GetArgs(1)
+	sort.Stable(args[0].(sort.Interface))
+	p.Ret(1)
+}
+
+func execInts(zero uint32, p *qlang.Context) {
+	args := p.GetArgs(1)
+	sort.Ints(args[0].([]int))
+	p.Ret(1)
+}
+
+func execStrings(zero uint32, p *qlang.Context) {
+	args := p.GetArgs(1)
+	sort.Strings(args[0].([]string))
+	p.Ret(1)
+}
+
+// -----------------------------------------------------------------------------
+
+// I is a Go package instance.
+var I = qlang.NewGoPackage("sort")
+
+func init() {
+	I.RegisterFuncs(
+		I.Func("Sort", sort.Sort, execSort),
+		I.Func("Stable", sort.Stable, execStable),
+		I.Func("Ints", sort.Ints, execInts),
+		I.Func("Strings", sort.Strings, execStrings),
+	)
+	I.RegisterInterfaces(
+		I.Interface("Interface", reflect.TypeOf((*sort.Interface)(nil)).Elem(), newInterfaceProxy),
+	)
+}
+
+// -----------------------------------------------------------------------------
@@ -0,0 +1,217 @@
+package parser
+
+import (
+	"go/scanner"
+	"go/token"
+	"strconv"
+)
+
+// -----------------------------------------------------------------------------
+
+const (
+	braceBlock     = iota // { ... } of a block, eg. function body
+	braceComposite        // { ... } of a Go composite literal, or a struct/interface type
+	braceLit              // { ... } of a qlang map literal
+	bracketNorm           // [ ... ] of an index expression or an array type
+	bracketLit            // [ ... ] of a qlang slice literal
+	paren                 // ( ... )
+)
+
+type litToken struct {
+	pos   token.Pos
+	tok   token.Token
+	match int // index of the matching close token if tok is ( [ {
+}
+
+// rewriteLiterals rewrites qlang-style slice and map literals into Go syntax:
+//
+//	[a, b, c]          =>  []_{a, b, c}
+//	{k1: v1, k2: v2}   =>  map[_]_{k1: v1, k2: v2}
+//
+// The compiler infers the real types of `_` from the elements. Every inserted
+// text is followed by a line directive, so positions of the original tokens
+// are kept.
+func rewriteLiterals(code []byte) []byte {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", -1, len(code))
+	toks := scanLitTokens(file, code)
+	if toks == nil {
+		return code
+	}
+
+	var b []byte
+	var last int
+	var stk []int
+	var header = -1 // stack depth of if/for/switch header that we are in
+	var prev = token.SEMICOLON
+	for i, t := range toks {
+		var prefix string
+		var replace byte
+		switch t.tok {
+		case token.IF, token.FOR, token.SWITCH:
+			if header < 0 {
+				header = len(stk)
+			}
+		case token.LPAREN:
+			stk = append(stk, paren)
+		case token.LBRACK:
+			kind := bracketNorm
+			if isExprStart(prev) && !isArrayType(toks, i) {
+				kind, prefix, replace = bracketLit, "[]_", '{'
+			}
+			stk = append(stk, kind)
+		case token.LBRACE:
+			kind := braceBlock
+			if isMapLitStart(prev, stk) { // eg. range {...} in a header
+				kind, prefix = braceLit, "map[_]_"
+			} else if header == len(stk) { // the block of the if/for/switch statement
+				header = -1
+			} else if isCompositeType(prev) {
+				kind = braceComposite
+			}
+			stk = append(stk, kind)
+		case token.RBRACK:
+			if n := len(stk); n > 0 {
+				if stk[n-1] == bracketLit {
+					replace = '}'
+				}
+				stk = stk[:n-1]
+			}
+		case token.RPAREN, token.RBRACE:
+			if n := len(stk); n > 0 {
+				stk = stk[:n-1]
+			}
+		}
+		if prefix != "" || replace != 0 {
+			off := file.Offset(t.pos)
+			if b == nil {
+				b = make([]byte, 0, len(code)+len(code)/4)
+			}
+			b = append(b, code[last:off]...)
+			if prefix != "" {
+				pos := file.PositionFor(t.pos, false)
+				b = append(b, prefix...)
+				b = append(b, "/*line :"...)
+				b = strconv.AppendInt(b, int64(pos.Line), 10)
+				b = append(b, ':')
+				b = strconv.AppendInt(b, int64(pos.Column), 10)
+				b = append(b, "*/"...)
+			}
+			if replace != 0 {
+				b = append(b, replace)
+			} else {
+				b = append(b, code[off])
+			}
+			last = off + 1
+		}
+		prev = toks[i].tok
+	}
+	if b == nil {
+		return code
+	}
+	return append(b, code[last:]...)
+}
+
+// scanLitTokens scans all tokens of code, and matches all brackets. It returns
+// nil if there are no brackets to rewrite or the brackets mismatch.
+func scanLitTokens(file *token.File, code []byte) []litToken {
+	var s scanner.Scanner
+	var toks []litToken
+	var opens []int
+	var found bool
+	s.Init(file, code, nil, 0)
+	for {
+		pos, tok, _ := s.Scan()
+		switch tok {
+		case token.LPAREN, token.LBRACK, token.LBRACE:
+			opens = append(opens, len(toks))
+			found = found || tok != token.LPAREN
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			n := len(opens)
+			if n == 0 || toks[opens[n-1]].tok+(token.RPAREN-token.LPAREN) != tok {
+				return nil
+			}
+			toks[opens[n-1]].match = len(toks)
+			opens = opens[:n-1]
+		}
+		toks = append(toks, litToken{pos: pos, tok: tok})
+		if tok == token.EOF {
+			break
+		}
+	}
+	if !found || len(opens) != 0 {
+		return nil
+	}
+	return toks
+}
+
+// isExprStart checks if an operand can start after the token prev.
+func isExprStart(prev token.Token) bool {
+	switch prev {
+	case token.IDENT, token.INT, token.FLOAT, token.IMAG, token.CHAR, token.STRING,
+		token.RPAREN, token.RBRACK, token.RBRACE, token.MAP, token.CHAN, token.INC, token.DEC:
+		return false
+	}
+	return true
+}
+
+// isArrayType checks if the [ at toks[i], where an operand can start, starts an
+// array or slice type (eg. []T, [N]T or [2][3]T) rather than a qlang slice literal.
+// The brackets of a type have no elements separated by commas, and are followed by
+// the element type.
+func isArrayType(toks []litToken, i int) bool {
+	for toks[i].tok == token.LBRACK {
+		end := toks[i].match
+		for j := i + 1; j < end; j++ {
+			if toks[j].tok == token.COMMA { // eg. [1, 2][0]
+				return false
+			}
+			if toks[j].match > j { // skip nested brackets
+				j = toks[j].match
+			}
+		}
+		i = end + 1
+	}
+	return isTypeStart(toks[i].tok)
+}
+
+// isTypeStart checks if the token tok can start a type (eg. the element type of []T).
+func isTypeStart(tok token.Token) bool {
+	switch tok {
+	case token.IDENT, token.LBRACK, token.MUL, token.LPAREN, token.ARROW,
+		token.MAP, token.CHAN, token.FUNC, token.STRUCT, token.INTERFACE:
+		return true
+	}
+	return false
+}
+
+// isMapLitStart checks if a { after the token prev starts a qlang map literal.
+func isMapLitStart(prev token.Token, stk []int) bool {
+	top := braceBlock
+	if n := len(stk); n > 0 {
+		top = stk[n-1]
+	}
+	switch prev {
+	case token.ASSIGN, token.DEFINE, token.RETURN, token.LPAREN, token.RANGE:
+		return true
+	case token.COMMA:
+		return top != braceComposite
+	case token.COLON:
+		return top == braceLit
+	case token.LBRACK:
+		return top == bracketLit
+	}
+	return false
+}
+
+// isCompositeType checks if a { after the token prev starts a Go composite literal
+// (or a struct/interface type).
+func isCompositeType(prev token.Token) bool {
+	switch prev {
+	case token.IDENT, token.RBRACK, token.RBRACE, token.STRUCT, token.INTERFACE:
+		return true
+	}
+	return false
+}
+
+// -----------------------------------------------------------------------------
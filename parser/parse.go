@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/qiniu/qlang/ast"
@@ -141,16 +142,13 @@ func ParseFSFile(fset *token.FileSet, fs FileSystem, filename string, src interf
 }
 
 func parseFile(fset *token.FileSet, filename string, code []byte, mode Mode) (f *ast.File, err error) {
-	var b []byte
 	var isMod bool
 	var fsetTmp = token.NewFileSet()
+	code = skipShebang(code)
+	code = rewriteLiterals(code)
 	f, err = parser.ParseFile(fsetTmp, filename, code, PackageClauseOnly)
 	if err != nil {
-		n := len(code)
-		b = make([]byte, n+28)
-		copy(b, "package main;")
-		copy(b[13:], code)
-		code = b[:n+13]
+		code = append([]byte("package main;/*line :1:1*/"), code...)
 	} else {
 		isMod = f.Name.Name != "main"
 	}
@@ -158,20 +156,21 @@ func parseFile(fset *token.FileSet, filename string, code []byte, mode Mode) (f
 	if err != nil {
 		if errlist, ok := err.(scanner.ErrorList); ok {
 			if e := errlist[0]; strings.HasPrefix(e.Msg, "expected declaration") {
-				n := len(code)
-				idx := e.Pos.Offset
-				if b == nil {
-					b = make([]byte, n+13)
-					copy(b, code[:idx])
-				}
-				copy(b[idx+12:], code[idx:n])
+				fn := "func main(){"
 				if isMod {
-					copy(b[idx:], "func init(){")
-				} else {
-					copy(b[idx:], "func main(){")
+					fn = "func init(){"
 				}
-				b[n+12] = '}'
-				code = b[:n+13]
+				idx := e.Pos.Offset
+				b := make([]byte, 0, len(code)+len(fn)+32)
+				b = append(b, code[:idx]...)
+				b = append(b, fn...)
+				b = append(b, "/*line :"...) // keep positions of the statements
+				b = strconv.AppendInt(b, int64(e.Pos.Line), 10)
+				b = append(b, ':')
+				b = strconv.AppendInt(b, int64(e.Pos.Column), 10)
+				b = append(b, "*/"...)
+				b = append(b, code[idx:]...)
+				code = append(b, '}')
 				err = nil
 			}
 		}
@@ -182,6 +181,15 @@ func parseFile(fset *token.FileSet, filename string, code []byte, mode Mode) (f
 	return
 }
 
+// skipShebang turns a shebang line (eg. `#!/usr/bin/env qrun`) at the beginning of
+// code into a comment of the same length, so positions of the code are kept.
+func skipShebang(code []byte) []byte {
+	if bytes.HasPrefix(code, []byte("#!")) {
+		code = append([]byte("//"), code[2:]...)
+	}
+	return code
+}
+
 var (
 	errInvalidSource = errors.New("invalid source")
 )
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"go/ast"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/qiniu/qlang/ast/asttest"
@@ -90,3 +91,87 @@ func TestParseNoPackageAndGlobalCode(t *testing.T) {
 }
 
 // -----------------------------------------------------------------------------
+
+func TestRewriteLiterals(t *testing.T) {
+	cases := []struct {
+		src, ret string
+	}{
+		{"a := [1, 2, 3.4]", "a := []_/*line :1:6*/{1, 2, 3.4}"},
+		{"b := {\"a\": 1}", "b := map[_]_/*line :1:6*/{\"a\": 1}"},
+		{"f([], {})", "f([]_/*line :1:3*/{}, map[_]_/*line :1:7*/{})"},
+		{"c := [[1], {}]", "c := []_/*line :1:6*/{[]_/*line :1:7*/{1}, map[_]_/*line :1:12*/{}}"},
+		{"d := {\"x\": {\"y\": [1]}}", "d := map[_]_/*line :1:6*/{\"x\": map[_]_/*line :1:12*/{\"y\": []_/*line :1:18*/{1}}}"},
+		{"e := []int{1, 2}[0]", "e := []int{1, 2}[0]"},
+		{"var x [2]int; y := [...]string{\"a\"}", "var x [2]int; y := [...]string{\"a\"}"},
+		{"m := map[string][]int{\"a\": {1}}", "m := map[string][]int{\"a\": {1}}"},
+		{"for _, v := range [1, 2] {\n\tfoo(v, {})\n}", "for _, v := range []_/*line :1:19*/{1, 2} {\n\tfoo(v, map[_]_/*line :2:9*/{})\n}"},
+		{"if x {\n}", "if x {\n}"},
+		{"s := []T{{1, 2}, {3, 4}}", "s := []T{{1, 2}, {3, 4}}"},
+		{"for k, v := range {\"x\": 1} {\n}", "for k, v := range map[_]_/*line :1:19*/{\"x\": 1} {\n}"},
+		{"if m := {}; m != nil {\n}", "if m := map[_]_/*line :1:9*/{}; m != nil {\n}"},
+		{"for i := 0; i < 2; i++ {\n}", "for i := 0; i < 2; i++ {\n}"},
+		{"a := [1, 2][0]", "a := []_/*line :1:6*/{1, 2}[0]"},
+		{"b := [[1]][0]", "b := []_/*line :1:6*/{[]_/*line :1:7*/{1}}[0]"},
+		{"c := [2][3]int{}", "c := [2][3]int{}"},
+	}
+	for _, c := range cases {
+		if ret := string(rewriteLiterals([]byte(c.src))); ret != c.ret {
+			t.Fatal("rewriteLiterals failed:", c.src, "=>", ret)
+		}
+	}
+}
+
+var fsTestLiteral = asttest.NewSingleFileFS("/foo", "bar.ql", `
+x := [1, {"a": 2}]
+y := 3
+`)
+
+func TestLiteralPos(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := ParseFSDir(fset, fsTestLiteral, "/foo", nil, 0)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatal("ParseFSDir failed:", err, len(pkgs))
+	}
+	file := pkgs["main"].Files["/foo/bar.ql"]
+	body := file.Decls[0].(*ast.FuncDecl).Body.List
+	x := body[0].(*ast.AssignStmt).Rhs[0].(*ast.CompositeLit)
+	m := x.Elts[1].(*ast.CompositeLit)
+	y := body[1].(*ast.AssignStmt).Lhs[0]
+	for _, c := range []struct {
+		pos       token.Pos
+		line, col int
+	}{
+		{x.Lbrace, 2, 6}, {x.Rbrace, 2, 18}, {m.Pos(), 2, 10}, {m.Lbrace, 2, 10}, {y.Pos(), 3, 1},
+	} {
+		pos := fset.Position(c.pos)
+		if pos.Line != c.line || pos.Column != c.col {
+			t.Fatal("position:", pos, c.line, c.col)
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var fsTestShebang = asttest.NewSingleFileFS("/foo", "bar.ql", `#!/usr/bin/env qrun
+x := 1
+y := )
+`)
+
+func TestShebang(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := ParseFile(fset, "bar.ql", "#!/usr/bin/env qrun\nx := 1\n", 0)
+	if err != nil {
+		t.Fatal("ParseFile failed:", err)
+	}
+	x := f.Decls[0].(*ast.FuncDecl).Body.List[0].(*ast.AssignStmt).Lhs[0]
+	if pos := fset.Position(x.Pos()); pos.Line != 2 || pos.Column != 1 {
+		t.Fatal("position:", pos)
+	}
+
+	_, err = ParseFSDir(fset, fsTestShebang, "/foo", nil, 0)
+	if err == nil || !strings.HasPrefix(err.Error(), "/foo/bar.ql:3:6:") {
+		t.Fatal("ParseFSDir: unexpected error -", err)
+	}
+}
+
+// -----------------------------------------------------------------------------
@@ -108,6 +108,15 @@ const (
 
 // -----------------------------------------------------------------------------
 
+// Pos is a compact encoding of a source position within a file set.
+type Pos = token.Pos
+
+// NoPos is the zero value for Pos; there is no file and line information associated with it.
+const NoPos = token.NoPos
+
+// Position describes an arbitrary source position including the file, line, and column location.
+type Position = token.Position
+
 // A FileSet represents a set of source files. Methods of file sets are synchronized;
 // multiple goroutines may invoke them concurrently.
 type FileSet = token.FileSet